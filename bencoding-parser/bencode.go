@@ -2,6 +2,7 @@ package bencodingParser
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -167,6 +168,31 @@ func NewBencodeFromBDict(bd *BencodeDict) *Bencode {
 	}
 }
 
+func NewBencodeFromString(s string) *Bencode {
+	return NewBencodeFromBString(NewBencodeString(s))
+}
+
+func NewBencodeFromInt64(v int64) *Bencode {
+	bi := BencodeInt(v)
+	return NewBencodeFromBInt(&bi)
+}
+
+// NewSortedBencodeDict builds a dictionary with its keys sorted as raw strings, as required by the bencode spec.
+// The serializer writes keys in insertion order, so dictionaries that are hashed or written to disk use this.
+func NewSortedBencodeDict(entries map[string]*Bencode) *Bencode {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bencodeDict := NewBencodeDict()
+	for _, key := range keys {
+		bencodeDict.Put(NewBencodeFromString(key), entries[key])
+	}
+	return NewBencodeFromBDict(bencodeDict)
+}
+
 // todo: check the usage of below functions
 func (b *Bencode) panicIfMultipleAssignment() {
 	count := 0
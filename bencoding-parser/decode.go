@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"strconv"
 	"unicode"
 )
@@ -105,7 +104,7 @@ func parseList(data []byte, startPos int) (bencode *Bencode, endPos int, err err
 
 	bencodeList := NewBencodeList()
 	pos := startPos + 1
-	for data[pos] != 'e' {
+	for pos < len(data) && data[pos] != 'e' {
 		bencodeType := getBencodeType(data, pos)
 		if bencodeType < 0 {
 			return nil, pos, fmt.Errorf("unhandled bencode type at position %d", pos)
@@ -122,9 +121,15 @@ func parseList(data []byte, startPos int) (bencode *Bencode, endPos int, err err
 		case DictionaryType:
 			bencodeCurr, pos, err = parseDictionary(data, pos)
 		}
+		if err != nil {
+			return nil, pos, err
+		}
 
 		bencodeList.Add(bencodeCurr)
 	}
+	if pos >= len(data) {
+		return nil, pos, fmt.Errorf("missing 'e' terminator for list starting at pos %d", startPos)
+	}
 	bencode = NewBencodeFromBList(bencodeList)
 
 	return bencode, pos + 1, err
@@ -143,7 +148,7 @@ func parseDictionary(data []byte, startPos int) (bencode *Bencode, endPos int, e
 
 	bencodeDictionary := NewBencodeDict()
 	pos := startPos + 1
-	for data[pos] != 'e' {
+	for pos < len(data) && data[pos] != 'e' {
 		bencodeTypeKey := getBencodeType(data, pos)
 		if bencodeTypeKey != StringType {
 			err = fmt.Errorf("error at pos %d: key is not a string", pos)
@@ -152,6 +157,9 @@ func parseDictionary(data []byte, startPos int) (bencode *Bencode, endPos int, e
 
 		var bencodeKey *Bencode
 		bencodeKey, pos, err = parseString(data, pos)
+		if err != nil {
+			return nil, pos, err
+		}
 
 		bencodeTypeValue := getBencodeType(data, pos)
 		if bencodeTypeValue < 0 {
@@ -169,9 +177,15 @@ func parseDictionary(data []byte, startPos int) (bencode *Bencode, endPos int, e
 		case DictionaryType:
 			bencodeValue, pos, err = parseDictionary(data, pos)
 		}
+		if err != nil {
+			return nil, pos, err
+		}
 
 		bencodeDictionary.Put(bencodeKey, bencodeValue)
 	}
+	if pos >= len(data) {
+		return nil, pos, fmt.Errorf("missing 'e' terminator for dictionary starting at pos %d", startPos)
+	}
 	bencode = NewBencodeFromBDict(bencodeDictionary)
 	return bencode, pos + 1, err
 }
@@ -179,7 +193,7 @@ func parseDictionary(data []byte, startPos int) (bencode *Bencode, endPos int, e
 func ParseBencodeFromTorrentFile(reader io.Reader) (bencode *Bencode, err error) {
 	fileContent, err := io.ReadAll(reader)
 	if err != nil {
-		log.Fatalf("error reading file %v", err)
+		return nil, fmt.Errorf("error reading file: %w", err)
 	}
 
 	bencode, _, err = parseDictionary(fileContent, 0)
@@ -189,6 +203,16 @@ func ParseBencodeFromTorrentFile(reader io.Reader) (bencode *Bencode, err error)
 	return bencode, err
 }
 
+// ParseBencodeDictionaryPrefix parses the dictionary at the start of the content, and returns the number of bytes it
+// spans; the bytes after it are left alone, e.g. the metadata piece following the dictionary of a BEP 9 message
+func ParseBencodeDictionaryPrefix(content []byte) (bencode *Bencode, length int, err error) {
+	bencode, length, err = parseDictionary(content, 0)
+	if err != nil {
+		return nil, 0, errors.New("parsing error: " + err.Error())
+	}
+	return bencode, length, nil
+}
+
 func ParseBencodeFromByteSlice(content []byte) (bencode *Bencode, err error) {
 	bencode, _, err = parseDictionary(content, 0)
 	if err != nil {
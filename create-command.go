@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bittorrent-client/ptorrent"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// repeatedFlag collects every occurrence of a flag, in order
+type repeatedFlag []string
+
+func (r *repeatedFlag) String() string {
+	return strings.Join(*r, ",")
+}
+
+func (r *repeatedFlag) Set(value string) error {
+	*r = append(*r, value)
+	return nil
+}
+
+func runCreate(args []string) {
+	var trackers repeatedFlag
+	var webSeeds repeatedFlag
+
+	flagSet := flag.NewFlagSet("create", flag.ExitOnError)
+	flagSet.Var(&trackers, "tracker", "tracker announce url; repeat for more trackers, the first one is the primary. Urls separated by ',' form one tier")
+	flagSet.Var(&webSeeds, "web-seed", "web seed url; may be repeated")
+	outputPath := flagSet.String("o", "", "output path of the torrent file (default: <name>.torrent)")
+	comment := flagSet.String("comment", "", "comment")
+	createdBy := flagSet.String("created-by", "pTorrent", "created by")
+	private := flagSet.Bool("private", false, "set the private flag")
+	pieceLength := flagSet.Int64("piece-length", 0, "piece length in bytes, a power of two (default: automatic)")
+	workers := flagSet.Int("workers", 0, "number of hashing goroutines (default: number of CPUs)")
+	_ = flagSet.Parse(args)
+
+	if flagSet.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		flagSet.PrintDefaults()
+		os.Exit(2)
+	}
+	sourcePath := flagSet.Arg(0)
+
+	var announceList [][]string
+	for _, tier := range trackers {
+		announceList = append(announceList, strings.Split(tier, ","))
+	}
+
+	if *outputPath == "" {
+		absolutePath, err := filepath.Abs(sourcePath)
+		if err != nil {
+			log.Fatalf("[fatal] invalid path %s: %v", sourcePath, err)
+		}
+		*outputPath = filepath.Base(absolutePath) + ".torrent"
+	}
+
+	torrent, err := ptorrent.WriteTorrentFile(ptorrent.CreateTorrentOptions{
+		Path:         sourcePath,
+		AnnounceList: announceList,
+		UrlList:      webSeeds,
+		Comment:      *comment,
+		CreatedBy:    *createdBy,
+		Private:      *private,
+		PieceLength:  *pieceLength,
+		Workers:      *workers,
+	}, *outputPath)
+	if err != nil {
+		log.Fatalf("[fatal] error creating torrent: %v", err)
+	}
+	fmt.Printf("created %s\ninfo-hash: %x\npieces: %d x %d bytes\n", *outputPath, torrent.InfoHash, torrent.Info.NumPieces, torrent.Info.PieceLength)
+}
@@ -0,0 +1,105 @@
+package httpApi
+
+import (
+	"bittorrent-client/ptorrent"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const eventStreamKeepAliveInterval = time.Second * 15
+
+type eventJson struct {
+	Type       ptorrent.EventType `json:"type"`
+	InfoHash   string             `json:"infoHash"`
+	Name       string             `json:"name"`
+	PieceIndex *uint32            `json:"pieceIndex,omitempty"`
+	Peer       string             `json:"peer,omitempty"`
+	Recheck    *recheckJson       `json:"recheck,omitempty"`
+}
+
+type recheckJson struct {
+	Checked  int64 `json:"checked"`
+	Verified int64 `json:"verified"`
+	Total    int64 `json:"total"`
+}
+
+func newEventJson(event ptorrent.Event) eventJson {
+	eventJson := eventJson{
+		Type:     event.Type,
+		InfoHash: hex.EncodeToString(event.InfoHash[:]),
+		Name:     event.Name,
+		Peer:     event.Peer,
+	}
+	switch event.Type {
+	case ptorrent.EventPieceCompleted:
+		pieceIndex := event.PieceIndex
+		eventJson.PieceIndex = &pieceIndex
+	case ptorrent.EventRecheckProgress:
+		eventJson.Recheck = &recheckJson{
+			Checked:  event.Recheck.Checked,
+			Verified: event.Recheck.Verified,
+			Total:    event.Recheck.Total,
+		}
+	}
+	return eventJson
+}
+
+// handleEvents streams the client events as Server-Sent Events, named after the event type.
+// The query parameter `types` filters the events, e.g. `types=piece-completed,torrent-completed`.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	wanted := make(map[ptorrent.EventType]bool)
+	if types := r.URL.Query().Get("types"); types != "" {
+		for _, eventType := range strings.Split(types, ",") {
+			wanted[ptorrent.EventType(strings.TrimSpace(eventType))] = true
+		}
+	}
+
+	events, unsubscribe := s.client.Subscribe(0)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventStreamKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if len(wanted) > 0 && !wanted[event.Type] {
+				continue
+			}
+			data, err := json.Marshal(newEventJson(event))
+			if err != nil {
+				logger.Error("error encoding event", "type", event.Type, "err", err)
+				continue
+			}
+			if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
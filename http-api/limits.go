@@ -0,0 +1,77 @@
+package httpApi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// limitsJson rates in bytes per second, 0 for unlimited
+type limitsJson struct {
+	MaxDownloadRate int64 `json:"maxDownloadRate"`
+	MaxUploadRate   int64 `json:"maxUploadRate"`
+}
+
+// limitsUpdateJson absent fields are left unchanged
+type limitsUpdateJson struct {
+	MaxDownloadRate *int64 `json:"maxDownloadRate"`
+	MaxUploadRate   *int64 `json:"maxUploadRate"`
+}
+
+// rateLimited the client, or a torrent
+type rateLimited interface {
+	RateLimits() (int64, int64)
+	SetRateLimits(int64, int64)
+}
+
+func (s *Server) handleGetLimits(w http.ResponseWriter, r *http.Request) {
+	writeLimits(w, s.client)
+}
+
+func (s *Server) handleSetLimits(w http.ResponseWriter, r *http.Request) {
+	updateLimits(w, r, s.client)
+}
+
+func (s *Server) handleGetTorrentLimits(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.lookupSession(w, r)
+	if !ok {
+		return
+	}
+	writeLimits(w, session)
+}
+
+func (s *Server) handleSetTorrentLimits(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.lookupSession(w, r)
+	if !ok {
+		return
+	}
+	updateLimits(w, r, session)
+}
+
+func writeLimits(w http.ResponseWriter, limited rateLimited) {
+	maxDownloadRate, maxUploadRate := limited.RateLimits()
+	writeJson(w, http.StatusOK, limitsJson{MaxDownloadRate: maxDownloadRate, MaxUploadRate: maxUploadRate})
+}
+
+// updateLimits the new limits take effect immediately, for the connected peers as well
+func updateLimits(w http.ResponseWriter, r *http.Request, limited rateLimited) {
+	var update limitsUpdateJson
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&update); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limits: %v", err))
+		return
+	}
+	maxDownloadRate, maxUploadRate := limited.RateLimits()
+	if update.MaxDownloadRate != nil {
+		maxDownloadRate = *update.MaxDownloadRate
+	}
+	if update.MaxUploadRate != nil {
+		maxUploadRate = *update.MaxUploadRate
+	}
+	if maxDownloadRate < 0 || maxUploadRate < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("rates can not be negative, 0 is unlimited"))
+		return
+	}
+	limited.SetRateLimits(maxDownloadRate, maxUploadRate)
+	writeLimits(w, limited)
+}
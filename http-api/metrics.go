@@ -0,0 +1,127 @@
+package httpApi
+
+import (
+	"bittorrent-client/ptorrent"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+/*
+- What is it supposed to do
+- - Serves the engine metrics on `GET /metrics` in the Prometheus text exposition format, one series per torrent,
+    labelled by `infohash`. Handshake failures not routed to a torrent have an empty `infohash`.
+- - The format is written by hand, the module has no dependencies.
+*/
+
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type metricsWriter struct {
+	buffer bytes.Buffer
+}
+
+// family writes the HELP and TYPE lines of a metric
+func (mw *metricsWriter) family(name string, metricType string, help string) {
+	fmt.Fprintf(&mw.buffer, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// sample labels are pairs of name and value
+func (mw *metricsWriter) sample(name string, value float64, labels ...string) {
+	mw.buffer.WriteString(name)
+	if len(labels) > 0 {
+		mw.buffer.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				mw.buffer.WriteByte(',')
+			}
+			fmt.Fprintf(&mw.buffer, "%s=%q", labels[i], labels[i+1])
+		}
+		mw.buffer.WriteByte('}')
+	}
+	mw.buffer.WriteByte(' ')
+	mw.buffer.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	mw.buffer.WriteByte('\n')
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var torrents []ptorrent.TorrentMetrics
+	for _, session := range s.client.Sessions() {
+		torrents = append(torrents, session.Metrics())
+	}
+	sort.Slice(torrents, func(i, j int) bool {
+		return bytes.Compare(torrents[i].InfoHash[:], torrents[j].InfoHash[:]) < 0
+	})
+
+	mw := &metricsWriter{}
+	perTorrent := func(name string, metricType string, help string, value func(ptorrent.TorrentMetrics) float64) {
+		mw.family(name, metricType, help)
+		for _, torrent := range torrents {
+			mw.sample(name, value(torrent), "infohash", hex.EncodeToString(torrent.InfoHash[:]))
+		}
+	}
+
+	mw.family("ptorrent_bytes_total", "counter", "Bytes transferred with peers, by direction and kind (payload: blocks of piece messages, protocol: everything else).")
+	for _, torrent := range torrents {
+		infoHash := hex.EncodeToString(torrent.InfoHash[:])
+		mw.sample("ptorrent_bytes_total", float64(torrent.PayloadDownloaded), "infohash", infoHash, "direction", "download", "kind", "payload")
+		mw.sample("ptorrent_bytes_total", float64(torrent.ProtocolDownloaded), "infohash", infoHash, "direction", "download", "kind", "protocol")
+		mw.sample("ptorrent_bytes_total", float64(torrent.PayloadUploaded), "infohash", infoHash, "direction", "upload", "kind", "payload")
+		mw.sample("ptorrent_bytes_total", float64(torrent.ProtocolUploaded), "infohash", infoHash, "direction", "upload", "kind", "protocol")
+	}
+
+	perTorrent("ptorrent_peers_connected", "gauge", "Connected peers.",
+		func(t ptorrent.TorrentMetrics) float64 { return float64(t.PeersConnected) })
+	perTorrent("ptorrent_peers_unchoked", "gauge", "Connected peers we are not choking.",
+		func(t ptorrent.TorrentMetrics) float64 { return float64(t.PeersUnchoked) })
+	perTorrent("ptorrent_peers_interested", "gauge", "Connected peers interested in our pieces.",
+		func(t ptorrent.TorrentMetrics) float64 { return float64(t.PeersInterested) })
+	perTorrent("ptorrent_pieces_verified_total", "counter", "Downloaded pieces that passed the hash check.",
+		func(t ptorrent.TorrentMetrics) float64 { return float64(t.PiecesVerified) })
+	perTorrent("ptorrent_pieces_failed_total", "counter", "Downloaded pieces that failed the hash check.",
+		func(t ptorrent.TorrentMetrics) float64 { return float64(t.PiecesFailed) })
+	perTorrent("ptorrent_peers_banned", "gauge", "Peers banned for sending corrupt pieces.",
+		func(t ptorrent.TorrentMetrics) float64 { return float64(t.PeersBanned) })
+	perTorrent("ptorrent_tracker_announce_errors_total", "counter", "Tracker announces that failed.",
+		func(t ptorrent.TorrentMetrics) float64 { return float64(t.AnnounceErrors) })
+	perTorrent("ptorrent_disk_queue_depth", "gauge", "Verified pieces waiting for a disk worker.",
+		func(t ptorrent.TorrentMetrics) float64 { return float64(t.DiskQueueDepth) })
+
+	mw.family("ptorrent_tracker_announce_duration_seconds", "histogram", "Latency of tracker announces, failed ones included.")
+	for _, torrent := range torrents {
+		infoHash := hex.EncodeToString(torrent.InfoHash[:])
+		latency := torrent.AnnounceLatency
+		for i, upperBound := range latency.UpperBounds {
+			mw.sample("ptorrent_tracker_announce_duration_seconds_bucket", float64(latency.CumulativeCounts[i]),
+				"infohash", infoHash, "le", strconv.FormatFloat(upperBound, 'g', -1, 64))
+		}
+		mw.sample("ptorrent_tracker_announce_duration_seconds_bucket", float64(latency.Count), "infohash", infoHash, "le", "+Inf")
+		mw.sample("ptorrent_tracker_announce_duration_seconds_sum", latency.Sum, "infohash", infoHash)
+		mw.sample("ptorrent_tracker_announce_duration_seconds_count", float64(latency.Count), "infohash", infoHash)
+	}
+
+	mw.family("ptorrent_handshake_failures_total", "counter", "Failed peer handshakes, by reason.")
+	writeHandshakeFailures(mw, "", s.client.HandshakeFailures())
+	for _, torrent := range torrents {
+		writeHandshakeFailures(mw, hex.EncodeToString(torrent.InfoHash[:]), torrent.HandshakeFailures)
+	}
+
+	w.Header().Set("Content-Type", metricsContentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(mw.buffer.Bytes())
+}
+
+// writeHandshakeFailures sorted by reason, so that the output is stable
+func writeHandshakeFailures(mw *metricsWriter, infoHash string, failures map[ptorrent.HandshakeFailureReason]int64) {
+	reasons := make([]string, 0, len(failures))
+	for reason := range failures {
+		reasons = append(reasons, string(reason))
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		mw.sample("ptorrent_handshake_failures_total", float64(failures[ptorrent.HandshakeFailureReason(reason)]),
+			"infohash", infoHash, "reason", reason)
+	}
+}
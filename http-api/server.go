@@ -0,0 +1,165 @@
+package httpApi
+
+import (
+	"bittorrent-client/ptorrent"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/** TOC
+- SERVER
+	- NewServer, ListenAndServe
+- AUTH
+	- authenticate
+- RESPONSES
+	- writeJson, writeError
+*/
+
+/*
+- What is it supposed to do
+- - Controls a headless client over HTTP: torrents are listed, added (as a .torrent file or a magnet link), paused,
+    resumed and removed, and their progress, speeds and peers are read from the engine stats.
+- - A magnet link is answered with `202 Accepted`, here and in the Transmission RPC: the torrent is listed once the
+    engine fetched its metadata from peers.
+- - Reads and changes the download and upload rate limits, of the client and of each torrent, see limits.go.
+- - Streams the client events to `GET /api/v1/events` as Server-Sent Events.
+- - Serves the Transmission RPC protocol, see transmission-rpc.go, and Prometheus metrics, see metrics.go.
+- - Every request needs the token, as `Authorization: Bearer <token>`, as the `token` query parameter for
+    EventSource clients which can not set headers, or as the password of HTTP basic auth for Transmission clients.
+*/
+
+const shutdownTimeout = time.Second * 5
+
+var logger = ptorrent.Logger(ptorrent.SubsystemApi)
+
+type ServerOptions struct {
+	Token      string                     // required
+	AddOptions ptorrent.AddTorrentOptions // used for every torrent added through the API
+}
+
+type Server struct {
+	client     *ptorrent.Client
+	token      string
+	addOptions ptorrent.AddTorrentOptions
+	mux        *http.ServeMux
+	startedAt  time.Time
+}
+
+/************************************** SERVER **************************************/
+
+func NewServer(client *ptorrent.Client, options ServerOptions) (*Server, error) {
+	if options.Token == "" {
+		return nil, errors.New("the http api needs a token")
+	}
+	s := &Server{
+		client:     client,
+		token:      options.Token,
+		addOptions: options.AddOptions,
+		mux:        http.NewServeMux(),
+		startedAt:  time.Now(),
+	}
+	transmissionRpc, err := newTransmissionRpc(s)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mux.HandleFunc("GET /api/v1/stats", s.handleClientStats)
+	s.mux.HandleFunc("GET /api/v1/torrents", s.handleListTorrents)
+	s.mux.HandleFunc("POST /api/v1/torrents", s.handleAddTorrent)
+	s.mux.HandleFunc("GET /api/v1/torrents/{infoHash}", s.handleGetTorrent)
+	s.mux.HandleFunc("DELETE /api/v1/torrents/{infoHash}", s.handleRemoveTorrent)
+	s.mux.HandleFunc("GET /api/v1/torrents/{infoHash}/peers", s.handleListPeers)
+	s.mux.HandleFunc("POST /api/v1/torrents/{infoHash}/pause", s.handlePauseTorrent)
+	s.mux.HandleFunc("POST /api/v1/torrents/{infoHash}/resume", s.handleResumeTorrent)
+	s.mux.HandleFunc("GET /api/v1/limits", s.handleGetLimits)
+	s.mux.HandleFunc("PUT /api/v1/limits", s.handleSetLimits)
+	s.mux.HandleFunc("GET /api/v1/torrents/{infoHash}/limits", s.handleGetTorrentLimits)
+	s.mux.HandleFunc("PUT /api/v1/torrents/{infoHash}/limits", s.handleSetTorrentLimits)
+	s.mux.HandleFunc("GET /api/v1/events", s.handleEvents)
+	s.mux.HandleFunc("GET /metrics", s.handleMetrics)
+	s.mux.HandleFunc(transmissionRpcPath, transmissionRpc.handleTransmissionRpc)
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authenticate(r) {
+		if r.URL.Path == transmissionRpcPath {
+			w.Header().Set("WWW-Authenticate", `Basic realm="ptorrent"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ptorrent"`)
+		}
+		writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves the api till the context is cancelled, then shuts the server down.
+// Event streams are closed once the context is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context, address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: time.Second * 10,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	logger.Info("http api listening", "addr", listener.Addr())
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	select {
+	case err = <-served:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err = server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	logger.Info("http api stopped")
+	return nil
+}
+
+/************************************** AUTH **************************************/
+
+// authenticate the username of basic auth is ignored
+func (s *Server) authenticate(r *http.Request) bool {
+	token := r.URL.Query().Get("token")
+	if header := r.Header.Get("Authorization"); header != "" {
+		if bearer, found := strings.CutPrefix(header, "Bearer "); found {
+			token = bearer
+		} else if _, password, ok := r.BasicAuth(); ok {
+			token = password
+		} else {
+			return false
+		}
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+/************************************** RESPONSES **************************************/
+
+func writeJson(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		logger.Warn("error writing http api response", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJson(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,295 @@
+package httpApi
+
+import (
+	"bittorrent-client/ptorrent"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+const maxTorrentFileSize = 10 << 20
+
+type torrentJson struct {
+	InfoHash     string  `json:"infoHash"`
+	Name         string  `json:"name"`
+	TotalLength  int64   `json:"totalLength"`
+	WantedLength int64   `json:"wantedLength"`
+	Left         int64   `json:"left"`
+	Downloaded   int64   `json:"downloaded"`
+	Uploaded     int64   `json:"uploaded"`
+	NumPieces    int64   `json:"numPieces"`
+	Verified     int64   `json:"piecesVerified"`
+	Progress     float64 `json:"progress"`
+	DownloadRate float64 `json:"downloadRate"`
+	UploadRate   float64 `json:"uploadRate"`
+	NumPeers     int     `json:"numPeers"`
+	Paused       bool    `json:"paused"`
+	Complete     bool    `json:"complete"`
+}
+
+type peerJson struct {
+	PeerId         string  `json:"peerId"`
+	Address        string  `json:"address"`
+	Client         string  `json:"client,omitempty"`
+	Outgoing       bool    `json:"outgoing"`
+	Progress       float64 `json:"progress"`
+	DownloadRate   float64 `json:"downloadRate"`
+	UploadRate     float64 `json:"uploadRate"`
+	AmChoking      bool    `json:"amChoking"`
+	AmInterested   bool    `json:"amInterested"`
+	PeerChoking    bool    `json:"peerChoking"`
+	PeerInterested bool    `json:"peerInterested"`
+	Snubbed        bool    `json:"snubbed"`
+}
+
+type torrentDetailsJson struct {
+	torrentJson
+	Peers       []peerJson `json:"peers"`
+	BannedPeers []string   `json:"bannedPeers"` // IP addresses, banned for sending corrupt pieces
+}
+
+type clientStatsJson struct {
+	NumTorrents    int     `json:"numTorrents"`
+	NumConnections int64   `json:"numConnections"`
+	DownloadRate   float64 `json:"downloadRate"`
+	UploadRate     float64 `json:"uploadRate"`
+}
+
+// magnetJson a torrent added from a magnet link, while its metadata is fetched
+type magnetJson struct {
+	InfoHash string `json:"infoHash"`
+	Name     string `json:"name"` // the display name of the link, "" if not given
+}
+
+func newTorrentJson(stats ptorrent.TorrentStats) torrentJson {
+	return torrentJson{
+		InfoHash:     hex.EncodeToString(stats.InfoHash[:]),
+		Name:         stats.Name,
+		TotalLength:  stats.TotalLength,
+		WantedLength: stats.WantedLength,
+		Left:         stats.Left,
+		Downloaded:   stats.Downloaded,
+		Uploaded:     stats.Uploaded,
+		NumPieces:    stats.NumPieces,
+		Verified:     stats.PiecesVerified,
+		Progress:     stats.Progress,
+		DownloadRate: stats.DownloadRate,
+		UploadRate:   stats.UploadRate,
+		NumPeers:     stats.NumPeers,
+		Paused:       stats.Paused,
+		Complete:     stats.Complete,
+	}
+}
+
+func newPeersJson(peers []ptorrent.PeerStats) []peerJson {
+	peersJson := make([]peerJson, 0, len(peers))
+	for _, peer := range peers {
+		peersJson = append(peersJson, peerJson{
+			PeerId:         peer.PeerId,
+			Address:        peer.Address,
+			Client:         peer.Client,
+			Outgoing:       peer.Outgoing,
+			Progress:       peer.Progress,
+			DownloadRate:   peer.DownloadRate,
+			UploadRate:     peer.UploadRate,
+			AmChoking:      peer.AmChoking,
+			AmInterested:   peer.AmInterested,
+			PeerChoking:    peer.PeerChoking,
+			PeerInterested: peer.PeerInterested,
+			Snubbed:        peer.Snubbed,
+		})
+	}
+	return peersJson
+}
+
+/************************************** HANDLERS **************************************/
+
+func (s *Server) handleClientStats(w http.ResponseWriter, r *http.Request) {
+	stats := s.client.Stats()
+	writeJson(w, http.StatusOK, clientStatsJson{
+		NumTorrents:    stats.NumTorrents,
+		NumConnections: stats.NumConnections,
+		DownloadRate:   stats.DownloadRate,
+		UploadRate:     stats.UploadRate,
+	})
+}
+
+func (s *Server) handleListTorrents(w http.ResponseWriter, r *http.Request) {
+	torrents := make([]torrentJson, 0)
+	for _, session := range s.client.Sessions() {
+		torrents = append(torrents, newTorrentJson(session.Stats()))
+	}
+	writeJson(w, http.StatusOK, torrents)
+}
+
+// handleAddTorrent accepts a .torrent file as a multipart form field `torrent`, or as an application/x-bittorrent body.
+// A magnet link is accepted as the multipart form field `magnet`, or as a json body `{"magnet": "magnet:?..."}`: it is
+// answered with 202 Accepted, the torrent is listed once its metadata is fetched from peers.
+// The torrent is added paused with the query parameter `paused=true`.
+func (s *Server) handleAddTorrent(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxTorrentFileSize)
+	torrent, magnet, err := readTorrent(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	options := s.addOptions
+	if paused, _ := strconv.ParseBool(r.URL.Query().Get("paused")); paused {
+		options.Paused = true
+	}
+	if magnet != nil {
+		s.addMagnet(w, magnet, &options)
+		return
+	}
+
+	if s.client.Session(torrent.InfoHash) != nil || s.client.FetchingMetadata(torrent.InfoHash) {
+		writeError(w, http.StatusConflict, fmt.Errorf("torrent %x is already added", torrent.InfoHash))
+		return
+	}
+	session, err := s.client.AddTorrent(torrent, &options)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJson(w, http.StatusCreated, newTorrentJson(session.Stats()))
+}
+
+func (s *Server) addMagnet(w http.ResponseWriter, magnet *ptorrent.MagnetLink, options *ptorrent.AddTorrentOptions) {
+	if s.client.Session(magnet.InfoHash) != nil || s.client.FetchingMetadata(magnet.InfoHash) {
+		writeError(w, http.StatusConflict, fmt.Errorf("torrent %x is already added", magnet.InfoHash))
+		return
+	}
+	if err := s.client.AddMagnet(magnet, options); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJson(w, http.StatusAccepted, magnetJson{InfoHash: hex.EncodeToString(magnet.InfoHash[:]), Name: magnet.Name})
+}
+
+// readTorrent either the torrent, or the magnet link
+func readTorrent(r *http.Request) (*ptorrent.Torrent, *ptorrent.MagnetLink, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		if link := r.FormValue("magnet"); link != "" {
+			magnet, err := ptorrent.ParseMagnetLink(link)
+			return nil, magnet, err
+		}
+		file, _, err := r.FormFile("torrent")
+		if err != nil {
+			return nil, nil, fmt.Errorf("no 'torrent' file in the form: %v", err)
+		}
+		defer file.Close()
+		torrent, err := ptorrent.LoadTorrent(file)
+		return torrent, nil, err
+	case "application/x-bittorrent":
+		torrent, err := ptorrent.LoadTorrent(r.Body)
+		return torrent, nil, err
+	case "application/json":
+		var body struct {
+			Magnet string `json:"magnet"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return nil, nil, fmt.Errorf("invalid json body: %v", err)
+		}
+		if body.Magnet == "" {
+			return nil, nil, errors.New("a json body needs a 'magnet' link")
+		}
+		magnet, err := ptorrent.ParseMagnetLink(body.Magnet)
+		return nil, magnet, err
+	default:
+		_, _ = io.Copy(io.Discard, r.Body)
+		return nil, nil, fmt.Errorf("unsupported content type %q", mediaType)
+	}
+}
+
+func (s *Server) handleGetTorrent(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.lookupSession(w, r)
+	if !ok {
+		return
+	}
+	writeJson(w, http.StatusOK, torrentDetailsJson{
+		torrentJson: newTorrentJson(session.Stats()),
+		Peers:       newPeersJson(session.Peers()),
+		BannedPeers: session.BannedPeers(),
+	})
+}
+
+func (s *Server) handleListPeers(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.lookupSession(w, r)
+	if !ok {
+		return
+	}
+	writeJson(w, http.StatusOK, newPeersJson(session.Peers()))
+}
+
+func (s *Server) handlePauseTorrent(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.lookupSession(w, r)
+	if !ok {
+		return
+	}
+	session.Pause()
+	writeJson(w, http.StatusOK, newTorrentJson(session.Stats()))
+}
+
+func (s *Server) handleResumeTorrent(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.lookupSession(w, r)
+	if !ok {
+		return
+	}
+	session.Resume()
+	writeJson(w, http.StatusOK, newTorrentJson(session.Stats()))
+}
+
+// handleRemoveTorrent the downloaded data is kept
+// handleRemoveTorrent also cancels the metadata fetch of a magnet link
+func (s *Server) handleRemoveTorrent(w http.ResponseWriter, r *http.Request) {
+	if infoHash, err := parseInfoHash(r.PathValue("infoHash")); err == nil && s.client.FetchingMetadata(infoHash) {
+		if err = s.client.RemoveTorrent(infoHash); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	session, ok := s.lookupSession(w, r)
+	if !ok {
+		return
+	}
+	if err := s.client.RemoveTorrent(session.Torrent().InfoHash); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lookupSession writes the error response if the info-hash of the path is invalid or unknown
+func (s *Server) lookupSession(w http.ResponseWriter, r *http.Request) (*ptorrent.TorrentSession, bool) {
+	infoHash, err := parseInfoHash(r.PathValue("infoHash"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	session := s.client.Session(infoHash)
+	if session == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("torrent %x not found", infoHash))
+		return nil, false
+	}
+	return session, true
+}
+
+func parseInfoHash(value string) ([20]byte, error) {
+	var infoHash [20]byte
+	decoded, err := hex.DecodeString(value)
+	if err != nil || len(decoded) != len(infoHash) {
+		return infoHash, fmt.Errorf("invalid info-hash %q: expected 40 hex digits", value)
+	}
+	copy(infoHash[:], decoded)
+	return infoHash, nil
+}
@@ -0,0 +1,624 @@
+package httpApi
+
+import (
+	"bittorrent-client/ptorrent"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/** TOC
+- RPC
+	- handleTransmissionRpc
+	- session id handshake
+- TORRENT IDS
+	- torrentId, selectSessions
+- METHODS
+	- session-get, session-set, session-stats
+	- torrent-get, torrent-set, torrent-add, torrent-start, torrent-stop, torrent-remove
+	- speed limits
+*/
+
+/*
+- What is it supposed to do
+- - Serves the Transmission JSON-RPC protocol on `/transmission/rpc`, so that Transmission remote GUIs and scripts
+    drive the client unchanged. Clients authenticate with HTTP basic auth, the password being the api token.
+- - Requests without the current `X-Transmission-Session-Id` header are answered with 409 and the id to use, as
+    Transmission does against CSRF.
+- - Transmission identifies torrents by small integers: ids are assigned in the order torrents are first seen, and are
+    stable while the server runs. Info-hashes are accepted wherever an id is.
+- - Only the methods and fields the engine has data for are implemented; unknown fields are left out of the response.
+*/
+
+const transmissionRpcPath = "/transmission/rpc"
+const transmissionSessionIdHeader = "X-Transmission-Session-Id"
+
+const (
+	transmissionRpcVersion        = 17
+	transmissionRpcVersionMinimum = 14
+	transmissionVersion           = "3.00 (ptorrent)"
+)
+
+// torrent status codes of the Transmission RPC
+const (
+	transmissionStatusStopped     = 0
+	transmissionStatusDownloading = 4
+	transmissionStatusSeeding     = 6
+)
+
+const maxTorrentDownloadTime = time.Second * 30
+
+const transmissionSpeedBytes = 1000 // speed limits are in kB/s
+
+type transmissionRequest struct {
+	Method    string          `json:"method"`
+	Arguments json.RawMessage `json:"arguments"`
+	Tag       *int            `json:"tag,omitempty"`
+}
+
+type transmissionResponse struct {
+	Result    string `json:"result"`
+	Arguments any    `json:"arguments"`
+	Tag       *int   `json:"tag,omitempty"`
+}
+
+type transmissionRpc struct {
+	server    *Server
+	sessionId string
+
+	mu     sync.Mutex
+	ids    map[[20]byte]int
+	nextId int
+}
+
+func newTransmissionRpc(server *Server) (*transmissionRpc, error) {
+	randomBytes := make([]byte, 24)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return nil, fmt.Errorf("can not generate a transmission session id: %v", err)
+	}
+	return &transmissionRpc{
+		server:    server,
+		sessionId: base64.RawURLEncoding.EncodeToString(randomBytes),
+		ids:       make(map[[20]byte]int),
+		nextId:    1,
+	}, nil
+}
+
+/************************************** RPC **************************************/
+
+func (rpc *transmissionRpc) handleTransmissionRpc(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(transmissionSessionIdHeader) != rpc.sessionId {
+		w.Header().Set(transmissionSessionIdHeader, rpc.sessionId)
+		http.Error(w, fmt.Sprintf("%s: %s", transmissionSessionIdHeader, rpc.sessionId), http.StatusConflict)
+		return
+	}
+
+	var request transmissionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTorrentFileSize*2)).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(request.Arguments) == 0 {
+		request.Arguments = json.RawMessage("{}")
+	}
+
+	arguments, err := rpc.call(request.Method, request.Arguments)
+	response := transmissionResponse{Result: "success", Arguments: arguments, Tag: request.Tag}
+	if err != nil {
+		logger.Warn("transmission rpc failed", "method", request.Method, "err", err)
+		response.Result = err.Error()
+		response.Arguments = struct{}{}
+	}
+	writeJson(w, http.StatusOK, response)
+}
+
+func (rpc *transmissionRpc) call(method string, arguments json.RawMessage) (any, error) {
+	switch method {
+	case "session-get":
+		return rpc.sessionGet()
+	case "session-set":
+		return rpc.sessionSet(arguments)
+	case "session-stats":
+		return rpc.sessionStats(), nil
+	case "torrent-get":
+		return rpc.torrentGet(arguments)
+	case "torrent-set":
+		return rpc.torrentSet(arguments)
+	case "torrent-add":
+		return rpc.torrentAdd(arguments)
+	case "torrent-start", "torrent-start-now":
+		return rpc.torrentAction(arguments, (*ptorrent.TorrentSession).Resume)
+	case "torrent-stop":
+		return rpc.torrentAction(arguments, (*ptorrent.TorrentSession).Pause)
+	case "torrent-remove":
+		return rpc.torrentRemove(arguments)
+	default:
+		return nil, errors.New("method name not recognized")
+	}
+}
+
+/************************************** TORRENT IDS **************************************/
+
+func (rpc *transmissionRpc) torrentId(infoHash [20]byte) int {
+	rpc.mu.Lock()
+	defer rpc.mu.Unlock()
+
+	id, ok := rpc.ids[infoHash]
+	if !ok {
+		id = rpc.nextId
+		rpc.nextId++
+		rpc.ids[infoHash] = id
+	}
+	return id
+}
+
+// selectSessions the `ids` argument is absent for every torrent, a number, a hash string, "recently-active",
+// or a list of numbers and hash strings
+func (rpc *transmissionRpc) selectSessions(ids json.RawMessage) ([]*ptorrent.TorrentSession, error) {
+	sessions := rpc.server.client.Sessions()
+	if len(ids) == 0 || string(ids) == "null" || string(ids) == `"recently-active"` {
+		return sessions, nil
+	}
+
+	var list []json.RawMessage
+	if ids[0] == '[' {
+		if err := json.Unmarshal(ids, &list); err != nil {
+			return nil, fmt.Errorf("invalid ids: %v", err)
+		}
+	} else {
+		list = []json.RawMessage{ids}
+	}
+
+	wantedIds := make(map[int]bool)
+	wantedHashes := make(map[[20]byte]bool)
+	for _, item := range list {
+		var id int
+		if err := json.Unmarshal(item, &id); err == nil {
+			wantedIds[id] = true
+			continue
+		}
+		var hashString string
+		if err := json.Unmarshal(item, &hashString); err != nil {
+			return nil, fmt.Errorf("invalid torrent id %s", item)
+		}
+		infoHash, err := parseInfoHash(hashString)
+		if err != nil {
+			return nil, err
+		}
+		wantedHashes[infoHash] = true
+	}
+
+	var selected []*ptorrent.TorrentSession
+	for _, session := range sessions {
+		infoHash := session.Torrent().InfoHash
+		if wantedHashes[infoHash] || wantedIds[rpc.torrentId(infoHash)] {
+			selected = append(selected, session)
+		}
+	}
+	return selected, nil
+}
+
+/************************************** METHODS **************************************/
+
+func (rpc *transmissionRpc) sessionGet() (map[string]any, error) {
+	downloadDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	options := rpc.server.client.Options()
+	addOptions := rpc.server.addOptions
+	arguments := map[string]any{
+		"version":                  transmissionVersion,
+		"rpc-version":              transmissionRpcVersion,
+		"rpc-version-minimum":      transmissionRpcVersionMinimum,
+		"session-id":               rpc.sessionId,
+		"download-dir":             downloadDir,
+		"incomplete-dir":           addOptions.IncompleteDir,
+		"incomplete-dir-enabled":   addOptions.IncompleteDir != "",
+		"rename-partial-files":     addOptions.PartSuffix,
+		"peer-port":                options.ListenerPort,
+		"peer-limit-global":        max(options.MaxConnections, 0), // 0 for unlimited
+		"peer-limit-per-torrent":   max(options.MaxPeersPerTorrent, 0),
+		"speed-limit-down":         options.MaxDownloadRate / transmissionSpeedBytes,
+		"speed-limit-down-enabled": options.MaxDownloadRate > 0,
+		"speed-limit-up":           options.MaxUploadRate / transmissionSpeedBytes,
+		"speed-limit-up-enabled":   options.MaxUploadRate > 0,
+		"alt-speed-enabled":        false,
+		"alt-speed-time-enabled":   false,
+		"units": map[string]any{
+			"speed-units":  []string{"kB/s", "MB/s", "GB/s", "TB/s"},
+			"speed-bytes":  transmissionSpeedBytes,
+			"size-units":   []string{"kB", "MB", "GB", "TB"},
+			"size-bytes":   1000,
+			"memory-units": []string{"KiB", "MiB", "GiB", "TiB"},
+			"memory-bytes": 1024,
+		},
+	}
+	// speed-limit-* are the normal limits, alt-speed-* the alternate ones, in effect if alt-speed-enabled
+	if scheduler := rpc.server.client.SpeedScheduler(); scheduler != nil {
+		scheduleOptions := scheduler.Options()
+		arguments["alt-speed-enabled"] = scheduler.Alternate()
+		arguments["alt-speed-time-enabled"] = true
+		arguments["alt-speed-down"] = scheduleOptions.MaxDownloadRate / transmissionSpeedBytes
+		arguments["alt-speed-up"] = scheduleOptions.MaxUploadRate / transmissionSpeedBytes
+	}
+	return arguments, nil
+}
+
+func (rpc *transmissionRpc) sessionStats() map[string]any {
+	var active, paused int
+	var downloaded, uploaded int64
+	for _, session := range rpc.server.client.Sessions() {
+		stats := session.Stats()
+		if stats.Paused {
+			paused++
+		} else {
+			active++
+		}
+		downloaded += stats.Downloaded
+		uploaded += stats.Uploaded
+	}
+	clientStats := rpc.server.client.Stats()
+	// transfer totals are not kept across runs, so the cumulative stats are the ones of the current run
+	currentStats := map[string]any{
+		"downloadedBytes": downloaded,
+		"uploadedBytes":   uploaded,
+		"filesAdded":      active + paused,
+		"sessionCount":    1,
+		"secondsActive":   int64(time.Since(rpc.server.startedAt).Seconds()),
+	}
+	return map[string]any{
+		"activeTorrentCount": active,
+		"pausedTorrentCount": paused,
+		"torrentCount":       active + paused,
+		"downloadSpeed":      int64(clientStats.DownloadRate),
+		"uploadSpeed":        int64(clientStats.UploadRate),
+		"current-stats":      currentStats,
+		"cumulative-stats":   currentStats,
+	}
+}
+
+func (rpc *transmissionRpc) torrentGet(arguments json.RawMessage) (map[string]any, error) {
+	var args struct {
+		Ids    json.RawMessage `json:"ids"`
+		Fields []string        `json:"fields"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %v", err)
+	}
+	sessions, err := rpc.selectSessions(args.Ids)
+	if err != nil {
+		return nil, err
+	}
+
+	torrents := make([]map[string]any, 0, len(sessions))
+	for _, session := range sessions {
+		all := rpc.torrentFields(session)
+		torrent := make(map[string]any, len(args.Fields))
+		for _, field := range args.Fields {
+			if value, ok := all[field]; ok {
+				torrent[field] = value
+			}
+		}
+		if len(args.Fields) == 0 {
+			torrent = all
+		}
+		torrents = append(torrents, torrent)
+	}
+	return map[string]any{"torrents": torrents}, nil
+}
+
+// torrentFields every torrent-get field the engine has data for
+func (rpc *transmissionRpc) torrentFields(session *ptorrent.TorrentSession) map[string]any {
+	stats := session.Stats()
+	torrent := session.Torrent()
+
+	status := transmissionStatusDownloading
+	if stats.Paused {
+		status = transmissionStatusStopped
+	} else if stats.Complete {
+		status = transmissionStatusSeeding
+	}
+	eta := int64(-1)
+	if stats.DownloadRate > 0 && !stats.Complete {
+		eta = int64(float64(stats.Left) / stats.DownloadRate)
+	}
+	uploadRatio := float64(-1)
+	if stats.Downloaded > 0 {
+		uploadRatio = float64(stats.Uploaded) / float64(stats.Downloaded)
+	}
+	downloadDir, _ := os.Getwd()
+	maxDownloadRate, maxUploadRate := session.RateLimits()
+
+	peers := session.Peers()
+	var peersGettingFromUs, peersSendingToUs int
+	peersJson := make([]map[string]any, 0, len(peers))
+	for _, peer := range peers {
+		host, portStr, _ := net.SplitHostPort(peer.Address)
+		port, _ := strconv.Atoi(portStr)
+		isDownloadingFrom := peer.AmInterested && !peer.PeerChoking
+		isUploadingTo := peer.PeerInterested && !peer.AmChoking
+		if isDownloadingFrom {
+			peersSendingToUs++
+		}
+		if isUploadingTo {
+			peersGettingFromUs++
+		}
+		peersJson = append(peersJson, map[string]any{
+			"address":            host,
+			"port":               port,
+			"clientName":         peerClientName(peer.PeerId),
+			"rateToClient":       int64(peer.DownloadRate),
+			"rateToPeer":         int64(peer.UploadRate),
+			"isDownloadingFrom":  isDownloadingFrom,
+			"isUploadingTo":      isUploadingTo,
+			"clientIsChoked":     peer.PeerChoking,
+			"clientIsInterested": peer.AmInterested,
+			"peerIsChoked":       peer.AmChoking,
+			"peerIsInterested":   peer.PeerInterested,
+			"isIncoming":         !peer.Outgoing,
+			"isEncrypted":        false,
+			"flagStr":            "",
+		})
+	}
+
+	return map[string]any{
+		"id":                      rpc.torrentId(stats.InfoHash),
+		"hashString":              hex.EncodeToString(stats.InfoHash[:]),
+		"name":                    stats.Name,
+		"status":                  status,
+		"error":                   0,
+		"errorString":             "",
+		"totalSize":               stats.TotalLength,
+		"sizeWhenDone":            stats.WantedLength,
+		"leftUntilDone":           stats.Left,
+		"haveValid":               stats.WantedLength - stats.Left,
+		"percentDone":             stats.Progress,
+		"metadataPercentComplete": 1,
+		"isFinished":              false,
+		"isPrivate":               torrent.Info.Private,
+		"rateDownload":            int64(stats.DownloadRate),
+		"rateUpload":              int64(stats.UploadRate),
+		"downloadedEver":          stats.Downloaded,
+		"uploadedEver":            stats.Uploaded,
+		"uploadRatio":             uploadRatio,
+		"eta":                     eta,
+		"pieceCount":              stats.NumPieces,
+		"pieceSize":               torrent.Info.PieceLength,
+		"addedDate":               stats.AddedAt.Unix(),
+		"downloadDir":             downloadDir,
+		"comment":                 torrent.Comment,
+		"creator":                 torrent.CreatedBy,
+		"dateCreated":             torrent.CreationDate.Unix(),
+		"peersConnected":          stats.NumPeers,
+		"maxConnectedPeers":       session.MaxPeers(),
+		"peersGettingFromUs":      peersGettingFromUs,
+		"peersSendingToUs":        peersSendingToUs,
+		"peers":                   peersJson,
+		"queuePosition":           0,
+		"downloadLimit":           maxDownloadRate / transmissionSpeedBytes,
+		"downloadLimited":         maxDownloadRate > 0,
+		"uploadLimit":             maxUploadRate / transmissionSpeedBytes,
+		"uploadLimited":           maxUploadRate > 0,
+	}
+}
+
+// peerClientName e.g. "PTC 001" for the Azureus-style peer id "-PTC001-..."
+func peerClientName(peerIdHex string) string {
+	peerId, err := hex.DecodeString(peerIdHex)
+	if err != nil || len(peerId) < 8 || peerId[0] != '-' || peerId[7] != '-' {
+		return ""
+	}
+	return string(peerId[1:3]) + " " + string(peerId[3:7])
+}
+
+func (rpc *transmissionRpc) torrentAdd(arguments json.RawMessage) (map[string]any, error) {
+	var args struct {
+		Filename    string `json:"filename"`
+		Metainfo    string `json:"metainfo"`
+		Paused      bool   `json:"paused"`
+		DownloadDir string `json:"download-dir"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %v", err)
+	}
+	if args.DownloadDir != "" {
+		if downloadDir, err := os.Getwd(); err != nil || args.DownloadDir != downloadDir {
+			return nil, fmt.Errorf("download-dir %q is not supported, torrents are downloaded to the session download-dir", args.DownloadDir)
+		}
+	}
+
+	options := rpc.server.addOptions
+	options.Paused = args.Paused
+	if strings.HasPrefix(args.Filename, "magnet:") {
+		return rpc.addMagnet(args.Filename, &options)
+	}
+
+	torrent, err := loadTransmissionTorrent(args.Filename, args.Metainfo)
+	if err != nil {
+		return nil, err
+	}
+	added := transmissionAdded(rpc.torrentId(torrent.InfoHash), torrent.Info.Name, torrent.InfoHash)
+	if rpc.server.client.Session(torrent.InfoHash) != nil || rpc.server.client.FetchingMetadata(torrent.InfoHash) {
+		return added("torrent-duplicate"), nil
+	}
+	if _, err = rpc.server.client.AddTorrent(torrent, &options); err != nil {
+		return nil, err
+	}
+	return added("torrent-added"), nil
+}
+
+// addMagnet the torrent is listed by torrent-get once its metadata is fetched from peers; its name is the display name
+// of the link, or its info-hash
+func (rpc *transmissionRpc) addMagnet(link string, options *ptorrent.AddTorrentOptions) (map[string]any, error) {
+	magnet, err := ptorrent.ParseMagnetLink(link)
+	if err != nil {
+		return nil, err
+	}
+	name := magnet.Name
+	if name == "" {
+		name = hex.EncodeToString(magnet.InfoHash[:])
+	}
+	added := transmissionAdded(rpc.torrentId(magnet.InfoHash), name, magnet.InfoHash)
+	if rpc.server.client.Session(magnet.InfoHash) != nil || rpc.server.client.FetchingMetadata(magnet.InfoHash) {
+		return added("torrent-duplicate"), nil
+	}
+	if err = rpc.server.client.AddMagnet(magnet, options); err != nil {
+		return nil, err
+	}
+	return added("torrent-added"), nil
+}
+
+// transmissionAdded the response of torrent-add, under the key "torrent-added" or "torrent-duplicate"
+func transmissionAdded(id int, name string, infoHash [20]byte) func(key string) map[string]any {
+	return func(key string) map[string]any {
+		return map[string]any{key: map[string]any{
+			"id":         id,
+			"name":       name,
+			"hashString": hex.EncodeToString(infoHash[:]),
+		}}
+	}
+}
+
+// loadTransmissionTorrent from the base64 encoded metainfo, or the filename: a path on the server or an http(s) url;
+// magnet links are added by addMagnet
+func loadTransmissionTorrent(filename string, metainfo string) (*ptorrent.Torrent, error) {
+	switch {
+	case metainfo != "":
+		data, err := base64.StdEncoding.DecodeString(metainfo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metainfo: %v", err)
+		}
+		return ptorrent.LoadTorrent(bytes.NewReader(data))
+	case strings.HasPrefix(filename, "http://") || strings.HasPrefix(filename, "https://"):
+		httpClient := http.Client{Timeout: maxTorrentDownloadTime}
+		resp, err := httpClient.Get(filename)
+		if err != nil {
+			return nil, fmt.Errorf("can not download torrent: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("can not download torrent: %s", resp.Status)
+		}
+		return ptorrent.LoadTorrent(io.LimitReader(resp.Body, maxTorrentFileSize))
+	case filename != "":
+		return ptorrent.LoadTorrentFile(filename)
+	default:
+		return nil, errors.New("no filename or metainfo given")
+	}
+}
+
+func (rpc *transmissionRpc) torrentAction(arguments json.RawMessage, action func(*ptorrent.TorrentSession)) (map[string]any, error) {
+	var args struct {
+		Ids json.RawMessage `json:"ids"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %v", err)
+	}
+	sessions, err := rpc.selectSessions(args.Ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		action(session)
+	}
+	return map[string]any{}, nil
+}
+
+// torrentRemove the downloaded data is kept; deleting it is refused
+func (rpc *transmissionRpc) torrentRemove(arguments json.RawMessage) (map[string]any, error) {
+	var args struct {
+		Ids             json.RawMessage `json:"ids"`
+		DeleteLocalData bool            `json:"delete-local-data"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %v", err)
+	}
+	if args.DeleteLocalData {
+		return nil, errors.New("delete-local-data is not supported, the data of removed torrents is kept")
+	}
+	sessions, err := rpc.selectSessions(args.Ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		if err = rpc.server.client.RemoveTorrent(session.Torrent().InfoHash); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]any{}, nil
+}
+
+/************************************** SPEED LIMITS **************************************/
+
+// sessionSet only the speed limits can be changed
+func (rpc *transmissionRpc) sessionSet(arguments json.RawMessage) (map[string]any, error) {
+	var args struct {
+		SpeedLimitDown        *int64 `json:"speed-limit-down"`
+		SpeedLimitDownEnabled *bool  `json:"speed-limit-down-enabled"`
+		SpeedLimitUp          *int64 `json:"speed-limit-up"`
+		SpeedLimitUpEnabled   *bool  `json:"speed-limit-up-enabled"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %v", err)
+	}
+	maxDownloadRate, maxUploadRate := rpc.server.client.RateLimits()
+	rpc.server.client.SetRateLimits(
+		transmissionLimit(maxDownloadRate, args.SpeedLimitDown, args.SpeedLimitDownEnabled),
+		transmissionLimit(maxUploadRate, args.SpeedLimitUp, args.SpeedLimitUpEnabled))
+	return map[string]any{}, nil
+}
+
+// torrentSet only the speed limits can be changed
+func (rpc *transmissionRpc) torrentSet(arguments json.RawMessage) (map[string]any, error) {
+	var args struct {
+		Ids             json.RawMessage `json:"ids"`
+		DownloadLimit   *int64          `json:"downloadLimit"`
+		DownloadLimited *bool           `json:"downloadLimited"`
+		UploadLimit     *int64          `json:"uploadLimit"`
+		UploadLimited   *bool           `json:"uploadLimited"`
+		PeerLimit       *int            `json:"peer-limit"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %v", err)
+	}
+	sessions, err := rpc.selectSessions(args.Ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		maxDownloadRate, maxUploadRate := session.RateLimits()
+		session.SetRateLimits(
+			transmissionLimit(maxDownloadRate, args.DownloadLimit, args.DownloadLimited),
+			transmissionLimit(maxUploadRate, args.UploadLimit, args.UploadLimited))
+		if args.PeerLimit != nil {
+			session.SetMaxPeers(*args.PeerLimit)
+		}
+	}
+	return map[string]any{}, nil
+}
+
+// transmissionLimit the rate in bytes per second, from a limit in kB/s and its enabled flag. Transmission keeps the
+// value of a disabled limit, the engine does not: enabling a limit without a value keeps the current rate.
+func transmissionLimit(currentRate int64, limit *int64, enabled *bool) int64 {
+	rate := currentRate
+	if limit != nil {
+		rate = max(*limit, 0) * transmissionSpeedBytes
+	}
+	if enabled != nil && !*enabled {
+		rate = 0
+	}
+	return rate
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bittorrent-client/ptorrent"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// runInspect prints the disk path of every file of the torrent, or the reason the torrent is rejected
+func runInspect(args []string) {
+	flagSet := flag.NewFlagSet("inspect", flag.ExitOnError)
+	_ = flagSet.Parse(args)
+	if flagSet.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	torrent, err := ptorrent.LoadTorrentFile(flagSet.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("name: %q\ninfo-hash: %x\n", torrent.Info.Name, torrent.InfoHash)
+
+	paths, err := ptorrent.SanitizeTorrentPaths(torrent)
+	if err != nil {
+		fmt.Printf("rejected: %v\n", err)
+		os.Exit(1)
+	}
+
+	lengths := []int64{torrent.Info.Length}
+	if torrent.StructureType == ptorrent.MultiFile {
+		lengths = lengths[:0]
+		for _, torrentFile := range torrent.Info.Files {
+			lengths = append(lengths, torrentFile.Length)
+		}
+	}
+	for fileIndex, path := range paths.Files {
+		fmt.Printf("%4d  %12d  %s\n", fileIndex, lengths[fileIndex], filepath.Join(append([]string{paths.BaseDir}, path...)...))
+	}
+}
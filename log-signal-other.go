@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// notifyVerboseToggle there is no SIGUSR1, verbose logging can only be set with -verbose
+func notifyVerboseToggle(toggle chan<- os.Signal) {}
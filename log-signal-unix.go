@@ -0,0 +1,14 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyVerboseToggle SIGUSR1 toggles verbose logging
+func notifyVerboseToggle(toggle chan<- os.Signal) {
+	signal.Notify(toggle, syscall.SIGUSR1)
+}
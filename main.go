@@ -1,137 +1,308 @@
 package main
 
 import (
+	httpApi "bittorrent-client/http-api"
+	"bittorrent-client/ptorrent"
+	"bittorrent-client/tui"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"os"
-	"sync"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+const usage = `usage:
+  bittorrent-client download [-recheck] [-priorities <index>=<priority>,...] [-api-listen <address>] [-tui] <torrent-file>...
+  bittorrent-client create [options] <file-or-directory>
+  bittorrent-client inspect <torrent-file>
+`
+
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	// Open the file
-	fileName := "test-torrents/ubuntu-server.torrent"
-	file, err := os.Open(fileName)
-	if err != nil {
-		log.Fatalf("[fatal] error opening file %s: %v", fileName, err)
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
 	}
-	log.Printf("file %s open successful", fileName)
 
-	var wg sync.WaitGroup
-	// Loads the file into a 'torrent' object
-	torrent, err := LoadTorrent(file)
-	if err != nil {
-		log.Fatalf("[fatal] error parsing torrent")
+	switch os.Args[1] {
+	case "download":
+		runDownload(os.Args[2:])
+	case "create":
+		runCreate(os.Args[2:])
+	case "inspect":
+		runInspect(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func runDownload(args []string) {
+	flagSet := flag.NewFlagSet("download", flag.ExitOnError)
+	recheck := flagSet.Bool("recheck", false, "ignore the resume file and hash-check all existing data")
+	incompleteDir := flagSet.String("incomplete-dir", "", "write incomplete files below this directory, moving them once complete")
+	partSuffix := flagSet.Bool("part-suffix", false, "write incomplete files with a .part suffix, renaming them once complete")
+	allocation := flagSet.String("allocation", string(ptorrent.AllocateSparse), "file allocation mode: sparse, full or none")
+	storageType := flagSet.String("storage", string(ptorrent.FileStorageType), "storage backend: file or mmap")
+	maxOpenFiles := flagSet.Int("max-open-files", ptorrent.DefaultMaxOpenFiles, "maximum number of file handles kept open, across all torrents")
+	filePriorities := flagSet.String("priorities", "", "comma separated file priorities, e.g. 0=skip,3=high (skip, low, normal, high); only with a single torrent")
+	port := flagSet.Uint("port", ptorrent.DefaultListenerPort, "port for incoming connections, shared by all torrents")
+	maxConnections := flagSet.Int("max-connections", ptorrent.DefaultMaxConnections, "maximum number of peer connections across all torrents, 0 for unlimited")
+	maxPeers := flagSet.Int("max-peers", ptorrent.DefaultMaxPeersPerTorrent, "maximum number of peers of each torrent, 0 for unlimited")
+	peerTimeout := flagSet.Duration("peer-timeout", ptorrent.DefaultPeerTimeout, "drop peers that sent nothing for this long")
+	maxBadPieces := flagSet.Int("max-bad-pieces", ptorrent.DefaultMaxBadPieces, "ban peers after they sent this many pieces failing the hash check")
+	maxHalfOpen := flagSet.Int("max-half-open", ptorrent.DefaultMaxHalfOpen, "maximum number of peers being dialed at once, across all torrents, 0 for unlimited")
+	maxDownload := flagSet.Int64("max-download", 0, "maximum download rate in B/s across all torrents, 0 for unlimited")
+	maxUpload := flagSet.Int64("max-upload", 0, "maximum upload rate in B/s across all torrents, 0 for unlimited")
+	altSchedule := flagSet.String("alt-schedule", "", "weekly schedule of the alternate speed limits, e.g. 'mon-fri 09:00-18:00; sat 10:00-12:00' (local time)")
+	altMaxDownload := flagSet.Int64("alt-max-download", 0, "maximum download rate in B/s across all torrents while the alternate schedule applies, 0 for unlimited")
+	altMaxUpload := flagSet.Int64("alt-max-upload", 0, "maximum upload rate in B/s across all torrents while the alternate schedule applies, 0 for unlimited")
+	altPause := flagSet.Bool("alt-pause", false, "pause every running torrent while the alternate schedule applies")
+	shutdownTimeout := flagSet.Duration("shutdown-timeout", 10*time.Second, "maximum time to stop the torrents on SIGINT/SIGTERM")
+	apiListen := flagSet.String("api-listen", "", "serve the http control api on this address, e.g. 127.0.0.1:9080")
+	apiToken := flagSet.String("api-token", os.Getenv("PTORRENT_API_TOKEN"), "token of the http control api; generated if empty")
+	showDashboard := flagSet.Bool("tui", false, "show a terminal dashboard instead of the log; the log is written to -log-file")
+	logFile := flagSet.String("log-file", "ptorrent.log", "file the log is written to while the dashboard is shown")
+	logLevel := flagSet.String("log-level", "", "comma separated log levels, e.g. info,tracker=debug (client, tracker, peer, disk, choker, picker, api); warn by default")
+	verbose := flagSet.Bool("verbose", false, "log everything at debug level; SIGUSR1 toggles it at runtime")
+	_ = flagSet.Parse(args)
+	// with the http api, torrents can be added later
+	if flagSet.NArg() < 1 && *apiListen == "" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if *filePriorities != "" && flagSet.NArg() != 1 {
+		log.Fatalf("[fatal] -priorities can only be used with a single torrent")
 	}
-	log.Printf("torrent parsed and loaded")
-	log.Print("The loaded torrent is : ", torrent)
 
-	// Generates a local peerConnection ID
-	localPeerId, err := generateLocalPeerId()
+	allocationMode, err := ptorrent.ParseAllocationMode(*allocation)
 	if err != nil {
-		log.Fatalf("[fatal] can not generate local peerConnection id: %v", err)
+		log.Fatalf("[fatal] %v", err)
 	}
-	log.Printf("local Peer Id generated")
 
-	// Starts a torrent session
-	torrentSession, err := NewTorrentSession(torrent, localPeerId)
+	var altRules []ptorrent.ScheduleRule
+	if *altSchedule != "" {
+		if altRules, err = ptorrent.ParseSpeedSchedule(*altSchedule); err != nil {
+			log.Fatalf("[fatal] %v", err)
+		}
+	}
+
+	logLevels, err := ptorrent.ParseLogLevels(*logLevel)
 	if err != nil {
-		log.Fatalf("[fatal] can not start a torrent session")
+		log.Fatalf("[fatal] %v", err)
 	}
-	log.Printf("torrent session created")
+	startVerboseToggle(logLevels, *verbose)
 
-	/************************ LISTENER ************************/
+	// log lines would be drawn over the dashboard
+	if *showDashboard {
+		file, err := os.OpenFile(*logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatalf("[fatal] can not open the log file: %v", err)
+		}
+		defer file.Close()
+		log.SetOutput(file)
+		ptorrent.SetLogOutput(file)
+	}
+
+	/************************ CLIENT ************************/
 
-	listener, err := CreateAndMountListener(torrentSession)
+	client, err := ptorrent.NewClient(&ptorrent.ClientOptions{
+		ListenerPort:       uint16(*port),
+		MaxConnections:     unlimitedIfZero(*maxConnections),
+		MaxHalfOpen:        unlimitedIfZero(*maxHalfOpen),
+		MaxPeersPerTorrent: unlimitedIfZero(*maxPeers),
+		MaxDownloadRate:    *maxDownload,
+		MaxUploadRate:      *maxUpload,
+		MaxOpenFiles:       *maxOpenFiles,
+		PeerTimeout:        *peerTimeout,
+		MaxBadPieces:       *maxBadPieces,
+	})
 	if err != nil {
-		log.Fatalf("error mounting listener on port %d: %v", torrentSession.configurable.listenerPort, err)
+		log.Fatalf("[fatal] can not create client: %v", err)
+	}
+	if err = client.Listen(); err != nil {
+		log.Fatalf("[fatal] %v", err)
 	}
-	log.Printf("listener mounted on port %d", torrentSession.configurable.listenerPort)
 
-	// Mounts the TPC listeners, to listen for handshakes
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err = listener.StartListening(torrentSession); err != nil {
-			log.Fatalf("error starting listener: %v", err)
+	// sends 'stopped' announces, flushes resume data and closes files on shutdown
+	signalChannel := make(chan os.Signal, 2)
+	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM)
+
+	/************************ TORRENTS ************************/
+
+	for _, fileName := range flagSet.Args() {
+		torrent, err := ptorrent.LoadTorrentFile(fileName)
+		if err != nil {
+			log.Fatalf("[fatal] %v", err)
 		}
-	}()
-	log.Printf("listener started")
-	defer listener.CloseListener()
+		log.Print("The loaded torrent is : ", torrent)
 
-	/************************ STATE HANDLER ************************/
+		numFiles := 1
+		if torrent.StructureType == ptorrent.MultiFile {
+			numFiles = len(torrent.Info.Files)
+		}
+		priorities, err := ptorrent.ParseFilePriorities(*filePriorities, numFiles)
+		if err != nil {
+			log.Fatalf("[fatal] invalid file priorities: %v", err)
+		}
 
-	state := NewTorrentState(torrent.Info.Length)
-	torrentSession.state = state
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		state.StateHandler()
-	}()
+		_, err = client.AddTorrent(torrent, &ptorrent.AddTorrentOptions{
+			Priorities:    priorities,
+			StorageType:   ptorrent.StorageType(*storageType),
+			Allocation:    allocationMode,
+			IncompleteDir: *incompleteDir,
+			PartSuffix:    *partSuffix,
+			Recheck:       *recheck,
+		})
+		if err != nil {
+			log.Fatalf("[fatal] can not add torrent %s: %v", fileName, err)
+		}
+		log.Printf("torrent %s added", fileName)
+	}
 
-	/************************ TRACKER REQUEST/RESPONSE/POLLING ************************/
+	// after the torrents are added, so that they are paused if the schedule applies already
+	if altRules != nil {
+		_, err = client.StartSpeedSchedule(ptorrent.SpeedScheduleOptions{
+			Rules:           altRules,
+			MaxDownloadRate: *altMaxDownload,
+			MaxUploadRate:   *altMaxUpload,
+			PauseTorrents:   *altPause,
+		})
+		if err != nil {
+			log.Fatalf("[fatal] %v", err)
+		}
+	}
 
-	trackerClient := NewTrackerClient(torrent, torrentSession)
-	torrentSession.trackerClient = trackerClient
-	// Explicitly handling first tracker response
-	trackerResponse, err := trackerClient.GetTrackerResponse(0, 0, torrent.Info.Length)
-	if err != nil {
-		log.Fatalf("[fatal] error getting response from tracker: %v", err)
-		return
+	/************************ HTTP API ************************/
+
+	apiCtx, stopApi := context.WithCancel(context.Background())
+	var apiStopped chan struct{}
+	if *apiListen != "" {
+		apiStopped = startApi(apiCtx, client, *apiListen, *apiToken, ptorrent.AddTorrentOptions{
+			StorageType:   ptorrent.StorageType(*storageType),
+			Allocation:    allocationMode,
+			IncompleteDir: *incompleteDir,
+			PartSuffix:    *partSuffix,
+		})
 	}
-	log.Printf("first tracker response fetched")
-	log.Printf("number of peers obtained : %d", len(trackerResponse.Peers))
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		log.Printf("starting tracker response handler")
-		trackerClient.HandleTrackerResponse(trackerResponse, torrentSession)
-	}()
+	/************************ DASHBOARD ************************/
 
-	trackerClient.SetTrackerPolling()
-	log.Printf("tracker poll ticker started")
+	var dashboardQuit <-chan struct{}
+	stopDashboard := func() {}
+	if *showDashboard {
+		dashboardQuit, stopDashboard = startDashboard(client)
+	}
 
-	// For all peers in the tracker list, perform a handshake
-	wg.Add(1)
+	select {
+	case sig := <-signalChannel:
+		log.Printf("received signal %v", sig)
+	case <-dashboardQuit:
+		log.Printf("quit from the dashboard")
+	}
+	// the shutdown is logged to the terminal again
+	stopDashboard()
+	log.SetOutput(os.Stderr)
+	ptorrent.SetLogOutput(os.Stderr)
+
+	stopApi()
+	if apiStopped != nil {
+		<-apiStopped
+	}
+	log.Printf("shutting down within %v; send SIGINT again to exit immediately", *shutdownTimeout)
 	go func() {
-		defer wg.Done()
-		log.Printf("starting tracker poll handler")
-		trackerClient.TrackerPollHandler(torrentSession)
+		<-signalChannel
+		log.Fatalf("[fatal] received a second signal, exiting without finishing the shutdown")
 	}()
 
-	/************************ RATE-TRACKER ************************/
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err = client.Close(ctx); err != nil {
+		log.Printf("shutdown incomplete: %v", err)
+		os.Exit(1)
+	}
+	log.Printf("shutdown complete")
+}
 
-	rateTracker := NewRateTracker()
-	torrentSession.rateTracker = rateTracker
+// unlimitedIfZero the flags take 0 for unlimited, the client options take it for the default
+func unlimitedIfZero(limit int) int {
+	if limit == 0 {
+		return ptorrent.Unlimited
+	}
+	return limit
+}
 
-	rateTracker.SetRateTrackerTicker()
-	log.Printf("rate tracker ticker started")
+// startVerboseToggle applies the log levels, or debug everywhere if verbose, and switches between the two on SIGUSR1
+func startVerboseToggle(levels map[ptorrent.Subsystem]slog.Level, verbose bool) {
+	apply := func(verbose bool) {
+		if verbose {
+			ptorrent.SetAllLogLevels(slog.LevelDebug)
+			return
+		}
+		ptorrent.SetAllLogLevels(ptorrent.DefaultLogLevel)
+		for subsystem, level := range levels {
+			_ = ptorrent.SetLogLevel(subsystem, level)
+		}
+	}
+	apply(verbose)
 
-	wg.Add(1)
+	toggle := make(chan os.Signal, 1)
+	notifyVerboseToggle(toggle)
 	go func() {
-		defer wg.Done()
-		log.Printf("starting speed calculator")
-		rateTracker.StartTotalSpeedCalculator()
+		for range toggle {
+			verbose = !verbose
+			apply(verbose)
+			log.Printf("verbose logging: %v", verbose)
+		}
 	}()
+}
 
-	/************************ QUITTER ************************/
-
-	wg.Add(1)
+// startDashboard draws the dashboard till `q` is pressed, which closes the returned channel, or till it is stopped.
+// Stopping waits for the terminal to be restored.
+func startDashboard(client *ptorrent.Client) (<-chan struct{}, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	quit := make(chan struct{})
+	dashboard := tui.NewDashboard(client, os.Stdin, os.Stdout, tui.Options{})
 	go func() {
-		defer wg.Done()
-		log.Printf("starting quitter")
-		torrentSession.StartQuitter()
+		defer close(quit)
+		dashboard.Run(ctx)
 	}()
 
-	/************************ TORRENT-FILE-SYSTEM ************************/
+	stop := func() {
+		cancel()
+		<-quit
+	}
+	return quit, stop
+}
 
-	//_, err = CreateTorrentFileSystem(torrent)
-	//if err != nil {
-	//	log.Fatalf("[fatal] can not create a torrent file system: %v", err)
-	//}
-	//log.Printf("created torrent file system")
-	log.Printf("torrent file system disabled")
+// startApi serves the http control api till the context is cancelled; the returned channel is closed once it stopped
+func startApi(ctx context.Context, client *ptorrent.Client, address string, token string, addOptions ptorrent.AddTorrentOptions) chan struct{} {
+	if token == "" {
+		randomBytes := make([]byte, 16)
+		if _, err := rand.Read(randomBytes); err != nil {
+			log.Fatalf("[fatal] can not generate an api token: %v", err)
+		}
+		token = hex.EncodeToString(randomBytes)
+		fmt.Fprintf(os.Stderr, "http api token: %s\n", token)
+	}
+	server, err := httpApi.NewServer(client, httpApi.ServerOptions{Token: token, AddOptions: addOptions})
+	if err != nil {
+		log.Fatalf("[fatal] %v", err)
+	}
 
-	wg.Wait()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if err := server.ListenAndServe(ctx, address); err != nil {
+			log.Fatalf("[fatal] http api: %v", err)
+		}
+	}()
+	return stopped
 }
@@ -1,8 +1,7 @@
-package main
+package ptorrent
 
 import (
 	"encoding/binary"
-	"log"
 	"math/bits"
 	"sync"
 )
@@ -82,6 +81,7 @@ func (b *Bitset) String() string {
 	return res
 }
 
+// Serialize the bitset as a wire bitfield of ceil(size / 8) bytes
 func (b *Bitset) Serialize() []byte {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
@@ -90,46 +90,56 @@ func (b *Bitset) Serialize() []byte {
 	for i, ele := range b.bits {
 		binary.BigEndian.PutUint64(res[i*8:(i+1)*8], ele)
 	}
-	return res
+	return res[:ceilDiv(b.size, 8)]
 }
 
-func (b *Bitset) checkOutOfBounds(v uint) {
+func (b *Bitset) checkOutOfBounds(v uint) error {
 	if v >= b.size {
-		log.Fatalf("bitset: out of bounds error")
+		return ErrBitOutOfRange(v, b.size)
 	}
+	return nil
 }
 
-func (b *Bitset) SetBit(v uint) {
+func (b *Bitset) SetBit(v uint) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	b.checkOutOfBounds(v)
+	if err := b.checkOutOfBounds(v); err != nil {
+		return err
+	}
 
 	byteIndex := v / 64
 	bitIndex := v % 64
 
 	adjustedBitIndex := 63 - bitIndex
 	b.bits[byteIndex] |= 1 << adjustedBitIndex
+	return nil
 }
 
-func (b *Bitset) ResetBit(v uint) {
+func (b *Bitset) ResetBit(v uint) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	b.checkOutOfBounds(v)
+	if err := b.checkOutOfBounds(v); err != nil {
+		return err
+	}
 
 	byteIndex := v / 64
 	bitIndex := v % 64
 
 	adjustedBitIndex := 63 - bitIndex
 	b.bits[byteIndex] &= ^(1 << adjustedBitIndex)
+	return nil
 }
 
+// GetBit is 0 for a bit out of range
 func (b *Bitset) GetBit(v uint) uint {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
-	b.checkOutOfBounds(v)
+	if v >= b.size {
+		return 0
+	}
 
 	byteIndex := v / 64
 	bitIndex := v % 64
@@ -138,17 +148,20 @@ func (b *Bitset) GetBit(v uint) uint {
 	return uint((b.bits[byteIndex] >> adjustedBitIndex) & 1)
 }
 
-func (b *Bitset) ToggleBit(v uint) {
+func (b *Bitset) ToggleBit(v uint) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	b.checkOutOfBounds(v)
+	if err := b.checkOutOfBounds(v); err != nil {
+		return err
+	}
 
 	byteIndex := v / 64
 	bitIndex := v % 64
 
 	adjustedBitIndex := 63 - bitIndex
 	b.bits[byteIndex] ^= 1 << adjustedBitIndex
+	return nil
 }
 
 func (b *Bitset) Clear() {
@@ -167,6 +180,12 @@ func (b *Bitset) SetAll() {
 	for i := range b.bits {
 		b.bits[i] = ^uint64(0)
 	}
+
+	// spare bits at the end must remain unset
+	remainder := b.size % 64
+	if remainder != 0 {
+		b.bits[len(b.bits)-1] &= ^uint64(0) << (64 - remainder)
+	}
 }
 
 func (b *Bitset) CountSetBits() uint {
@@ -199,18 +218,17 @@ func (b *Bitset) Size() uint {
 	return b.size
 }
 
-func (b *Bitset) And(other *Bitset) *Bitset {
+func (b *Bitset) And(other *Bitset) (*Bitset, error) {
 	b.mu.RLock()
 	other.mu.RLock()
 	defer b.mu.RUnlock()
 	defer other.mu.RUnlock()
 
 	if b.size != other.size {
-		log.Fatalf("[fatal]: bitset sizes are not equal, can not compute AND")
-		return nil
+		return nil, ErrBitsetSizeInvalid(b.size, other.size)
 	}
 
-	return computeAnd(b, other)
+	return computeAnd(b, other), nil
 }
 
 // computeAnd assumes bitsets are of equal sizes
@@ -222,18 +240,17 @@ func computeAnd(x *Bitset, y *Bitset) *Bitset {
 	return result
 }
 
-func (b *Bitset) Or(other *Bitset) *Bitset {
+func (b *Bitset) Or(other *Bitset) (*Bitset, error) {
 	b.mu.RLock()
 	other.mu.RLock()
 	defer b.mu.RUnlock()
 	defer other.mu.RUnlock()
 
 	if b.size != other.size {
-		log.Fatalf("[fatal]: bitset sizes are not equal, can not compute OR")
-		return nil
+		return nil, ErrBitsetSizeInvalid(b.size, other.size)
 	}
 
-	return computeOr(b, other)
+	return computeOr(b, other), nil
 }
 
 // computeOr assumes bitsets are of equal sizes
@@ -245,18 +262,17 @@ func computeOr(x *Bitset, y *Bitset) *Bitset {
 	return result
 }
 
-func (b *Bitset) Xor(other *Bitset) *Bitset {
+func (b *Bitset) Xor(other *Bitset) (*Bitset, error) {
 	b.mu.RLock()
 	other.mu.RLock()
 	defer b.mu.RUnlock()
 	defer other.mu.RUnlock()
 
 	if b.size != other.size {
-		log.Fatalf("[fatal]: bitset sizes are not equal, can not XOR")
-		return nil
+		return nil, ErrBitsetSizeInvalid(b.size, other.size)
 	}
 
-	return computeXor(b, other)
+	return computeXor(b, other), nil
 }
 
 func computeXor(x *Bitset, y *Bitset) *Bitset {
@@ -288,29 +304,27 @@ func computeNot(x *Bitset) *Bitset {
 	return result
 }
 
-func (b *Bitset) AndNot(other *Bitset) *Bitset {
+func (b *Bitset) AndNot(other *Bitset) (*Bitset, error) {
 	b.mu.RLock()
 	other.mu.RLock()
 	defer b.mu.RUnlock()
 	defer other.mu.RUnlock()
 
 	if b.size != other.size {
-		log.Fatalf("[fatal]: bitset sizes are not equal, can not AND NOT")
-		return nil
+		return nil, ErrBitsetSizeInvalid(b.size, other.size)
 	}
-	return computeAnd(b, computeNot(other))
+	return computeAnd(b, computeNot(other)), nil
 }
 
-func (b *Bitset) OrNot(other *Bitset) *Bitset {
+func (b *Bitset) OrNot(other *Bitset) (*Bitset, error) {
 	b.mu.RLock()
 	other.mu.RLock()
 	defer b.mu.RUnlock()
 	defer other.mu.RUnlock()
 
 	if b.size != other.size {
-		log.Fatalf("[fatal]: bitset sizes are not equal, can not OR NOT")
-		return nil
+		return nil, ErrBitsetSizeInvalid(b.size, other.size)
 	}
 
-	return computeOr(b, computeNot(other))
+	return computeOr(b, computeNot(other)), nil
 }
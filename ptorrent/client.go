@@ -0,0 +1,564 @@
+package ptorrent
+
+import (
+	"bittorrent-client/structs"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/** TOC
+- CLIENT
+	- NewClient, Listen
+	- Close, startGoroutine
+- SESSIONS
+	- AddTorrent, RemoveTorrent, PauseTorrent, ResumeTorrent
+	- Session, Sessions
+- STATS
+	- Client.Options, Client.Stats
+- GLOBAL LIMITS
+	- acquireConnectionSlot, releaseConnectionSlot
+	- acquireHalfOpenSlot, releaseHalfOpenSlot
+	- SetRateLimits, RateLimits
+*/
+
+/*
+- What is it supposed to do
+- - A `Client` owns the sessions of many torrents. They share the local peer id, one listener, and the file handle cache.
+- - Incoming connections are routed to a session by the info-hash of their handshake.
+- - Connections and bandwidth are limited across all torrents:
+- - - no peer is dialed or accepted once `maxConnections` connections are open
+- - - at most `maxHalfOpen` peers are being dialed at once, the dial queue of each session waits for a slot
+- - - every torrent has at most `maxPeersPerTorrent` peers, unless set otherwise when it is added, see peer-manager.go
+- - - the traffic of every torrent is charged to the download and upload rate limiters of the client, and to those of
+      its own session; the limits can be changed while running, see rate-limiter.go
+- - Torrents can also be added from a magnet link, once their metadata is fetched from peers, see magnet.go
+- - A paused torrent keeps its files open, but has no peers and does not announce to its tracker.
+- - Closing the client stops every goroutine: torrents send a 'stopped' announce, save their resume data and close their
+    files. Closing is bounded by the context; goroutines still running once it is done are given up on.
+*/
+
+const DefaultListenerPort = 8888
+const DefaultMaxConnections = 200
+
+// Unlimited lifts a connection or peer limit of ClientOptions and AddTorrentOptions, where 0 is the default
+const Unlimited = -1
+const DefaultStopTimeout = time.Second * 10 // bounds RemoveTorrent
+
+type ClientConfigurable struct {
+	/* Listener conf */
+	listenerPort uint16
+
+	/* Global limits conf; 0 for unlimited */
+	maxConnections     int
+	maxHalfOpen        int
+	maxPeersPerTorrent int // the default of every torrent
+
+	/* Peer reaper conf */
+	peerTimeout time.Duration
+
+	/* Ban conf */
+	maxBadPieces int
+
+	/* File system conf */
+	maxOpenFiles int
+}
+
+type Client struct {
+	mu sync.RWMutex
+
+	configurable *ClientConfigurable
+	localPeerId  [20]byte
+	listener     *Listener
+	handleCache  *FileHandleCache // shared by the file storage of every torrent
+
+	sessions        map[[20]byte]*TorrentSession    // look up using info-hash
+	metadataFetches map[[20]byte]context.CancelFunc // torrents added from a magnet link, till their metadata is fetched
+	events          *eventBus
+
+	ctx    context.Context // cancelled once the client is closed
+	cancel context.CancelFunc
+	wg     sync.WaitGroup // the listener and incoming handshakes
+
+	numConnections  atomic.Int64
+	numHalfOpen     atomic.Int64
+	downloadLimiter *RateLimiter // across all torrents
+	uploadLimiter   *RateLimiter
+
+	handshakeFailures handshakeFailures // of incoming connections not routed to a session
+
+	selfAddresses *structs.MutexMap[string, struct{}] // addresses that turned out to be ours, never dialed again
+
+	speedScheduler *SpeedScheduler // nil without a speed schedule
+}
+
+// ClientOptions zero values fall back to the defaults, field by field
+type ClientOptions struct {
+	PeerId             [20]byte      // generated if zero
+	ListenerPort       uint16        // DefaultListenerPort if 0
+	MaxConnections     int           // across all torrents; DefaultMaxConnections if 0, Unlimited for no limit
+	MaxHalfOpen        int           // dials in progress, across all torrents; DefaultMaxHalfOpen if 0, Unlimited for no limit
+	MaxPeersPerTorrent int           // default of every torrent; DefaultMaxPeersPerTorrent if 0, Unlimited for no limit
+	MaxDownloadRate    int64         // bytes per second, across all torrents; 0 for unlimited
+	MaxUploadRate      int64         // bytes per second, across all torrents; 0 for unlimited
+	MaxOpenFiles       int           // DefaultMaxOpenFiles if 0
+	PeerTimeout        time.Duration // peers silent for longer are dropped; DefaultPeerTimeout if 0
+	MaxBadPieces       int           // corrupt pieces a peer is banned after; DefaultMaxBadPieces if 0
+}
+
+// AddTorrentOptions how the files of a torrent are stored, see FileSystemOptions
+type AddTorrentOptions struct {
+	Priorities    []FilePriority // one entry per file of the torrent; nil downloads every file with normal priority
+	StorageType   StorageType    // FileStorageType if empty
+	Allocation    AllocationMode // AllocateSparse if empty
+	IncompleteDir string
+	PartSuffix    bool
+	Recheck       bool // ignores the resume file and hash-checks all existing data
+	Paused        bool // adds the torrent without connecting to peers
+
+	MaxDownloadRate int64 // bytes per second, of this torrent only; 0 for unlimited
+	MaxUploadRate   int64 // bytes per second, of this torrent only; 0 for unlimited
+	MaxPeers        int   // the MaxPeersPerTorrent of the client if 0, Unlimited for no limit
+}
+
+// NewClient creates a client without torrents; call Listen to accept incoming connections
+func NewClient(options *ClientOptions) (*Client, error) {
+	if options == nil {
+		options = &ClientOptions{}
+	}
+	localPeerId := options.PeerId
+	if localPeerId == [20]byte{} {
+		var err error
+		if localPeerId, err = GenerateLocalPeerId(); err != nil {
+			return nil, err
+		}
+	}
+
+	configurable := &ClientConfigurable{
+		listenerPort:       options.ListenerPort,
+		maxConnections:     connectionLimit(options.MaxConnections, DefaultMaxConnections),
+		maxHalfOpen:        connectionLimit(options.MaxHalfOpen, DefaultMaxHalfOpen),
+		maxPeersPerTorrent: connectionLimit(options.MaxPeersPerTorrent, DefaultMaxPeersPerTorrent),
+		maxOpenFiles:       options.MaxOpenFiles,
+		peerTimeout:        options.PeerTimeout,
+		maxBadPieces:       options.MaxBadPieces,
+	}
+	if configurable.listenerPort == 0 {
+		configurable.listenerPort = DefaultListenerPort
+	}
+	if configurable.maxOpenFiles <= 0 {
+		configurable.maxOpenFiles = DefaultMaxOpenFiles
+	}
+	if configurable.peerTimeout <= 0 {
+		configurable.peerTimeout = DefaultPeerTimeout
+	}
+	if configurable.maxBadPieces <= 0 {
+		configurable.maxBadPieces = DefaultMaxBadPieces
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Client{
+		ctx:          ctx,
+		cancel:       cancel,
+		events:       newEventBus(),
+		configurable: configurable,
+		localPeerId:  localPeerId,
+		handleCache:  NewFileHandleCache(configurable.maxOpenFiles),
+		sessions:     make(map[[20]byte]*TorrentSession),
+
+		metadataFetches: make(map[[20]byte]context.CancelFunc),
+
+		selfAddresses: structs.NewMutexMap[string, struct{}](),
+
+		downloadLimiter: NewRateLimiter(options.MaxDownloadRate),
+		uploadLimiter:   NewRateLimiter(options.MaxUploadRate),
+	}, nil
+}
+
+// connectionLimit the default for 0; 0, unlimited within the client, for a negative limit
+func connectionLimit(limit int, defaultLimit int) int {
+	if limit == 0 {
+		return defaultLimit
+	}
+	return max(limit, 0)
+}
+
+// optionLimit the limit as an option, Unlimited for 0
+func optionLimit(limit int) int {
+	if limit == 0 {
+		return Unlimited
+	}
+	return limit
+}
+
+/************************************** CLIENT **************************************/
+
+// Listen mounts the shared listener, and starts accepting connections for every torrent
+func (c *Client) Listen() error {
+	listener, err := NewListener(c.configurable.listenerPort)
+	if err != nil {
+		return err
+	}
+	c.listener = listener
+	c.startGoroutine(func() { listener.StartListening(c) })
+	logs.client.Info("listener mounted", "port", c.configurable.listenerPort)
+
+	return nil
+}
+
+// Close stops accepting connections, then stops every torrent in parallel: each one sends a 'stopped' announce,
+// saves its resume data and closes its files. Returns once everything is stopped, or the context is done.
+func (c *Client) Close(ctx context.Context) error {
+	if c.listener != nil {
+		c.listener.CloseListener()
+	}
+	c.cancel()
+
+	c.mu.Lock()
+	sessions := make([]*TorrentSession, 0, len(c.sessions))
+	for infoHash, session := range c.sessions {
+		if session != nil {
+			sessions = append(sessions, session)
+			delete(c.sessions, infoHash)
+		}
+	}
+	c.mu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(sessions))
+	for i, session := range sessions {
+		wg.Add(1)
+		go func(i int, session *TorrentSession) {
+			defer wg.Done()
+			errs[i] = c.stopSession(ctx, session)
+		}(i, session)
+	}
+	wg.Wait()
+
+	stopped := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("client goroutines did not stop in time: %w", ctx.Err()))
+	}
+	return errors.Join(errs...)
+}
+
+// startGoroutine runs `f` as a goroutine, waited for by Close
+func (c *Client) startGoroutine(f func()) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		f()
+	}()
+}
+
+/************************************** SESSIONS **************************************/
+
+// AddTorrent creates the file system of the torrent, resumes or rechecks existing data, and starts downloading
+// unless `options.Paused` is set. Blocks while existing data is rechecked.
+func (c *Client) AddTorrent(torrent *Torrent, options *AddTorrentOptions) (*TorrentSession, error) {
+	if options == nil {
+		options = &AddTorrentOptions{}
+	}
+	c.mu.Lock()
+	if _, exists := c.sessions[torrent.InfoHash]; exists {
+		c.mu.Unlock()
+		return nil, ErrTorrentAlreadyAdded(torrent.InfoHash)
+	}
+	// reserves the info-hash while the session starts
+	c.sessions[torrent.InfoHash] = nil
+	c.mu.Unlock()
+
+	session, err := c.startSession(torrent, options)
+	c.mu.Lock()
+	if err != nil {
+		delete(c.sessions, torrent.InfoHash)
+	} else {
+		c.sessions[torrent.InfoHash] = session
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	c.publish(Event{Type: EventTorrentAdded, InfoHash: torrent.InfoHash, Name: torrent.Info.Name})
+	if !options.Paused {
+		session.Resume()
+	}
+	return session, nil
+}
+
+func (c *Client) startSession(torrent *Torrent, options *AddTorrentOptions) (*TorrentSession, error) {
+	session, err := newTorrentSession(c, torrent)
+	if err != nil {
+		return nil, err
+	}
+	session.configurable.listenerPort = c.configurable.listenerPort
+	session.configurable.maxOpenFiles = c.configurable.maxOpenFiles
+	session.configurable.peerTimeout = c.configurable.peerTimeout
+	session.configurable.maxBadPieces = c.configurable.maxBadPieces
+	session.bans = NewBanList(c.configurable.maxBadPieces)
+	session.SetRateLimits(options.MaxDownloadRate, options.MaxUploadRate)
+	session.peerManager.SetMaxPeers(connectionLimit(options.MaxPeers, c.configurable.maxPeersPerTorrent))
+
+	storageType, allocation := options.StorageType, options.Allocation
+	if storageType == "" {
+		storageType = FileStorageType
+	}
+	if allocation == "" {
+		allocation = AllocateSparse
+	}
+	newStorage, err := NewStorageConstructor(storageType, c.handleCache, allocation)
+	if err != nil {
+		session.cancel()
+		return nil, err
+	}
+	torrentFileSystem, err := CreateTorrentFileSystem(torrent, &FileSystemOptions{
+		Priorities:    options.Priorities,
+		NewStorage:    newStorage,
+		IncompleteDir: options.IncompleteDir,
+		PartSuffix:    options.PartSuffix,
+	})
+	if err != nil {
+		session.cancel()
+		return nil, err
+	}
+	session.fileSystem = torrentFileSystem
+	session.piecePicker.SetPiecePriorities(torrentFileSystem.PiecePriorities())
+	session.logs.disk.Debug("created torrent file system", "name", torrent.Info.Name)
+
+	session.state = NewTorrentState(torrentFileSystem.WantedLength())
+	session.startGoroutine(func() { session.state.StateHandler(session.ctx) })
+
+	session.diskIO = NewDiskIO(session, torrentFileSystem, session.configurable.diskQueueLength)
+	for i := 0; i < session.configurable.diskWorkers; i++ {
+		session.startGoroutine(func() { session.diskIO.Worker(session.ctx) })
+	}
+
+	if !options.Recheck {
+		knownPeers, err := session.LoadResumeData()
+		if err != nil {
+			session.logs.disk.Warn("can not use resume data, starting from scratch", "err", err)
+		}
+		session.peerManager.AddPeers(knownPeers, PeerSourceResume)
+	}
+	err = session.RecheckExistingData(session.ctx, options.Recheck, func(progress RecheckProgress) {
+		session.logs.disk.Debug("rechecking existing data", "checked", progress.Checked, "total", progress.Total, "verified", progress.Verified)
+		c.publish(Event{Type: EventRecheckProgress, InfoHash: torrent.InfoHash, Name: torrent.Info.Name, Recheck: progress})
+	})
+	if err != nil {
+		session.cancel()
+		session.wg.Wait()
+		session.fileSystem.CleanUp()
+		return nil, err
+	}
+	session.trackerClient = NewTrackerClient(torrent, session)
+	session.startGoroutine(func() { session.ResumeWriter(session.ctx) })
+
+	session.rateTracker = NewRateTracker()
+	session.rateTracker.SetRateTrackerTicker()
+	session.startGoroutine(func() { session.rateTracker.StartTotalSpeedCalculator(session.ctx) })
+
+	session.startGoroutine(func() { session.StartQuitter(session.ctx) })
+	session.addedAt = time.Now()
+	return session, nil
+}
+
+// RemoveTorrent disconnects the peers of the torrent, saves its resume data and closes its files; the data is kept.
+// Cancels the metadata fetch of a torrent added from a magnet link.
+// Waits at most `DefaultStopTimeout` for the goroutines of the torrent.
+func (c *Client) RemoveTorrent(infoHash [20]byte) error {
+	c.mu.Lock()
+	session, exists := c.sessions[infoHash]
+	if cancelFetch, fetching := c.metadataFetches[infoHash]; fetching && session == nil {
+		delete(c.metadataFetches, infoHash)
+		c.mu.Unlock()
+		cancelFetch()
+		logs.client.Info("cancelled the metadata fetch of a magnet link", "infohash", hex.EncodeToString(infoHash[:]))
+		return nil
+	}
+	if !exists || session == nil {
+		c.mu.Unlock()
+		return ErrTorrentNotFound(infoHash)
+	}
+	delete(c.sessions, infoHash)
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultStopTimeout)
+	defer cancel()
+	return c.stopSession(ctx, session)
+}
+
+func (c *Client) stopSession(ctx context.Context, session *TorrentSession) error {
+	err := session.Stop(ctx)
+	session.logs.client.Info("removed torrent", "name", session.torrent.Info.Name)
+	c.publish(Event{Type: EventTorrentRemoved, InfoHash: session.torrent.InfoHash, Name: session.torrent.Info.Name})
+	return err
+}
+
+func (c *Client) PauseTorrent(infoHash [20]byte) error {
+	session := c.Session(infoHash)
+	if session == nil {
+		return ErrTorrentNotFound(infoHash)
+	}
+	session.Pause()
+	return nil
+}
+
+func (c *Client) ResumeTorrent(infoHash [20]byte) error {
+	session := c.Session(infoHash)
+	if session == nil {
+		return ErrTorrentNotFound(infoHash)
+	}
+	session.Resume()
+	return nil
+}
+
+// Session returns nil if the torrent was not added, or is still starting
+func (c *Client) Session(infoHash [20]byte) *TorrentSession {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.sessions[infoHash]
+}
+
+func (c *Client) Sessions() []*TorrentSession {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	sessions := make([]*TorrentSession, 0, len(c.sessions))
+	for _, session := range c.sessions {
+		if session != nil {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
+
+/************************************** STATS **************************************/
+
+// Options returns the options the client runs with, defaults applied; the rate limits are the normal ones, also while
+// the alternate speed limits are on
+func (c *Client) Options() ClientOptions {
+	maxDownloadRate, maxUploadRate := c.RateLimits()
+	return ClientOptions{
+		PeerId:             c.localPeerId,
+		ListenerPort:       c.configurable.listenerPort,
+		MaxConnections:     optionLimit(c.configurable.maxConnections),
+		MaxHalfOpen:        optionLimit(c.configurable.maxHalfOpen),
+		MaxPeersPerTorrent: optionLimit(c.configurable.maxPeersPerTorrent),
+		MaxDownloadRate:    maxDownloadRate,
+		MaxUploadRate:      maxUploadRate,
+		MaxOpenFiles:       c.configurable.maxOpenFiles,
+		PeerTimeout:        c.configurable.peerTimeout,
+		MaxBadPieces:       c.configurable.maxBadPieces,
+	}
+}
+
+type ClientStats struct {
+	NumTorrents    int
+	NumConnections int64
+	DownloadRate   float64 // bytes per second, across all torrents
+	UploadRate     float64 // bytes per second, across all torrents
+}
+
+func (c *Client) Stats() ClientStats {
+	downloadRate, uploadRate := c.totalSpeeds()
+	return ClientStats{
+		NumTorrents:    len(c.Sessions()),
+		NumConnections: c.NumConnections(),
+		DownloadRate:   downloadRate,
+		UploadRate:     uploadRate,
+	}
+}
+
+/************************************** GLOBAL LIMITS **************************************/
+
+// acquireConnectionSlot returns false if `maxConnections` connections are open already.
+// The slot is released with releaseConnectionSlot, or by RemovePeer once the peer is initialized.
+func (c *Client) acquireConnectionSlot() bool {
+	return acquireSlot(&c.numConnections, c.configurable.maxConnections)
+}
+
+func (c *Client) releaseConnectionSlot() {
+	c.numConnections.Add(-1)
+}
+
+// acquireHalfOpenSlot returns false if `maxHalfOpen` peers are being dialed already.
+// The slot is released once the handshake is done, or failed.
+func (c *Client) acquireHalfOpenSlot() bool {
+	return acquireSlot(&c.numHalfOpen, c.configurable.maxHalfOpen)
+}
+
+func (c *Client) releaseHalfOpenSlot() {
+	c.numHalfOpen.Add(-1)
+}
+
+// acquireSlot increments the counter, unless it reached the limit; 0 for unlimited
+func acquireSlot(counter *atomic.Int64, limit int) bool {
+	if limit <= 0 {
+		counter.Add(1)
+		return true
+	}
+	for {
+		current := counter.Load()
+		if current >= int64(limit) {
+			return false
+		}
+		if counter.CompareAndSwap(current, current+1) {
+			return true
+		}
+	}
+}
+
+func (c *Client) NumConnections() int64 {
+	return c.numConnections.Load()
+}
+
+func (c *Client) totalSpeeds() (float64, float64) {
+	downloadSpeed, uploadSpeed := float64(0), float64(0)
+	for _, session := range c.Sessions() {
+		if session.rateTracker == nil {
+			continue
+		}
+		downloadSpeed += session.rateTracker.GetTotalDownloadSpeed()
+		uploadSpeed += session.rateTracker.GetTotalUploadSpeed()
+	}
+	return downloadSpeed, uploadSpeed
+}
+
+// SetRateLimits changes the download and upload rates across all torrents, in bytes per second; 0 for unlimited.
+// While the alternate speed limits are on, the new rates take effect once they are off, see speed-schedule.go
+func (c *Client) SetRateLimits(maxDownloadRate int64, maxUploadRate int64) {
+	if scheduler := c.SpeedScheduler(); scheduler != nil {
+		scheduler.setNormalRateLimits(maxDownloadRate, maxUploadRate)
+		return
+	}
+	c.applyRateLimits(maxDownloadRate, maxUploadRate)
+}
+
+// RateLimits the normal rates, also while the alternate speed limits are on
+func (c *Client) RateLimits() (maxDownloadRate int64, maxUploadRate int64) {
+	if scheduler := c.SpeedScheduler(); scheduler != nil {
+		return scheduler.normalRateLimits()
+	}
+	return c.appliedRateLimits()
+}
+
+func (c *Client) applyRateLimits(maxDownloadRate int64, maxUploadRate int64) {
+	c.downloadLimiter.SetRate(maxDownloadRate)
+	c.uploadLimiter.SetRate(maxUploadRate)
+}
+
+// appliedRateLimits the rates in effect, the alternate ones while they are on
+func (c *Client) appliedRateLimits() (maxDownloadRate int64, maxUploadRate int64) {
+	return c.downloadLimiter.Rate(), c.uploadLimiter.Rate()
+}
@@ -0,0 +1,242 @@
+package ptorrent
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+/** TOC
+- DISK IO
+	- BufferBlock (peer reader goroutine)
+	- SubmitPiece (peer reader goroutine, blocks while the queue is full)
+	- Worker (goroutine): hash from memory, write the whole piece
+	- FlushPartialPieces (shutdown)
+- TORRENT FILE SYSTEM
+	- readWrittenBlocks, WriteVerifiedPiece, writePartialPiece
+*/
+
+/*
+- What is it supposed to do
+- - Blocks received from peers are buffered in memory per piece, so that the peer reader goroutine never waits on the disk.
+- - Once every block of a piece is buffered, the piece is queued to a bounded queue. A worker hashes it from memory,
+    and writes it in one go if it matches. The senders of a piece that does not match are found out, see peer-ban.go
+- - While the queue is full, submitting blocks the peer reader, and no new blocks are requested (backpressure).
+    Once the queue drains, the request pipelines of all peers are filled again.
+*/
+
+const (
+	DefaultDiskWorkers     = 2
+	DefaultDiskQueueLength = 16
+)
+
+// pieceBuffer the blocks of a piece held in memory
+type pieceBuffer struct {
+	data     []byte
+	received []bool
+}
+
+type DiskIO struct {
+	mu sync.Mutex
+
+	session    *TorrentSession
+	fileSystem *TorrentFileSystem
+
+	buffers   map[uint32]*pieceBuffer
+	jobs      chan uint32 // piece indices, with every block buffered
+	saturated atomic.Bool // the queue was full, pipelines need to be filled once it drains
+}
+
+func NewDiskIO(session *TorrentSession, fileSystem *TorrentFileSystem, queueLength int) *DiskIO {
+	return &DiskIO{
+		session:    session,
+		fileSystem: fileSystem,
+		buffers:    make(map[uint32]*pieceBuffer),
+		jobs:       make(chan uint32, max(queueLength, 1)),
+	}
+}
+
+// Saturated if the queue is full, no more blocks should be requested
+func (dio *DiskIO) Saturated() bool {
+	if len(dio.jobs) >= cap(dio.jobs) {
+		dio.saturated.Store(true)
+		return true
+	}
+	return false
+}
+
+// BufferBlock copies the block into the buffer of its piece
+func (dio *DiskIO) BufferBlock(pieceIndex uint32, begin uint32, block []byte) error {
+	tfs := dio.fileSystem
+	if _, _, err := tfs.validateRequest(Write, int64(pieceIndex), int64(begin), int64(len(block))); err != nil {
+		return ErrInvalidRequest(err)
+	}
+
+	dio.mu.Lock()
+	defer dio.mu.Unlock()
+
+	buffer, ok := dio.buffers[pieceIndex]
+	if !ok {
+		// blocks written before, e.g. restored from the resume file, are read back to hash the piece from memory
+		data, received, err := tfs.readWrittenBlocks(int64(pieceIndex))
+		if err != nil {
+			return err
+		}
+		buffer = &pieceBuffer{data: data, received: received}
+		dio.buffers[pieceIndex] = buffer
+	}
+
+	blockIndex := int64(begin) / BlockSize
+	if buffer.received[blockIndex] {
+		return ErrBlockAlreadyExists
+	}
+	copy(buffer.data[begin:], block)
+	buffer.received[blockIndex] = true
+	return nil
+}
+
+// SubmitPiece queues a piece with every block buffered; blocks while the queue is full
+func (dio *DiskIO) SubmitPiece(pieceIndex uint32) {
+	select {
+	case dio.jobs <- pieceIndex:
+	case <-dio.session.ctx.Done():
+	}
+}
+
+// Worker Meant to be run as a goroutine, till the context is cancelled
+func (dio *DiskIO) Worker(ctx context.Context) {
+	for {
+		var pieceIndex uint32
+		select {
+		case <-ctx.Done():
+			return
+		case pieceIndex = <-dio.jobs:
+		}
+		dio.processPiece(pieceIndex)
+
+		// the queue has room again
+		if dio.saturated.Load() && len(dio.jobs) < cap(dio.jobs) && dio.saturated.CompareAndSwap(true, false) {
+			dio.session.refillAllRequestPipelines()
+		}
+	}
+}
+
+func (dio *DiskIO) processPiece(pieceIndex uint32) {
+	dio.mu.Lock()
+	buffer, ok := dio.buffers[pieceIndex]
+	delete(dio.buffers, pieceIndex)
+	dio.mu.Unlock()
+	if !ok {
+		dio.session.logs.disk.Debug("no buffered blocks for piece, flushed already", "piece", pieceIndex)
+		return
+	}
+
+	ts := dio.session
+	tfs := dio.fileSystem
+	if !verifySHA1(buffer.data, tfs.pieces[pieceIndex].expectedHash) {
+		ts.logs.disk.Warn("piece failed the hash check", "piece", pieceIndex)
+		ts.metrics.piecesFailed.Add(1)
+		senders := ts.piecePicker.BlockSenders(pieceIndex)
+		tfs.discardPiece(int64(pieceIndex))
+		ts.piecePicker.ResetPiece(pieceIndex)
+		ts.onPieceFailed(pieceIndex, buffer.data, senders)
+		return
+	}
+
+	if err := tfs.WriteVerifiedPiece(int64(pieceIndex), buffer.data); err != nil {
+		ts.logs.disk.Error("error writing piece", "piece", pieceIndex, "err", err)
+		tfs.discardPiece(int64(pieceIndex))
+		ts.piecePicker.ResetPiece(pieceIndex)
+		return
+	}
+	ts.metrics.piecesVerified.Add(1)
+	ts.onPieceVerified(pieceIndex, buffer.data)
+	ts.updateState(Left, int64(len(buffer.data)))
+	ts.onPieceComplete(pieceIndex)
+}
+
+// FlushPartialPieces writes the buffered blocks of incomplete pieces to disk, so that they are kept in the resume file
+func (dio *DiskIO) FlushPartialPieces() {
+	dio.mu.Lock()
+	defer dio.mu.Unlock()
+
+	for pieceIndex, buffer := range dio.buffers {
+		if err := dio.fileSystem.writePartialPiece(int64(pieceIndex), buffer.data, buffer.received); err != nil {
+			dio.session.logs.disk.Error("error flushing blocks of piece", "piece", pieceIndex, "err", err)
+		}
+		delete(dio.buffers, pieceIndex)
+	}
+}
+
+/************************************** TORRENT FILE SYSTEM **************************************/
+
+// readWrittenBlocks returns a piece sized buffer, filled with the blocks of the piece already written to disk
+func (tfs *TorrentFileSystem) readWrittenBlocks(pieceIndex int64) ([]byte, []bool, error) {
+	tfs.pieceMutexes[pieceIndex].RLock()
+	defer tfs.pieceMutexes[pieceIndex].RUnlock()
+
+	piece := tfs.pieces[pieceIndex]
+	data := make([]byte, piece.length)
+	received := make([]bool, piece.numBlocksInPiece)
+	for blockIndex, hasBlock := range piece.hasBlock {
+		if !hasBlock {
+			continue
+		}
+		begin := int64(blockIndex) * BlockSize
+		length := findBlockLength(int64(blockIndex), piece.length, piece.numBlocksInPiece)
+		absoluteOffset := pieceIndex*tfs.pieceLength + begin
+		_, block, err := tfs.readStorage(length, absoluteOffset, absoluteOffset+length)
+		if err != nil {
+			return nil, nil, err
+		}
+		copy(data[begin:], block)
+		received[blockIndex] = true
+	}
+	return data, received, nil
+}
+
+// WriteVerifiedPiece writes a piece already hashed from memory, and marks it as verified
+func (tfs *TorrentFileSystem) WriteVerifiedPiece(pieceIndex int64, data []byte) error {
+	tfs.pieceMutexes[pieceIndex].Lock()
+	defer tfs.pieceMutexes[pieceIndex].Unlock()
+
+	absoluteOffset := pieceIndex * tfs.pieceLength
+	length := int64(len(data))
+	if _, err := tfs.writeStorage(data, length, absoluteOffset, absoluteOffset+length); err != nil {
+		return err
+	}
+	tfs.markPieceVerified(pieceIndex)
+	return nil
+}
+
+// writePartialPiece writes the received blocks of an incomplete piece, without hashing
+func (tfs *TorrentFileSystem) writePartialPiece(pieceIndex int64, data []byte, received []bool) error {
+	tfs.pieceMutexes[pieceIndex].Lock()
+	defer tfs.pieceMutexes[pieceIndex].Unlock()
+
+	piece := tfs.pieces[pieceIndex]
+	for blockIndex, isReceived := range received {
+		if !isReceived || piece.hasBlock[blockIndex] {
+			continue
+		}
+		begin := int64(blockIndex) * BlockSize
+		length := findBlockLength(int64(blockIndex), piece.length, piece.numBlocksInPiece)
+		absoluteOffset := pieceIndex*tfs.pieceLength + begin
+		if _, err := tfs.writeStorage(data[begin:begin+length], length, absoluteOffset, absoluteOffset+length); err != nil {
+			return err
+		}
+		piece.hasBlock[blockIndex] = true
+		piece.numBlocksCompleted++
+	}
+	return nil
+}
+
+// discardPiece forgets the blocks of a piece that failed the hash check
+func (tfs *TorrentFileSystem) discardPiece(pieceIndex int64) {
+	tfs.pieceMutexes[pieceIndex].Lock()
+	defer tfs.pieceMutexes[pieceIndex].Unlock()
+
+	tfs.mu.Lock()
+	tfs.pieces[pieceIndex].invalidatePiece(tfs)
+	tfs.mu.Unlock()
+}
@@ -0,0 +1,37 @@
+// Package ptorrent is the BitTorrent engine of the client, meant to be embedded by other programs.
+//
+// The stable API is:
+//
+//   - loading torrents: LoadTorrent, LoadTorrentFile, and creating them: CreateTorrent, WriteTorrentFile
+//   - running torrents: NewClient, Client.Listen, Client.AddTorrent, Client.RemoveTorrent, Client.PauseTorrent,
+//     Client.ResumeTorrent, Client.Session, Client.Sessions, Client.Close
+//   - options: ClientOptions, AddTorrentOptions, ParseFilePriorities, ParseAllocationMode
+//   - progress events: Client.Subscribe, Event
+//   - stats: Client.Stats, TorrentSession.Stats
+//   - logging: SetLogLevel, SetAllLogLevels, ParseLogLevels, SetLogHandler, SetLogOutput
+//
+// A minimal download:
+//
+//	client, err := ptorrent.NewClient(&ptorrent.ClientOptions{MaxConnections: 100})
+//	if err != nil { ... }
+//	if err = client.Listen(); err != nil { ... }
+//	defer func() {
+//		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+//		defer cancel()
+//		_ = client.Close(ctx)
+//	}()
+//
+//	torrent, err := ptorrent.LoadTorrentFile("file.torrent")
+//	if err != nil { ... }
+//	events, unsubscribe := client.Subscribe(0)
+//	defer unsubscribe()
+//	if _, err = client.AddTorrent(torrent, nil); err != nil { ... }
+//	for event := range events {
+//		if event.Type == ptorrent.EventTorrentCompleted && event.InfoHash == torrent.InfoHash {
+//			break
+//		}
+//	}
+//
+// Other exported identifiers are the building blocks of the engine (peer connections, piece picker, storage
+// backends, ...); they are exported for the subsystems to be tested and swapped, and may change between versions.
+package ptorrent
@@ -0,0 +1,191 @@
+package ptorrent
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// chdirTemp runs the test in a temporary directory, torrents are downloaded below the working directory
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(previous) })
+	return dir
+}
+
+func freePort(t *testing.T) uint16 {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return uint16(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func closeClient(t *testing.T, client *Client) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if err := client.Close(ctx); err != nil {
+		t.Errorf("error closing client: %v", err)
+	}
+}
+
+// TestDownloadToMemoryStorage a leecher on the memory backend downloads a torrent from a seeder over loopback.
+// Nothing unchokes peers yet: the torrent has fewer pieces than the allowed fast set, which the seeder serves while
+// choking.
+func TestDownloadToMemoryStorage(t *testing.T) {
+	dir := chdirTemp(t)
+
+	const pieceLength = 2 * BlockSize
+	data := make([]byte, 7*pieceLength+BlockSize+123) // the last piece and its last block are short
+	rand.New(rand.NewSource(1)).Read(data)
+	sourcePath := filepath.Join(dir, "payload.bin")
+	if err := os.WriteFile(sourcePath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, torrent, err := CreateTorrent(CreateTorrentOptions{
+		Path:        sourcePath,
+		Announce:    "http://127.0.0.1:1/announce", // unreachable, peers are added by hand
+		PieceLength: pieceLength,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the single-file seeder reads its data from <name without extension>/<name>
+	if err = os.Mkdir(filepath.Join(dir, "payload"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(sourcePath, filepath.Join(dir, "payload", "payload.bin")); err != nil {
+		t.Fatal(err)
+	}
+
+	seederPort := freePort(t)
+	seeder, err := NewClient(&ClientOptions{ListenerPort: seederPort})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeClient(t, seeder)
+	if err = seeder.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	seed, err := seeder.AddTorrent(torrent, &AddTorrentOptions{Recheck: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !seed.Stats().Complete {
+		t.Fatal("the seeder does not have every piece after the recheck")
+	}
+
+	leecher, err := NewClient(&ClientOptions{ListenerPort: freePort(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeClient(t, leecher)
+	events, unsubscribe := leecher.Subscribe(64)
+	defer unsubscribe()
+	// the recheck ignores the resume file of the seeder, written to the same directory
+	leech, err := leecher.AddTorrent(torrent, &AddTorrentOptions{StorageType: MemoryStorageType, Recheck: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leech.peerManager.AddPeers([]Peer{{IP: net.IPv4(127, 0, 0, 1), Type: IPv4, Port: seederPort}}, PeerSourceResume)
+
+	timeout := time.After(time.Second * 30)
+	for completed := false; !completed; {
+		select {
+		case event := <-events:
+			completed = event.Type == EventTorrentCompleted
+		case <-timeout:
+			t.Fatalf("download not complete in time: %+v", leech.Stats())
+		}
+	}
+
+	for pieceIndex, state := range leech.PieceStates() {
+		if state != PieceDone {
+			t.Errorf("piece %d not verified", pieceIndex)
+		}
+	}
+	if verified := leech.Metrics().PiecesVerified; verified != int64(torrent.Info.NumPieces) {
+		t.Errorf("verified %d pieces, expected %d", verified, torrent.Info.NumPieces)
+	}
+	if _, ok := leech.fileSystem.storage.(*MemoryStorage); !ok {
+		t.Fatalf("leecher storage is %T, expected the memory backend", leech.fileSystem.storage)
+	}
+	downloaded := make([]byte, len(data))
+	if _, err = leech.fileSystem.storage.ReadAt(downloaded, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, data) {
+		t.Error("downloaded data differs from the seeded data")
+	}
+	if _, err = os.Stat(filepath.Join(dir, "payload", "payload.bin.part")); err == nil {
+		t.Error("the memory backend wrote to the disk")
+	}
+}
+
+// TestCreateFileSystemOddPieceLength a piece length that is not a multiple of the block size is an error, not an exit
+func TestCreateFileSystemOddPieceLength(t *testing.T) {
+	torrent := NewTorrent()
+	torrent.StructureType = SingleFile
+	torrent.Info = &InfoDict{
+		Name:        "odd.bin",
+		PieceLength: BlockSize + 1,
+		Pieces:      make([][20]byte, 2),
+		NumPieces:   2,
+		Length:      BlockSize + 2,
+	}
+	if _, err := CreateTorrentFileSystem(torrent, &FileSystemOptions{NewStorage: MemoryStorageConstructor()}); err == nil {
+		t.Error("expected an error for a piece length that is not a multiple of the block size")
+	}
+}
+
+// TestMmapLargeFile a file of 3 GiB can only be mapped on 64-bit platforms, elsewhere the mmap backend rejects the
+// torrent and the storage falls back to the file backend
+func TestMmapLargeFile(t *testing.T) {
+	chdirTemp(t)
+	const length = 3 << 30
+	torrent := NewTorrent()
+	torrent.StructureType = SingleFile
+	torrent.Info = &InfoDict{
+		Name:        "large.bin",
+		PieceLength: 1 << 24,
+		Pieces:      make([][20]byte, length>>24),
+		NumPieces:   length >> 24,
+		Length:      length,
+	}
+	noStorage := func(tfs *TorrentFileSystem) (Storage, error) { return nil, nil }
+	tfs, err := CreateTorrentFileSystem(torrent, &FileSystemOptions{NewStorage: noStorage})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tooLarge := tfs.fileLayout().fileTooLargeToMap()
+	if fits := int64(math.MaxInt) >= length; fits != (tooLarge == nil) {
+		t.Fatalf("file too large to map: %v, expected %v", tooLarge != nil, !fits)
+	}
+	if tooLarge == nil {
+		return
+	}
+	if _, err = MmapStorageConstructor(AllocateSparse)(tfs); err == nil {
+		t.Error("expected the mmap backend to reject the file")
+	}
+	if _, err = os.Stat(filepath.Join(tfs.files[0].path...)); !os.IsNotExist(err) {
+		t.Errorf("file created by the rejected mmap backend: %v", err)
+	}
+}
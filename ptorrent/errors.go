@@ -1,4 +1,4 @@
-package main
+package ptorrent
 
 import (
 	"errors"
@@ -24,6 +24,9 @@ var ErrCreatingFile = func(fileName string) error { return fmt.Errorf("error cre
 var ErrOpeningFile = func(fileName string) error { return fmt.Errorf("error opening file: %s", fileName) }
 var ErrClosingFile = func(fileName string) error { return fmt.Errorf("error closing file: %s", fileName) }
 var ErrAllocatingBytes = func(fileName string) error { return fmt.Errorf("error allocating bytes: %s", fileName) }
+var ErrInsufficientDiskSpace = func(dir string, required int64, available int64) error {
+	return fmt.Errorf("not enough free disk space in %s: %d bytes required, %d bytes available", dir, required, available)
+}
 
 /* READ-WRITE*/
 
@@ -51,8 +54,26 @@ var ErrPieceDoesNotExist = errors.New("piece does not exist")
 var ErrInvalidBlockLength = errors.New("invalid block length")
 var ErrHashVerificationFailed = errors.New("calculated hash does not match the expected hash")
 
+var ErrMmapNotSupported = errors.New("mmap storage is not supported on this platform")
+var ErrFileTooLargeToMap = func(fileName string, length int64) error {
+	return fmt.Errorf("file %s of %d bytes is too large to be memory-mapped on this platform", fileName, length)
+}
+
+var ErrUnsafePath = func(path string, err error) error {
+	return fmt.Errorf("unsafe path %q in torrent: %v", path, err)
+}
+
+var ErrPieceNotWanted = func(pieceIndex int64, fileName string) error {
+	return fmt.Errorf("piece %d lies in skipped file %s", pieceIndex, fileName)
+}
+
 /* MATH ASSERTIONS */
 
+var ErrDivisionByZero = errors.New("division by zero")
+var ErrDivisionNotPerfect = func(a int64, b int64) error {
+	return fmt.Errorf("%d is not divisible by %d, the division leaves a remainder", a, b)
+}
+
 var ErrOffsetNotDivisibleByBlockSize = func(offset int64, blockSize int64) error {
 	return fmt.Errorf("offset : %d, not divisible by block size (%d)", offset, blockSize)
 }
@@ -62,3 +83,38 @@ var ErrOffsetNotDivisibleByBlockSize = func(offset int64, blockSize int64) error
 var ErrBitsetSizeInvalid = func(expected uint, actual uint) error {
 	return fmt.Errorf("bitset size is invalid, expected: %d, actual: %d", expected, actual)
 }
+
+var ErrBitOutOfRange = func(index uint, size uint) error {
+	return fmt.Errorf("bit %d out of range of a bitset of %d bits", index, size)
+}
+
+/* TORRENT FILE */
+
+var ErrMissingTorrentField = func(field string) error {
+	return fmt.Errorf("corrupt torrent file: no '%s' field", field)
+}
+
+/* CLIENT */
+
+var ErrTorrentAlreadyAdded = func(infoHash [20]byte) error { return fmt.Errorf("torrent %x is already added", infoHash) }
+var ErrTorrentNotFound = func(infoHash [20]byte) error { return fmt.Errorf("torrent %x not found", infoHash) }
+var ErrStopTimeout = func(name string, err error) error {
+	return fmt.Errorf("torrent %s did not stop in time, closing its files anyway: %w", name, err)
+}
+
+/* MAGNET LINKS */
+
+var ErrInvalidMagnetLink = func(reason string) error { return fmt.Errorf("invalid magnet link: %s", reason) }
+var ErrMetadataHashMismatch = errors.New("the metadata does not match the info-hash")
+var ErrMetadataRejected = errors.New("the peer rejected the metadata request")
+var ErrNoMetadataExtension = errors.New("the peer does not support the metadata extension")
+var ErrMetadataSizeInvalid = func(size int64) error { return fmt.Errorf("invalid metadata size %d", size) }
+var ErrMetadataNotFetched = func(numPeers int) error {
+	return fmt.Errorf("the metadata could not be fetched from any of %d peers", numPeers)
+}
+
+/* PEERS */
+
+var ErrSelfConnection = errors.New("connected to ourselves")
+var ErrDuplicatePeer = errors.New("already connected to the peer")
+var ErrBannedPeer = errors.New("the peer is banned")
@@ -0,0 +1,97 @@
+package ptorrent
+
+import (
+	"sync"
+)
+
+/*
+- What is it supposed to do
+- - Applications embedding the client subscribe to events: torrents added, removed, paused, resumed or completed,
+    pieces verified, peers connected, disconnected or banned, and recheck progress.
+- - Publishing never blocks the engine: an event is dropped for a subscriber whose buffer is full. Subscribers that
+    need exact numbers read them from the stats instead.
+*/
+
+const DefaultEventBufferSize = 256
+
+type EventType string
+
+const (
+	EventTorrentAdded     EventType = "torrent-added"
+	EventTorrentRemoved   EventType = "torrent-removed"
+	EventTorrentPaused    EventType = "torrent-paused"
+	EventTorrentResumed   EventType = "torrent-resumed"
+	EventTorrentCompleted EventType = "torrent-completed" // every wanted piece is verified
+	EventPieceCompleted   EventType = "piece-completed"   // a downloaded piece is verified and written
+	EventRecheckProgress  EventType = "recheck-progress"
+	EventPeerConnected    EventType = "peer-connected" // the handshake succeeded
+	EventPeerDisconnected EventType = "peer-disconnected"
+	EventPeerBanned       EventType = "peer-banned" // the peer sent too many corrupt pieces
+)
+
+type Event struct {
+	Type     EventType
+	InfoHash [20]byte
+	Name     string
+
+	PieceIndex uint32          // EventPieceCompleted only
+	Recheck    RecheckProgress // EventRecheckProgress only
+	Peer       string          // EventPeerConnected, EventPeerDisconnected and EventPeerBanned only, the address of the peer; its IP for a ban
+}
+
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[int]chan Event
+	nextId      int
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: make(map[int]chan Event)}
+}
+
+// Subscribe returns a channel receiving every event published from now on, and a function closing it.
+// Events are dropped while the channel buffer is full.
+func (c *Client) Subscribe(bufferSize int) (<-chan Event, func()) {
+	return c.events.subscribe(bufferSize)
+}
+
+func (c *Client) publish(event Event) {
+	c.events.publish(event)
+}
+
+func (eb *eventBus) subscribe(bufferSize int) (<-chan Event, func()) {
+	if bufferSize <= 0 {
+		bufferSize = DefaultEventBufferSize
+	}
+
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	id := eb.nextId
+	eb.nextId++
+	events := make(chan Event, bufferSize)
+	eb.subscribers[id] = events
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			eb.mu.Lock()
+			defer eb.mu.Unlock()
+			delete(eb.subscribers, id)
+			close(events)
+		})
+	}
+	return events, unsubscribe
+}
+
+func (eb *eventBus) publish(event Event) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	for _, events := range eb.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package ptorrent
+
+import (
+	bencodingParser "bittorrent-client/bencoding-parser"
+	"fmt"
+)
+
+/** TOC
+- MESSAGES
+	- extended handshake: newExtendedHandshakeMessage, parseExtendedHandshake
+	- ut_metadata: newMetadataMessage, parseMetadataMessage
+- SERVING METADATA
+	- sendExtendedHandshake
+	- handleExtendedMessage, handleMetadataRequest
+*/
+
+/*
+- What is it supposed to do
+- - Implements the Extension Protocol (BEP 10): both ends set a reserved bit of the handshake, then send an extended
+    handshake naming the extensions they support, each along with the message id they expect it with.
+- - The only extension is ut_metadata (BEP 9): the bencoded info dictionary is exchanged in pieces of 16KB, so that a
+    torrent can be added from its info-hash alone, e.g. from a magnet link.
+- - Every session serves the metadata of its torrent; fetching it is done by metadata-fetch.go
+*/
+
+const extendedHandshakeId = 0
+const utMetadataName = "ut_metadata"
+const utMetadataId = 1 // the id we expect ut_metadata messages with, sent in our extended handshake
+
+const metadataPieceSize = 1 << 14 // 16KB
+const maxMetadataSize = 10 << 20
+
+type metadataMessageType int64
+
+const (
+	metadataRequest metadataMessageType = 0
+	metadataData    metadataMessageType = 1
+	metadataReject  metadataMessageType = 2
+)
+
+type extendedHandshake struct {
+	metadataId   byte  // the id the peer expects ut_metadata messages with, 0 if it does not support them
+	metadataSize int64 // the length of the info dictionary, 0 if the peer does not tell
+}
+
+type metadataMessage struct {
+	messageType metadataMessageType
+	piece       int64
+	totalSize   int64  // the length of the info dictionary, in 'data' messages
+	data        []byte // the piece of the info dictionary, in 'data' messages
+}
+
+/************************************** MESSAGES **************************************/
+
+// newExtendedBencodeMessage the payload is the bencoded dictionary, followed by the trailing bytes
+func newExtendedBencodeMessage(extendedId byte, entries map[string]*bencodingParser.Bencode, trailing []byte) (*PeerMessage, error) {
+	payload, err := bencodingParser.SerializeBencode(bencodingParser.NewSortedBencodeDict(entries))
+	if err != nil {
+		return nil, err
+	}
+	return NewExtendedMessage(extendedId, append(payload, trailing...)), nil
+}
+
+// newExtendedHandshakeMessage `metadataSize` is 0 if the metadata is not known yet
+func newExtendedHandshakeMessage(metadataSize int64) (*PeerMessage, error) {
+	entries := map[string]*bencodingParser.Bencode{
+		"m": bencodingParser.NewSortedBencodeDict(map[string]*bencodingParser.Bencode{
+			utMetadataName: bencodingParser.NewBencodeFromInt64(utMetadataId),
+		}),
+	}
+	if metadataSize > 0 {
+		entries["metadata_size"] = bencodingParser.NewBencodeFromInt64(metadataSize)
+	}
+	return newExtendedBencodeMessage(extendedHandshakeId, entries, nil)
+}
+
+func parseExtendedHandshake(payload []byte) (*extendedHandshake, error) {
+	bencode, err := bencodingParser.ParseBencodeFromByteSlice(payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid extended handshake: %v", err)
+	}
+
+	handshake := &extendedHandshake{}
+	// an id of 0 disables the extension
+	if extensions, exists := bencode.BDict.Get("m"); exists && extensions.BDict != nil {
+		if id, ok := bencodeInt(extensions.BDict, utMetadataName); ok && id > 0 && id <= 255 {
+			handshake.metadataId = byte(id)
+		}
+	}
+	if size, ok := bencodeInt(bencode.BDict, "metadata_size"); ok && size > 0 {
+		handshake.metadataSize = size
+	}
+	return handshake, nil
+}
+
+// newMetadataMessage `totalSize` and `data` are only sent in 'data' messages
+func newMetadataMessage(peerMetadataId byte, messageType metadataMessageType, piece int64, totalSize int64, data []byte) (*PeerMessage, error) {
+	entries := map[string]*bencodingParser.Bencode{
+		"msg_type": bencodingParser.NewBencodeFromInt64(int64(messageType)),
+		"piece":    bencodingParser.NewBencodeFromInt64(piece),
+	}
+	if messageType == metadataData {
+		entries["total_size"] = bencodingParser.NewBencodeFromInt64(totalSize)
+	}
+	return newExtendedBencodeMessage(peerMetadataId, entries, data)
+}
+
+func parseMetadataMessage(payload []byte) (*metadataMessage, error) {
+	bencode, length, err := bencodingParser.ParseBencodeDictionaryPrefix(payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata message: %v", err)
+	}
+	messageType, hasType := bencodeInt(bencode.BDict, "msg_type")
+	piece, hasPiece := bencodeInt(bencode.BDict, "piece")
+	if !hasType || !hasPiece || piece < 0 {
+		return nil, fmt.Errorf("invalid metadata message: missing or negative 'msg_type' or 'piece'")
+	}
+
+	message := &metadataMessage{messageType: metadataMessageType(messageType), piece: piece}
+	if message.messageType == metadataData {
+		message.totalSize, _ = bencodeInt(bencode.BDict, "total_size")
+		message.data = payload[length:]
+	}
+	return message, nil
+}
+
+// bencodeInt the integer value of the key, false if it is missing or not an integer
+func bencodeInt(dict *bencodingParser.BencodeDict, key string) (int64, bool) {
+	value, exists := dict.Get(key)
+	if !exists || value.BInt == nil {
+		return 0, false
+	}
+	return int64(*value.BInt), true
+}
+
+/************************************** SERVING METADATA **************************************/
+
+// sendExtendedHandshake queues the extended handshake if the peer supports the extension protocol, after the piece
+// availability; never blocks, the availability may fill the write channel before the writer starts
+func (ts *TorrentSession) sendExtendedHandshake(pc *PeerConnection) {
+	if !pc.supportsExtensionProtocol {
+		return
+	}
+	message, err := newExtendedHandshakeMessage(int64(len(ts.torrent.infoBytes)))
+	if err != nil {
+		pc.logs.peer.Warn("can not build the extended handshake", "err", err)
+		return
+	}
+	pc.queueWithoutBlocking(message)
+}
+
+// handleExtendedMessage Meant to be called from the peer reader
+func (pc *PeerConnection) handleExtendedMessage(extendedId byte, payload []byte, session *TorrentSession) {
+	if !pc.supportsExtensionProtocol {
+		pc.logs.peer.Debug("extended message from a peer without extension protocol support ignored", "id", extendedId)
+		return
+	}
+	switch extendedId {
+	case extendedHandshakeId:
+		handshake, err := parseExtendedHandshake(payload)
+		if err != nil {
+			pc.logs.peer.Warn("invalid extended handshake", "err", err)
+			return
+		}
+		pc.peerMetadataId = handshake.metadataId
+	case utMetadataId:
+		message, err := parseMetadataMessage(payload)
+		if err != nil {
+			pc.logs.peer.Warn("invalid metadata message", "err", err)
+			return
+		}
+		// the metadata of the session is known already, 'data' and 'reject' messages are ignored
+		if message.messageType == metadataRequest {
+			pc.handleMetadataRequest(message.piece, session)
+		}
+	default:
+		pc.logs.peer.Debug("unknown extended message ignored", "id", extendedId)
+	}
+}
+
+// handleMetadataRequest answers with the piece of the info dictionary, or rejects the request if the piece is out of
+// range or the info dictionary is not known, e.g. for a torrent not loaded from a metainfo file
+func (pc *PeerConnection) handleMetadataRequest(piece int64, session *TorrentSession) {
+	if pc.peerMetadataId == 0 {
+		pc.logs.peer.Debug("metadata request from a peer without ut_metadata in its extended handshake ignored")
+		return
+	}
+	infoBytes := session.torrent.infoBytes
+
+	var message *PeerMessage
+	var err error
+	if piece >= ceilDiv(int64(len(infoBytes)), metadataPieceSize) {
+		pc.logs.peer.Debug("rejecting metadata request", "piece", piece)
+		message, err = newMetadataMessage(pc.peerMetadataId, metadataReject, piece, 0, nil)
+	} else {
+		begin := piece * metadataPieceSize
+		end := min(begin+metadataPieceSize, int64(len(infoBytes)))
+		message, err = newMetadataMessage(pc.peerMetadataId, metadataData, piece, int64(len(infoBytes)), infoBytes[begin:end])
+	}
+	if err != nil {
+		pc.logs.peer.Warn("can not build the metadata message", "err", err)
+		return
+	}
+	pc.queueWithoutBlocking(message)
+}
@@ -0,0 +1,192 @@
+package ptorrent
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"net"
+)
+
+/** TOC
+- ALLOWED FAST SET
+	- generateAllowedFastSet
+- PIECE AVAILABILITY
+	- SendPieceAvailability (Bitfield / Have All / Have None)
+	- sendAllowedFastSet
+- HANDLER METHODS
+	- Have All, Have None, Suggest Piece, Reject Request, Allowed Fast
+*/
+
+/************************************** ALLOWED FAST SET **************************************/
+
+// generateAllowedFastSet computes the canonical allowed fast set of size k, as described in BEP 6.
+// Only defined for IPv4 peers; the last octet of the address is masked out.
+func generateAllowedFastSet(ip net.IP, infoHash [20]byte, numPieces uint32, k int) []uint32 {
+	ipv4 := ip.To4()
+	if ipv4 == nil || numPieces == 0 {
+		return nil
+	}
+	k = min(k, int(numPieces))
+
+	x := make([]byte, 0, 24)
+	x = append(x, ipv4[0], ipv4[1], ipv4[2], 0x00)
+	x = append(x, infoHash[:]...)
+
+	allowedFastSet := make([]uint32, 0, k)
+	contains := func(pieceIndex uint32) bool {
+		for _, existing := range allowedFastSet {
+			if existing == pieceIndex {
+				return true
+			}
+		}
+		return false
+	}
+
+	for len(allowedFastSet) < k {
+		hash := sha1.Sum(x)
+		x = hash[:]
+		for i := 0; i < 5 && len(allowedFastSet) < k; i++ {
+			y := binary.BigEndian.Uint32(x[i*4 : (i+1)*4])
+			pieceIndex := y % numPieces
+			if !contains(pieceIndex) {
+				allowedFastSet = append(allowedFastSet, pieceIndex)
+			}
+		}
+	}
+	return allowedFastSet
+}
+
+/************************************** PIECE AVAILABILITY **************************************/
+
+// SendPieceAvailability sends the first message after the handshake: 'Have All' or 'Have None' if the peer
+// supports the fast extension and the bitfield is full or empty, a 'Bitfield' otherwise
+func (ts *TorrentSession) SendPieceAvailability(pc *PeerConnection) {
+	if pc.supportsFastExtension {
+		numPiecesObtained := ts.bitfield.CountSetBits()
+		if numPiecesObtained == ts.bitfield.Size() {
+			pc.queueMessage(NewHaveAllMessage())
+		} else if numPiecesObtained == 0 {
+			pc.queueMessage(NewHaveNoneMessage())
+		} else {
+			pc.queueMessage(NewBitfieldMessage(ts.bitfield))
+		}
+		ts.sendAllowedFastSet(pc)
+		return
+	}
+	pc.queueMessage(NewBitfieldMessage(ts.bitfield))
+}
+
+func (ts *TorrentSession) sendAllowedFastSet(pc *PeerConnection) {
+	// the set is queued before the writer starts, it has to fit in the write channel along with the availability
+	setSize := min(ts.configurable.allowedFastSetSize, writeChannelLength-1)
+	allowedFastSet := generateAllowedFastSet(pc.peer.IP, ts.torrent.InfoHash, uint32(ts.torrent.Info.NumPieces), setSize)
+
+	pc.fastMutex.Lock()
+	for _, pieceIndex := range allowedFastSet {
+		pc.allowedFastIncoming[pieceIndex] = struct{}{}
+	}
+	pc.fastMutex.Unlock()
+
+	for _, pieceIndex := range allowedFastSet {
+		pc.queueMessage(NewAllowedFastMessage(pieceIndex))
+	}
+	pc.logs.peer.Debug("sent allowed fast set", "pieces", len(allowedFastSet))
+}
+
+func (pc *PeerConnection) isAllowedFastIncoming(pieceIndex uint32) bool {
+	pc.fastMutex.RLock()
+	defer pc.fastMutex.RUnlock()
+
+	_, ok := pc.allowedFastIncoming[pieceIndex]
+	return ok
+}
+
+func (pc *PeerConnection) isAllowedFastOutgoing(pieceIndex uint32) bool {
+	pc.fastMutex.RLock()
+	defer pc.fastMutex.RUnlock()
+
+	_, ok := pc.allowedFastOutgoing[pieceIndex]
+	return ok
+}
+
+func (pc *PeerConnection) hasAllowedFastOutgoing() bool {
+	pc.fastMutex.RLock()
+	defer pc.fastMutex.RUnlock()
+
+	return len(pc.allowedFastOutgoing) > 0
+}
+
+func (pc *PeerConnection) getSuggestedPieces() []uint32 {
+	pc.fastMutex.RLock()
+	defer pc.fastMutex.RUnlock()
+
+	suggested := make([]uint32, len(pc.suggestedPieces))
+	copy(suggested, pc.suggestedPieces)
+	return suggested
+}
+
+/************************************** HANDLER METHODS **************************************/
+
+// requireFastExtension a peer that has not advertised the fast extension must not send its messages
+func (pc *PeerConnection) requireFastExtension(messageId PeerMessageType, session *TorrentSession) bool {
+	if !pc.supportsFastExtension {
+		pc.logs.peer.Warn("fast extension message from a peer without fast extension support, closing connection", "type", messageId)
+		session.reportQuit(pc)
+		return false
+	}
+	return true
+}
+
+func (pc *PeerConnection) handleHaveAllMessage(session *TorrentSession) {
+	bitfield := NewBitset(session.bitfield.Size())
+	bitfield.SetAll()
+	pc.handleBitfieldMessage(bitfield, session)
+}
+
+func (pc *PeerConnection) handleHaveNoneMessage(session *TorrentSession) {
+	bitfield := NewBitset(session.bitfield.Size())
+	pc.handleBitfieldMessage(bitfield, session)
+}
+
+func (pc *PeerConnection) handleSuggestPieceMessage(pieceIndex uint32, session *TorrentSession) {
+	if uint(pieceIndex) >= session.bitfield.Size() {
+		pc.logs.peer.Warn("suggested piece out of range", "piece", pieceIndex)
+		return
+	}
+
+	pc.fastMutex.Lock()
+	for i, suggested := range pc.suggestedPieces {
+		if suggested == pieceIndex {
+			pc.suggestedPieces = append(pc.suggestedPieces[:i], pc.suggestedPieces[i+1:]...)
+			break
+		}
+	}
+	pc.suggestedPieces = append(pc.suggestedPieces, pieceIndex)
+	if len(pc.suggestedPieces) > session.configurable.allowedFastSetSize {
+		pc.suggestedPieces = pc.suggestedPieces[1:]
+	}
+	pc.fastMutex.Unlock()
+
+	pc.fillRequestPipeline(session)
+}
+
+func (pc *PeerConnection) handleRejectRequestMessage(request *BlockRequest, session *TorrentSession) {
+	if !pc.removePendingRequest(request.index, request.begin) {
+		pc.logs.peer.Debug("rejected a request that was never sent", "request", request)
+		return
+	}
+	session.piecePicker.ReleaseBlock(request.index, request.begin, pc.peerIdStr)
+	pc.fillRequestPipeline(session)
+}
+
+func (pc *PeerConnection) handleAllowedFastMessage(pieceIndex uint32, session *TorrentSession) {
+	if uint(pieceIndex) >= session.bitfield.Size() {
+		pc.logs.peer.Warn("allowed fast piece out of range", "piece", pieceIndex)
+		return
+	}
+
+	pc.fastMutex.Lock()
+	pc.allowedFastOutgoing[pieceIndex] = struct{}{}
+	pc.fastMutex.Unlock()
+
+	pc.fillRequestPipeline(session)
+}
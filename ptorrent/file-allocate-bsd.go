@@ -0,0 +1,21 @@
+//go:build darwin || dragonfly || freebsd
+
+package ptorrent
+
+import (
+	"os"
+	"syscall"
+)
+
+func preallocateFile(file *os.File, sizeInBytes int64) error {
+	return writeZeros(file, sizeInBytes)
+}
+
+// freeDiskSpace the field types of Statfs_t differ between these systems
+func freeDiskSpace(dir string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
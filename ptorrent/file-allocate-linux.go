@@ -0,0 +1,27 @@
+//go:build linux
+
+package ptorrent
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// preallocateFile reserves the disk space of the file with fallocate, falling back to writing zeros
+// on file systems that do not support it
+func preallocateFile(file *os.File, sizeInBytes int64) error {
+	err := syscall.Fallocate(int(file.Fd()), 0, 0, sizeInBytes)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return writeZeros(file, sizeInBytes)
+	}
+	return err
+}
+
+func freeDiskSpace(dir string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
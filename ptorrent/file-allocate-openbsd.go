@@ -0,0 +1,20 @@
+//go:build openbsd
+
+package ptorrent
+
+import (
+	"os"
+	"syscall"
+)
+
+func preallocateFile(file *os.File, sizeInBytes int64) error {
+	return writeZeros(file, sizeInBytes)
+}
+
+func freeDiskSpace(dir string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return stat.F_bavail * int64(stat.F_bsize), nil
+}
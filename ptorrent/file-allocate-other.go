@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !openbsd && !windows
+
+package ptorrent
+
+import (
+	"errors"
+	"os"
+)
+
+func preallocateFile(file *os.File, sizeInBytes int64) error {
+	return writeZeros(file, sizeInBytes)
+}
+
+// freeDiskSpace the free disk space is not checked on systems without statfs, e.g. netbsd
+func freeDiskSpace(dir string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
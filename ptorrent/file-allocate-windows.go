@@ -0,0 +1,29 @@
+//go:build windows
+
+package ptorrent
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+func preallocateFile(file *os.File, sizeInBytes int64) error {
+	return writeZeros(file, sizeInBytes)
+}
+
+// freeDiskSpace the bytes available to the user, which disk quotas may make less than the free bytes of the volume
+func freeDiskSpace(dir string) (int64, error) {
+	dirPtr, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var freeBytesAvailable uint64
+	ok, _, err := procGetDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(dirPtr)), uintptr(unsafe.Pointer(&freeBytesAvailable)), 0, 0)
+	if ok == 0 {
+		return 0, err
+	}
+	return int64(freeBytesAvailable), nil
+}
@@ -0,0 +1,119 @@
+package ptorrent
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+/*
+- What is it supposed to do
+- - Decide how the files of the torrent are allocated on disk, when they are created or grown:
+- - - sparse: the file is sized by writing its last byte; no disk space is reserved (default)
+- - - full: the disk space is reserved up front (fallocate on linux, zeros elsewhere), so that the files do not fragment
+- - - none: the file is not sized at all, it grows as blocks are written
+- - Before any file is created, the free space on the disk is checked against the bytes still to be allocated:
+    statfs on linux and the BSDs, including macOS, GetDiskFreeSpaceEx on windows; the check is skipped elsewhere.
+*/
+
+type AllocationMode string
+
+const (
+	AllocateSparse AllocationMode = "sparse"
+	AllocateFull   AllocationMode = "full"
+	AllocateNone   AllocationMode = "none"
+)
+
+func ParseAllocationMode(s string) (AllocationMode, error) {
+	switch mode := AllocationMode(s); mode {
+	case AllocateSparse, AllocateFull, AllocateNone:
+		return mode, nil
+	}
+	return AllocateSparse, fmt.Errorf("unknown allocation mode %q, expected one of sparse, full, none", s)
+}
+
+// allocateFile sizes a file smaller than `sizeInBytes` according to the allocation mode, keeping its data
+func allocateFile(file *os.File, sizeInBytes int64, mode AllocationMode) error {
+	switch mode {
+	case AllocateNone:
+		return nil
+	case AllocateFull:
+		return preallocateFile(file, sizeInBytes)
+	default:
+		return allocateBytesToEmptyFile(file, sizeInBytes)
+	}
+}
+
+// writeZeros fills the file with zeros from its current size up to `sizeInBytes`
+func writeZeros(file *os.File, sizeInBytes int64) error {
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err = file.Seek(fileInfo.Size(), io.SeekStart); err != nil {
+		return err
+	}
+	zeros := make([]byte, 1<<20)
+	for remaining := sizeInBytes - fileInfo.Size(); remaining > 0; {
+		n, err := file.Write(zeros[:min(remaining, int64(len(zeros)))])
+		if err != nil {
+			return err
+		}
+		remaining -= int64(n)
+	}
+	return nil
+}
+
+// bytesToAllocate the bytes the wanted files still need on disk, beyond what they already hold
+func (tfs *TorrentFileSystem) bytesToAllocate() int64 {
+	required := int64(0)
+	for _, file := range tfs.files {
+		if file.priority == PrioritySkip {
+			continue
+		}
+		existingSize := int64(0)
+		if fileInfo, err := os.Stat(filepath.Join(file.path...)); err == nil {
+			existingSize = fileInfo.Size()
+		}
+		required += max(file.length-existingSize, 0)
+	}
+	if tfs.partFile != nil {
+		required += tfs.partFile.length
+	}
+	return required
+}
+
+// checkFreeSpace fails if the disk holding the download directory can not hold the rest of the torrent
+func (tfs *TorrentFileSystem) checkFreeSpace() error {
+	required := tfs.bytesToAllocate()
+	if required == 0 {
+		return nil
+	}
+
+	// the download directory may not exist yet; incomplete files may be staged on another disk
+	dataDir := tfs.baseDir
+	if tfs.incompleteDir != "" {
+		dataDir = tfs.incompleteDir
+	}
+	dir, err := filepath.Abs(dataDir)
+	if err != nil {
+		return err
+	}
+	for {
+		if _, err = os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	available, err := freeDiskSpace(dir)
+	if err != nil {
+		logs.disk.Warn("can not check free disk space, skipping the check", "dir", dir, "err", err)
+		return nil
+	}
+	if available < required {
+		return ErrInsufficientDiskSpace(dir, required, available)
+	}
+	return nil
+}
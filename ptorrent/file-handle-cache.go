@@ -0,0 +1,144 @@
+package ptorrent
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/*
+- What is it supposed to do
+- - Keeps at most `maxOpen` os file handles open across the torrent files, evicting the least recently used.
+- - A handle is opened read-only for reads, and upgraded to read-write on the first write to the file.
+- - Handles in use by an in-flight read or write are never closed under it: an evicted (or upgraded) handle
+    is only closed once its last user releases it. While every handle is in use, the limit is exceeded temporarily.
+*/
+
+const DefaultMaxOpenFiles = 64
+
+type fileHandle struct {
+	file     *TorrentFile
+	osFile   *os.File
+	writable bool
+	refs     int  // in-flight reads and writes
+	evicted  bool // no longer in the cache, closed when `refs` drops to 0
+}
+
+type FileHandleCache struct {
+	mu      sync.Mutex
+	maxOpen int
+	lru     *list.List // of *fileHandle, most recently used at the front
+	entries map[*TorrentFile]*list.Element
+}
+
+func NewFileHandleCache(maxOpen int) *FileHandleCache {
+	return &FileHandleCache{
+		maxOpen: max(maxOpen, 1),
+		lru:     list.New(),
+		entries: make(map[*TorrentFile]*list.Element),
+	}
+}
+
+// Acquire returns an open handle to the file, which must be given back with Release once the I/O is done
+func (fhc *FileHandleCache) Acquire(file *TorrentFile, writable bool) (*fileHandle, error) {
+	fhc.mu.Lock()
+	defer fhc.mu.Unlock()
+
+	if element, ok := fhc.entries[file]; ok {
+		handle := element.Value.(*fileHandle)
+		if handle.writable || !writable {
+			handle.refs++
+			fhc.lru.MoveToFront(element)
+			return handle, nil
+		}
+		// upgrade: the read-only handle is replaced, and closed once its readers are done
+		fhc.removeElement(element)
+	}
+
+	filePath := filepath.Join(file.path...)
+	var osFile *os.File
+	var err error
+	if writable {
+		osFile, err = os.OpenFile(filePath, os.O_RDWR, 0644)
+	} else {
+		osFile, err = os.Open(filePath)
+	}
+	if err != nil {
+		return nil, ErrOpeningFile(filePath)
+	}
+
+	handle := &fileHandle{file: file, osFile: osFile, writable: writable, refs: 1}
+	fhc.entries[file] = fhc.lru.PushFront(handle)
+
+	for fhc.lru.Len() > fhc.maxOpen {
+		fhc.removeElement(fhc.lru.Back())
+	}
+	return handle, nil
+}
+
+func (fhc *FileHandleCache) Release(handle *fileHandle) {
+	fhc.mu.Lock()
+	defer fhc.mu.Unlock()
+
+	handle.refs--
+	if handle.evicted && handle.refs == 0 {
+		CloseReadCloserWithLog(handle.osFile)
+	}
+}
+
+// removeElement evicts the handle from the cache, closing it right away if it is not in use
+func (fhc *FileHandleCache) removeElement(element *list.Element) {
+	handle := element.Value.(*fileHandle)
+	fhc.lru.Remove(element)
+	delete(fhc.entries, handle.file)
+
+	handle.evicted = true
+	if handle.refs == 0 {
+		CloseReadCloserWithLog(handle.osFile)
+	}
+}
+
+// Evict closes the handle to the file if it is cached; a handle in use is closed once released
+func (fhc *FileHandleCache) Evict(file *TorrentFile) {
+	fhc.mu.Lock()
+	defer fhc.mu.Unlock()
+
+	if element, ok := fhc.entries[file]; ok {
+		fhc.removeElement(element)
+	}
+}
+
+// Sync flushes the file to disk if it has a writable handle cached
+func (fhc *FileHandleCache) Sync(file *TorrentFile) error {
+	fhc.mu.Lock()
+	element, ok := fhc.entries[file]
+	if !ok || !element.Value.(*fileHandle).writable {
+		fhc.mu.Unlock()
+		return nil
+	}
+	handle := element.Value.(*fileHandle)
+	handle.refs++
+	fhc.mu.Unlock()
+
+	defer fhc.Release(handle)
+	return handle.osFile.Sync()
+}
+
+// CloseAll evicts every handle; handles in use are closed once released
+func (fhc *FileHandleCache) CloseAll() {
+	fhc.mu.Lock()
+	defer fhc.mu.Unlock()
+
+	logs.disk.Debug("closing cached file handles", "handles", fhc.lru.Len())
+	for fhc.lru.Len() > 0 {
+		fhc.removeElement(fhc.lru.Back())
+	}
+}
+
+func (fhc *FileHandleCache) NumOpen() int {
+	fhc.mu.Lock()
+	defer fhc.mu.Unlock()
+
+	return fhc.lru.Len()
+}
@@ -0,0 +1,194 @@
+package ptorrent
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/** TOC
+- FILE PRIORITY
+	- parse / print
+	- ParseFilePriorities (command line)
+- TORRENT FILE SYSTEM
+	- applyFilePriorities (piece priorities, partfile slots)
+	- IsPieceWanted, PiecePriorities, WantedLength
+*/
+
+/*
+- What is it supposed to do
+- - Every file of the torrent has a priority: skip, low, normal or high.
+- - Skipped files are never allocated. A piece is wanted if any file it overlaps is wanted, and takes the highest
+    priority among them.
+- - A wanted piece that also overlaps a skipped file is a boundary piece; the bytes belonging to the skipped file
+    are stored in a side partfile (`<download-dir>.parts`), so that skipped files never appear on disk.
+    Each boundary piece gets a slot of `pieceLength` bytes in the partfile, at the same offset as within the piece.
+*/
+
+const PartFileSuffix = ".parts"
+
+/************************************** FILE PRIORITY **************************************/
+
+type FilePriority int
+
+// ordered, so that the highest priority of the files overlapping a piece can be taken; the zero value is normal
+const (
+	PrioritySkip FilePriority = iota - 2
+	PriorityLow
+	PriorityNormal
+	PriorityHigh
+)
+
+func ParseFilePriority(s string) (FilePriority, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "skip":
+		return PrioritySkip, nil
+	case "low":
+		return PriorityLow, nil
+	case "normal":
+		return PriorityNormal, nil
+	case "high":
+		return PriorityHigh, nil
+	}
+	return PriorityNormal, fmt.Errorf("unknown file priority %q, expected one of skip, low, normal, high", s)
+}
+
+func (fp FilePriority) String() string {
+	switch fp {
+	case PrioritySkip:
+		return "skip"
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	}
+	return fmt.Sprintf("FilePriority(%d)", int(fp))
+}
+
+// ParseFilePriorities parses a comma separated list of `<file index>=<priority>`, e.g. "0=skip,3=high".
+// Files that are not listed have normal priority.
+func ParseFilePriorities(spec string, numFiles int) ([]FilePriority, error) {
+	priorities := make([]FilePriority, numFiles)
+	if strings.TrimSpace(spec) == "" {
+		return priorities, nil
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		indexStr, priorityStr, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid file priority %q, expected <file index>=<priority>", entry)
+		}
+		fileIndex, err := strconv.Atoi(strings.TrimSpace(indexStr))
+		if err != nil || fileIndex < 0 || fileIndex >= numFiles {
+			return nil, fmt.Errorf("invalid file index %q, the torrent has %d files", indexStr, numFiles)
+		}
+		priority, err := ParseFilePriority(priorityStr)
+		if err != nil {
+			return nil, err
+		}
+		priorities[fileIndex] = priority
+	}
+
+	for _, priority := range priorities {
+		if priority != PrioritySkip {
+			return priorities, nil
+		}
+	}
+	return nil, fmt.Errorf("every file is skipped, nothing to download")
+}
+
+/************************************** TORRENT FILE SYSTEM **************************************/
+
+// applyFilePriorities sets the file priorities, and derives the piece priorities and the partfile slots.
+// Must be called before the os file system is built.
+func (tfs *TorrentFileSystem) applyFilePriorities(priorities []FilePriority) error {
+	if priorities != nil && len(priorities) != len(tfs.files) {
+		return fmt.Errorf("%d file priorities given, torrent has %d files", len(priorities), len(tfs.files))
+	}
+
+	piecePriorities := make([]FilePriority, tfs.numPieces)
+	hasSkippedFile := make([]bool, tfs.numPieces)
+	hasWantedFile := make([]bool, tfs.numPieces)
+	for pieceIndex := range piecePriorities {
+		piecePriorities[pieceIndex] = PrioritySkip
+	}
+
+	for fileIndex, file := range tfs.files {
+		if priorities != nil {
+			file.priority = priorities[fileIndex]
+		}
+		if file.length == 0 {
+			continue
+		}
+
+		firstPiece := file.startingOffset / tfs.pieceLength
+		lastPiece := (file.startingOffset + file.length - 1) / tfs.pieceLength
+		file.piecesRemaining = lastPiece - firstPiece + 1
+		for pieceIndex := firstPiece; pieceIndex <= lastPiece; pieceIndex++ {
+			piecePriorities[pieceIndex] = max(piecePriorities[pieceIndex], file.priority)
+			if file.priority == PrioritySkip {
+				hasSkippedFile[pieceIndex] = true
+			} else {
+				hasWantedFile[pieceIndex] = true
+			}
+		}
+	}
+
+	partSlots := make(map[int64]int64)
+	for pieceIndex := int64(0); pieceIndex < tfs.numPieces; pieceIndex++ {
+		if hasSkippedFile[pieceIndex] && hasWantedFile[pieceIndex] {
+			partSlots[pieceIndex] = int64(len(partSlots))
+		}
+	}
+
+	tfs.piecePriorities = piecePriorities
+	tfs.numWantedPiecesMissing = 0
+	for pieceIndex := range piecePriorities {
+		if piecePriorities[pieceIndex] != PrioritySkip && !tfs.hasPiece[pieceIndex] {
+			tfs.numWantedPiecesMissing++
+		}
+	}
+	tfs.partSlots = partSlots
+	if len(partSlots) > 0 {
+		tfs.partFile = NewTorrentFile([]string{tfs.baseDir + PartFileSuffix}, int64(len(partSlots))*tfs.pieceLength, 0)
+	}
+	return nil
+}
+
+func (tfs *TorrentFileSystem) IsPieceWanted(pieceIndex int64) bool {
+	return tfs.piecePriorities[pieceIndex] != PrioritySkip
+}
+
+// PiecePriorities returns the priority of every piece, to be handed to the piece picker
+func (tfs *TorrentFileSystem) PiecePriorities() []FilePriority {
+	piecePriorities := make([]FilePriority, len(tfs.piecePriorities))
+	copy(piecePriorities, tfs.piecePriorities)
+	return piecePriorities
+}
+
+// WantedComplete if every wanted piece is verified
+func (tfs *TorrentFileSystem) WantedComplete() bool {
+	tfs.mu.Lock()
+	defer tfs.mu.Unlock()
+	return tfs.numWantedPiecesMissing == 0
+}
+
+// NumPiecesObtained the number of verified pieces, wanted or not
+func (tfs *TorrentFileSystem) NumPiecesObtained() int64 {
+	tfs.mu.Lock()
+	defer tfs.mu.Unlock()
+	return tfs.numPiecesObtained
+}
+
+// WantedLength the total length of the wanted pieces, which is what is `left` to download initially
+func (tfs *TorrentFileSystem) WantedLength() int64 {
+	wantedLength := int64(0)
+	for pieceIndex, piece := range tfs.pieces {
+		if tfs.IsPieceWanted(int64(pieceIndex)) {
+			wantedLength += piece.length
+		}
+	}
+	return wantedLength
+}
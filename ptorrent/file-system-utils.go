@@ -1,4 +1,4 @@
-package main
+package ptorrent
 
 import (
 	"crypto/sha1"
@@ -14,7 +14,7 @@ func verifySHA1(data []byte, expectedHash [20]byte) bool {
 	return computedHash == expectedHash
 }
 
-func populatePiecesSlice(torrent *Torrent) []*TorrentPiece {
+func populatePiecesSlice(torrent *Torrent) ([]*TorrentPiece, error) {
 	pieces := make([]*TorrentPiece, torrent.Info.NumPieces)
 
 	for pieceIndex := uint(0); pieceIndex < torrent.Info.NumPieces; pieceIndex++ {
@@ -26,13 +26,17 @@ func populatePiecesSlice(torrent *Torrent) []*TorrentPiece {
 			numBlocksInPiece = ceilDiv(pieceLength, BlockSize)
 		} else {
 			/* REGULAR PIECE */
-			numBlocksInPiece = assertAndReturnPerfectDivision(torrent.Info.PieceLength, int64(BlockSize))
+			var err error
+			numBlocksInPiece, err = assertAndReturnPerfectDivision(torrent.Info.PieceLength, int64(BlockSize))
+			if err != nil {
+				return nil, fmt.Errorf("piece length of the torrent: %v", err)
+			}
 			pieceLength = torrent.Info.PieceLength
 		}
 
 		pieces[pieceIndex] = NewTorrentPiece(pieceIndex, pieceLength, numBlocksInPiece, torrent.Info.Pieces[pieceIndex])
 	}
-	return pieces
+	return pieces, nil
 }
 
 func findNextOffsetIndex(fileOffset []int64, absoluteOffset int64) int {
@@ -69,6 +73,27 @@ func allocateBytesToEmptyFile(file *os.File, sizeInBytes int64) error {
 	return nil
 }
 
+// resizeFile allocates bytes to a new file according to the allocation mode, or shrinks an existing one to
+// exactly `sizeInBytes`, keeping its data
+func resizeFile(file *os.File, sizeInBytes int64, mode AllocationMode) error {
+	if file == nil {
+		return ErrNullObject("file is nil, can not resize")
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	// an untouched file keeps its modification time, which is checked against the resume file
+	if fileInfo.Size() == sizeInBytes {
+		return nil
+	}
+	if fileInfo.Size() > sizeInBytes || sizeInBytes == 0 {
+		return file.Truncate(sizeInBytes)
+	}
+	return allocateFile(file, sizeInBytes, mode)
+}
+
 func findBlockIndex(relativeOffset int64) (int64, error) {
 	// 0, BS, 2BS, 3BS
 	if relativeOffset%BlockSize != 0 {
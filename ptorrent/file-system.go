@@ -0,0 +1,377 @@
+package ptorrent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type RequestType int
+
+const (
+	Read RequestType = iota
+	Write
+)
+
+type TorrentFileSystem struct {
+	mu sync.Mutex
+
+	baseDir      string // the base directory
+	totalLength  int64  // total size of the entire torrent in bytes
+	files        []*TorrentFile
+	fileOffset   []int64
+	pieces       []*TorrentPiece
+	pieceMutexes []sync.RWMutex
+	hasPiece     []bool // if we have the complete piece at (pieceIndex)
+	complete     bool   // if we have the entire torrent
+
+	pieceLength int64
+	numPieces   int64
+
+	numPiecesObtained      int64
+	numWantedPiecesMissing int64 // wanted pieces not verified yet
+
+	piecePriorities []FilePriority  // highest priority among the files overlapping each piece
+	partSlots       map[int64]int64 // piece index -> slot in the partfile, for pieces overlapping a skipped file
+	partFile        *TorrentFile    // holds the bytes of skipped files in boundary pieces; nil if there are none
+
+	storage Storage // where the data is persisted, addressed in piece space
+
+	incompleteDir string         // where incomplete files are staged; "" if they are staged next to their final path
+	finalizing    sync.WaitGroup // complete files being moved to their final path
+}
+
+type TorrentFile struct {
+	mu             sync.RWMutex
+	path           []string
+	length         int64
+	startingOffset int64
+
+	needsRecheck bool // the file had data before it was opened, which has not been verified yet
+	priority     FilePriority
+
+	finalPath       []string // where the file is moved once complete; `path` is the staging path till then
+	piecesRemaining int64    // pieces overlapping the file, that are not verified yet
+}
+
+type TorrentPiece struct {
+	index  uint
+	length int64
+
+	numBlocksInPiece   int64
+	numBlocksCompleted int64
+	complete           bool
+	hasBlock           []bool
+	expectedHash       [20]byte
+}
+
+func NewTorrentPiece(index uint, length int64, numBlocksInPiece int64, expectedHash [20]byte) *TorrentPiece {
+	return &TorrentPiece{
+		index:  index,
+		length: length,
+
+		numBlocksInPiece:   numBlocksInPiece,
+		numBlocksCompleted: 0,
+		complete:           false,
+		hasBlock:           make([]bool, numBlocksInPiece),
+		expectedHash:       expectedHash,
+	}
+}
+
+func NewTorrentFile(path []string, length int64, offset int64) *TorrentFile {
+	return &TorrentFile{
+		path:           path,
+		length:         length,
+		startingOffset: offset,
+	}
+}
+
+func NewTorrentFileSystemSingleFile(torrent *Torrent, paths *TorrentPaths, pieces []*TorrentPiece) *TorrentFileSystem {
+	torrentFile := NewTorrentFile(append([]string{paths.BaseDir}, paths.Files[0]...), torrent.Info.Length, 0)
+	fileOffset := []int64{0, torrent.Info.Length} // has the end offset as well
+
+	numPieces := ceilDiv(torrent.Info.Length, torrent.Info.PieceLength)
+	return &TorrentFileSystem{
+		baseDir:      paths.BaseDir,
+		totalLength:  torrent.Info.Length,
+		files:        []*TorrentFile{torrentFile},
+		fileOffset:   fileOffset,
+		pieceLength:  torrent.Info.PieceLength,
+		pieces:       pieces,
+		pieceMutexes: make([]sync.RWMutex, numPieces),
+		numPieces:    numPieces,
+
+		complete:          false,
+		hasPiece:          make([]bool, numPieces),
+		numPiecesObtained: 0,
+	}
+}
+
+func NewTorrentFileSystemMultiFile(torrent *Torrent, paths *TorrentPaths, pieces []*TorrentPiece) (*TorrentFileSystem, error) {
+	var torrentFiles []*TorrentFile
+	currentOffset := int64(0)
+	var fileOffset []int64
+
+	for fileIndex, file := range torrent.Info.Files {
+		torrentFile := NewTorrentFile(append([]string{paths.BaseDir}, paths.Files[fileIndex]...), file.Length, currentOffset)
+		fileOffset = append(fileOffset, currentOffset)
+		currentOffset += file.Length
+		torrentFiles = append(torrentFiles, torrentFile)
+	}
+
+	// append the end offset as well
+	fileOffset = append(fileOffset, currentOffset)
+
+	if currentOffset != torrent.Info.Length {
+		return nil, ErrFlawInLogic("last absolute offset is not equal to the total torrent length")
+	}
+
+	numPieces := ceilDiv(torrent.Info.Length, torrent.Info.PieceLength)
+	return &TorrentFileSystem{
+		baseDir:      paths.BaseDir,
+		totalLength:  torrent.Info.Length,
+		files:        torrentFiles,
+		fileOffset:   fileOffset,
+		pieceLength:  torrent.Info.PieceLength,
+		pieces:       pieces,
+		pieceMutexes: make([]sync.RWMutex, numPieces),
+		numPieces:    numPieces,
+
+		complete:          false,
+		hasPiece:          make([]bool, numPieces),
+		numPiecesObtained: 0,
+	}, nil
+}
+
+func (tfs *TorrentFileSystem) BuildOsFileSystem(mode AllocationMode) error {
+	if err := tfs.checkFreeSpace(); err != nil {
+		return err
+	}
+
+	tfs.mu.Lock()
+	defer tfs.mu.Unlock()
+
+	// Create a directory in the present working directory
+	if err := os.MkdirAll(tfs.baseDir, os.ModePerm); err != nil {
+		return ErrCreatingDirectory(tfs.baseDir)
+	}
+	for _, file := range tfs.files {
+		// skipped files never appear on disk
+		if file.priority == PrioritySkip {
+			continue
+		}
+
+		dirFilePath := filepath.Join(file.path[:len(file.path)-1]...)
+		if err := os.MkdirAll(dirFilePath, os.ModePerm); err != nil {
+			return ErrCreatingDirectory(dirFilePath)
+		}
+
+		fileName := file.path[len(file.path)-1]
+		fullFilePath := filepath.Join(dirFilePath, fileName)
+		// existing data is kept, so that it can be resumed or rechecked
+		osFile, err := os.OpenFile(fullFilePath, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return ErrCreatingFile(fullFilePath)
+		}
+
+		if fileInfo, err := osFile.Stat(); err == nil && fileInfo.Size() > 0 {
+			file.needsRecheck = true
+		}
+
+		if err = resizeFile(osFile, file.length, mode); err != nil {
+			_ = osFile.Close()
+			return ErrAllocatingBytes(fileName)
+		}
+
+		err = osFile.Close()
+		if err != nil {
+			return ErrClosingFile(fileName)
+		}
+	}
+
+	// the partfile is not preallocated, slots are filled as blocks arrive
+	if tfs.partFile != nil {
+		if err := createEmptyFile(filepath.Join(tfs.partFile.path...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type FileSystemOptions struct {
+	Priorities    []FilePriority     // one entry per file of the torrent; nil downloads every file with normal priority
+	NewStorage    StorageConstructor // builds the backend the data is persisted to, e.g. FileStorageConstructor
+	IncompleteDir string             // incomplete files are written below this directory, if set
+	PartSuffix    bool               // incomplete files are written with a `.part` suffix
+}
+
+func CreateTorrentFileSystem(torrent *Torrent, options *FileSystemOptions) (*TorrentFileSystem, error) {
+	// paths come from the metainfo, they must not escape the download directory
+	paths, err := SanitizeTorrentPaths(torrent)
+	if err != nil {
+		return nil, fmt.Errorf("error creating torrent file system: %v", err)
+	}
+	pieces, err := populatePiecesSlice(torrent)
+	if err != nil {
+		return nil, fmt.Errorf("error creating torrent file system: %v", err)
+	}
+
+	var torrentFileSystem *TorrentFileSystem
+	if torrent.StructureType == SingleFile {
+		torrentFileSystem = NewTorrentFileSystemSingleFile(torrent, paths, pieces)
+	} else if torrent.StructureType == MultiFile {
+		torrentFileSystem, err = NewTorrentFileSystemMultiFile(torrent, paths, pieces)
+		if err != nil {
+			return nil, fmt.Errorf("error creating torrent file system: %v", err)
+		}
+	} else {
+		return nil, fmt.Errorf("unsupported torrent file type: can not create torrent file system")
+	}
+
+	if err := torrentFileSystem.applyFilePriorities(options.Priorities); err != nil {
+		return nil, fmt.Errorf("error creating torrent file system: %v", err)
+	}
+	torrentFileSystem.applyStaging(options.IncompleteDir, options.PartSuffix)
+
+	storage, err := options.NewStorage(torrentFileSystem)
+	if err != nil {
+		return nil, fmt.Errorf("error creating torrent file system: %v", err)
+	}
+	torrentFileSystem.storage = storage
+	return torrentFileSystem, nil
+}
+
+// validateRequest validates request body for read/write block/piece
+func (tfs *TorrentFileSystem) validateRequest(requestType RequestType, pieceIndex int64, relativeOffset int64, length int64) (int64, int64, error) {
+	// validation: piece index within bounds
+	if pieceIndex < 0 || pieceIndex >= tfs.numPieces {
+		return 0, 0, ErrOutOfRange("piece index")
+	}
+
+	// validation: has the complete piece for `Read` and does not have the block for `Write`
+	blockIndex, err := findBlockIndex(relativeOffset)
+	if err != nil {
+		return 0, 0, err
+	} else {
+		if requestType == Read && !tfs.pieces[pieceIndex].complete {
+			// if READ and we do not have the complete piece
+			return 0, 0, ErrPieceDoesNotExist
+		} else if requestType == Write && tfs.pieces[pieceIndex].hasBlock[blockIndex] {
+			// if WRITE and we already have the block
+			return 0, 0, ErrBlockAlreadyExists
+		} else if requestType != Read && requestType != Write {
+			// invalid request type
+			return 0, 0, errors.New("neither read nor write")
+		}
+	}
+
+	// validation: length is equal to block size
+	if length != findBlockLength(blockIndex, tfs.pieces[pieceIndex].length, tfs.pieces[pieceIndex].numBlocksInPiece) {
+		return 0, 0, ErrInvalidBlockLength
+	}
+
+	// validation: relative offset does not lie outside the piece
+	// in case of equality as well, it lies on the next piece
+	if relativeOffset >= tfs.pieces[pieceIndex].length {
+		return 0, 0, ErrOutOfRange("relative offset")
+	}
+
+	absoluteOffset := relativeOffset + (pieceIndex * tfs.pieceLength)
+	offsetToProcessTill := absoluteOffset + length
+
+	// validation: if absolute end offset does not lie outside the torrent
+	if offsetToProcessTill > tfs.totalLength {
+		return 0, 0, ErrOutOfRange("end offset")
+	}
+
+	return absoluteOffset, offsetToProcessTill, nil
+}
+
+func (tfs *TorrentFileSystem) ReadBlock(pieceIndex int64, relativeOffset int64, length int64) (int64, []byte, error) {
+
+	/* REQUEST VALIDATION */
+	absoluteOffset, offsetToReadTill, err := tfs.validateRequest(Read, pieceIndex, relativeOffset, length)
+	if err != nil {
+		return 0, nil, ErrInvalidRequest(err)
+	}
+
+	/* LOCK THE PIECE MUTEX */
+	tfs.pieceMutexes[pieceIndex].RLock()
+	defer tfs.pieceMutexes[pieceIndex].RUnlock()
+
+	/* READ FROM STORAGE */
+	return tfs.readStorage(length, absoluteOffset, offsetToReadTill)
+}
+
+// HasPiece if the piece is completely written and verified
+func (tfs *TorrentFileSystem) HasPiece(pieceIndex int64) bool {
+	tfs.mu.Lock()
+	defer tfs.mu.Unlock()
+
+	if pieceIndex < 0 || pieceIndex >= tfs.numPieces {
+		return false
+	}
+	return tfs.hasPiece[pieceIndex]
+}
+
+func (tfs *TorrentFileSystem) readPieceForValidation(pieceIndex int64) (int64, []byte, error) {
+
+	/* REQUEST VALIDATION */
+	if pieceIndex < 0 || pieceIndex >= tfs.numPieces {
+		return 0, nil, ErrInvalidRequest(ErrOutOfRange("piece index"))
+	}
+
+	absoluteOffset := pieceIndex * tfs.pieceLength
+	offsetToReadTill := min(absoluteOffset+tfs.pieceLength, tfs.totalLength) // min for the last piece
+	lengthToRead := offsetToReadTill - absoluteOffset
+
+	/* READ FROM STORAGE */
+	return tfs.readStorage(lengthToRead, absoluteOffset, offsetToReadTill)
+}
+
+func (tp *TorrentPiece) invalidatePiece(torrentFileSystem *TorrentFileSystem) {
+	tp.complete = false
+	for i := range tp.hasBlock {
+		tp.hasBlock[i] = false
+	}
+	tp.numBlocksCompleted = 0
+	torrentFileSystem.hasPiece[tp.index] = false
+}
+
+func (tfs *TorrentFileSystem) readStorage(lengthToRead int64, absoluteOffset int64, offsetToReadTill int64) (int64, []byte, error) {
+	if offsetToReadTill-absoluteOffset != lengthToRead {
+		return 0, nil, ErrFlawInLogic("read range does not match the length to read")
+	}
+
+	buffer := make([]byte, lengthToRead)
+	n, err := tfs.storage.ReadAt(buffer, absoluteOffset)
+	if err != nil {
+		return int64(n), nil, err
+	}
+	return int64(n), buffer, nil
+}
+
+func (tfs *TorrentFileSystem) writeStorage(block []byte, lengthToWrite int64, absoluteOffset int64, offsetToWriteTill int64) (int64, error) {
+	if offsetToWriteTill-absoluteOffset != lengthToWrite || int64(len(block)) != lengthToWrite {
+		return 0, ErrFlawInLogic("write range does not match the length to write")
+	}
+
+	n, err := tfs.storage.WriteAt(block, absoluteOffset)
+	return int64(n), err
+}
+
+func (tfs *TorrentFileSystem) CleanUp() {
+	tfs.finalizing.Wait()
+
+	tfs.mu.Lock()
+	defer tfs.mu.Unlock()
+
+	if err := tfs.storage.Sync(); err != nil {
+		logs.disk.Error("error syncing storage", "err", err)
+	}
+	if err := tfs.storage.Close(); err != nil {
+		logs.disk.Error("error closing storage", "err", err)
+	}
+}
@@ -0,0 +1,252 @@
+package ptorrent
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// HandshakeMessage struct for peerConnection handshake
+type HandshakeMessage struct {
+	Pstr     string
+	Reserved [8]byte
+	InfoHash [20]byte
+	PeerId   [20]byte
+}
+
+const protocolString = "BitTorrent protocol"
+
+// HandshakeTimeout bounds the handshake of a peer, so that a silent peer does not hold a goroutine
+const HandshakeTimeout = time.Second * 10
+
+/* RESERVED BITS */
+
+const fastExtensionByte = 7
+const fastExtensionBit = 0x04
+
+const extensionProtocolByte = 5
+const extensionProtocolBit = 0x10
+
+func NewHandshakeMessage(infoHash [20]byte, peerId [20]byte) *HandshakeMessage {
+	var reserved [8]byte
+	reserved[fastExtensionByte] |= fastExtensionBit
+	reserved[extensionProtocolByte] |= extensionProtocolBit
+
+	return &HandshakeMessage{
+		Pstr:     protocolString,
+		Reserved: reserved,
+		InfoHash: infoHash,
+		PeerId:   peerId,
+	}
+}
+
+// SupportsFastExtension if the sender of the handshake has set the Fast Extension (BEP 6) bit
+func (hs *HandshakeMessage) SupportsFastExtension() bool {
+	return hs.Reserved[fastExtensionByte]&fastExtensionBit != 0
+}
+
+// SupportsExtensionProtocol if the sender of the handshake has set the Extension Protocol (BEP 10) bit
+func (hs *HandshakeMessage) SupportsExtensionProtocol() bool {
+	return hs.Reserved[extensionProtocolByte]&extensionProtocolBit != 0
+}
+
+func (hs *HandshakeMessage) String() string {
+	return fmt.Sprintf("Protocol String: %s | InfoHash: %x | PeerId: %x",
+		hs.Pstr,
+		hs.InfoHash[:],
+		hs.PeerId[:])
+}
+
+func (hs *HandshakeMessage) serialize() []byte {
+	totalLength := 1 + len(hs.Pstr) + 8 + 20 + 20
+	serializedHandshake := make([]byte, totalLength)
+
+	serializedHandshake[0] = byte(len(hs.Pstr)) // First byte is the length of protocol string
+	var pos = 1
+	pos += copy(serializedHandshake[pos:], hs.Pstr)        // protocol string
+	pos += copy(serializedHandshake[pos:], hs.Reserved[:]) // reserved 8 bytes
+	pos += copy(serializedHandshake[pos:], hs.InfoHash[:]) // info-hash
+	pos += copy(serializedHandshake[pos:], hs.PeerId[:])   // peerConnection id
+	return serializedHandshake
+}
+
+func (hs *HandshakeMessage) validate(infoHash [20]byte) error {
+	if hs == nil {
+		return newHandshakeError(HandshakeFailureProtocol, fmt.Errorf("invalid handshake"))
+	}
+
+	if hs.Pstr != protocolString {
+		return newHandshakeError(HandshakeFailureProtocol, fmt.Errorf("invalid protocol string identifier: %s", hs.Pstr))
+	}
+
+	if infoHash != hs.InfoHash {
+		return newHandshakeError(HandshakeFailureInfoHashMismatch, fmt.Errorf("invalid info-hash recieved"))
+	}
+
+	return nil
+}
+
+func parseHandshake(handshake []byte) *HandshakeMessage {
+	if handshake == nil || len(handshake) < 1+int(handshake[0])+8+20+20 {
+		return nil
+	}
+
+	lenPstr := int(handshake[0])
+	var reserved [8]byte
+	var infohash [20]byte
+	var peerId [20]byte
+
+	pstr := string(handshake[1 : lenPstr+1])
+	copy(reserved[:], handshake[lenPstr+1:lenPstr+9])
+	copy(infohash[:], handshake[lenPstr+9:lenPstr+29])
+	copy(peerId[:], handshake[lenPstr+29:lenPstr+49])
+
+	return &HandshakeMessage{
+		Pstr:     pstr,
+		Reserved: reserved,
+		InfoHash: infohash,
+		PeerId:   peerId,
+	}
+}
+
+// readHandshakeBytes reads exactly one handshake off the wire, so that messages sent right after it are not consumed
+func readHandshakeBytes(reader io.Reader) ([]byte, error) {
+	lenPstr := make([]byte, 1)
+	if _, err := io.ReadFull(reader, lenPstr); err != nil {
+		return nil, err
+	}
+
+	handshake := make([]byte, 1+int(lenPstr[0])+8+20+20)
+	handshake[0] = lenPstr[0]
+	if _, err := io.ReadFull(reader, handshake[1:]); err != nil {
+		return nil, err
+	}
+	return handshake, nil
+}
+
+func PerformHandshake(conn *PeerConnection, session *TorrentSession, peerId [20]byte) error {
+	torrent := session.torrent
+	if err := conn.tcpConn.SetDeadline(time.Now().Add(HandshakeTimeout)); err != nil {
+		return fmt.Errorf("error setting handshake deadline: %v", err)
+	}
+	defer func() { _ = conn.tcpConn.SetDeadline(time.Time{}) }()
+	handshakeMessage := NewHandshakeMessage(torrent.InfoHash, peerId)
+	_, err := sendHandshake(conn, handshakeMessage, session)
+	if err != nil {
+		return fmt.Errorf("error sending handshake message: %w", err)
+	}
+
+	conn.logs.peer.Debug("sent handshake")
+	peerHandshake, err := receiveHandshake(conn, session)
+	if err != nil {
+		return fmt.Errorf("error receiving handshake message from peer: %w", err)
+	}
+	conn.logs.peer.Debug("received handshake")
+
+	if err = peerHandshake.validate(torrent.InfoHash); err != nil {
+		return fmt.Errorf("error validating received handshake from peer %s: %w", conn.peerIdStr, err)
+	}
+	if peerHandshake.PeerId == peerId {
+		return newHandshakeError(HandshakeFailureSelf, ErrSelfConnection)
+	}
+	// the peer id of a compact tracker response is derived from the address
+	conn.setPeerId(peerHandshake.PeerId, session)
+
+	conn.supportsFastExtension = peerHandshake.SupportsFastExtension()
+	conn.supportsExtensionProtocol = peerHandshake.SupportsExtensionProtocol()
+	return nil
+}
+
+func sendHandshake(conn *PeerConnection, message *HandshakeMessage, session *TorrentSession) (n int, err error) {
+	serializedHandshake := message.serialize()
+	n, err = conn.WriteBytes(serializedHandshake, session.rateTracker)
+	if err != nil {
+		return 0, fmt.Errorf("error sending handshake message to peer: %w", err)
+	}
+	return
+}
+
+func receiveHandshake(conn *PeerConnection, session *TorrentSession) (*HandshakeMessage, error) {
+	buffer, err := readHandshakeBytes(conn.tcpConn)
+	if err != nil {
+		return nil, fmt.Errorf("error receiving handshake from peer: %w", err)
+	}
+	session.rateTracker.RecordDownload(conn.peerIdStr, len(buffer))
+	conn.SafeUpdateLastReadTime()
+
+	peerHandshake := parseHandshake(buffer)
+	if peerHandshake == nil {
+		return nil, newHandshakeError(HandshakeFailureProtocol, fmt.Errorf("no handshake recieved from peer"))
+	}
+
+	return peerHandshake, nil
+}
+
+// HandleHandshake answers the handshake of an incoming peer, if the client has an active session for its info-hash.
+// The session is returned with the error once the handshake is routed to it, to count the failure.
+func HandleHandshake(conn net.Conn, client *Client) (*HandshakeMessage, *TorrentSession, error) {
+	if err := conn.SetDeadline(time.Now().Add(HandshakeTimeout)); err != nil {
+		return nil, nil, fmt.Errorf("error setting handshake deadline: %v", err)
+	}
+	defer func() { _ = conn.SetDeadline(time.Time{}) }()
+	receivedHandshake, err := acceptHandshake(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	logs.client.Debug("received handshake from incoming peer", "addr", conn.RemoteAddr().String())
+
+	torrentSession := client.Session(receivedHandshake.InfoHash)
+	if torrentSession == nil {
+		return nil, nil, newHandshakeError(HandshakeFailureUnknownTorrent,
+			fmt.Errorf("no torrent with info-hash %x", receivedHandshake.InfoHash))
+	}
+	if torrentSession.paused.Load() {
+		return nil, torrentSession, newHandshakeError(HandshakeFailurePaused,
+			fmt.Errorf("torrent %s is paused", torrentSession.torrent.Info.Name))
+	}
+	if host, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil && torrentSession.bans.IsBanned(host) {
+		return nil, torrentSession, newHandshakeError(HandshakeFailureBanned, ErrBannedPeer)
+	}
+	if !torrentSession.peerManager.acceptsIncoming() {
+		return nil, torrentSession, newHandshakeError(HandshakeFailurePeerLimit,
+			fmt.Errorf("torrent %s has its maximum number of peers", torrentSession.torrent.Info.Name))
+	}
+	if err = receivedHandshake.validate(torrentSession.torrent.InfoHash); err != nil {
+		return nil, torrentSession, fmt.Errorf("error validating handshake from connection: %w", err)
+	}
+
+	handshakeMessage := NewHandshakeMessage(torrentSession.torrent.InfoHash, torrentSession.localPeerId)
+	_, err = respondHandshake(conn, handshakeMessage)
+	if err != nil {
+		return nil, torrentSession, err
+	}
+	torrentSession.logs.peer.Debug("sent handshake to incoming peer", "addr", conn.RemoteAddr().String())
+	// answered all the same, for the dialing end to see its own peer id
+	if receivedHandshake.PeerId == torrentSession.localPeerId {
+		return nil, torrentSession, newHandshakeError(HandshakeFailureSelf, ErrSelfConnection)
+	}
+	return receivedHandshake, torrentSession, nil
+}
+
+func acceptHandshake(conn net.Conn) (*HandshakeMessage, error) {
+	buffer, err := readHandshakeBytes(conn)
+	if err != nil {
+		return nil, fmt.Errorf("error accepting handshake from peer: %w", err)
+	}
+
+	peerHandshake := parseHandshake(buffer)
+	if peerHandshake == nil {
+		return nil, newHandshakeError(HandshakeFailureProtocol, fmt.Errorf("no handshake recieved from peer"))
+	}
+	return peerHandshake, nil
+}
+
+func respondHandshake(conn net.Conn, message *HandshakeMessage) (n int, err error) {
+	serializedHandshake := message.serialize()
+	n, err = conn.Write(serializedHandshake)
+	if err != nil {
+		return 0, fmt.Errorf("error responding to handshake by peer: %w", err)
+	}
+	return
+}
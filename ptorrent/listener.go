@@ -0,0 +1,115 @@
+package ptorrent
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+type Listener struct {
+	conn *net.TCPListener
+	port uint16
+}
+
+func NewListener(port uint16) (*Listener, error) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: int(port)})
+	if err != nil {
+		return nil, fmt.Errorf("error mounting a listener on port %d: %v", port, err)
+	}
+
+	return &Listener{conn: listener, port: port}, nil
+}
+
+// StartListening Meant to be run as a goroutine, accepts connections for every torrent of the client
+func (l *Listener) StartListening(client *Client) {
+	for {
+		conn, err := l.conn.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logs.client.Warn("listener accept failed", "err", err)
+			continue
+		}
+		// a slow handshake does not hold up other incoming connections
+		client.startGoroutine(func() { client.handleIncomingConnection(conn) })
+	}
+}
+
+// handleIncomingConnection routes the connection to the session of the info-hash in its handshake
+func (c *Client) handleIncomingConnection(conn net.Conn) {
+	remoteAddr := conn.RemoteAddr().String()
+	host, portStr, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		logs.client.Warn("error splitting host and port for connection", "addr", remoteAddr, "err", err)
+		_ = conn.Close()
+		return
+	}
+
+	ip := net.ParseIP(host)
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		logs.client.Warn("error converting port-string to integer", "addr", remoteAddr, "err", err)
+		_ = conn.Close()
+		return
+	}
+
+	if !c.acquireConnectionSlot() {
+		logs.client.Info("maximum number of connections reached, refusing incoming peer", "addr", remoteAddr)
+		_ = conn.Close()
+		return
+	}
+
+	// a handshake in progress is aborted once the client is closed
+	stopAbort := context.AfterFunc(c.ctx, func() { _ = conn.Close() })
+	receivedHandshake, session, err := HandleHandshake(conn, c)
+	aborted := !stopAbort()
+	if aborted && err == nil {
+		err = fmt.Errorf("client closed during the handshake")
+	}
+	if err != nil {
+		c.releaseConnectionSlot()
+		if !aborted {
+			c.countHandshakeFailure(session, err)
+		}
+		logs.client.Debug("can not perform handshake with incoming peer", "addr", remoteAddr, "err", err)
+		_ = conn.Close()
+		return
+	}
+	peer := Peer{
+		PeerId: receivedHandshake.PeerId,
+		IP:     ip,
+		Type:   GetIPType(ip),
+		Port:   uint16(port),
+	}
+
+	if err = CreatePeerConnectionAndStartReaderWriter(peer, conn, receivedHandshake, session); err != nil {
+		c.releaseConnectionSlot()
+		c.countHandshakeFailure(session, err)
+		session.logs.peer.Debug("closing incoming peer", "addr", remoteAddr, "err", err)
+		_ = conn.Close()
+		return
+	}
+	session.logs.peer.Debug("incoming peer connected", "peer", hex.EncodeToString(receivedHandshake.PeerId[:]), "addr", remoteAddr)
+}
+
+// countHandshakeFailure failures before the handshake is routed to a session are counted by the client
+func (c *Client) countHandshakeFailure(session *TorrentSession, err error) {
+	if session != nil {
+		session.metrics.handshakeFailures.add(handshakeFailureReason(err))
+	} else {
+		c.handshakeFailures.add(handshakeFailureReason(err))
+	}
+}
+
+func (l *Listener) CloseListener() {
+	err := l.conn.Close()
+	if err != nil {
+		logs.client.Warn("error closing tcp listener", "err", err)
+		return
+	}
+	logs.client.Info("listener closed")
+}
@@ -0,0 +1,227 @@
+package ptorrent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+/** TOC
+- SUBSYSTEMS
+	- SetLogLevel, SetAllLogLevels, LogLevel, ParseLogLevels
+- OUTPUT
+	- SetLogHandler, SetLogOutput
+- HANDLER
+	- subsystemHandler
+- LOGGERS
+	- Logger
+	- subsystemLoggers
+*/
+
+/*
+- What is it supposed to do
+- - Every subsystem of the engine logs with `log/slog` to its own logger, tagged `subsystem=<name>`; the loggers of a
+    torrent carry its `infohash`, those of a peer connection also carry the `peer` id.
+- - Each subsystem has its own level, changed at runtime with `SetLogLevel`. Records below the level are dropped
+    before any formatting, so that per-block and per-message debug logs cost nothing by default.
+- - The default is quiet: warnings and errors only. Lifecycle events (torrent added, announced, completed) are info,
+    protocol chatter is debug.
+- - Every logger writes to one handler, text on stderr by default, changed at runtime with `SetLogHandler`.
+*/
+
+type Subsystem string
+
+const (
+	SubsystemClient  Subsystem = "client"  // sessions, listener, handshakes
+	SubsystemTracker Subsystem = "tracker" // announces and their responses
+	SubsystemPeer    Subsystem = "peer"    // peer wire: connections and messages
+	SubsystemDisk    Subsystem = "disk"    // file system, disk workers, resume data, recheck, staging
+	SubsystemChoker  Subsystem = "choker"  // choking, interest and rate tracking
+	SubsystemPicker  Subsystem = "picker"  // piece selection and the bitfields of peers
+	SubsystemApi     Subsystem = "api"     // the http api, outside the engine
+)
+
+var Subsystems = []Subsystem{
+	SubsystemClient, SubsystemTracker, SubsystemPeer, SubsystemDisk, SubsystemChoker, SubsystemPicker, SubsystemApi,
+}
+
+const DefaultLogLevel = slog.LevelWarn
+
+var logLevels = func() map[Subsystem]*slog.LevelVar {
+	levels := make(map[Subsystem]*slog.LevelVar, len(Subsystems))
+	for _, subsystem := range Subsystems {
+		levels[subsystem] = new(slog.LevelVar)
+		levels[subsystem].Set(DefaultLogLevel)
+	}
+	return levels
+}()
+
+var logHandler atomic.Pointer[slog.Handler]
+
+func init() {
+	SetLogOutput(os.Stderr)
+}
+
+/************************************** SUBSYSTEMS **************************************/
+
+// SetLogLevel takes effect immediately, for every logger of the subsystem
+func SetLogLevel(subsystem Subsystem, level slog.Level) error {
+	levelVar, ok := logLevels[subsystem]
+	if !ok {
+		return fmt.Errorf("unknown log subsystem %q", subsystem)
+	}
+	levelVar.Set(level)
+	return nil
+}
+
+func SetAllLogLevels(level slog.Level) {
+	for _, levelVar := range logLevels {
+		levelVar.Set(level)
+	}
+}
+
+func LogLevel(subsystem Subsystem) slog.Level {
+	if levelVar, ok := logLevels[subsystem]; ok {
+		return levelVar.Level()
+	}
+	return DefaultLogLevel
+}
+
+// ParseLogLevels parses comma separated levels, e.g. `info,tracker=debug,peer=error`; a level without a subsystem
+// applies to every subsystem not listed
+func ParseLogLevels(value string) (map[Subsystem]slog.Level, error) {
+	levels := make(map[Subsystem]slog.Level)
+	var defaultLevel *slog.Level
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, levelName, found := strings.Cut(entry, "=")
+		if !found {
+			name, levelName = "", entry
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(levelName)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q: %v", levelName, err)
+		}
+		if name == "" {
+			defaultLevel = &level
+			continue
+		}
+		if _, ok := logLevels[Subsystem(name)]; !ok {
+			return nil, fmt.Errorf("unknown log subsystem %q, expected one of %v", name, Subsystems)
+		}
+		levels[Subsystem(name)] = level
+	}
+	if defaultLevel != nil {
+		for _, subsystem := range Subsystems {
+			if _, ok := levels[subsystem]; !ok {
+				levels[subsystem] = *defaultLevel
+			}
+		}
+	}
+	return levels, nil
+}
+
+/************************************** OUTPUT **************************************/
+
+// SetLogHandler the handler receives the records of every subsystem at or above its level; its own level should
+// let them all through
+func SetLogHandler(handler slog.Handler) {
+	logHandler.Store(&handler)
+}
+
+// SetLogOutput logs as text to the writer
+func SetLogOutput(writer io.Writer) {
+	SetLogHandler(slog.NewTextHandler(writer, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+/************************************** HANDLER **************************************/
+
+// subsystemHandler filters by the level of its subsystem, and hands records to the current log handler
+type subsystemHandler struct {
+	level *slog.LevelVar
+	with  []func(slog.Handler) slog.Handler // attributes and groups, applied in order to the current handler
+}
+
+func (h *subsystemHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *subsystemHandler) Handle(ctx context.Context, record slog.Record) error {
+	handler := *logHandler.Load()
+	for _, with := range h.with {
+		handler = with(handler)
+	}
+	return handler.Handle(ctx, record)
+}
+
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.withFunc(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	return h.withFunc(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+func (h *subsystemHandler) withFunc(with func(slog.Handler) slog.Handler) *subsystemHandler {
+	return &subsystemHandler{
+		level: h.level,
+		with:  append(h.with[:len(h.with):len(h.with)], with),
+	}
+}
+
+/************************************** LOGGERS **************************************/
+
+// Logger the logger of a subsystem, for the packages built on the engine; nil for an unknown subsystem
+func Logger(subsystem Subsystem) *slog.Logger {
+	if _, ok := logLevels[subsystem]; !ok {
+		return nil
+	}
+	return newLogger(subsystem)
+}
+
+func newLogger(subsystem Subsystem) *slog.Logger {
+	return slog.New(&subsystemHandler{level: logLevels[subsystem]}).With("subsystem", string(subsystem))
+}
+
+// subsystemLoggers one logger per subsystem, sharing the same attributes
+type subsystemLoggers struct {
+	client  *slog.Logger
+	tracker *slog.Logger
+	peer    *slog.Logger
+	disk    *slog.Logger
+	choker  *slog.Logger
+	picker  *slog.Logger
+}
+
+// logs the loggers of code not bound to a torrent
+var logs = newSubsystemLoggers()
+
+func newSubsystemLoggers() *subsystemLoggers {
+	return &subsystemLoggers{
+		client:  newLogger(SubsystemClient),
+		tracker: newLogger(SubsystemTracker),
+		peer:    newLogger(SubsystemPeer),
+		disk:    newLogger(SubsystemDisk),
+		choker:  newLogger(SubsystemChoker),
+		picker:  newLogger(SubsystemPicker),
+	}
+}
+
+// with attaches the attributes, e.g. the info-hash of a torrent, to every logger
+func (sl *subsystemLoggers) with(args ...any) *subsystemLoggers {
+	return &subsystemLoggers{
+		client:  sl.client.With(args...),
+		tracker: sl.tracker.With(args...),
+		peer:    sl.peer.With(args...),
+		disk:    sl.disk.With(args...),
+		choker:  sl.choker.With(args...),
+		picker:  sl.picker.With(args...),
+	}
+}
@@ -0,0 +1,227 @@
+package ptorrent
+
+import (
+	bencodingParser "bittorrent-client/bencoding-parser"
+	"bytes"
+	"context"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+/** TOC
+- MAGNET LINKS
+	- ParseMagnetLink
+	- MagnetLink.peers, MagnetLink.httpTrackers, MagnetLink.torrent
+- CLIENT
+	- AddMagnet, FetchingMetadata
+	- fetchMetadataAndAdd (goroutine)
+*/
+
+/*
+- What is it supposed to do
+- - Parses magnet links: the info-hash `xt=urn:btih:`, in hex or base32, along with the optional display name `dn`,
+    trackers `tr` and peer addresses `x.pe`.
+- - A torrent added from a magnet link is added to the client once its metadata, the info dictionary, is fetched from
+    peers, see metadata-fetch.go. The fetch runs in the background till it succeeds, the torrent is removed, or the
+    client is closed.
+- - The trackers of the link are those of the torrent, each one in a tier of its own; the first HTTP tracker is the one
+    announced to.
+*/
+
+type MagnetLink struct {
+	InfoHash [20]byte
+	Name     string   // display name, "" if not given
+	Trackers []string // announce URLs
+	Peers    []string // host:port
+}
+
+/************************************** MAGNET LINKS **************************************/
+
+func ParseMagnetLink(link string) (*MagnetLink, error) {
+	uri, err := url.Parse(link)
+	if err != nil {
+		return nil, ErrInvalidMagnetLink(err.Error())
+	}
+	if uri.Scheme != "magnet" {
+		return nil, ErrInvalidMagnetLink(fmt.Sprintf("scheme %q is not magnet", uri.Scheme))
+	}
+	query, err := url.ParseQuery(uri.RawQuery)
+	if err != nil {
+		return nil, ErrInvalidMagnetLink(err.Error())
+	}
+
+	magnet := &MagnetLink{Name: query.Get("dn"), Trackers: query["tr"], Peers: query["x.pe"]}
+	for _, exactTopic := range query["xt"] {
+		// other topics, e.g. the 'urn:btmh:' of v2 torrents, are skipped
+		encoded, ok := strings.CutPrefix(exactTopic, "urn:btih:")
+		if !ok {
+			continue
+		}
+		if magnet.InfoHash, err = decodeMagnetInfoHash(encoded); err != nil {
+			return nil, err
+		}
+		return magnet, nil
+	}
+	return nil, ErrInvalidMagnetLink("no 'xt=urn:btih:' info-hash")
+}
+
+// decodeMagnetInfoHash 40 hex characters, or 32 base32 characters
+func decodeMagnetInfoHash(encoded string) ([20]byte, error) {
+	var infoHash [20]byte
+	var decoded []byte
+	var err error
+	switch len(encoded) {
+	case 40:
+		decoded, err = hex.DecodeString(encoded)
+	case 32:
+		decoded, err = base32.StdEncoding.DecodeString(strings.ToUpper(encoded))
+	default:
+		return infoHash, ErrInvalidMagnetLink(fmt.Sprintf("info-hash %q is neither 40 hex nor 32 base32 characters", encoded))
+	}
+	if err != nil {
+		return infoHash, ErrInvalidMagnetLink(fmt.Sprintf("info-hash %q: %v", encoded, err))
+	}
+	copy(infoHash[:], decoded)
+	return infoHash, nil
+}
+
+// peers the peer addresses of the link given as IP addresses; host names are not resolved
+func (m *MagnetLink) peers() []Peer {
+	var peers []Peer
+	for _, address := range m.Peers {
+		host, portString, err := net.SplitHostPort(address)
+		if err != nil {
+			continue
+		}
+		ip := net.ParseIP(host)
+		port, err := strconv.ParseUint(portString, 10, 16)
+		if ip == nil || err != nil || port == 0 {
+			continue
+		}
+		peers = append(peers, Peer{IP: ip, Type: GetIPType(ip), Port: uint16(port)})
+	}
+	return peers
+}
+
+// httpTrackers the trackers the client can announce to
+func (m *MagnetLink) httpTrackers() []string {
+	var trackers []string
+	for _, tracker := range m.Trackers {
+		if strings.HasPrefix(tracker, "http://") || strings.HasPrefix(tracker, "https://") {
+			trackers = append(trackers, tracker)
+		}
+	}
+	return trackers
+}
+
+// torrent builds the torrent from the fetched info dictionary and the trackers of the link
+func (m *MagnetLink) torrent(metadata []byte) (*Torrent, error) {
+	info, err := bencodingParser.ParseBencodeFromByteSlice(metadata)
+	if err != nil || info.BDict == nil {
+		return nil, fmt.Errorf("invalid metadata: %v", err)
+	}
+
+	entries := map[string]*bencodingParser.Bencode{
+		InfoKey:     info,
+		AnnounceKey: bencodingParser.NewBencodeFromString(""),
+	}
+	if trackers := m.httpTrackers(); len(trackers) > 0 {
+		entries[AnnounceKey] = bencodingParser.NewBencodeFromString(trackers[0])
+	}
+	if len(m.Trackers) > 0 {
+		tiers := bencodingParser.NewBencodeList()
+		for _, tracker := range m.Trackers {
+			tier := bencodingParser.NewBencodeList()
+			tier.Add(bencodingParser.NewBencodeFromString(tracker))
+			tiers.Add(bencodingParser.NewBencodeFromBList(tier))
+		}
+		entries[AnnounceListKey] = bencodingParser.NewBencodeFromBList(tiers)
+	}
+	metainfo, err := bencodingParser.SerializeBencode(bencodingParser.NewSortedBencodeDict(entries))
+	if err != nil {
+		return nil, err
+	}
+
+	torrent, err := LoadTorrent(bytes.NewReader(metainfo))
+	if err != nil {
+		return nil, err
+	}
+	// the info dictionary is serialized again when loaded, keys out of order would change the info-hash
+	if torrent.InfoHash != m.InfoHash {
+		return nil, ErrMetadataHashMismatch
+	}
+	return torrent, nil
+}
+
+/************************************** CLIENT **************************************/
+
+// AddMagnet looks up peers through the trackers and the peer addresses of the link, fetches the metadata from them in
+// the background, then adds the torrent with the options as AddTorrent does. RemoveTorrent cancels the fetch.
+func (c *Client) AddMagnet(magnet *MagnetLink, options *AddTorrentOptions) error {
+	if options == nil {
+		options = &AddTorrentOptions{}
+	}
+	c.mu.Lock()
+	_, exists := c.sessions[magnet.InfoHash]
+	_, fetching := c.metadataFetches[magnet.InfoHash]
+	if exists || fetching {
+		c.mu.Unlock()
+		return ErrTorrentAlreadyAdded(magnet.InfoHash)
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.metadataFetches[magnet.InfoHash] = cancel
+	c.mu.Unlock()
+
+	addOptions := *options
+	c.startGoroutine(func() { c.fetchMetadataAndAdd(ctx, magnet, &addOptions) })
+	return nil
+}
+
+// FetchingMetadata if the torrent was added from a magnet link, and its metadata is not fetched yet
+func (c *Client) FetchingMetadata(infoHash [20]byte) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, fetching := c.metadataFetches[infoHash]
+	return fetching
+}
+
+// fetchMetadataAndAdd Meant to be run as a goroutine, till the torrent is added or the fetch is cancelled
+func (c *Client) fetchMetadataAndAdd(ctx context.Context, magnet *MagnetLink, options *AddTorrentOptions) {
+	defer func() {
+		c.mu.Lock()
+		if cancel, fetching := c.metadataFetches[magnet.InfoHash]; fetching {
+			cancel()
+			delete(c.metadataFetches, magnet.InfoHash)
+		}
+		c.mu.Unlock()
+	}()
+
+	fetch := newMetadataFetch(c, magnet)
+	fetch.logs.client.Info("fetching the metadata of a magnet link", "name", magnet.Name)
+	metadata, peers, err := fetch.run(ctx)
+	if err != nil {
+		fetch.logs.client.Info("metadata fetch stopped", "err", err)
+		return
+	}
+	torrent, err := magnet.torrent(metadata)
+	if err != nil {
+		fetch.logs.client.Error("can not load the fetched metadata", "err", err)
+		return
+	}
+	// removed or closed while the last piece arrived
+	if ctx.Err() != nil {
+		return
+	}
+
+	session, err := c.AddTorrent(torrent, options)
+	if err != nil {
+		fetch.logs.client.Error("can not add the torrent of a magnet link", "err", err)
+		return
+	}
+	session.peerManager.AddPeers(peers, PeerSourceMagnet)
+}
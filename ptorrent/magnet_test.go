@@ -0,0 +1,175 @@
+package ptorrent
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMagnetLink(t *testing.T) {
+	infoHash := [20]byte{0x15, 0x3e, 0x23, 0xaa, 0x05, 0x6a, 0x9f, 0x6f, 0x2c, 0x4d, 0x33, 0xa9, 0x79, 0x91, 0x5c, 0xbc, 0xda, 0xed, 0x0a, 0x2e}
+	hexLink := "magnet:?xt=urn:btih:" + hex.EncodeToString(infoHash[:]) + "&dn=some+name" +
+		"&tr=" + url.QueryEscape("http://tracker.example.com/announce") + "&tr=udp%3A%2F%2Fbackup.example.com%3A6969" +
+		"&x.pe=127.0.0.1:6881&x.pe=%5B::1%5D:6882&x.pe=peer.example.com:6883"
+	magnet, err := ParseMagnetLink(hexLink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if magnet.InfoHash != infoHash || magnet.Name != "some name" || len(magnet.Trackers) != 2 || len(magnet.Peers) != 3 {
+		t.Errorf("parsed %+v", magnet)
+	}
+	if trackers := magnet.httpTrackers(); len(trackers) != 1 || trackers[0] != "http://tracker.example.com/announce" {
+		t.Errorf("http trackers %v, expected the first tracker only", trackers)
+	}
+	// host names are not resolved
+	if peers := magnet.peers(); len(peers) != 2 || peers[0].Port != 6881 || peers[1].Type != IPv6 {
+		t.Errorf("peers %v, expected the IPv4 and IPv6 addresses", peers)
+	}
+
+	base32Link := "magnet:?xt=urn:btih:" + strings.ToLower(base32.StdEncoding.EncodeToString(infoHash[:]))
+	if magnet, err = ParseMagnetLink(base32Link); err != nil || magnet.InfoHash != infoHash {
+		t.Errorf("base32 info-hash: %+v, %v", magnet, err)
+	}
+	// the btih topic is found among others
+	if magnet, err = ParseMagnetLink("magnet:?xt=urn:btmh:1220abcd&xt=urn:btih:" + hex.EncodeToString(infoHash[:])); err != nil || magnet.InfoHash != infoHash {
+		t.Errorf("second topic: %+v, %v", magnet, err)
+	}
+
+	for _, link := range []string{
+		"", "http://example.com/?xt=urn:btih:" + hex.EncodeToString(infoHash[:]), "magnet:?dn=name",
+		"magnet:?xt=urn:btmh:1220abcd", "magnet:?xt=urn:btih:1234", "magnet:?xt=urn:btih:" + strings.Repeat("z", 40),
+		"magnet:?xt=urn:btih:" + strings.Repeat("1", 32),
+	} {
+		if _, err = ParseMagnetLink(link); err == nil {
+			t.Errorf("%q: expected an error", link)
+		}
+	}
+}
+
+// TestAddMagnet a leecher adds a torrent from a magnet link giving the address of the seeder only: the metadata is
+// fetched from the seeder, then the data is downloaded. The file names are long enough for the metadata to be sent
+// in more than one piece.
+func TestAddMagnet(t *testing.T) {
+	dir := chdirTemp(t)
+
+	const numFiles = 200
+	var data []byte
+	for i := 0; i < numFiles; i++ {
+		name := fmt.Sprintf("%03d-%s.bin", i, strings.Repeat("x", 90))
+		data = append(data, writeRandomFile(t, filepath.Join(dir, "payload", name), 100, int64(i))...)
+	}
+	// the multi-file seeder reads its data from <name>/<path>, where the files were written
+	_, torrent, err := CreateTorrent(CreateTorrentOptions{
+		Path:        filepath.Join(dir, "payload"),
+		Announce:    "http://127.0.0.1:1/announce", // unreachable, the peer is given by the magnet link
+		PieceLength: BlockSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(torrent.infoBytes) <= metadataPieceSize {
+		t.Fatalf("metadata of %d bytes fits in a single piece", len(torrent.infoBytes))
+	}
+
+	seederPort := freePort(t)
+	seeder, err := NewClient(&ClientOptions{ListenerPort: seederPort})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeClient(t, seeder)
+	if err = seeder.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	seed, err := seeder.AddTorrent(torrent, &AddTorrentOptions{Recheck: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !seed.Stats().Complete {
+		t.Fatal("the seeder does not have every piece after the recheck")
+	}
+
+	leecher, err := NewClient(&ClientOptions{ListenerPort: freePort(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeClient(t, leecher)
+	events, unsubscribe := leecher.Subscribe(64)
+	defer unsubscribe()
+
+	magnet, err := ParseMagnetLink(fmt.Sprintf("magnet:?xt=urn:btih:%x&dn=payload&x.pe=127.0.0.1:%d", torrent.InfoHash, seederPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the recheck ignores the resume file of the seeder, written to the same directory
+	if err = leecher.AddMagnet(magnet, &AddTorrentOptions{StorageType: MemoryStorageType, Recheck: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err = leecher.AddMagnet(magnet, nil); err == nil {
+		t.Error("expected an error adding the magnet link twice")
+	}
+
+	timeout := time.After(time.Second * 30)
+	for completed := false; !completed; {
+		select {
+		case event := <-events:
+			completed = event.Type == EventTorrentCompleted
+		case <-timeout:
+			t.Fatalf("download not complete in time, fetching metadata: %v", leecher.FetchingMetadata(torrent.InfoHash))
+		}
+	}
+
+	leech := leecher.Session(torrent.InfoHash)
+	if leech == nil {
+		t.Fatal("no session for the magnet link")
+	}
+	if !bytes.Equal(leech.torrent.infoBytes, torrent.infoBytes) {
+		t.Error("fetched metadata differs from the seeded metadata")
+	}
+	if leecher.FetchingMetadata(torrent.InfoHash) {
+		t.Error("still fetching the metadata once the torrent is complete")
+	}
+	downloaded := make([]byte, len(data))
+	if _, err = leech.fileSystem.storage.ReadAt(downloaded, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, data) {
+		t.Error("downloaded data differs from the seeded data")
+	}
+}
+
+// TestRemoveMagnet removing a torrent while its metadata is fetched cancels the fetch
+func TestRemoveMagnet(t *testing.T) {
+	chdirTemp(t)
+	client, err := NewClient(&ClientOptions{ListenerPort: freePort(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeClient(t, client)
+
+	// no peer to fetch the metadata from
+	magnet, err := ParseMagnetLink("magnet:?xt=urn:btih:" + strings.Repeat("ab", 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.AddMagnet(magnet, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !client.FetchingMetadata(magnet.InfoHash) {
+		t.Error("not fetching the metadata")
+	}
+	if err = client.RemoveTorrent(magnet.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	if client.FetchingMetadata(magnet.InfoHash) {
+		t.Error("still fetching the metadata once removed")
+	}
+	if err = client.AddMagnet(magnet, nil); err != nil {
+		t.Errorf("adding the magnet link again: %v", err)
+	}
+}
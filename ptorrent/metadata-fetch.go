@@ -0,0 +1,323 @@
+package ptorrent
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+/** TOC
+- METADATA FETCH
+	- newMetadataFetch, run
+	- findPeers, fetchFromAny
+- PEER
+	- fetchFromPeer, readExtendedMessage, closeGracefully
+*/
+
+/*
+- What is it supposed to do
+- - Fetches the info dictionary of a torrent known by its info-hash alone, with the ut_metadata extension (BEP 9).
+- - Peers are those of the magnet link, and those returned by announcing once to each of its HTTP trackers.
+- - `metadataFetchWorkers` peers are tried at once. Each one gets a handshake with the extension protocol bit, then an
+    extended handshake; the pieces of the metadata are requested one after the other. The first peer to send all of
+    them stops the others.
+- - The metadata is only accepted once its SHA-1 matches the info-hash. If no peer sent it, peers are looked up and tried
+    again after `metadataRetryInterval`.
+*/
+
+const metadataFetchWorkers = 4
+const metadataDialTimeout = time.Second * 5
+const metadataPeerTimeout = time.Second * 30 // bounds the whole exchange with a single peer
+const metadataRetryInterval = time.Second * 30
+const metadataCloseTimeout = time.Second * 2
+
+// metadataUnknownLeft announced while the length of the torrent is not known; 0 would announce a seeder
+const metadataUnknownLeft = 1
+
+type metadataFetch struct {
+	client *Client
+	magnet *MagnetLink
+	logs   *subsystemLoggers
+}
+
+/************************************** METADATA FETCH **************************************/
+
+func newMetadataFetch(client *Client, magnet *MagnetLink) *metadataFetch {
+	return &metadataFetch{
+		client: client,
+		magnet: magnet,
+		logs:   logs.with("infohash", hex.EncodeToString(magnet.InfoHash[:])),
+	}
+}
+
+// run returns the verified metadata along with the peers found, or the error of the context once it is done
+func (mf *metadataFetch) run(ctx context.Context) ([]byte, []Peer, error) {
+	for {
+		peers := mf.findPeers(ctx)
+		metadata, err := mf.fetchFromAny(ctx, peers)
+		if err == nil {
+			mf.logs.client.Info("metadata fetched", "bytes", len(metadata))
+			return metadata, peers, nil
+		}
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+
+		mf.logs.client.Info("metadata not fetched, retrying", "err", err, "retryIn", metadataRetryInterval)
+		if err = sleepWithContext(ctx, metadataRetryInterval); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// findPeers the peers of the link and of its HTTP trackers, without duplicates nor our own addresses
+func (mf *metadataFetch) findPeers(ctx context.Context) []Peer {
+	peers := mf.magnet.peers()
+	for _, announce := range mf.magnet.httpTrackers() {
+		trackerClient := newTrackerClient(announce, mf.magnet.InfoHash, mf.client.localPeerId,
+			mf.client.configurable.listenerPort, &sessionMetrics{}, mf.logs)
+		// a single attempt, `run` retries
+		response, err := trackerClient.getTrackerResponse(ctx, "", 0, 0, metadataUnknownLeft)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			mf.logs.tracker.Warn("error announcing to the tracker", "tracker", announce, "err", err)
+			continue
+		}
+		peers = append(peers, response.Peers...)
+	}
+
+	seen := make(map[string]struct{})
+	unique := make([]Peer, 0, len(peers))
+	for _, peer := range peers {
+		address := peerAddress(peer)
+		if _, exists := seen[address]; exists || peer.Type == InvalidIpType || mf.client.isSelfAddress(address) {
+			continue
+		}
+		seen[address] = struct{}{}
+		unique = append(unique, peer)
+	}
+	return unique
+}
+
+// fetchFromAny tries `metadataFetchWorkers` peers at once, till one of them sends the metadata
+func (mf *metadataFetch) fetchFromAny(ctx context.Context, peers []Peer) ([]byte, error) {
+	if len(peers) == 0 {
+		return nil, ErrMetadataNotFetched(0)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := make(chan Peer, len(peers))
+	for _, peer := range peers {
+		queue <- peer
+	}
+	close(queue)
+
+	var once sync.Once
+	var metadata []byte
+	var wg sync.WaitGroup
+	for range min(metadataFetchWorkers, len(peers)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for peer := range queue {
+				if ctx.Err() != nil {
+					return
+				}
+				fetched, err := mf.fetchFromPeer(ctx, peer)
+				if err != nil {
+					if ctx.Err() == nil {
+						mf.logs.peer.Debug("metadata not fetched from the peer", "peer", peerAddress(peer), "err", err)
+					}
+					continue
+				}
+				once.Do(func() {
+					metadata = fetched
+					cancel()
+				})
+				return
+			}
+		}()
+	}
+	wg.Wait()
+
+	if metadata == nil {
+		return nil, ErrMetadataNotFetched(len(peers))
+	}
+	return metadata, nil
+}
+
+/************************************** PEER **************************************/
+
+// fetchFromPeer downloads the metadata from a single peer, and checks it against the info-hash
+func (mf *metadataFetch) fetchFromPeer(ctx context.Context, peer Peer) ([]byte, error) {
+	if !mf.client.acquireConnectionSlot() {
+		return nil, fmt.Errorf("maximum number of connections reached")
+	}
+	defer mf.client.releaseConnectionSlot()
+
+	ctx, cancel := context.WithTimeout(ctx, metadataPeerTimeout)
+	defer cancel()
+	address := peerAddress(peer)
+	dialer := net.Dialer{Timeout: metadataDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// unblocks reads and writes once the fetch is cancelled or times out
+	stopClosing := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stopClosing()
+
+	infoHash := mf.magnet.InfoHash
+	if _, err = conn.Write(NewHandshakeMessage(infoHash, mf.client.localPeerId).serialize()); err != nil {
+		return nil, err
+	}
+	handshakeBytes, err := readHandshakeBytes(conn)
+	if err != nil {
+		return nil, err
+	}
+	handshake := parseHandshake(handshakeBytes)
+	if err = handshake.validate(infoHash); err != nil {
+		return nil, err
+	}
+	if handshake.PeerId == mf.client.localPeerId {
+		mf.client.rememberSelfAddress(address)
+		return nil, ErrSelfConnection
+	}
+	if !handshake.SupportsExtensionProtocol() {
+		return nil, ErrNoMetadataExtension
+	}
+
+	message, err := newExtendedHandshakeMessage(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write(message.Serialize()); err != nil {
+		return nil, err
+	}
+
+	var metadata []byte // nil till the extended handshake of the peer
+	var metadataSize int64
+	var peerMetadataId byte
+	requestPiece := func(piece int64) error {
+		request, err := newMetadataMessage(peerMetadataId, metadataRequest, piece, 0, nil)
+		if err != nil {
+			return err
+		}
+		_, err = conn.Write(request.Serialize())
+		return err
+	}
+	for {
+		extendedId, payload, err := readExtendedMessage(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case extendedId == extendedHandshakeId && metadata == nil:
+			peerHandshake, err := parseExtendedHandshake(payload)
+			if err != nil {
+				return nil, err
+			}
+			if peerHandshake.metadataId == 0 {
+				return nil, ErrNoMetadataExtension
+			}
+			if peerHandshake.metadataSize <= 0 || peerHandshake.metadataSize > maxMetadataSize {
+				return nil, ErrMetadataSizeInvalid(peerHandshake.metadataSize)
+			}
+			peerMetadataId = peerHandshake.metadataId
+			metadataSize = peerHandshake.metadataSize
+			metadata = make([]byte, 0, metadataSize)
+			if err = requestPiece(0); err != nil {
+				return nil, err
+			}
+
+		case extendedId == utMetadataId && metadata != nil:
+			response, err := parseMetadataMessage(payload)
+			if err != nil {
+				return nil, err
+			}
+			if response.messageType == metadataReject {
+				return nil, ErrMetadataRejected
+			}
+			if response.messageType != metadataData {
+				continue
+			}
+
+			piece := int64(len(metadata)) / metadataPieceSize
+			expectedLength := min(metadataPieceSize, metadataSize-int64(len(metadata)))
+			if response.piece != piece || response.totalSize != metadataSize || int64(len(response.data)) != expectedLength {
+				return nil, fmt.Errorf("unexpected metadata piece %d of %d bytes, total size %d; expected piece %d",
+					response.piece, len(response.data), response.totalSize, piece)
+			}
+			metadata = append(metadata, response.data...)
+			if int64(len(metadata)) < metadataSize {
+				if err = requestPiece(piece + 1); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			if sha1.Sum(metadata) != infoHash {
+				return nil, ErrMetadataHashMismatch
+			}
+			closeGracefully(conn)
+			return metadata, nil
+		}
+	}
+}
+
+// readExtendedMessage reads messages off the wire till an extended one; the others, e.g. the piece availability of
+// the peer, are skipped
+func readExtendedMessage(reader io.Reader) (byte, []byte, error) {
+	lengthPrefix := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(reader, lengthPrefix); err != nil {
+			return 0, nil, err
+		}
+		messageLength := binary.BigEndian.Uint32(lengthPrefix)
+		if messageLength > MaxMessageLength {
+			return 0, nil, fmt.Errorf("message length %d exceeds the maximum of %d bytes", messageLength, MaxMessageLength)
+		}
+		// keep-alive
+		if messageLength == 0 {
+			continue
+		}
+
+		message := make([]byte, messageLength)
+		if _, err := io.ReadFull(reader, message); err != nil {
+			return 0, nil, err
+		}
+		if PeerMessageType(message[0]) != Extended {
+			continue
+		}
+		if messageLength < 2 {
+			return 0, nil, errors.New("extended message without an id")
+		}
+		return message[1], message[2:], nil
+	}
+}
+
+// closeGracefully waits for the peer to close its end of the connection, so that it forgets about it before the
+// session of the torrent connects again; the new connection would be closed as a duplicate otherwise
+func closeGracefully(conn net.Conn) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if err := tcpConn.CloseWrite(); err != nil {
+		return
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(metadataCloseTimeout))
+	_, _ = io.Copy(io.Discard, conn)
+}
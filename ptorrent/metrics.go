@@ -0,0 +1,234 @@
+package ptorrent
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/** TOC
+- HANDSHAKE FAILURES
+	- handshakeError, handshakeFailureReason
+- SESSION METRICS
+	- sessionMetrics, histogram
+- SNAPSHOTS
+	- TorrentSession.Metrics, Client.HandshakeFailures
+*/
+
+/*
+- What is it supposed to do
+- - Counts what the stats do not: payload and protocol bytes, verified and failed pieces, tracker announces and their
+    latency, and handshake failures by reason, for every torrent.
+- - Byte and piece counters are atomics, updated from the hot paths; snapshots are read by the http api to serve `/metrics`.
+- - Handshake failures of incoming connections that can not be routed to a torrent are counted by the client.
+*/
+
+type HandshakeFailureReason string
+
+const (
+	HandshakeFailureDial             HandshakeFailureReason = "dial"               // the tcp connection could not be opened
+	HandshakeFailureTimeout          HandshakeFailureReason = "timeout"            // the peer did not answer within HandshakeTimeout
+	HandshakeFailureConnection       HandshakeFailureReason = "connection"         // the connection was closed or reset
+	HandshakeFailureProtocol         HandshakeFailureReason = "protocol"           // not a BitTorrent handshake
+	HandshakeFailureInfoHashMismatch HandshakeFailureReason = "info_hash_mismatch" // the peer answered for another torrent
+	HandshakeFailureUnknownTorrent   HandshakeFailureReason = "unknown_torrent"    // an incoming peer asked for a torrent we do not have
+	HandshakeFailurePaused           HandshakeFailureReason = "paused"             // an incoming peer asked for a paused torrent
+	HandshakeFailurePeerLimit        HandshakeFailureReason = "peer_limit"         // an incoming peer asked for a torrent with its maximum number of peers
+	HandshakeFailureSelf             HandshakeFailureReason = "self"               // we connected to ourselves
+	HandshakeFailureDuplicate        HandshakeFailureReason = "duplicate"          // the peer is connected already, the other connection is kept
+	HandshakeFailureBanned           HandshakeFailureReason = "banned"             // an incoming peer was banned for sending corrupt pieces
+)
+
+// AnnounceLatencyBuckets upper bounds of the tracker announce latency histogram, in seconds
+var AnnounceLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+/************************************** HANDSHAKE FAILURES **************************************/
+
+type handshakeError struct {
+	reason HandshakeFailureReason
+	err    error
+}
+
+func newHandshakeError(reason HandshakeFailureReason, err error) error {
+	return &handshakeError{reason: reason, err: err}
+}
+
+func (e *handshakeError) Error() string { return e.err.Error() }
+func (e *handshakeError) Unwrap() error { return e.err }
+
+// handshakeFailureReason errors not tagged with a reason are timeouts or broken connections
+func handshakeFailureReason(err error) HandshakeFailureReason {
+	var hsErr *handshakeError
+	if errors.As(err, &hsErr) {
+		return hsErr.reason
+	}
+	var netErr net.Error
+	if errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return HandshakeFailureTimeout
+	}
+	return HandshakeFailureConnection
+}
+
+type handshakeFailures struct {
+	mu     sync.Mutex
+	counts map[HandshakeFailureReason]int64
+}
+
+func (hf *handshakeFailures) add(reason HandshakeFailureReason) {
+	hf.mu.Lock()
+	defer hf.mu.Unlock()
+	if hf.counts == nil {
+		hf.counts = make(map[HandshakeFailureReason]int64)
+	}
+	hf.counts[reason]++
+}
+
+func (hf *handshakeFailures) snapshot() map[HandshakeFailureReason]int64 {
+	hf.mu.Lock()
+	defer hf.mu.Unlock()
+	counts := make(map[HandshakeFailureReason]int64, len(hf.counts))
+	for reason, count := range hf.counts {
+		counts[reason] = count
+	}
+	return counts
+}
+
+/************************************** SESSION METRICS **************************************/
+
+type sessionMetrics struct {
+	piecesVerified atomic.Int64 // downloaded pieces that passed the hash check, rechecked pieces excluded
+	piecesFailed   atomic.Int64
+
+	announces       atomic.Int64 // tracker requests, retries included
+	announceErrors  atomic.Int64
+	announceLatency histogram
+
+	handshakeFailures handshakeFailures
+}
+
+// recordAnnounce requests cancelled by the session are not counted
+func (sm *sessionMetrics) recordAnnounce(duration time.Duration, err error) {
+	sm.announces.Add(1)
+	if err != nil {
+		sm.announceErrors.Add(1)
+	}
+	sm.announceLatency.observe(duration.Seconds())
+}
+
+type histogram struct {
+	mu     sync.Mutex
+	counts []int64 // per bucket of AnnounceLatencyBuckets, plus +Inf
+	sum    float64
+}
+
+func (h *histogram) observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.counts == nil {
+		h.counts = make([]int64, len(AnnounceLatencyBuckets)+1)
+	}
+	bucket := len(AnnounceLatencyBuckets)
+	for i, upperBound := range AnnounceLatencyBuckets {
+		if value <= upperBound {
+			bucket = i
+			break
+		}
+	}
+	h.counts[bucket]++
+	h.sum += value
+}
+
+// HistogramSnapshot counts are cumulative, as in the Prometheus format
+type HistogramSnapshot struct {
+	UpperBounds      []float64
+	CumulativeCounts []int64 // observations less than or equal to the upper bound of the same index
+	Count            int64
+	Sum              float64
+}
+
+func (h *histogram) snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	snapshot := HistogramSnapshot{
+		UpperBounds:      AnnounceLatencyBuckets,
+		CumulativeCounts: make([]int64, len(AnnounceLatencyBuckets)),
+		Sum:              h.sum,
+	}
+	for i, count := range h.counts {
+		snapshot.Count += count
+		if i < len(AnnounceLatencyBuckets) {
+			snapshot.CumulativeCounts[i] = snapshot.Count
+		}
+	}
+	return snapshot
+}
+
+/************************************** SNAPSHOTS **************************************/
+
+type TorrentMetrics struct {
+	InfoHash [20]byte
+	Name     string
+
+	PayloadDownloaded  int64 // blocks of 'piece' messages
+	PayloadUploaded    int64
+	ProtocolDownloaded int64 // handshakes, message headers and every other message
+	ProtocolUploaded   int64
+
+	PeersConnected  int
+	PeersUnchoked   int // peers we are not choking
+	PeersInterested int // peers interested in our pieces
+
+	PiecesVerified int64
+	PiecesFailed   int64 // failed the hash check
+	PeersBanned    int   // for sending corrupt pieces
+
+	Announces       int64
+	AnnounceErrors  int64
+	AnnounceLatency HistogramSnapshot // seconds
+
+	DiskQueueDepth int // verified pieces waiting for a disk worker
+
+	HandshakeFailures map[HandshakeFailureReason]int64
+}
+
+func (ts *TorrentSession) Metrics() TorrentMetrics {
+	metrics := TorrentMetrics{
+		InfoHash:          ts.torrent.InfoHash,
+		Name:              ts.torrent.Info.Name,
+		PiecesVerified:    ts.metrics.piecesVerified.Load(),
+		PiecesFailed:      ts.metrics.piecesFailed.Load(),
+		Announces:         ts.metrics.announces.Load(),
+		AnnounceErrors:    ts.metrics.announceErrors.Load(),
+		AnnounceLatency:   ts.metrics.announceLatency.snapshot(),
+		HandshakeFailures: ts.metrics.handshakeFailures.snapshot(),
+		PeersBanned:       len(ts.bans.Banned()),
+	}
+	if ts.rateTracker != nil {
+		metrics.PayloadDownloaded, metrics.ProtocolDownloaded, metrics.PayloadUploaded, metrics.ProtocolUploaded =
+			ts.rateTracker.GetTransferredBytes()
+	}
+	if ts.diskIO != nil {
+		metrics.DiskQueueDepth = len(ts.diskIO.jobs)
+	}
+	ts.connectedPeers.ReadOnlyIterate(func(peerIdStr string, connection *PeerConnection) bool {
+		metrics.PeersConnected++
+		connection.stateMutex.RLock()
+		if !connection.amChoking {
+			metrics.PeersUnchoked++
+		}
+		if connection.peerInterested {
+			metrics.PeersInterested++
+		}
+		connection.stateMutex.RUnlock()
+		return true
+	})
+	return metrics
+}
+
+// HandshakeFailures of incoming connections not routed to a torrent: timeouts, invalid handshakes and unknown info-hashes
+func (c *Client) HandshakeFailures() map[HandshakeFailureReason]int64 {
+	return c.handshakeFailures.snapshot()
+}
@@ -0,0 +1,157 @@
+package ptorrent
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+- What is it supposed to do
+- - The name and file paths of a torrent come from untrusted metainfo, and are mapped to disk paths below the
+    download directory. No path may escape it.
+- - Rejected (the torrent is not downloaded):
+- - - empty segments, "." and ".."
+- - - segments containing a path separator ('/' or '\'), which covers absolute paths
+- - - two files mapping to the same path after sanitisation (compared case-insensitively), or a file mapping to
+      a directory of another file
+- - Renamed deterministically:
+- - - invalid UTF-8 sequences, control characters and characters reserved on Windows are replaced with '_'
+- - - trailing dots and spaces are removed
+- - - names reserved on Windows (CON, PRN, AUX, NUL, COM1-9, LPT1-9, with any extension) are prefixed with '_'
+- - - names longer than `MaxPathSegmentLength` bytes are shortened, keeping the extension, with a hash of the
+      original name, so that two long names sharing a prefix do not collide
+*/
+
+const MaxPathSegmentLength = 255
+
+// TorrentPaths the sanitised disk paths of a torrent, relative to the working directory
+type TorrentPaths struct {
+	BaseDir string     // the download directory
+	Files   [][]string // for every file of the torrent, the path segments below BaseDir
+}
+
+var windowsReservedNames = func() map[string]struct{} {
+	names := map[string]struct{}{"CON": {}, "PRN": {}, "AUX": {}, "NUL": {}}
+	for i := 1; i <= 9; i++ {
+		names[fmt.Sprintf("COM%d", i)] = struct{}{}
+		names[fmt.Sprintf("LPT%d", i)] = struct{}{}
+	}
+	return names
+}()
+
+// SanitizeTorrentPaths validates the name and file paths of the torrent, and maps them to safe disk paths
+func SanitizeTorrentPaths(torrent *Torrent) (*TorrentPaths, error) {
+	name, err := sanitizePathSegment(torrent.Info.Name)
+	if err != nil {
+		return nil, ErrUnsafePath(torrent.Info.Name, err)
+	}
+
+	paths := &TorrentPaths{}
+	if torrent.StructureType == SingleFile {
+		// the name without its extension may be empty, `.` or `..`, e.g. for `...iso`: the whole name is used then
+		paths.BaseDir = name
+		if baseDir, err := sanitizePathSegment(strings.TrimSuffix(name, filepath.Ext(name))); err == nil {
+			paths.BaseDir = baseDir
+		}
+		paths.Files = [][]string{{name}}
+		return paths, nil
+	}
+
+	paths.BaseDir = name
+	seen := make(map[string]int)     // lower cased path -> file index
+	seenDirs := make(map[string]int) // lower cased directory -> index of a file below it
+	for fileIndex, file := range torrent.Info.Files {
+		displayPath := strings.Join(file.Path, "/")
+		if len(file.Path) == 0 {
+			return nil, ErrUnsafePath(displayPath, fmt.Errorf("file %d has an empty path", fileIndex))
+		}
+
+		segments := make([]string, 0, len(file.Path))
+		for _, segment := range file.Path {
+			sanitized, err := sanitizePathSegment(segment)
+			if err != nil {
+				return nil, ErrUnsafePath(displayPath, err)
+			}
+			segments = append(segments, sanitized)
+		}
+
+		key := strings.ToLower(strings.Join(segments, "/"))
+		if other, ok := seen[key]; ok {
+			return nil, ErrUnsafePath(displayPath, fmt.Errorf("collides with file %d after sanitisation", other))
+		}
+		if other, ok := seenDirs[key]; ok {
+			return nil, ErrUnsafePath(displayPath, fmt.Errorf("collides with a directory of file %d", other))
+		}
+		for i := 1; i < len(segments); i++ {
+			dirKey := strings.ToLower(strings.Join(segments[:i], "/"))
+			if other, ok := seen[dirKey]; ok {
+				return nil, ErrUnsafePath(displayPath, fmt.Errorf("a directory collides with file %d", other))
+			}
+			seenDirs[dirKey] = fileIndex
+		}
+		seen[key] = fileIndex
+		paths.Files = append(paths.Files, segments)
+	}
+	return paths, nil
+}
+
+// sanitizePathSegment rejects segments that could escape the download directory, and renames unsafe ones
+func sanitizePathSegment(segment string) (string, error) {
+	if segment == "" {
+		return "", fmt.Errorf("empty path segment")
+	}
+	if segment == "." || segment == ".." {
+		return "", fmt.Errorf("relative path segment %q", segment)
+	}
+	if strings.ContainsAny(segment, `/\`) {
+		return "", fmt.Errorf("path separator in segment %q", segment)
+	}
+
+	original := segment
+	segment = strings.ToValidUTF8(segment, "_")
+	segment = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, segment)
+
+	// Windows drops trailing dots and spaces, which could make two names collide, or a name become ".."
+	segment = strings.TrimRight(segment, ". ")
+	if segment == "" {
+		return "", fmt.Errorf("path segment %q is empty once trailing dots and spaces are removed", original)
+	}
+
+	baseName, _, _ := strings.Cut(segment, ".")
+	if _, reserved := windowsReservedNames[strings.ToUpper(strings.TrimRight(baseName, " "))]; reserved {
+		segment = "_" + segment
+	}
+
+	if len(segment) > MaxPathSegmentLength {
+		segment = shortenPathSegment(segment, original)
+	}
+	return segment, nil
+}
+
+// shortenPathSegment keeps the extension, and replaces the end of the name with a hash of the original segment
+func shortenPathSegment(segment string, original string) string {
+	hash := sha1.Sum([]byte(original))
+	suffix := "~" + hex.EncodeToString(hash[:4])
+
+	extension := filepath.Ext(segment)
+	if len(extension) > 16 {
+		extension = ""
+	}
+	prefixLength := MaxPathSegmentLength - len(suffix) - len(extension)
+	prefix := segment[:prefixLength]
+	// does not cut a multi-byte character in half
+	for len(prefix) > 0 && !utf8.ValidString(prefix) {
+		prefix = prefix[:len(prefix)-1]
+	}
+	return prefix + suffix + extension
+}
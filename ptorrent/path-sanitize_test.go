@@ -0,0 +1,126 @@
+package ptorrent
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestSanitizeHostileTorrents every torrent of testdata/hostile-torrents is either rejected, or mapped to safe paths
+func TestSanitizeHostileTorrents(t *testing.T) {
+	tests := []struct {
+		torrent string
+		err     string // expected in the error, "" if the torrent is accepted
+		baseDir string
+		files   [][]string
+	}{
+		{torrent: "dotdot-segment", err: `relative path segment ".."`},
+		{torrent: "dotdot-in-subdir", err: `relative path segment ".."`},
+		{torrent: "dot-segment", err: `relative path segment "."`},
+		{torrent: "dots-only", err: "empty once trailing dots and spaces are removed"},
+		{torrent: "absolute-segment", err: "path separator in segment"},
+		{torrent: "backslash-traversal", err: "path separator in segment"},
+		{torrent: "empty-segment", err: "empty path segment"},
+		{torrent: "empty-path", err: "file 0 has an empty path"},
+		{torrent: "name-dotdot", err: `relative path segment ".."`},
+		{torrent: "name-absolute", err: "path separator in segment"},
+		{torrent: "name-empty", err: "empty path segment"},
+		{torrent: "single-name-traversal", err: "path separator in segment"},
+		{torrent: "collision-reserved-chars", err: "collides with file 0 after sanitisation"},
+		{torrent: "collision-case", err: "collides with file 0 after sanitisation"},
+		{torrent: "collision-trailing-dot", err: "collides with file 0 after sanitisation"},
+		{torrent: "collision-file-and-dir", err: "a directory collides with file 0"},
+		{torrent: "collision-dir-and-file", err: "collides with a directory of file 0"},
+		{
+			torrent: "renamed-reserved-names",
+			baseDir: "hostile",
+			files:   [][]string{{"_CON"}, {"_nul.txt"}, {"_com1", "_lpt9.log"}, {"CONSOLE"}},
+		},
+		{
+			torrent: "renamed-control-chars",
+			baseDir: "hostile",
+			files:   [][]string{{"tab_here"}, {"new_line"}, {"bell_"}},
+		},
+		{
+			torrent: "renamed-invalid-utf8",
+			baseDir: "hostile",
+			files:   [][]string{{"bad_name"}, {"ok-名前"}},
+		},
+		{
+			torrent: "renamed-trailing-dots-spaces",
+			baseDir: "hostile",
+			files:   [][]string{{"file"}, {"dir", "file"}},
+		},
+		{
+			// 255 bytes: the prefix, '~' and 8 hex digits of the SHA-1 of the original name, then the extension
+			torrent: "renamed-overlong",
+			baseDir: "hostile",
+			files: [][]string{
+				{strings.Repeat("a", 242) + "~6a21aa5f.txt"},
+				{strings.Repeat("a", 242) + "~87fa28c7.txt"},
+				{strings.Repeat("é", 123) + "~41f11f3d"},
+			},
+		},
+		{
+			torrent: "single-name-reserved",
+			baseDir: "_aux",
+			files:   [][]string{{"_aux.iso"}},
+		},
+		{
+			torrent: "single-name-dotdot-stem",
+			baseDir: "...iso",
+			files:   [][]string{{"...iso"}},
+		},
+		{
+			torrent: "single-name-dot-stem",
+			baseDir: "..foo",
+			files:   [][]string{{"..foo"}},
+		},
+		{
+			torrent: "single-name-extension-only",
+			baseDir: ".iso",
+			files:   [][]string{{".iso"}},
+		},
+	}
+
+	corpus, err := filepath.Glob(filepath.Join("..", "testdata", "hostile-torrents", "*.torrent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(corpus) != len(tests) {
+		t.Errorf("%d torrents in the corpus, %d test cases", len(corpus), len(tests))
+	}
+
+	for _, test := range tests {
+		t.Run(test.torrent, func(t *testing.T) {
+			torrent, err := LoadTorrentFile(filepath.Join("..", "testdata", "hostile-torrents", test.torrent+".torrent"))
+			if err != nil {
+				t.Fatalf("error loading torrent: %v", err)
+			}
+			paths, err := SanitizeTorrentPaths(torrent)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected an error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if paths.BaseDir != test.baseDir {
+				t.Errorf("base directory %q, expected %q", paths.BaseDir, test.baseDir)
+			}
+			if !reflect.DeepEqual(paths.Files, test.files) {
+				t.Errorf("files %q, expected %q", paths.Files, test.files)
+			}
+			for _, segments := range paths.Files {
+				for _, segment := range segments {
+					if len(segment) > MaxPathSegmentLength {
+						t.Errorf("segment of %d bytes, longer than %d", len(segment), MaxPathSegmentLength)
+					}
+				}
+			}
+		})
+	}
+}
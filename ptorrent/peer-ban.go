@@ -0,0 +1,258 @@
+package ptorrent
+
+import (
+	"crypto/sha1"
+	"sort"
+	"sync"
+)
+
+/** TOC
+- BAN LIST
+	- NewBanList, IsBanned, Banned, restore
+	- addBadPiece, hasBadPieces
+	- addSuspects, takeSuspects, hasSuspects, isSuspect
+- SESSION
+	- onPieceFailed, onPieceVerified
+	- trustedPeer
+	- chargeBadPiece, banPeer, BannedPeers
+*/
+
+/*
+- What is it supposed to do
+- - The piece picker records the IP address every block of a piece was received from.
+- - A piece failing the hash check, with every block from a single peer, is a bad piece of that peer.
+- - Otherwise the offender is not known yet: the hash of every block is kept along with its sender, and the piece is
+    downloaded again from a single trusted peer, one that sent none of its blocks and no bad piece. Once the piece
+    passes the hash check, the sender of every kept block that differs from the verified data gets a bad piece.
+    If the trusted peer sends a corrupt piece as well, that is a bad piece of its own, and another peer is picked.
+- - A peer with `maxBadPieces` bad pieces is banned by IP address: its connections are closed, it is never dialed
+    again, and refused when it connects. The ban list is kept in the resume file.
+*/
+
+const DefaultMaxBadPieces = 3
+
+// suspectBlock a block of a piece that failed the hash check
+type suspectBlock struct {
+	blockIndex int64
+	hash       [20]byte
+	address    string // IP address of the sender
+}
+
+/************************************** BAN LIST **************************************/
+
+// BanList the peers banned from a torrent, by IP address; safe for concurrent use
+type BanList struct {
+	mu           sync.Mutex
+	maxBadPieces int
+	badPieces    map[string]int            // IP address -> pieces found corrupt
+	banned       map[string]struct{}       // IP addresses
+	suspects     map[uint32][]suspectBlock // piece index -> blocks of its failed downloads, sent by several peers
+}
+
+func NewBanList(maxBadPieces int) *BanList {
+	return &BanList{
+		maxBadPieces: max(maxBadPieces, 1),
+		badPieces:    make(map[string]int),
+		banned:       make(map[string]struct{}),
+		suspects:     make(map[uint32][]suspectBlock),
+	}
+}
+
+func (bl *BanList) IsBanned(address string) bool {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	_, banned := bl.banned[address]
+	return banned
+}
+
+// Banned the banned IP addresses, sorted
+func (bl *BanList) Banned() []string {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	addresses := make([]string, 0, len(bl.banned))
+	for address := range bl.banned {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// restore bans the addresses, e.g. from the resume file
+func (bl *BanList) restore(addresses []string) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	for _, address := range addresses {
+		bl.banned[address] = struct{}{}
+	}
+}
+
+// addBadPiece returns the bad pieces of the peer so far, and true if the peer is banned by this one
+func (bl *BanList) addBadPiece(address string) (int, bool) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bl.badPieces[address]++
+	count := bl.badPieces[address]
+	if _, banned := bl.banned[address]; banned || count < bl.maxBadPieces {
+		return count, false
+	}
+	bl.banned[address] = struct{}{}
+	return count, true
+}
+
+func (bl *BanList) hasBadPieces(address string) bool {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	return bl.badPieces[address] > 0
+}
+
+// addSuspects keeps the hash of every block with a known sender
+func (bl *BanList) addSuspects(pieceIndex uint32, data []byte, senders []string) {
+	numBlocks := int64(len(senders))
+	pieceLength := int64(len(data))
+	blocks := make([]suspectBlock, 0, numBlocks)
+	for blockIndex, address := range senders {
+		if address == "" {
+			continue
+		}
+		begin := int64(blockIndex) * BlockSize
+		length := findBlockLength(int64(blockIndex), pieceLength, numBlocks)
+		blocks = append(blocks, suspectBlock{
+			blockIndex: int64(blockIndex),
+			hash:       sha1.Sum(data[begin : begin+length]),
+			address:    address,
+		})
+	}
+
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bl.suspects[pieceIndex] = append(bl.suspects[pieceIndex], blocks...)
+}
+
+// takeSuspects returns and forgets the suspect blocks of the piece
+func (bl *BanList) takeSuspects(pieceIndex uint32) []suspectBlock {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	blocks := bl.suspects[pieceIndex]
+	delete(bl.suspects, pieceIndex)
+	return blocks
+}
+
+func (bl *BanList) hasSuspects(pieceIndex uint32) bool {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	return len(bl.suspects[pieceIndex]) > 0
+}
+
+// isSuspect if the peer sent a block of a failed download of the piece
+func (bl *BanList) isSuspect(pieceIndex uint32, address string) bool {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	for _, block := range bl.suspects[pieceIndex] {
+		if block.address == address {
+			return true
+		}
+	}
+	return false
+}
+
+/************************************** SESSION **************************************/
+
+// onPieceFailed charges the sender of a corrupt piece, or has the piece downloaded from a trusted peer to find it out.
+// Must be called once the piece is reset in the piece picker; `senders` as returned by BlockSenders before.
+func (ts *TorrentSession) onPieceFailed(pieceIndex uint32, data []byte, senders []string) {
+	distinct := make(map[string]struct{})
+	for _, address := range senders {
+		distinct[address] = struct{}{}
+	}
+	if len(distinct) == 1 {
+		for address := range distinct {
+			if address != "" {
+				ts.chargeBadPiece(address)
+			}
+		}
+		// the trusted peer sent a corrupt piece, another one is picked
+		if !ts.bans.hasSuspects(pieceIndex) {
+			return
+		}
+	} else {
+		ts.bans.addSuspects(pieceIndex, data, senders)
+	}
+
+	trusted := ts.trustedPeer(pieceIndex)
+	ts.piecePicker.RestrictPiece(pieceIndex, trusted)
+	ts.logs.picker.Info("piece sent by several peers failed the hash check, downloading it from a single peer",
+		"piece", pieceIndex, "senders", len(distinct), "peer", trusted)
+}
+
+// onPieceVerified charges the senders of the blocks that differ from the verified piece, if it failed before
+func (ts *TorrentSession) onPieceVerified(pieceIndex uint32, data []byte) {
+	blocks := ts.bans.takeSuspects(pieceIndex)
+	if len(blocks) == 0 {
+		return
+	}
+	pieceLength := int64(len(data))
+	numBlocks := ceilDiv(pieceLength, BlockSize)
+	offenders := make(map[string]struct{})
+	for _, block := range blocks {
+		begin := block.blockIndex * BlockSize
+		length := findBlockLength(block.blockIndex, pieceLength, numBlocks)
+		if sha1.Sum(data[begin:begin+length]) != block.hash {
+			offenders[block.address] = struct{}{}
+		}
+	}
+	ts.logs.picker.Info("piece that failed the hash check is verified", "piece", pieceIndex, "offenders", len(offenders))
+	for address := range offenders {
+		ts.chargeBadPiece(address)
+	}
+}
+
+// trustedPeer a connected peer having the piece, that sent none of its failed blocks and no bad piece; "" if none
+func (ts *TorrentSession) trustedPeer(pieceIndex uint32) string {
+	trusted := ""
+	ts.connectedPeers.ReadOnlyIterate(func(peerIdStr string, connection *PeerConnection) bool {
+		address := connection.peer.IP.String()
+		if connection.snubbed.Load() || ts.bans.isSuspect(pieceIndex, address) || ts.bans.hasBadPieces(address) {
+			return true
+		}
+		connection.piecesMutex.RLock()
+		hasPiece := connection.piecesBitfield != nil && connection.piecesBitfield.GetBit(uint(pieceIndex)) == 1
+		connection.piecesMutex.RUnlock()
+		if hasPiece {
+			trusted = peerIdStr
+			return false
+		}
+		return true
+	})
+	return trusted
+}
+
+func (ts *TorrentSession) chargeBadPiece(address string) {
+	count, banned := ts.bans.addBadPiece(address)
+	ts.logs.peer.Warn("peer sent a corrupt piece", "addr", address, "badPieces", count)
+	if banned {
+		ts.banPeer(address)
+	}
+}
+
+// banPeer closes the connections of the peer; the peer manager forgets it on its next dial
+func (ts *TorrentSession) banPeer(address string) {
+	var connections []*PeerConnection
+	ts.connectedPeers.ReadOnlyIterate(func(peerIdStr string, connection *PeerConnection) bool {
+		if connection.peer.IP.String() == address {
+			connections = append(connections, connection)
+		}
+		return true
+	})
+	for _, connection := range connections {
+		if ts.RemovePeer(connection) {
+			connection.CloseConnection()
+		}
+	}
+	ts.logs.peer.Warn("banned peer for sending corrupt pieces", "addr", address, "connections", len(connections))
+	ts.client.publish(Event{Type: EventPeerBanned, InfoHash: ts.torrent.InfoHash, Name: ts.torrent.Info.Name, Peer: address})
+}
+
+// BannedPeers the IP addresses of the peers banned from the torrent
+func (ts *TorrentSession) BannedPeers() []string {
+	return ts.bans.Banned()
+}
@@ -1,8 +1,7 @@
-package main
+package ptorrent
 
 import (
 	"bittorrent-client/structs"
-	"log"
 	"sync"
 )
 
@@ -40,7 +39,7 @@ func NewBitfieldManager(selfBitfield *Bitset) *BitfieldManager {
 }
 
 func (bm *BitfieldManager) AddPeerWithoutBitfield(peerIdStr string) {
-	log.Printf("configuring new peer %s in bitfield manager", peerIdStr)
+	logs.picker.Debug("configuring new peer in bitfield manager", "peer", peerIdStr)
 
 	bm.peerMutex.PutOnlyIfNotExists(peerIdStr, new(sync.RWMutex))
 
@@ -50,7 +49,7 @@ func (bm *BitfieldManager) AddPeerWithoutBitfield(peerIdStr string) {
 }
 
 func (bm *BitfieldManager) AddBitfieldToPeer(peerIdStr string, peerBitfield *Bitset) {
-	log.Printf("adding bitfield to peer %s", peerIdStr)
+	logs.picker.Debug("adding bitfield to peer", "peer", peerIdStr)
 
 	peerMu := bm.peerMutex.GetOrDefault(peerIdStr)
 	peerMu.Lock()
@@ -61,7 +60,7 @@ func (bm *BitfieldManager) AddBitfieldToPeer(peerIdStr string, peerBitfield *Bit
 }
 
 func (bm *BitfieldManager) UpdateBitfieldForPeer(peerIdStr string, newPeerBitfield *Bitset) {
-	log.Printf("updating the complete bitfield for peer %s", peerIdStr)
+	logs.picker.Debug("updating the complete bitfield for peer", "peer", peerIdStr)
 
 	peerMu := bm.peerMutex.GetOrDefault(peerIdStr)
 	peerMu.Lock()
@@ -76,7 +75,7 @@ func (bm *BitfieldManager) UpdateBitfieldForPeer(peerIdStr string, newPeerBitfie
 }
 
 func (bm *BitfieldManager) RemovePeer(peerIdStr string) {
-	log.Printf("removing peer %s from bitfield manager", peerIdStr)
+	logs.picker.Debug("removing peer from bitfield manager", "peer", peerIdStr)
 
 	peerMu := bm.peerMutex.GetOrDefault(peerIdStr)
 	peerMu.Lock()
@@ -89,16 +88,19 @@ func (bm *BitfieldManager) RemovePeer(peerIdStr string) {
 	bm.peerMutex.Delete(peerIdStr)
 }
 
-func (bm *BitfieldManager) AddPieceToExistingPeer(peerIdStr string, pieceIndex int) {
-	log.Printf("adding piece %d to existing peer %s", pieceIndex, peerIdStr)
+func (bm *BitfieldManager) AddPieceToExistingPeer(peerIdStr string, pieceIndex int) error {
+	logs.picker.Debug("adding piece to existing peer", "piece", pieceIndex, "peer", peerIdStr)
 
 	peerMu := bm.peerMutex.GetOrDefault(peerIdStr)
 	peerMu.Lock()
 	defer peerMu.Unlock()
 
 	peerBitfield := bm.peerBitfields.GetOrDefault(peerIdStr)
-	peerBitfield.SetBit(uint(pieceIndex))
+	if err := peerBitfield.SetBit(uint(pieceIndex)); err != nil {
+		return err
+	}
 	bm.pieceFrequency.Inc(pieceIndex)
+	return nil
 }
 
 func (bm *BitfieldManager) IsAmInterested(peerIdStr string) bool {
@@ -106,10 +108,12 @@ func (bm *BitfieldManager) IsAmInterested(peerIdStr string) bool {
 	peerMu.RLock()
 	defer peerMu.RUnlock()
 
-	if bm.peerBitfields.GetOrDefault(peerIdStr).AndNot(bm.selfBitfield).AnySetBits() {
-		return true
+	missing, err := bm.peerBitfields.GetOrDefault(peerIdStr).AndNot(bm.selfBitfield)
+	if err != nil {
+		logs.picker.Warn("can not compare the peer pieces with ours", "peer", peerIdStr, "error", err)
+		return false
 	}
-	return false
+	return missing.AnySetBits()
 }
 
 // GetRarestPieceIndex find the most rare piece in swarm
@@ -117,6 +121,11 @@ func (bm *BitfieldManager) GetRarestPieceIndex() int {
 	return bm.pieceFrequency.GetMostRareKey()
 }
 
+// GetPieceFrequency number of peers in the swarm that have the piece
+func (bm *BitfieldManager) GetPieceFrequency(pieceIndex int) int {
+	return bm.pieceFrequency.GetCount(pieceIndex)
+}
+
 func (bm *BitfieldManager) addBitfieldToFrequencyMap(peerBitfield *Bitset) {
 	if peerBitfield != nil {
 		for i := range peerBitfield.bits {
@@ -0,0 +1,75 @@
+package ptorrent
+
+import (
+	"strings"
+)
+
+/*
+- What is it supposed to do
+- - Names the client software of a peer from its peer id, for display.
+- - - Azureus style: `-` + two letter client code + four version characters + `-`, e.g. `-TR4060-`
+- - - Mainline style: `M` + version numbers separated by `-`, e.g. `M7-4-3--`
+*/
+
+var azureusClientCodes = map[string]string{
+	"AZ": "Vuze",
+	"BC": "BitComet",
+	"BI": "BiglyBT",
+	"BT": "BitTorrent",
+	"DE": "Deluge",
+	"FD": "Free Download Manager",
+	"KT": "KTorrent",
+	"LT": "libtorrent",
+	"lt": "rTorrent",
+	"PT": "pTorrent",
+	"qB": "qBittorrent",
+	"SD": "Thunder",
+	"TR": "Transmission",
+	"TX": "Tixati",
+	"UM": "µTorrent Mac",
+	"UT": "µTorrent",
+	"UW": "µTorrent Web",
+	"WW": "WebTorrent",
+	"XL": "Xunlei",
+}
+
+// PeerClientName returns "" for peer ids of an unknown style
+func PeerClientName(peerId [20]byte) string {
+	if peerId[0] == '-' && peerId[7] == '-' {
+		code := string(peerId[1:3])
+		name, ok := azureusClientCodes[code]
+		if !ok {
+			name = sanitizeClientCode(code)
+		}
+		return name + " " + azureusVersion(peerId[3:7])
+	}
+	if peerId[0] == 'M' {
+		version, _, found := strings.Cut(string(peerId[1:8]), "--")
+		if found && version != "" {
+			return "BitTorrent " + sanitizeClientCode(strings.ReplaceAll(version, "-", "."))
+		}
+	}
+	return ""
+}
+
+// azureusVersion e.g. `4060` is 4.0.6, trailing zeros after the minor version are dropped
+func azureusVersion(version []byte) string {
+	parts := make([]string, 0, len(version))
+	for _, c := range version {
+		parts = append(parts, sanitizeClientCode(string(c)))
+	}
+	for len(parts) > 2 && parts[len(parts)-1] == "0" {
+		parts = parts[:len(parts)-1]
+	}
+	return strings.Join(parts, ".")
+}
+
+// sanitizeClientCode keeps peer ids from writing control characters to a terminal
+func sanitizeClientCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return '?'
+		}
+		return r
+	}, code)
+}
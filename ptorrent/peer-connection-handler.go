@@ -0,0 +1,264 @@
+package ptorrent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+const ConnectionBufferSize = 32768
+const MaxMessageLength = 1 << 20 // large enough for a bitfield or a 'piece' with a 16KB block
+
+const Reading = "reading"
+const Writing = "writing"
+
+// PeerReader Meant to be run as a goroutine, till the connection is closed or the session is stopped.
+// Blocks on the connection; silent peers are dropped by the reaper, see peer-reaper.go
+func (pc *PeerConnection) PeerReader(session *TorrentSession) {
+	// the blocked read is aborted once the session is stopped, e.g. for a peer accepted while it was being stopped
+	stopAbort := context.AfterFunc(session.ctx, func() {
+		if session.RemovePeer(pc) {
+			pc.CloseConnection()
+		}
+	})
+	defer stopAbort()
+
+	for {
+		peerMessage, _, err := pc.ReadMessage(session.rateTracker)
+		if isError := pc.errorHandler(err, session, nil, Reading); isError {
+			if isTemporaryError(err) {
+				continue
+			}
+			pc.logs.peer.Debug("quitting peer reader", "err", err)
+			return
+		}
+		pc.logs.peer.Debug("message received", "type", peerMessage.MessageId)
+		pc.PeerReaderMessageHandler(peerMessage, session)
+	}
+}
+
+// PeerWriter Meant to be run as a goroutine, till the connection is closed or the session is stopped
+func (pc *PeerConnection) PeerWriter(session *TorrentSession) {
+	// the piece availability, queued before the writer started, goes before any other message
+	pc.writeQueuedMessages(session)
+	for {
+		select {
+		case <-pc.closed:
+			pc.logs.peer.Debug("connection closed, quitting peer writer")
+			return
+		case <-session.ctx.Done():
+			return
+		case <-time.After(session.configurable.keepAliveInterval):
+			pc.logs.peer.Debug("nothing written for the keep alive interval, sending keep alive")
+			_, err := pc.SendKeepAlive(session)
+			pc.errorHandler(err, session, nil, Writing)
+		case msg := <-pc.writeChannel:
+			_, err := pc.WriteMessage(msg, session.rateTracker)
+			pc.errorHandler(err, session, msg, Writing)
+		case <-pc.outboxNotify:
+			pc.writeOutbox(session)
+		case <-pc.refillNotify:
+			pc.fillRequestPipeline(session)
+		case <-pc.uploadQueue.notify:
+			pc.serveUploadQueue(session)
+		}
+	}
+}
+
+func (pc *PeerConnection) errorHandler(err error, session *TorrentSession, message *PeerMessage, errDuring string) bool {
+	if err != nil {
+		if err == io.EOF {
+			pc.logs.peer.Debug("connection closed by the peer", "during", errDuring)
+			session.reportQuit(pc)
+		} else if errors.Is(err, net.ErrClosed) {
+			// closed by us, e.g. by the quitter or the reaper
+		} else if isTemporaryError(err) {
+			pc.logs.peer.Debug("temporary network error", "during", errDuring, "err", err)
+			// written again later; the writer itself is running this, it can not wait for room in its channel
+			if errDuring == Writing && message != nil {
+				pc.queueWithoutBlocking(message)
+			}
+		} else {
+			pc.logs.peer.Info("closing connection after an error", "during", errDuring, "err", err)
+			session.reportQuit(pc)
+		}
+		return true
+	}
+	return false
+}
+
+func isTemporaryError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Temporary()
+}
+
+func (pc *PeerConnection) PeerReaderMessageHandler(peerMessage *PeerMessage, session *TorrentSession) {
+	switch peerMessage.MessageId {
+	case KeepAlive:
+		// the read time is already updated
+	case Choke:
+		pc.handleChokeMessage(session)
+	case Unchoke:
+		pc.handleUnchokeMessage(session)
+	case Interested:
+		pc.stateMutex.Lock()
+		pc.peerInterested = true
+		pc.stateMutex.Unlock()
+	case NotInterested:
+		pc.stateMutex.Lock()
+		pc.peerInterested = false
+		pc.stateMutex.Unlock()
+	case Have:
+		have, err := peerMessage.GetHaveMessagePayload()
+		if err != nil {
+			pc.logs.peer.Warn("invalid have message", "err", err)
+			return
+		}
+		pc.handleHaveMessage(uint(have), session)
+		pc.fillRequestPipeline(session)
+	case Bitfield:
+		bitfield := peerMessage.GetBitfieldMessagePayload(session)
+		if bitfield != nil {
+			pc.handleBitfieldMessage(bitfield, session)
+			pc.fillRequestPipeline(session)
+		}
+	case Request:
+		request, err := peerMessage.GetRequestMessagePayload()
+		if err != nil {
+			pc.logs.peer.Warn("invalid request message", "err", err)
+			return
+		}
+		pc.handleRequestMessage(request, session)
+	case Piece:
+		piece, err := peerMessage.GetPieceMessagePayload()
+		if err != nil {
+			pc.logs.peer.Warn("invalid piece message", "err", err)
+			return
+		}
+		pc.handlePieceMessage(piece, session)
+	case Cancel:
+		cancel, err := peerMessage.GetCancelMessagePayload()
+		if err != nil {
+			pc.logs.peer.Warn("invalid cancel message", "err", err)
+			return
+		}
+		pc.handleCancelMessage(cancel)
+	case SuggestPiece:
+		if !pc.requireFastExtension(peerMessage.MessageId, session) {
+			return
+		}
+		pieceIndex, err := peerMessage.GetSuggestPieceMessagePayload()
+		if err != nil {
+			pc.logs.peer.Warn("invalid suggest piece message", "err", err)
+			return
+		}
+		pc.handleSuggestPieceMessage(pieceIndex, session)
+	case HaveAll:
+		if !pc.requireFastExtension(peerMessage.MessageId, session) {
+			return
+		}
+		pc.handleHaveAllMessage(session)
+		pc.fillRequestPipeline(session)
+	case HaveNone:
+		if !pc.requireFastExtension(peerMessage.MessageId, session) {
+			return
+		}
+		pc.handleHaveNoneMessage(session)
+	case RejectRequest:
+		if !pc.requireFastExtension(peerMessage.MessageId, session) {
+			return
+		}
+		request, err := peerMessage.GetRejectRequestMessagePayload()
+		if err != nil {
+			pc.logs.peer.Warn("invalid reject request message", "err", err)
+			return
+		}
+		pc.handleRejectRequestMessage(request, session)
+	case AllowedFast:
+		if !pc.requireFastExtension(peerMessage.MessageId, session) {
+			return
+		}
+		pieceIndex, err := peerMessage.GetAllowedFastMessagePayload()
+		if err != nil {
+			pc.logs.peer.Warn("invalid allowed fast message", "err", err)
+			return
+		}
+		pc.handleAllowedFastMessage(pieceIndex, session)
+	case Extended:
+		extendedId, payload, err := peerMessage.GetExtendedMessagePayload()
+		if err != nil {
+			pc.logs.peer.Warn("invalid extended message", "err", err)
+			return
+		}
+		pc.handleExtendedMessage(extendedId, payload, session)
+	default:
+		pc.logs.peer.Debug("unknown message ignored", "type", peerMessage.MessageId)
+	}
+}
+
+// BroadcastMessage hands the message to the writer of every connected peer; never blocks on a slow peer
+func (ts *TorrentSession) BroadcastMessage(peerMessage *PeerMessage) {
+	ts.logs.peer.Debug("broadcasting message", "type", peerMessage.MessageId)
+	var connections []*PeerConnection
+	ts.connectedPeers.ReadOnlyIterate(func(peerIdStr string, connection *PeerConnection) bool {
+		connections = append(connections, connection)
+		return true
+	})
+	for _, connection := range connections {
+		connection.queueWithoutBlocking(peerMessage)
+	}
+}
+
+/************************************************** HANDLER METHODS **************************************************/
+
+func (pc *PeerConnection) handleHaveMessage(have uint, session *TorrentSession) {
+	pc.piecesMutex.Lock()
+	defer pc.piecesMutex.Unlock()
+
+	if have >= session.bitfield.Size() {
+		pc.logs.peer.Warn("'have' for a piece out of range", "piece", have)
+		return
+	}
+
+	// a peer with no pieces may skip the bitfield altogether
+	if pc.piecesBitfield == nil {
+		pc.piecesBitfield = NewBitset(session.bitfield.Size())
+		session.bitfieldManager.AddBitfieldToPeer(pc.peerIdStr, pc.piecesBitfield)
+	}
+	pc.piecesBitfield.SetBit(have)
+	if err := session.bitfieldManager.AddPieceToExistingPeer(pc.peerIdStr, int(have)); err != nil {
+		pc.logs.peer.Warn("can not add the piece of a 'have'", "piece", have, "error", err)
+		return
+	}
+
+	if session.bitfieldManager.IsAmInterested(pc.peerIdStr) {
+		pc.stateMutex.Lock()
+		pc.amInterested = true
+		pc.stateMutex.Unlock()
+		pc.queueMessage(NewInterestedMessage())
+	}
+
+}
+
+func (pc *PeerConnection) handleBitfieldMessage(bitfield *Bitset, session *TorrentSession) {
+	pc.piecesMutex.Lock()
+	pc.stateMutex.Lock()
+
+	defer pc.piecesMutex.Unlock()
+	defer pc.stateMutex.Unlock()
+
+	if pc.piecesBitfield == nil {
+		session.bitfieldManager.AddBitfieldToPeer(pc.peerIdStr, bitfield)
+	} else {
+		session.bitfieldManager.UpdateBitfieldForPeer(pc.peerIdStr, bitfield)
+	}
+
+	pc.piecesBitfield = bitfield
+	if session.bitfieldManager.IsAmInterested(pc.peerIdStr) {
+		pc.logs.choker.Debug("interested in the peer")
+		pc.amInterested = true
+		pc.queueMessage(NewInterestedMessage())
+	}
+}
@@ -1,14 +1,13 @@
-package main
+package ptorrent
 
 import (
 	"fmt"
-	"log"
 )
 
 func (pc *PeerConnection) sendMessage(peerMessage *PeerMessage, session *TorrentSession) (n int, err error) {
 	n, err = pc.WriteMessage(peerMessage, session.rateTracker)
 	if err != nil {
-		log.Printf("error sending 'message - %d' to peer %s: %v", peerMessage.MessageId, pc.peerIdStr, err)
+		pc.logs.peer.Debug("error sending message", "type", peerMessage.MessageId, "err", err)
 		return 0, fmt.Errorf("error sending 'message - %d' to peer %s: %v", peerMessage.MessageId, pc.peerIdStr, err)
 	}
 	return
@@ -17,7 +16,7 @@ func (pc *PeerConnection) sendMessage(peerMessage *PeerMessage, session *Torrent
 func (pc *PeerConnection) SendKeepAlive(session *TorrentSession) (n int, err error) {
 	n, err = pc.WriteMessage(NewKeepAliveMessage(), session.rateTracker)
 	if err != nil {
-		log.Printf("error sending 'keep-alive' to peer %s: %v", pc.peerIdStr, err)
+		pc.logs.peer.Debug("error sending message", "type", "keep-alive", "err", err)
 		return 0, fmt.Errorf("error sending 'keep-alive' to peer %s: %v", pc.peerIdStr, err)
 	}
 	return
@@ -26,7 +25,7 @@ func (pc *PeerConnection) SendKeepAlive(session *TorrentSession) (n int, err err
 func (pc *PeerConnection) SendChoke(session *TorrentSession) (n int, err error) {
 	n, err = pc.WriteMessage(NewChokeMessage(), session.rateTracker)
 	if err != nil {
-		log.Printf("error sending 'choke' to peer %s: %v", pc.peerIdStr, err)
+		pc.logs.peer.Debug("error sending message", "type", "choke", "err", err)
 		return 0, fmt.Errorf("error sending 'choke' to peer %s: %v", pc.peerIdStr, err)
 	}
 	return
@@ -35,7 +34,7 @@ func (pc *PeerConnection) SendChoke(session *TorrentSession) (n int, err error)
 func (pc *PeerConnection) SendUnchoke(session *TorrentSession) (n int, err error) {
 	n, err = pc.WriteMessage(NewUnchokeMessage(), session.rateTracker)
 	if err != nil {
-		log.Printf("error sending 'unchoke' message to peer %s: %v", pc.peerIdStr, err)
+		pc.logs.peer.Debug("error sending message", "type", "unchoke", "err", err)
 		return 0, fmt.Errorf("error sending 'unchoke' to peer %s: %v", pc.peerIdStr, err)
 	}
 	return
@@ -44,7 +43,7 @@ func (pc *PeerConnection) SendUnchoke(session *TorrentSession) (n int, err error
 func (pc *PeerConnection) SendInterested(session *TorrentSession) (n int, err error) {
 	n, err = pc.WriteMessage(NewInterestedMessage(), session.rateTracker)
 	if err != nil {
-		log.Printf("error sending 'interested' message to peer %s: %v", pc.peerIdStr, err)
+		pc.logs.peer.Debug("error sending message", "type", "interested", "err", err)
 		return 0, fmt.Errorf("error sending 'interested' message to peer %s: %v", pc.peerIdStr, err)
 	}
 	return
@@ -53,7 +52,7 @@ func (pc *PeerConnection) SendInterested(session *TorrentSession) (n int, err er
 func (pc *PeerConnection) SendNotInterested(session *TorrentSession) (n int, err error) {
 	n, err = pc.WriteMessage(NewNotInterestedMessage(), session.rateTracker)
 	if err != nil {
-		log.Printf("error sending 'not-interested' message to peer %s: %v", pc.peerIdStr, err)
+		pc.logs.peer.Debug("error sending message", "type", "not-interested", "err", err)
 		return 0, fmt.Errorf("error sending 'not-interested' message to peer %s: %v", pc.peerIdStr, err)
 	}
 	return
@@ -62,7 +61,7 @@ func (pc *PeerConnection) SendNotInterested(session *TorrentSession) (n int, err
 func (pc *PeerConnection) SendHave(pieceIndex uint32, session *TorrentSession) (n int, err error) {
 	n, err = pc.WriteMessage(NewHaveMessage(pieceIndex), session.rateTracker)
 	if err != nil {
-		log.Printf("error sending 'have' message to peer %s: %v", pc.peerIdStr, err)
+		pc.logs.peer.Debug("error sending message", "type", "have", "err", err)
 		return 0, fmt.Errorf("error sending 'have' message to peer %s: %v", pc.peerIdStr, err)
 	}
 	return
@@ -75,7 +74,7 @@ func (pc *PeerConnection) SendBitfield(session *TorrentSession) (n int, err erro
 
 	n, err = pc.WriteMessage(NewBitfieldMessage(session.bitfield), session.rateTracker)
 	if err != nil {
-		log.Printf("error sending 'bitfield' message to peer %s: %v", pc.peerIdStr, err)
+		pc.logs.peer.Debug("error sending message", "type", "bitfield", "err", err)
 		return 0, fmt.Errorf("error sending 'bitfield' message to peer %s: %v", pc.peerIdStr, err)
 	}
 	return
@@ -84,7 +83,7 @@ func (pc *PeerConnection) SendBitfield(session *TorrentSession) (n int, err erro
 func (pc *PeerConnection) SendRequest(index uint32, begin uint32, length uint32, session *TorrentSession) (n int, err error) {
 	n, err = pc.WriteMessage(NewRequestMessage(index, begin, length), session.rateTracker)
 	if err != nil {
-		log.Printf("error sending 'request' message to peer %s: %v", pc.peerIdStr, err)
+		pc.logs.peer.Debug("error sending message", "type", "request", "err", err)
 		return 0, fmt.Errorf("error sending 'request' message to peer %s: %v", pc.peerIdStr, err)
 	}
 	return
@@ -93,7 +92,7 @@ func (pc *PeerConnection) SendRequest(index uint32, begin uint32, length uint32,
 func (pc *PeerConnection) SendPiece(index uint32, begin uint32, block []byte, session *TorrentSession) (n int, err error) {
 	n, err = pc.WriteMessage(NewPieceMessage(index, begin, block), session.rateTracker)
 	if err != nil {
-		log.Printf("error sending 'piece' message to peer %s: %v", pc.peerIdStr, err)
+		pc.logs.peer.Debug("error sending message", "type", "piece", "err", err)
 		return 0, fmt.Errorf("error sending 'piece' message to peer %s: %v", pc.peerIdStr, err)
 	}
 	return
@@ -102,8 +101,17 @@ func (pc *PeerConnection) SendPiece(index uint32, begin uint32, block []byte, se
 func (pc *PeerConnection) SendCancel(index uint32, begin uint32, length uint32, session *TorrentSession) (n int, err error) {
 	n, err = pc.WriteMessage(NewCancelMessage(index, begin, length), session.rateTracker)
 	if err != nil {
-		log.Printf("error sending 'cancel' message to peer %s: %v", pc.peerIdStr, err)
+		pc.logs.peer.Debug("error sending message", "type", "cancel", "err", err)
 		return 0, fmt.Errorf("error sending 'cancel' message to peer %s: %v", pc.peerIdStr, err)
 	}
 	return
 }
+
+func (pc *PeerConnection) SendRejectRequest(index uint32, begin uint32, length uint32, session *TorrentSession) (n int, err error) {
+	n, err = pc.WriteMessage(NewRejectRequestMessage(index, begin, length), session.rateTracker)
+	if err != nil {
+		pc.logs.peer.Debug("error sending message", "type", "reject request", "err", err)
+		return 0, fmt.Errorf("error sending 'reject request' message to peer %s: %v", pc.peerIdStr, err)
+	}
+	return
+}
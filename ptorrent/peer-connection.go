@@ -0,0 +1,419 @@
+package ptorrent
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/** TOC
+- INIT
+	- New Peer Without Reader and Writer Goroutines
+	- Start Reader and Writer Goroutines
+	- New Peer With Reader and Writer Goroutines
+	- Dial New TCP connection to create a connection
+	- bindToSession, setPeerId
+- READ
+	- ReadBytes
+	- ReadMessage
+	- SafeUpdateLastReadTime
+- WRITE
+	- WriteBytes
+	- WriteMessage
+	- queueMessage, queueWithoutBlocking, requestRefill
+	- writeOutbox, writeQueuedMessages, waitForUploadBandwidth
+	- SafeUpdateLastWriteTime
+- CLOSE
+	- CloseConnection
+*/
+
+// writeChannelLength holds the piece availability and the allowed fast set, queued before the writer starts
+const writeChannelLength = 32
+
+// PeerConnection represents an active connection with a peer
+type PeerConnection struct {
+	mutex    sync.Mutex
+	isActive bool
+
+	/* Immutable fields */
+	tcpConn   net.Conn
+	peer      Peer
+	peerId    [20]byte
+	peerIdStr string
+
+	logs *subsystemLoggers // tagged with the peer id, and the info-hash once bound to a session
+
+	downloadLimiters bandwidthLimiters // every byte is charged to them once bound to a session, see rate-limiter.go
+	uploadLimiters   bandwidthLimiters
+
+	isOutgoing            bool // if we dialed the peer; the port of an incoming peer is not its listening port
+	connectedAt           time.Time
+	supportsFastExtension bool // set once during the handshake
+
+	/* Mutable Fields */
+	stateMutex     sync.RWMutex
+	amChoking      bool
+	amInterested   bool
+	peerChoking    bool
+	peerInterested bool
+
+	piecesMutex    sync.RWMutex
+	piecesBitfield *Bitset
+
+	timeMutex            sync.RWMutex
+	lastWriteTime        time.Time
+	lastReadTime         time.Time
+	lastBlockTime        time.Time // a requested block was received
+	interestedAt         time.Time // either side was interested, as of the last check of the reaper
+	expectingBlocksSince time.Time // requests are pending and the peer unchoked us, as of the last check of the reaper
+
+	snubbed atomic.Bool // see peer-reaper.go
+
+	/* Fast Extension (BEP 6) */
+	fastMutex           sync.RWMutex
+	allowedFastIncoming map[uint32]struct{} // pieces the peer may request from us while we choke it
+	allowedFastOutgoing map[uint32]struct{} // pieces we may request from the peer while it chokes us
+	suggestedPieces     []uint32            // pieces suggested by the peer, most recent last
+
+	/* Extension Protocol (BEP 10) */
+	supportsExtensionProtocol bool // set once during the handshake
+	peerMetadataId            byte // id of the ut_metadata messages sent to the peer, 0 if unsupported; used by the peer reader only
+
+	/* Request pipeline and upload queue */
+	requestsMutex   sync.Mutex
+	pendingRequests map[uint64]*BlockRequest // requests sent to the peer, not yet answered
+	uploadQueue     *UploadQueue             // requests received from the peer, not yet served
+
+	/* Channels */
+	writeChannel chan *PeerMessage
+
+	outboxMutex  sync.Mutex
+	outbox       []*PeerMessage // e.g. 'have' and 'request' messages, queued without blocking, written by the peer writer
+	outboxNotify chan struct{}
+
+	refillNotify chan struct{} // the peer writer refills the request pipeline, e.g. once the disk queue drains
+
+	closeOnce sync.Once
+	closed    chan struct{} // closed with the connection, stops the reader and the writer
+}
+
+/************************************** INIT **************************************/
+
+func NewPeerConnection(peer Peer, conn net.Conn) *PeerConnection {
+	peerConnection := &PeerConnection{
+		isActive: false,
+
+		tcpConn:        conn,
+		connectedAt:    time.Now(),
+		piecesBitfield: nil,
+
+		peer:      peer,
+		peerId:    peer.PeerId,
+		peerIdStr: hex.EncodeToString(peer.PeerId[:]),
+		logs:      logs.with("peer", hex.EncodeToString(peer.PeerId[:])),
+
+		allowedFastIncoming: make(map[uint32]struct{}),
+		allowedFastOutgoing: make(map[uint32]struct{}),
+
+		pendingRequests: make(map[uint64]*BlockRequest),
+		uploadQueue:     NewUploadQueue(DefaultUploadQueueLength),
+
+		writeChannel: make(chan *PeerMessage, writeChannelLength),
+		outboxNotify: make(chan struct{}, 1),
+		refillNotify: make(chan struct{}, 1),
+
+		closed: make(chan struct{}),
+	}
+
+	if err := peerConnection.tcpConn.(*net.TCPConn).SetKeepAlive(true); err != nil {
+		peerConnection.logs.peer.Debug("error setting tcp keep alive", "err", err)
+	}
+	if err := peerConnection.tcpConn.(*net.TCPConn).SetKeepAlivePeriod(30 * time.Second); err != nil {
+		peerConnection.logs.peer.Debug("error setting tcp keep alive period", "err", err)
+	}
+
+	peerConnection.amChoking = true
+	peerConnection.amInterested = false
+	peerConnection.peerChoking = true
+	peerConnection.peerInterested = false
+	return peerConnection
+}
+
+// StartReaderAndWriter returns ErrDuplicatePeer if the peer is connected already, and the other connection is kept.
+// The piece availability is queued first, before the peer gets any broadcast or reply (BEP 6), then the extended
+// handshake (BEP 10).
+func (pc *PeerConnection) StartReaderAndWriter(session *TorrentSession) error {
+	session.SendPieceAvailability(pc)
+	session.sendExtendedHandshake(pc)
+	if err := session.InitializePeer(pc); err != nil {
+		return err
+	}
+	session.startGoroutine(func() { pc.PeerWriter(session) })
+	session.startGoroutine(func() { pc.PeerReader(session) })
+	return nil
+}
+
+func CreatePeerConnectionAndStartReaderWriter(peer Peer, conn net.Conn, handshake *HandshakeMessage, session *TorrentSession) error {
+	var peerConnection = NewPeerConnection(peer, conn)
+	peerConnection.bindToSession(session)
+	peerConnection.supportsFastExtension = handshake.SupportsFastExtension()
+	peerConnection.supportsExtensionProtocol = handshake.SupportsExtensionProtocol()
+	return peerConnection.StartReaderAndWriter(session)
+}
+
+// DialPeerWithTimeoutTCP the dial is aborted once the context is cancelled
+func DialPeerWithTimeoutTCP(ctx context.Context, peer Peer, session *TorrentSession) (*PeerConnection, error) {
+	var address *net.TCPAddr
+	var err error
+
+	switch peer.Type {
+	case IPv4:
+		address, err = net.ResolveTCPAddr("tcp4", peer.IP.String()+":"+strconv.Itoa(int(peer.Port)))
+	case IPv6:
+		address, err = net.ResolveTCPAddr("tcp6", "["+peer.IP.String()+"]"+":"+strconv.Itoa(int(peer.Port)))
+	default:
+		return nil, fmt.Errorf("unsupported peer address type: only ipv4 and ipv6 supported")
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve peer address : %v", err)
+	}
+
+	session.logs.peer.Debug("dialing peer", "address", address.String())
+
+	dialer := net.Dialer{Timeout: session.configurable.tcpDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address.String())
+	if err != nil {
+		return nil, fmt.Errorf("error initiating tcp connection with peer %s: %v", hex.EncodeToString(peer.PeerId[:]), err)
+	}
+	peerConnection := NewPeerConnection(peer, conn)
+	peerConnection.isOutgoing = true
+	peerConnection.bindToSession(session)
+	return peerConnection, nil
+}
+
+// bindToSession tags the logs with the info-hash, and charges the traffic to the rate limiters of the session
+func (pc *PeerConnection) bindToSession(session *TorrentSession) {
+	pc.logs = session.logs.with("peer", pc.peerIdStr)
+	pc.downloadLimiters, pc.uploadLimiters = session.bandwidthLimiters()
+}
+
+// setPeerId once the handshake of a dialed peer tells its real peer id, before the peer is initialized
+func (pc *PeerConnection) setPeerId(peerId [20]byte, session *TorrentSession) {
+	if session.rateTracker != nil {
+		session.rateTracker.RemoveConnection(pc.peerIdStr)
+	}
+	pc.peer.PeerId = peerId
+	pc.peerId = peerId
+	pc.peerIdStr = hex.EncodeToString(peerId[:])
+	pc.bindToSession(session)
+}
+
+/****************************** READ FROM PEER ******************************/
+
+// ReadMessage reads exactly one length-prefixed message off the wire.
+// The block of a 'piece' message is only read once the download limiters allow it.
+func (pc *PeerConnection) ReadMessage(rateTracker *RateTracker) (message *PeerMessage, n int, err error) {
+	header := make([]byte, 5)
+	if _, err = io.ReadFull(pc.tcpConn, header[:4]); err != nil {
+		return nil, 0, err
+	}
+
+	messageLength := binary.BigEndian.Uint32(header)
+	if messageLength > MaxMessageLength {
+		return nil, 0, fmt.Errorf("message length %d exceeds the maximum of %d bytes", messageLength, MaxMessageLength)
+	}
+
+	buffer := make([]byte, 4+messageLength)
+	read := 4
+	if messageLength > 0 {
+		if _, err = io.ReadFull(pc.tcpConn, header[4:]); err != nil {
+			return nil, 0, err
+		}
+		read = 5
+		if header[4] == byte(Piece) && !pc.downloadLimiters.wait(pc.closed) {
+			return nil, 0, net.ErrClosed
+		}
+	}
+	copy(buffer, header[:read])
+	if _, err = io.ReadFull(pc.tcpConn, buffer[read:]); err != nil {
+		return nil, 0, err
+	}
+	n = len(buffer)
+	pc.downloadLimiters.charge(n)
+
+	message, err = ParsePeerMessage(buffer)
+	if err != nil {
+		return nil, 0, err
+	}
+	pc.logs.peer.Debug("read message", "type", message.MessageId, "bytes", n)
+	rateTracker.RecordDownload(pc.peerIdStr, n)
+	rateTracker.RecordPayload(message.blockLength(), 0)
+	pc.SafeUpdateLastReadTime()
+	return
+}
+
+func (pc *PeerConnection) ReadBytes(rateTracker *RateTracker) (data []byte, n int, err error) {
+	buffer := make([]byte, ConnectionBufferSize)
+	n, err = pc.tcpConn.Read(buffer)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pc.logs.peer.Debug("read bytes", "bytes", n)
+	pc.downloadLimiters.charge(n)
+	data = buffer[:n]
+	rateTracker.RecordDownload(pc.peerIdStr, n)
+	pc.SafeUpdateLastReadTime()
+	return
+}
+
+func (pc *PeerConnection) SafeUpdateLastReadTime() {
+	pc.timeMutex.Lock()
+	defer pc.timeMutex.Unlock()
+	pc.lastReadTime = time.Now()
+}
+
+/****************************** WRITE TO PEER ******************************/
+
+func (pc *PeerConnection) WriteMessage(message *PeerMessage, rateTracker *RateTracker) (n int, err error) {
+	n, err = pc.tcpConn.Write(message.Serialize())
+	if err != nil {
+		return 0, err
+	}
+
+	pc.logs.peer.Debug("written message", "type", message.MessageId, "bytes", n)
+	pc.uploadLimiters.charge(n)
+	pc.SafeUpdateLastWriteTime()
+	rateTracker.RecordUpload(pc.peerIdStr, n)
+	rateTracker.RecordPayload(0, message.blockLength())
+	return
+}
+
+func (pc *PeerConnection) WriteBytes(data []byte, rateTracker *RateTracker) (n int, err error) {
+	n, err = pc.tcpConn.Write(data)
+	if err != nil {
+		return 0, err
+	}
+
+	pc.logs.peer.Debug("written bytes", "bytes", n)
+	pc.uploadLimiters.charge(n)
+	pc.SafeUpdateLastWriteTime()
+	rateTracker.RecordUpload(pc.peerIdStr, n)
+	return
+}
+
+// queueMessage hands the message to the peer writer; the message is dropped once the connection is closed
+func (pc *PeerConnection) queueMessage(message *PeerMessage) {
+	select {
+	case pc.writeChannel <- message:
+	case <-pc.closed:
+	}
+}
+
+// queueWithoutBlocking hands the message to the peer writer without blocking, so that a slow peer never holds up the
+// sender, e.g. a disk worker announcing a piece, or the peer writer itself requesting blocks
+func (pc *PeerConnection) queueWithoutBlocking(message *PeerMessage) {
+	pc.outboxMutex.Lock()
+	pc.outbox = append(pc.outbox, message)
+	pc.outboxMutex.Unlock()
+
+	select {
+	case pc.outboxNotify <- struct{}{}:
+	default:
+	}
+}
+
+// requestRefill has the peer writer refill the request pipeline; never blocks
+func (pc *PeerConnection) requestRefill() {
+	select {
+	case pc.refillNotify <- struct{}{}:
+	default:
+	}
+}
+
+// writeOutbox writes the messages queued without blocking so far; returns false if a write failed
+// Meant to be called from the peer writer goroutine
+func (pc *PeerConnection) writeOutbox(session *TorrentSession) bool {
+	pc.outboxMutex.Lock()
+	messages := pc.outbox
+	pc.outbox = nil
+	pc.outboxMutex.Unlock()
+
+	for _, message := range messages {
+		_, err := pc.WriteMessage(message, session.rateTracker)
+		if pc.errorHandler(err, session, message, Writing) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeQueuedMessages writes the messages already queued, without waiting for more; returns false if a write failed
+// Meant to be called from the peer writer goroutine
+func (pc *PeerConnection) writeQueuedMessages(session *TorrentSession) bool {
+	for {
+		select {
+		case message := <-pc.writeChannel:
+			_, err := pc.WriteMessage(message, session.rateTracker)
+			if pc.errorHandler(err, session, message, Writing) {
+				return false
+			}
+		default:
+			return pc.writeOutbox(session)
+		}
+	}
+}
+
+// waitForUploadBandwidth blocks till the upload limiters paid off their debt, writing the messages queued meanwhile;
+// returns false if the connection is closed or a write failed.
+// Meant to be called from the peer writer goroutine
+func (pc *PeerConnection) waitForUploadBandwidth(session *TorrentSession) bool {
+	for {
+		delay := pc.uploadLimiters.delay()
+		if delay == 0 {
+			return true
+		}
+		timer := time.NewTimer(min(delay, maxBandwidthWait))
+		select {
+		case <-pc.closed:
+			timer.Stop()
+			return false
+		case <-session.ctx.Done():
+			timer.Stop()
+			return false
+		case message := <-pc.writeChannel:
+			timer.Stop()
+			_, err := pc.WriteMessage(message, session.rateTracker)
+			if pc.errorHandler(err, session, message, Writing) {
+				return false
+			}
+		case <-timer.C:
+		}
+	}
+}
+
+func (pc *PeerConnection) SafeUpdateLastWriteTime() {
+	pc.timeMutex.Lock()
+	defer pc.timeMutex.Unlock()
+	pc.lastWriteTime = time.Now()
+}
+
+/****************************** CLOSE CONNECTION ******************************/
+
+// CloseConnection stops the reader and the writer of the peer; safe to call more than once
+func (pc *PeerConnection) CloseConnection() {
+	pc.closeOnce.Do(func() {
+		close(pc.closed)
+		if err := pc.tcpConn.Close(); err != nil {
+			pc.logs.peer.Debug("error closing connection", "err", err)
+		}
+	})
+}
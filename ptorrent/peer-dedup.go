@@ -0,0 +1,67 @@
+package ptorrent
+
+import (
+	"bytes"
+)
+
+/** TOC
+- SELF-CONNECTIONS
+	- rememberSelfAddress, isSelfAddress
+- DUPLICATES
+	- findDuplicate, keepsNewConnection
+*/
+
+/*
+- What is it supposed to do
+- - The tracker returns our own address among the peers. Once a dialed peer answers with our own peer id, its address
+    is remembered by the client, for every torrent, and never dialed again. The accepting end of a self-connection
+    answers the handshake before closing it, so that the dialing end can tell.
+- - Dialed connections take the peer id of the handshake, instead of the one the tracker client derives from the
+    address of a compact peer; connections are keyed by their real peer id.
+- - Two connections to the same peer, by peer id, or by address if both were dialed, are duplicates; one is kept:
+- - - if one was dialed and the other accepted, the one dialed by the lower peer id is kept; both ends apply the same
+      rule, so that they keep the same tcp connection
+- - - otherwise the older one is kept
+*/
+
+/************************************** SELF-CONNECTIONS **************************************/
+
+func (c *Client) rememberSelfAddress(address string) {
+	c.selfAddresses.Put(address, struct{}{})
+	logs.client.Info("remembered our own address, it is not dialed again", "address", address)
+}
+
+func (c *Client) isSelfAddress(address string) bool {
+	return c.selfAddresses.ContainsKey(address)
+}
+
+/************************************** DUPLICATES **************************************/
+
+// findDuplicate the connected peer with the same peer id, or with the same address if both were dialed; nil if none
+func (ts *TorrentSession) findDuplicate(peerConnection *PeerConnection) *PeerConnection {
+	if existing := ts.connectedPeers.GetOrDefault(peerConnection.peerIdStr); existing != nil {
+		return existing
+	}
+	if !peerConnection.isOutgoing {
+		return nil
+	}
+	address := peerAddress(peerConnection.peer)
+	var duplicate *PeerConnection
+	ts.connectedPeers.ReadOnlyIterate(func(peerIdStr string, connection *PeerConnection) bool {
+		if connection.isOutgoing && peerAddress(connection.peer) == address {
+			duplicate = connection
+			return false
+		}
+		return true
+	})
+	return duplicate
+}
+
+// keepsNewConnection the tie-break between a connected peer and a new connection to the same peer
+func (ts *TorrentSession) keepsNewConnection(existing *PeerConnection, newConnection *PeerConnection) bool {
+	if existing.isOutgoing == newConnection.isOutgoing {
+		return false
+	}
+	ourDialWins := bytes.Compare(ts.localPeerId[:], newConnection.peerId[:]) < 0
+	return newConnection.isOutgoing == ourDialWins
+}
@@ -0,0 +1,395 @@
+package ptorrent
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/** TOC
+- CANDIDATES
+	- AddPeers, NumCandidates
+	- nextCandidate
+- DIAL QUEUE
+	- run (goroutine), dialCandidates, dial
+	- dialSucceeded, dialFailed, peerDisconnected, backOff
+- REPLACEMENT
+	- replaceLowValuePeer
+- LIMITS
+	- SetMaxPeers, MaxPeers, acceptsIncoming
+*/
+
+/*
+- What is it supposed to do
+- - Every session has a peer manager, holding the candidate peers from all sources (the tracker, the resume file),
+    keyed by address. Candidates are dialed from a queue, instead of all at once.
+- - At most `maxPeers` peers are connected to a torrent, incoming ones included; the client limits the connections
+    across all torrents, and the dials in progress (half-open connections) across all torrents.
+- - A candidate that can not be dialed, or fails the handshake, is retried with an exponential backoff; it is dropped
+    after `maxDialFailures` failures in a row. A peer that disconnects is retried after `dialRetryBackoff`.
+- - Every `peerReplaceInterval`, if a candidate is waiting and no slot is free, a snubbed peer, or else the connected
+    peer with the lowest download and upload rates, is disconnected to make room for it. Peers connected for less than `minPeerAge` are kept.
+- - Our own address is never dialed, and neither are peers connected already; see peer-dedup.go
+- - The dial queue only runs while the session is active; candidates are kept across a pause.
+*/
+
+const DefaultMaxPeersPerTorrent = 50
+const DefaultMaxHalfOpen = 20 // dials in progress, across all torrents
+
+const maxPeerCandidates = 1000
+const maxDialFailures = 5
+const dialRetryBackoff = time.Second * 30 // doubled on every failure in a row
+const maxDialRetryBackoff = time.Minute * 30
+const peerManagerTick = time.Second // picks up the candidates whose backoff is over
+const peerReplaceInterval = time.Minute * 2
+const minPeerAge = time.Minute * 2 // time given to a peer to start transferring before it can be replaced
+const replacedPeerBackoff = time.Minute * 10
+const duplicatePeerBackoff = time.Minute * 10 // the peer is connected through another connection
+
+type PeerSource string
+
+const (
+	PeerSourceTracker PeerSource = "tracker"
+	PeerSourceResume  PeerSource = "resume"
+	PeerSourceMagnet  PeerSource = "magnet" // the peers the metadata of a magnet link was looked up from
+)
+
+type candidateState int
+
+const (
+	candidateIdle candidateState = iota
+	candidateDialing
+	candidateConnected
+)
+
+type peerCandidate struct {
+	peer        Peer
+	source      PeerSource
+	state       candidateState
+	failures    int       // in a row
+	nextAttempt time.Time // zero to dial right away
+}
+
+type PeerManager struct {
+	session *TorrentSession
+
+	mu         sync.Mutex
+	candidates map[string]*peerCandidate // look up using address, ip:port
+	numDialing int
+	maxPeers   int // 0 for unlimited
+
+	wake chan struct{} // wakes the dial queue up before the next tick, e.g. once new candidates are added
+}
+
+func NewPeerManager(session *TorrentSession, maxPeers int) *PeerManager {
+	return &PeerManager{
+		session:    session,
+		candidates: make(map[string]*peerCandidate),
+		maxPeers:   maxPeers,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+func peerAddress(peer Peer) string {
+	return net.JoinHostPort(peer.IP.String(), strconv.Itoa(int(peer.Port)))
+}
+
+/************************************** CANDIDATES **************************************/
+
+// AddPeers adds the peers not known yet to the candidates; they are dialed once a slot is free
+func (pm *PeerManager) AddPeers(peers []Peer, source PeerSource) {
+	pm.mu.Lock()
+	added := 0
+	for _, peer := range peers {
+		if len(pm.candidates) >= maxPeerCandidates {
+			break
+		}
+		if peer.Port == 0 || peer.Type == InvalidIpType {
+			continue
+		}
+		address := peerAddress(peer)
+		if _, exists := pm.candidates[address]; exists || pm.session.client.isSelfAddress(address) {
+			continue
+		}
+		if pm.session.bans.IsBanned(peer.IP.String()) {
+			continue
+		}
+		pm.candidates[address] = &peerCandidate{peer: peer, source: source}
+		added++
+	}
+	numCandidates := len(pm.candidates)
+	pm.mu.Unlock()
+
+	pm.session.logs.client.Debug("added peer candidates", "source", source, "added", added, "candidates", numCandidates)
+	pm.notify()
+}
+
+// NumCandidates the known peers, connected or not
+func (pm *PeerManager) NumCandidates() int {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return len(pm.candidates)
+}
+
+// nextCandidate the idle candidate with the fewest failures, whose backoff is over; must be called with the mutex held
+func (pm *PeerManager) nextCandidate(now time.Time) *peerCandidate {
+	var next *peerCandidate
+	for _, candidate := range pm.candidates {
+		if candidate.state != candidateIdle || candidate.nextAttempt.After(now) {
+			continue
+		}
+		if next == nil || candidate.failures < next.failures ||
+			(candidate.failures == next.failures && candidate.nextAttempt.Before(next.nextAttempt)) {
+			next = candidate
+		}
+	}
+	return next
+}
+
+func (pm *PeerManager) notify() {
+	select {
+	case pm.wake <- struct{}{}:
+	default:
+	}
+}
+
+/************************************** DIAL QUEUE **************************************/
+
+// run Meant to be run as a goroutine, dials candidates and replaces low-value peers till the context is cancelled
+func (pm *PeerManager) run(ctx context.Context) {
+	ticker := time.NewTicker(peerManagerTick)
+	defer ticker.Stop()
+	lastReplace := time.Now()
+
+	for {
+		pm.dialCandidates(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-pm.wake:
+		case <-ticker.C:
+		}
+		if time.Since(lastReplace) >= peerReplaceInterval {
+			pm.replaceLowValuePeer()
+			lastReplace = time.Now()
+		}
+	}
+}
+
+// dialCandidates starts dialing candidates, as long as the torrent and the client have free slots
+func (pm *PeerManager) dialCandidates(ctx context.Context) {
+	session := pm.session
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	now := time.Now()
+	for ctx.Err() == nil {
+		if pm.maxPeers > 0 && session.connectedPeers.Size()+pm.numDialing >= pm.maxPeers {
+			return
+		}
+		candidate := pm.nextCandidate(now)
+		if candidate == nil {
+			return
+		}
+		// e.g. found out to be our own address by another torrent, or banned once connected
+		if session.client.isSelfAddress(peerAddress(candidate.peer)) || session.bans.IsBanned(candidate.peer.IP.String()) {
+			delete(pm.candidates, peerAddress(candidate.peer))
+			continue
+		}
+		// the peer id of a compact peer is derived from its address, a connected peer has its real one
+		if session.connectedPeers.ContainsKey(hex.EncodeToString(candidate.peer.PeerId[:])) {
+			candidate.nextAttempt = now.Add(duplicatePeerBackoff)
+			continue
+		}
+		if !session.client.acquireHalfOpenSlot() {
+			return
+		}
+		if !session.client.acquireConnectionSlot() {
+			session.client.releaseHalfOpenSlot()
+			return
+		}
+		candidate.state = candidateDialing
+		pm.numDialing++
+		session.startGoroutine(func() { pm.dial(ctx, candidate) })
+	}
+}
+
+// dial Meant to be run as a goroutine, with a half-open and a connection slot acquired
+func (pm *PeerManager) dial(ctx context.Context, candidate *peerCandidate) {
+	session := pm.session
+	conn, err := DialPeerWithTimeoutTCP(ctx, candidate.peer, session)
+	if err != nil {
+		session.client.releaseHalfOpenSlot()
+		session.client.releaseConnectionSlot()
+		if ctx.Err() == nil {
+			session.metrics.handshakeFailures.add(HandshakeFailureDial)
+		}
+		session.logs.peer.Debug("error dialing peer", "err", err)
+		pm.dialFailed(ctx, candidate, err)
+		pm.notify()
+		return
+	}
+
+	err = PerformHandshake(conn, session, session.localPeerId)
+	session.client.releaseHalfOpenSlot()
+	if err != nil {
+		session.client.releaseConnectionSlot()
+		if ctx.Err() == nil {
+			session.metrics.handshakeFailures.add(handshakeFailureReason(err))
+		}
+		conn.logs.peer.Debug("handshake failed, closing connection", "err", err)
+		conn.CloseConnection()
+		pm.dialFailed(ctx, candidate, err)
+		pm.notify()
+		return
+	}
+	if err = conn.StartReaderAndWriter(session); err != nil {
+		session.client.releaseConnectionSlot()
+		session.metrics.handshakeFailures.add(handshakeFailureReason(err))
+		conn.logs.peer.Debug("closing connection", "err", err)
+		conn.CloseConnection()
+		pm.dialFailed(ctx, candidate, err)
+		pm.notify()
+		return
+	}
+	pm.dialSucceeded(candidate)
+	pm.notify()
+	conn.logs.peer.Debug("handshake successful")
+}
+
+// dialSucceeded once the peer is counted as connected; it may have disconnected already
+func (pm *PeerManager) dialSucceeded(candidate *peerCandidate) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pm.numDialing--
+	candidate.failures = 0
+	if candidate.state == candidateDialing {
+		candidate.state = candidateConnected
+	}
+}
+
+// dialFailed backs the candidate off, or drops it after `maxDialFailures` failures; a cancelled dial is not a failure.
+// Our own address is dropped right away, and remembered by the client.
+func (pm *PeerManager) dialFailed(ctx context.Context, candidate *peerCandidate, err error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pm.numDialing--
+	candidate.state = candidateIdle
+	address := peerAddress(candidate.peer)
+	switch {
+	case ctx.Err() != nil:
+	case errors.Is(err, ErrSelfConnection):
+		pm.session.client.rememberSelfAddress(address)
+		delete(pm.candidates, address)
+	case errors.Is(err, ErrDuplicatePeer):
+		candidate.nextAttempt = time.Now().Add(duplicatePeerBackoff)
+	default:
+		candidate.failures++
+		if candidate.failures >= maxDialFailures {
+			delete(pm.candidates, address)
+			return
+		}
+		backoff := min(dialRetryBackoff<<(candidate.failures-1), maxDialRetryBackoff)
+		candidate.nextAttempt = time.Now().Add(backoff)
+	}
+}
+
+// peerDisconnected frees the slot of an outgoing peer; it is dialed again after `dialRetryBackoff`, or right away on
+// resume if it was disconnected by a pause
+func (pm *PeerManager) peerDisconnected(peerConnection *PeerConnection) {
+	if !peerConnection.isOutgoing {
+		pm.notify()
+		return
+	}
+	pm.mu.Lock()
+	if candidate, exists := pm.candidates[peerAddress(peerConnection.peer)]; exists && candidate.state != candidateIdle {
+		candidate.state = candidateIdle
+		if pm.session.paused.Load() {
+			candidate.nextAttempt = time.Time{}
+		} else {
+			candidate.nextAttempt = time.Now().Add(dialRetryBackoff)
+		}
+	}
+	pm.mu.Unlock()
+	pm.notify()
+}
+
+// backOff delays the next dial of an idle candidate
+func (pm *PeerManager) backOff(peer Peer, backoff time.Duration) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if candidate, exists := pm.candidates[peerAddress(peer)]; exists && candidate.state == candidateIdle {
+		candidate.nextAttempt = time.Now().Add(backoff)
+	}
+}
+
+/************************************** REPLACEMENT **************************************/
+
+// replaceLowValuePeer disconnects the slowest peer, if a candidate is waiting for a slot
+func (pm *PeerManager) replaceLowValuePeer() {
+	session := pm.session
+	client := session.client
+
+	pm.mu.Lock()
+	torrentFull := pm.maxPeers > 0 && session.connectedPeers.Size()+pm.numDialing >= pm.maxPeers
+	clientFull := client.configurable.maxConnections > 0 && client.NumConnections() >= int64(client.configurable.maxConnections)
+	waiting := pm.nextCandidate(time.Now()) != nil
+	pm.mu.Unlock()
+	if !waiting || (!torrentFull && !clientFull) || session.rateTracker == nil {
+		return
+	}
+
+	var slowest *PeerConnection
+	slowestRate, slowestSnubbed := 0.0, false
+	session.connectedPeers.ReadOnlyIterate(func(peerIdStr string, connection *PeerConnection) bool {
+		if time.Since(connection.connectedAt) < minPeerAge {
+			return true
+		}
+		rate := session.rateTracker.GetDownloadSpeed(peerIdStr) + session.rateTracker.GetUploadSpeed(peerIdStr)
+		snubbed := connection.snubbed.Load()
+		if slowest == nil || (snubbed && !slowestSnubbed) || (snubbed == slowestSnubbed && rate < slowestRate) {
+			slowest, slowestRate, slowestSnubbed = connection, rate, snubbed
+		}
+		return true
+	})
+	if slowest == nil || !session.RemovePeer(slowest) {
+		return
+	}
+	slowest.CloseConnection()
+	slowest.logs.peer.Debug("replaced low-value peer", "rate", slowestRate, "snubbed", slowestSnubbed)
+
+	// the replaced peer makes room for the others first
+	if slowest.isOutgoing {
+		pm.backOff(slowest.peer, replacedPeerBackoff)
+	}
+}
+
+/************************************** LIMITS **************************************/
+
+// SetMaxPeers the maximum number of connected peers, 0 for unlimited; peers above a lowered limit are not disconnected
+func (pm *PeerManager) SetMaxPeers(maxPeers int) {
+	pm.mu.Lock()
+	pm.maxPeers = max(maxPeers, 0)
+	pm.mu.Unlock()
+	pm.notify()
+}
+
+func (pm *PeerManager) MaxPeers() int {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.maxPeers
+}
+
+// acceptsIncoming if an incoming peer fits within the maximum number of peers
+func (pm *PeerManager) acceptsIncoming() bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.maxPeers <= 0 || pm.session.connectedPeers.Size()+pm.numDialing < pm.maxPeers
+}
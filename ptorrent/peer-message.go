@@ -1,9 +1,8 @@
-package main
+package ptorrent
 
 import (
 	"encoding/binary"
 	"fmt"
-	"log"
 )
 
 /* TOC
@@ -43,10 +42,27 @@ const (
 	Request       PeerMessageType = 6
 	Piece         PeerMessageType = 7
 	Cancel        PeerMessageType = 8
-	Extended      PeerMessageType = 84
+	Extended      PeerMessageType = 20 // Extension Protocol (BEP 10)
 	//Port // used for dht, not implemented
+
+	/* Fast Extension (BEP 6) */
+	SuggestPiece  PeerMessageType = 13
+	HaveAll       PeerMessageType = 14
+	HaveNone      PeerMessageType = 15
+	RejectRequest PeerMessageType = 16
+	AllowedFast   PeerMessageType = 17
 )
 
+func isValidMessageId(messageId int) bool {
+	if messageId >= int(Choke) && messageId <= int(Cancel) {
+		return true
+	}
+	if messageId >= int(SuggestPiece) && messageId <= int(AllowedFast) {
+		return true
+	}
+	return messageId == int(Extended)
+}
+
 type PeerMessage struct {
 	MessageLength uint32
 	MessageId     PeerMessageType
@@ -114,8 +130,8 @@ func NewPieceResponse(index uint32, begin uint32, block []byte) *PieceResponse {
 }
 
 func ParsePieceResponse(message []byte) (*PieceResponse, error) {
-	if len(message) < 12 {
-		return nil, fmt.Errorf("invalid message length: expected at least 12 bytes, got %d", len(message))
+	if len(message) < 8 {
+		return nil, fmt.Errorf("invalid message length: expected at least 8 bytes, got %d", len(message))
 	}
 
 	index := binary.BigEndian.Uint32(message[0:4])
@@ -130,7 +146,7 @@ func ParsePieceResponse(message []byte) (*PieceResponse, error) {
 }
 
 func (p *PieceResponse) Serialize() []byte {
-	responseBuf := make([]byte, 12+len(p.block))
+	responseBuf := make([]byte, 8+len(p.block))
 	binary.BigEndian.PutUint32(responseBuf[0:4], p.index)
 	binary.BigEndian.PutUint32(responseBuf[4:8], p.begin)
 	copy(responseBuf[8:], p.block)
@@ -214,8 +230,8 @@ func ParsePeerMessage(data []byte) (*PeerMessage, error) {
 		copy(payload, data[5:])
 
 		// Validate Message ID
-		if (messageId != 84) && (messageId < -1 || messageId > 8) {
-			return nil, fmt.Errorf("invalid message id: expected between -1 and 8, or 84, got %d", messageId)
+		if !isValidMessageId(messageId) {
+			return nil, fmt.Errorf("invalid message id: expected between 0 and 8, 13 and 17, or 20, got %d", messageId)
 		}
 
 		// Validate payload length
@@ -230,7 +246,7 @@ func ParsePeerMessage(data []byte) (*PeerMessage, error) {
 
 func (p *PeerMessage) Serialize() []byte {
 	message := make([]byte, p.MessageLength+4)
-	binary.BigEndian.AppendUint32(message, p.MessageLength)
+	binary.BigEndian.PutUint32(message[0:4], p.MessageLength)
 	if p.MessageLength > 0 {
 		message[4] = byte(p.MessageId)
 		copy(message[5:], p.Payload)
@@ -265,12 +281,11 @@ func NewHaveMessage(pieceIndex uint32) *PeerMessage {
 	return NewPeerMessage(5, Have, payload)
 }
 
-func (p *PeerMessage) GetHaveMessagePayload() uint {
-	if p.MessageId == Have {
-		return uint(binary.BigEndian.Uint32(p.Payload[:4]))
+func (p *PeerMessage) GetHaveMessagePayload() (uint32, error) {
+	if p.MessageId != Have || len(p.Payload) < 4 {
+		return 0, fmt.Errorf("message id %d not a valid 'Have'", p.MessageId)
 	}
-	log.Fatalf("message id %d not of type 'Have'", p.MessageId)
-	return 0
+	return binary.BigEndian.Uint32(p.Payload[:4]), nil
 }
 
 func NewBitfieldMessage(bitset *Bitset) *PeerMessage {
@@ -281,7 +296,7 @@ func NewBitfieldMessage(bitset *Bitset) *PeerMessage {
 func (p *PeerMessage) GetBitfieldMessagePayload(session *TorrentSession) *Bitset {
 	bitset, err := ParseAndValidateBitset(p.Payload, session.bitfield.size)
 	if err != nil {
-		log.Printf(err.Error())
+		session.logs.peer.Warn("invalid bitfield", "err", err)
 		return nil
 	}
 	return bitset
@@ -304,3 +319,101 @@ func NewCancelMessage(index uint32, begin uint32, length uint32) *PeerMessage {
 	payload := cancelReq.Serialize()
 	return NewPeerMessage(uint32(len(payload)+1), Cancel, payload)
 }
+
+func (p *PeerMessage) GetRequestMessagePayload() (*BlockRequest, error) {
+	if p.MessageId != Request {
+		return nil, fmt.Errorf("message id %d not of type 'Request'", p.MessageId)
+	}
+	return ParseBlockRequest(p.Payload)
+}
+
+func (p *PeerMessage) GetPieceMessagePayload() (*PieceResponse, error) {
+	if p.MessageId != Piece {
+		return nil, fmt.Errorf("message id %d not of type 'Piece'", p.MessageId)
+	}
+	return ParsePieceResponse(p.Payload)
+}
+
+// blockLength the length of the block of a 'piece' message, 0 for every other message
+func (p *PeerMessage) blockLength() int {
+	if p.MessageId != Piece || len(p.Payload) < 8 {
+		return 0
+	}
+	return len(p.Payload) - 8
+}
+
+func (p *PeerMessage) GetCancelMessagePayload() (*CancelRequest, error) {
+	if p.MessageId != Cancel {
+		return nil, fmt.Errorf("message id %d not of type 'Cancel'", p.MessageId)
+	}
+	return ParseCancelRequest(p.Payload)
+}
+
+/* FAST EXTENSION (BEP 6) */
+
+// NewSuggestPieceMessage The payload is the zero-based index of a piece the receiver is advised to download.
+func NewSuggestPieceMessage(pieceIndex uint32) *PeerMessage {
+	var payload = make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, pieceIndex)
+	return NewPeerMessage(5, SuggestPiece, payload)
+}
+
+func NewHaveAllMessage() *PeerMessage {
+	return NewPeerMessageNoPayload(1, HaveAll)
+}
+
+func NewHaveNoneMessage() *PeerMessage {
+	return NewPeerMessageNoPayload(1, HaveNone)
+}
+
+// NewRejectRequestMessage The payload mirrors the payload of the rejected 'Request'.
+func NewRejectRequestMessage(index uint32, begin uint32, length uint32) *PeerMessage {
+	req := NewBlockRequest(index, begin, length)
+	payload := req.Serialize()
+	return NewPeerMessage(uint32(len(payload)+1), RejectRequest, payload)
+}
+
+// NewAllowedFastMessage The payload is the zero-based index of a piece that may be requested even while choked.
+func NewAllowedFastMessage(pieceIndex uint32) *PeerMessage {
+	var payload = make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, pieceIndex)
+	return NewPeerMessage(5, AllowedFast, payload)
+}
+
+func (p *PeerMessage) GetSuggestPieceMessagePayload() (uint32, error) {
+	if p.MessageId != SuggestPiece || len(p.Payload) < 4 {
+		return 0, fmt.Errorf("message id %d not a valid 'Suggest Piece'", p.MessageId)
+	}
+	return binary.BigEndian.Uint32(p.Payload[:4]), nil
+}
+
+func (p *PeerMessage) GetRejectRequestMessagePayload() (*BlockRequest, error) {
+	if p.MessageId != RejectRequest {
+		return nil, fmt.Errorf("message id %d not of type 'Reject Request'", p.MessageId)
+	}
+	return ParseBlockRequest(p.Payload)
+}
+
+func (p *PeerMessage) GetAllowedFastMessagePayload() (uint32, error) {
+	if p.MessageId != AllowedFast || len(p.Payload) < 4 {
+		return 0, fmt.Errorf("message id %d not a valid 'Allowed Fast'", p.MessageId)
+	}
+	return binary.BigEndian.Uint32(p.Payload[:4]), nil
+}
+
+/* EXTENSION PROTOCOL (BEP 10) */
+
+// NewExtendedMessage The payload starts with the id of the extended message, 0 for the extended handshake.
+func NewExtendedMessage(extendedId byte, payload []byte) *PeerMessage {
+	extendedPayload := make([]byte, 1+len(payload))
+	extendedPayload[0] = extendedId
+	copy(extendedPayload[1:], payload)
+	return NewPeerMessage(uint32(len(extendedPayload)+1), Extended, extendedPayload)
+}
+
+func (p *PeerMessage) GetExtendedMessagePayload() (byte, []byte, error) {
+	if p.MessageId != Extended || len(p.Payload) < 1 {
+		return 0, nil, fmt.Errorf("message id %d not a valid 'Extended'", p.MessageId)
+	}
+	return p.Payload[0], p.Payload[1:], nil
+}
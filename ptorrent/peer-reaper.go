@@ -0,0 +1,141 @@
+package ptorrent
+
+import (
+	"context"
+	"time"
+)
+
+/** TOC
+- REAPER
+	- StartPeerReaper (goroutine), reapPeers
+	- checkPeer
+- SNUBBING
+	- onSnubbed, onBlockReceived
+	- pipelineDepth
+*/
+
+/*
+- What is it supposed to do
+- - Every `peerReaperInterval`, while the session is active, every connected peer is checked. A peer is dropped:
+- - - if nothing was read from it for `peerTimeout`; peers send a keep alive at least every 2 minutes
+- - - if neither side was interested for `notInterestedTimeout`, e.g. two seeds
+- - A peer is snubbed if it unchoked us, and sent no block for `snubTimeout` while we had requests pending. Its pending
+    requests are cancelled and handed to the other peers; it gets one request at a time, and is the first replaced by
+    the peer manager, till it sends a block again.
+*/
+
+const DefaultPeerTimeout = time.Minute * 3
+const peerReaperInterval = time.Second * 10
+
+/************************************** REAPER **************************************/
+
+// StartPeerReaper Meant to be run as a goroutine, checks the connected peers till the context is cancelled
+func (ts *TorrentSession) StartPeerReaper(ctx context.Context) {
+	ticker := time.NewTicker(peerReaperInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ts.reapPeers(time.Now())
+	}
+}
+
+func (ts *TorrentSession) reapPeers(now time.Time) {
+	var connections []*PeerConnection
+	ts.connectedPeers.ReadOnlyIterate(func(peerIdStr string, connection *PeerConnection) bool {
+		connections = append(connections, connection)
+		return true
+	})
+	for _, connection := range connections {
+		reason := ts.checkPeer(connection, now)
+		if reason == "" {
+			continue
+		}
+		if ts.RemovePeer(connection) {
+			connection.CloseConnection()
+			connection.logs.peer.Info("dropped peer", "reason", reason)
+		}
+	}
+}
+
+// checkPeer returns why the peer is dropped, "" to keep it; snubs the peer if needed
+func (ts *TorrentSession) checkPeer(pc *PeerConnection, now time.Time) string {
+	pc.stateMutex.RLock()
+	amInterested, peerInterested, peerChoking := pc.amInterested, pc.peerInterested, pc.peerChoking
+	pc.stateMutex.RUnlock()
+	expectingBlocks := amInterested && !peerChoking && pc.numPendingRequests() > 0
+
+	pc.timeMutex.Lock()
+	if amInterested || peerInterested {
+		pc.interestedAt = now
+	}
+	if !expectingBlocks {
+		pc.expectingBlocksSince = time.Time{}
+	} else if pc.expectingBlocksSince.IsZero() {
+		pc.expectingBlocksSince = now
+	}
+	lastRead := latest(pc.lastReadTime, pc.connectedAt)
+	lastInterested := latest(pc.interestedAt, pc.connectedAt)
+	lastBlock := latest(pc.lastBlockTime, pc.expectingBlocksSince)
+	pc.timeMutex.Unlock()
+
+	if now.Sub(lastRead) > ts.configurable.peerTimeout {
+		return "inactive"
+	}
+	if now.Sub(lastInterested) > ts.configurable.notInterestedTimeout {
+		return "not interested"
+	}
+	if expectingBlocks && now.Sub(lastBlock) > ts.configurable.snubTimeout && !pc.snubbed.Swap(true) {
+		pc.onSnubbed(ts)
+	}
+	return ""
+}
+
+func latest(a time.Time, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+/************************************** SNUBBING **************************************/
+
+// onSnubbed cancels the pending requests of the peer, for the other peers to request the blocks
+func (pc *PeerConnection) onSnubbed(session *TorrentSession) {
+	pc.requestsMutex.Lock()
+	pending := make([]*BlockRequest, 0, len(pc.pendingRequests))
+	for _, request := range pc.pendingRequests {
+		pending = append(pending, request)
+	}
+	clear(pc.pendingRequests)
+	pc.requestsMutex.Unlock()
+
+	for _, request := range pending {
+		pc.queueMessage(NewCancelMessage(request.index, request.begin, request.length))
+	}
+	session.piecePicker.ReleasePeer(pc.peerIdStr)
+	pc.logs.peer.Info("peer snubbed us, cancelled its requests", "requests", len(pending))
+	session.refillAllRequestPipelines()
+}
+
+// onBlockReceived a requested block; a snubbed peer is no longer snubbed
+func (pc *PeerConnection) onBlockReceived() {
+	pc.timeMutex.Lock()
+	pc.lastBlockTime = time.Now()
+	pc.timeMutex.Unlock()
+
+	if pc.snubbed.Swap(false) {
+		pc.logs.peer.Info("peer is no longer snubbed")
+	}
+}
+
+// pipelineDepth one request at a time for a snubbed peer
+func (pc *PeerConnection) pipelineDepth(session *TorrentSession) int {
+	if pc.snubbed.Load() {
+		return 1
+	}
+	return session.configurable.maxPipelineDepth
+}
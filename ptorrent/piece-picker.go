@@ -0,0 +1,329 @@
+package ptorrent
+
+import (
+	"sync"
+)
+
+/*
+- What is it supposed to do
+- - Decide which blocks to request from a peer, so that no two peers are asked for the same block.
+- - Order of preference:
+- - - pieces suggested by the peer (BEP 6)
+- - - pieces already in progress, to complete them sooner
+- - - the rarest piece in the swarm that the peer has, among those of the highest file priority
+- - Only pieces accepted by the `allowed` filter are picked (e.g. the allowed fast set while choked)
+- - Pieces that only overlap skipped files are never picked
+- - A piece restricted after failing the hash check is only picked for a single peer, see peer-ban.go
+*/
+
+// pieceDownload tracks the blocks of a piece that is being downloaded
+type pieceDownload struct {
+	numBlocks    int64
+	pieceLength  int64
+	requestedBy  []string // peer id of the peer that the block was requested from; "" if not requested
+	received     []bool
+	receivedFrom []string // IP address of the peer that sent the block; "" if unknown, e.g. restored from the resume file
+
+	restricted bool   // downloaded from a single peer, to find out who sent a corrupt block
+	soleSource string // peer id of that peer; "" till a peer picks a block of the piece
+}
+
+func (pd *pieceDownload) numReceived() int64 {
+	count := int64(0)
+	for _, received := range pd.received {
+		if received {
+			count++
+		}
+	}
+	return count
+}
+
+type PiecePicker struct {
+	mu sync.Mutex
+
+	selfBitfield    *Bitset
+	bitfieldManager *BitfieldManager
+
+	numPieces   uint
+	pieceLength int64
+	totalLength int64
+
+	inProgress      map[uint32]*pieceDownload
+	piecePriorities []FilePriority // nil if every piece has normal priority
+}
+
+func NewPiecePicker(torrent *Torrent, selfBitfield *Bitset, bitfieldManager *BitfieldManager) *PiecePicker {
+	return &PiecePicker{
+		selfBitfield:    selfBitfield,
+		bitfieldManager: bitfieldManager,
+		numPieces:       torrent.Info.NumPieces,
+		pieceLength:     torrent.Info.PieceLength,
+		totalLength:     torrent.Info.Length,
+		inProgress:      make(map[uint32]*pieceDownload),
+	}
+}
+
+// SetPiecePriorities must be called before any block is picked
+func (pp *PiecePicker) SetPiecePriorities(piecePriorities []FilePriority) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	pp.piecePriorities = piecePriorities
+}
+
+func (pp *PiecePicker) piecePriority(pieceIndex uint32) FilePriority {
+	if pp.piecePriorities == nil {
+		return PriorityNormal
+	}
+	return pp.piecePriorities[pieceIndex]
+}
+
+func blockKey(index uint32, begin uint32) uint64 {
+	return uint64(index)<<32 | uint64(begin)
+}
+
+func (pp *PiecePicker) lengthOfPiece(pieceIndex uint32) int64 {
+	if uint(pieceIndex) == pp.numPieces-1 {
+		return pp.totalLength - pp.pieceLength*int64(pp.numPieces-1)
+	}
+	return pp.pieceLength
+}
+
+func (pp *PiecePicker) getOrCreatePieceDownload(pieceIndex uint32) *pieceDownload {
+	if pd, ok := pp.inProgress[pieceIndex]; ok {
+		return pd
+	}
+
+	pieceLength := pp.lengthOfPiece(pieceIndex)
+	numBlocks := ceilDiv(pieceLength, BlockSize)
+	pd := &pieceDownload{
+		numBlocks:    numBlocks,
+		pieceLength:  pieceLength,
+		requestedBy:  make([]string, numBlocks),
+		received:     make([]bool, numBlocks),
+		receivedFrom: make([]string, numBlocks),
+	}
+	pp.inProgress[pieceIndex] = pd
+	return pd
+}
+
+// PickBlocks assigns at most `count` unrequested blocks to the peer
+func (pp *PiecePicker) PickBlocks(peerIdStr string, peerBitfield *Bitset, suggested []uint32, allowed func(pieceIndex uint32) bool, count int) []*BlockRequest {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	if peerBitfield == nil || count <= 0 {
+		return nil
+	}
+
+	var requests []*BlockRequest
+	visited := make(map[uint32]struct{})
+	pickFromPiece := func(pieceIndex uint32) {
+		visited[pieceIndex] = struct{}{}
+		if !pp.isWanted(pieceIndex, peerBitfield, allowed) {
+			return
+		}
+		pd := pp.getOrCreatePieceDownload(pieceIndex)
+		if pd.restricted && pd.soleSource != "" && pd.soleSource != peerIdStr {
+			return
+		}
+		for blockIndex := int64(0); blockIndex < pd.numBlocks && len(requests) < count; blockIndex++ {
+			if pd.received[blockIndex] || pd.requestedBy[blockIndex] != "" {
+				continue
+			}
+			pd.requestedBy[blockIndex] = peerIdStr
+			if pd.restricted {
+				pd.soleSource = peerIdStr
+			}
+			begin := blockIndex * BlockSize
+			length := findBlockLength(blockIndex, pd.pieceLength, pd.numBlocks)
+			requests = append(requests, NewBlockRequest(pieceIndex, uint32(begin), uint32(length)))
+		}
+	}
+
+	// suggested pieces, most recent first
+	for i := len(suggested) - 1; i >= 0 && len(requests) < count; i-- {
+		pickFromPiece(suggested[i])
+	}
+
+	// pieces in progress
+	for pieceIndex := range pp.inProgress {
+		if len(requests) >= count {
+			break
+		}
+		if _, ok := visited[pieceIndex]; !ok {
+			pickFromPiece(pieceIndex)
+		}
+	}
+
+	// rarest pieces
+	for len(requests) < count {
+		pieceIndex, found := pp.findRarestWantedPiece(peerBitfield, allowed, visited)
+		if !found {
+			break
+		}
+		pickFromPiece(pieceIndex)
+	}
+	return requests
+}
+
+func (pp *PiecePicker) isWanted(pieceIndex uint32, peerBitfield *Bitset, allowed func(pieceIndex uint32) bool) bool {
+	if uint(pieceIndex) >= pp.numPieces {
+		return false
+	}
+	if pp.selfBitfield.GetBit(uint(pieceIndex)) == 1 || peerBitfield.GetBit(uint(pieceIndex)) == 0 {
+		return false
+	}
+	if pp.piecePriority(pieceIndex) == PrioritySkip {
+		return false
+	}
+	return allowed == nil || allowed(pieceIndex)
+}
+
+func (pp *PiecePicker) findRarestWantedPiece(peerBitfield *Bitset, allowed func(pieceIndex uint32) bool, visited map[uint32]struct{}) (uint32, bool) {
+	found := false
+	rarestPiece := uint32(0)
+	rarestFrequency := 0
+	rarestPriority := PrioritySkip
+	for i := uint(0); i < pp.numPieces; i++ {
+		pieceIndex := uint32(i)
+		if _, ok := visited[pieceIndex]; ok {
+			continue
+		}
+		if !pp.isWanted(pieceIndex, peerBitfield, allowed) {
+			continue
+		}
+		// a higher priority wins over rarity
+		priority := pp.piecePriority(pieceIndex)
+		if found && priority < rarestPriority {
+			continue
+		}
+		frequency := pp.bitfieldManager.GetPieceFrequency(int(pieceIndex))
+		if !found || priority > rarestPriority || frequency < rarestFrequency {
+			found = true
+			rarestPiece = pieceIndex
+			rarestFrequency = frequency
+			rarestPriority = priority
+		}
+	}
+	return rarestPiece, found
+}
+
+// MarkBlockReceived returns true if every block of the piece has now been received; `address` the IP address of the
+// peer that sent the block
+func (pp *PiecePicker) MarkBlockReceived(index uint32, begin uint32, address string) bool {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	pd, ok := pp.inProgress[index]
+	if !ok {
+		return false
+	}
+	blockIndex := int64(begin) / BlockSize
+	if blockIndex >= pd.numBlocks {
+		return false
+	}
+	pd.received[blockIndex] = true
+	pd.receivedFrom[blockIndex] = address
+	pd.requestedBy[blockIndex] = ""
+	return pd.numReceived() == pd.numBlocks
+}
+
+// ReleaseBlock makes the block available to be requested again, in case it was requested from the given peer
+func (pp *PiecePicker) ReleaseBlock(index uint32, begin uint32, peerIdStr string) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	pd, ok := pp.inProgress[index]
+	if !ok {
+		return
+	}
+	blockIndex := int64(begin) / BlockSize
+	if blockIndex < pd.numBlocks && pd.requestedBy[blockIndex] == peerIdStr {
+		pd.requestedBy[blockIndex] = ""
+	}
+}
+
+// ReleasePeer makes every block requested from the peer available to be requested again; the pieces restricted to
+// the peer are left to the next peer picking them
+func (pp *PiecePicker) ReleasePeer(peerIdStr string) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	for _, pd := range pp.inProgress {
+		if pd.soleSource == peerIdStr {
+			pd.soleSource = ""
+		}
+		for blockIndex := range pd.requestedBy {
+			if pd.requestedBy[blockIndex] == peerIdStr {
+				pd.requestedBy[blockIndex] = ""
+			}
+		}
+	}
+}
+
+// InFlightPieces returns the pieces with blocks requested or received, which are not verified yet
+func (pp *PiecePicker) InFlightPieces() []uint32 {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	pieces := make([]uint32, 0, len(pp.inProgress))
+	for pieceIndex, pd := range pp.inProgress {
+		for blockIndex := range pd.requestedBy {
+			if pd.requestedBy[blockIndex] != "" || pd.received[blockIndex] {
+				pieces = append(pieces, pieceIndex)
+				break
+			}
+		}
+	}
+	return pieces
+}
+
+// RestorePartialPiece marks the blocks of a partially downloaded piece as received, e.g. from a resume file
+func (pp *PiecePicker) RestorePartialPiece(pieceIndex uint32, received []bool) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	pd := pp.getOrCreatePieceDownload(pieceIndex)
+	copy(pd.received, received)
+}
+
+// CompletePiece is called once the piece is verified
+func (pp *PiecePicker) CompletePiece(pieceIndex uint32) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	delete(pp.inProgress, pieceIndex)
+}
+
+// ResetPiece is called if the piece fails the hash check, so that it is downloaded again
+func (pp *PiecePicker) ResetPiece(pieceIndex uint32) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	logs.picker.Debug("resetting piece in piece picker", "piece", pieceIndex)
+	delete(pp.inProgress, pieceIndex)
+}
+
+// BlockSenders the IP address each block of the piece was received from, "" if unknown; nil if the piece is not
+// in progress
+func (pp *PiecePicker) BlockSenders(pieceIndex uint32) []string {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	pd, ok := pp.inProgress[pieceIndex]
+	if !ok {
+		return nil
+	}
+	return append([]string(nil), pd.receivedFrom...)
+}
+
+// RestrictPiece has the piece downloaded from a single peer: `peerIdStr`, or the first peer picking a block of it if ""
+func (pp *PiecePicker) RestrictPiece(pieceIndex uint32, peerIdStr string) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	pd := pp.getOrCreatePieceDownload(pieceIndex)
+	pd.restricted = true
+	pd.soleSource = peerIdStr
+}
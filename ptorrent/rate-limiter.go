@@ -0,0 +1,145 @@
+package ptorrent
+
+import (
+	"sync"
+	"time"
+)
+
+/** TOC
+- RATE LIMITER
+	- NewRateLimiter, SetRate, Rate
+	- charge, delay
+- BANDWIDTH
+	- bandwidthLimiters: charge, delay, wait
+*/
+
+/*
+- What is it supposed to do
+- - A token bucket per direction limits the bytes per second, one for the client (across all torrents) and one for
+    each session. A rate of 0 is unlimited. Rates can be changed at any time; waiters pick up the new rate within
+    `maxBandwidthWait`.
+- - Every byte on the wire is charged to the buckets at the framing layer, once it is read or written. Charging
+    never blocks: a bucket may go into debt.
+- - Only piece payloads wait for a bucket to pay off its debt, before the block is read off the wire or sent. Protocol
+    messages (requests, haves, chokes, ...) are never held back; their bytes delay the next payload instead.
+- - The bucket holds at most one second worth of bytes, so that an idle connection can not burst past the rate.
+*/
+
+const maxBandwidthWait = time.Millisecond * 250 // re-checks the rates while waiting, in case they were changed
+
+/************************************** RATE LIMITER **************************************/
+
+// RateLimiter a token bucket, in bytes; safe for concurrent use
+type RateLimiter struct {
+	mu         sync.Mutex
+	rate       int64   // bytes per second, 0 for unlimited
+	tokens     float64 // negative while in debt
+	lastRefill time.Time
+}
+
+// NewRateLimiter the bucket starts full; a rate of 0 is unlimited
+func NewRateLimiter(rate int64) *RateLimiter {
+	rl := &RateLimiter{}
+	rl.SetRate(rate)
+	return rl
+}
+
+// SetRate takes effect immediately; the debt of the bucket is kept, the tokens above the new rate are dropped
+func (rl *RateLimiter) SetRate(rate int64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rate = max(rate, 0)
+	if rl.rate == 0 {
+		rl.tokens = float64(rate)
+	}
+	rl.refill()
+	rl.rate = rate
+	rl.tokens = min(rl.tokens, float64(rate))
+}
+
+// Rate bytes per second, 0 for unlimited
+func (rl *RateLimiter) Rate() int64 {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.rate
+}
+
+// charge takes the bytes out of the bucket, going into debt if needed
+func (rl *RateLimiter) charge(n int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.rate == 0 {
+		return
+	}
+	rl.refill()
+	rl.tokens -= float64(n)
+}
+
+// delay till the debt of the bucket is paid off, 0 if it is not in debt
+func (rl *RateLimiter) delay() time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.rate == 0 {
+		return 0
+	}
+	rl.refill()
+	if rl.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-rl.tokens / float64(rl.rate) * float64(time.Second))
+}
+
+// refill must be called with the mutex held
+func (rl *RateLimiter) refill() {
+	now := time.Now()
+	if rl.rate > 0 && !rl.lastRefill.IsZero() {
+		elapsed := now.Sub(rl.lastRefill).Seconds()
+		rl.tokens = min(rl.tokens+elapsed*float64(rl.rate), float64(rl.rate))
+	}
+	rl.lastRefill = now
+}
+
+/************************************** BANDWIDTH **************************************/
+
+// bandwidthLimiters the buckets the bytes of one direction of a connection are charged to: the session's and the
+// client's. Nil limiters are skipped.
+type bandwidthLimiters []*RateLimiter
+
+func (bl bandwidthLimiters) charge(n int) {
+	for _, limiter := range bl {
+		if limiter != nil {
+			limiter.charge(n)
+		}
+	}
+}
+
+// delay till every bucket has paid off its debt
+func (bl bandwidthLimiters) delay() time.Duration {
+	var delay time.Duration
+	for _, limiter := range bl {
+		if limiter != nil {
+			delay = max(delay, limiter.delay())
+		}
+	}
+	return delay
+}
+
+// wait blocks till every bucket has paid off its debt; returns false if `done` is closed first
+func (bl bandwidthLimiters) wait(done <-chan struct{}) bool {
+	for {
+		delay := bl.delay()
+		if delay == 0 {
+			return true
+		}
+		timer := time.NewTimer(min(delay, maxBandwidthWait))
+		select {
+		case <-done:
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+}
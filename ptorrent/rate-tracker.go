@@ -1,8 +1,9 @@
-package main
+package ptorrent
 
 import (
-	"log"
+	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -34,6 +35,13 @@ type RateTracker struct {
 	totalDownloadSpeed float64
 	totalUploadSpeed   float64
 
+	// bytes since the session started, handshakes and message headers included; payload is the blocks of 'piece'
+	// messages
+	downloadedTotal   atomic.Int64
+	uploadedTotal     atomic.Int64
+	downloadedPayload atomic.Int64
+	uploadedPayload   atomic.Int64
+
 	rateTrackerTicker *time.Ticker
 }
 
@@ -71,14 +79,21 @@ func (rt *RateTracker) SetRateTrackerTicker() {
 
 func (rt *RateTracker) StopRateTrackerTicker() {
 	if rt.rateTrackerTicker == nil {
-		log.Printf("rate tracker ticker is already stopped")
+		logs.choker.Debug("rate tracker ticker is already stopped")
 		return
 	}
 	rt.rateTrackerTicker.Stop()
 }
 
-func (rt *RateTracker) StartTotalSpeedCalculator() {
-	for range rt.rateTrackerTicker.C {
+// StartTotalSpeedCalculator Meant to be run as a goroutine, till the context is cancelled
+func (rt *RateTracker) StartTotalSpeedCalculator(ctx context.Context) {
+	defer rt.StopRateTrackerTicker()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-rt.rateTrackerTicker.C:
+		}
 		rt.muUpload.Lock()
 		rt.muDownload.Lock()
 
@@ -114,8 +129,7 @@ func (rt *RateTracker) StartTotalSpeedCalculator() {
 			rt.totalUploadSpeed += speed
 		})
 
-		log.Printf("total upload speed now is %f bytes/sec", rt.totalUploadSpeed)
-		log.Printf("total download speed now is %f bytes/sec", rt.totalDownloadSpeed)
+		logs.choker.Debug("total speeds", "upload", rt.totalUploadSpeed, "download", rt.totalDownloadSpeed)
 
 		rt.muDownload.Unlock()
 		rt.muUpload.Unlock()
@@ -151,6 +165,7 @@ func (rt *RateTracker) RemoveConnection(peerId string) {
 }
 
 func (rt *RateTracker) RecordDownload(peerId string, bytes int) {
+	rt.downloadedTotal.Add(int64(bytes))
 	rt.muDownload.Lock()
 	defer rt.muDownload.Unlock()
 
@@ -165,6 +180,7 @@ func (rt *RateTracker) RecordDownload(peerId string, bytes int) {
 }
 
 func (rt *RateTracker) RecordUpload(peerId string, bytes int) {
+	rt.uploadedTotal.Add(int64(bytes))
 	rt.muUpload.Lock()
 	defer rt.muUpload.Unlock()
 
@@ -178,6 +194,18 @@ func (rt *RateTracker) RecordUpload(peerId string, bytes int) {
 	rt.calculateUploadSpeed(peerId)
 }
 
+// RecordPayload counts the block bytes of a message already recorded with RecordDownload or RecordUpload
+func (rt *RateTracker) RecordPayload(downloaded int, uploaded int) {
+	rt.downloadedPayload.Add(int64(downloaded))
+	rt.uploadedPayload.Add(int64(uploaded))
+}
+
+// GetTransferredBytes returns the payload and the protocol bytes, downloaded and uploaded
+func (rt *RateTracker) GetTransferredBytes() (payloadDown int64, protocolDown int64, payloadUp int64, protocolUp int64) {
+	payloadDown, payloadUp = rt.downloadedPayload.Load(), rt.uploadedPayload.Load()
+	return payloadDown, rt.downloadedTotal.Load() - payloadDown, payloadUp, rt.uploadedTotal.Load() - payloadUp
+}
+
 func (rt *RateTracker) GetDownloadSpeed(peerId string) float64 {
 	rt.muDownload.RLock()
 	defer rt.muDownload.RUnlock()
@@ -197,7 +225,8 @@ func (rt *RateTracker) GetUploadSpeed(peerId string) float64 {
 func (rt *RateTracker) calculateDownloadSpeed(peerId string) {
 	peerLastDownloadTime, exists := rt.lastDownloadTime.Get(peerId)
 	if !exists {
-		log.Fatalf("[fatal] flaw in logic while calculating rate")
+		// nothing recorded for the peer yet, the caller creates the entry first
+		return
 	}
 	currTime := time.Now()
 	duration := currTime.Sub(peerLastDownloadTime)
@@ -215,7 +244,8 @@ func (rt *RateTracker) calculateDownloadSpeed(peerId string) {
 func (rt *RateTracker) calculateUploadSpeed(peerId string) {
 	peerLastUploadTime, exists := rt.lastUploadTime.Get(peerId)
 	if !exists {
-		log.Fatalf("[fatal] flaw in logic while calculating rate")
+		// nothing recorded for the peer yet, the caller creates the entry first
+		return
 	}
 	currTime := time.Now()
 	duration := currTime.Sub(peerLastUploadTime)
@@ -0,0 +1,199 @@
+package ptorrent
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/** TOC
+- TORRENT FILE SYSTEM
+	- markPieceVerified
+	- piecesToRecheck
+	- Recheck (parallel hashing)
+- SESSION
+	- RecheckExistingData
+*/
+
+/*
+- What is it supposed to do
+- - Data already present in the download directory (e.g. copied from another machine) is hash-checked
+    before the download starts, instead of being downloaded again.
+- - Only pieces overlapping a file that had data before it was opened are checked, unless the resume
+    file already vouches for that file.
+- - Pieces are hashed in parallel by `recheckWorkers` goroutines; progress is reported through a callback,
+    and the recheck stops as soon as the context is cancelled.
+*/
+
+type RecheckProgress struct {
+	Checked  int64 // pieces hashed so far
+	Verified int64 // pieces that matched the expected hash
+	Total    int64 // pieces to hash
+}
+
+/************************************** TORRENT FILE SYSTEM **************************************/
+
+// markPieceVerified the caller must hold the piece mutex
+func (tfs *TorrentFileSystem) markPieceVerified(pieceIndex int64) {
+	piece := tfs.pieces[pieceIndex]
+	piece.complete = true
+	piece.numBlocksCompleted = piece.numBlocksInPiece
+	for blockIndex := range piece.hasBlock {
+		piece.hasBlock[blockIndex] = true
+	}
+
+	tfs.mu.Lock()
+	newlyVerified := !tfs.hasPiece[pieceIndex]
+	if newlyVerified {
+		tfs.hasPiece[pieceIndex] = true
+		tfs.numPiecesObtained++
+		if tfs.IsPieceWanted(pieceIndex) {
+			tfs.numWantedPiecesMissing--
+		}
+	}
+	tfs.mu.Unlock()
+
+	if newlyVerified {
+		tfs.onPieceVerified(pieceIndex)
+	}
+}
+
+// piecesToRecheck returns the pieces not verified yet, that overlap a wanted file flagged for recheck
+func (tfs *TorrentFileSystem) piecesToRecheck(all bool) []int64 {
+	var pieceIndices []int64
+	seen := make(map[int64]struct{})
+	for _, file := range tfs.files {
+		if (!all && !file.needsRecheck) || file.length == 0 || file.priority == PrioritySkip {
+			continue
+		}
+
+		firstPiece := file.startingOffset / tfs.pieceLength
+		lastPiece := (file.startingOffset + file.length - 1) / tfs.pieceLength
+		for pieceIndex := firstPiece; pieceIndex <= lastPiece; pieceIndex++ {
+			if _, ok := seen[pieceIndex]; ok || tfs.HasPiece(pieceIndex) {
+				continue
+			}
+			seen[pieceIndex] = struct{}{}
+			pieceIndices = append(pieceIndices, pieceIndex)
+		}
+	}
+	return pieceIndices
+}
+
+// Recheck hashes the given pieces with `workers` goroutines, and marks the matching ones as verified.
+// Returns the verified pieces; on cancellation, the pieces verified so far are returned along with the context error.
+func (tfs *TorrentFileSystem) Recheck(ctx context.Context, pieceIndices []int64, workers int, progress func(RecheckProgress)) ([]int64, error) {
+	workers = max(workers, 1)
+	total := int64(len(pieceIndices))
+
+	var checked, verified atomic.Int64
+	var verifiedMutex sync.Mutex
+	var verifiedPieces []int64
+
+	jobs := make(chan int64)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pieceIndex := range jobs {
+				if tfs.recheckPiece(pieceIndex) {
+					verified.Add(1)
+					verifiedMutex.Lock()
+					verifiedPieces = append(verifiedPieces, pieceIndex)
+					verifiedMutex.Unlock()
+				}
+				checked.Add(1)
+			}
+		}()
+	}
+
+	// reports progress periodically, and once more at the end
+	report := func() {
+		if progress != nil {
+			progress(RecheckProgress{Checked: checked.Load(), Verified: verified.Load(), Total: total})
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				report()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var err error
+feed:
+	for _, pieceIndex := range pieceIndices {
+		select {
+		case jobs <- pieceIndex:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(done)
+	report()
+
+	return verifiedPieces, err
+}
+
+// recheckPiece reads the piece back from disk, returns true if it matches the expected hash
+func (tfs *TorrentFileSystem) recheckPiece(pieceIndex int64) bool {
+	tfs.pieceMutexes[pieceIndex].Lock()
+	defer tfs.pieceMutexes[pieceIndex].Unlock()
+
+	_, piece, err := tfs.readPieceForValidation(pieceIndex)
+	if err != nil {
+		logs.disk.Warn("error reading piece for recheck", "piece", pieceIndex, "err", err)
+		return false
+	}
+	if !verifySHA1(piece, tfs.pieces[pieceIndex].expectedHash) {
+		return false
+	}
+	tfs.markPieceVerified(pieceIndex)
+	return true
+}
+
+/************************************** SESSION **************************************/
+
+// RecheckExistingData hash-checks the data already on disk, and updates the local bitfield and the state.
+// If `all` is set, every piece not verified yet is checked, not only those in files flagged for recheck.
+// Must be called after the torrent file system, state and resume data are set up, and before connecting to any peer.
+func (ts *TorrentSession) RecheckExistingData(ctx context.Context, all bool, progress func(RecheckProgress)) error {
+	pieceIndices := ts.fileSystem.piecesToRecheck(all)
+	if len(pieceIndices) == 0 {
+		return nil
+	}
+	ts.logs.disk.Info("rechecking existing data", "pieces", len(pieceIndices), "workers", ts.configurable.recheckWorkers)
+
+	ts.rechecking.Store(true)
+	defer ts.rechecking.Store(false)
+
+	verifiedPieces, err := ts.fileSystem.Recheck(ctx, pieceIndices, ts.configurable.recheckWorkers, progress)
+	for _, pieceIndex := range verifiedPieces {
+		if setErr := ts.bitfield.SetBit(uint(pieceIndex)); setErr != nil {
+			return setErr
+		}
+		ts.piecePicker.CompletePiece(uint32(pieceIndex))
+		ts.updateState(Left, ts.fileSystem.pieces[pieceIndex].length)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, file := range ts.fileSystem.files {
+		file.needsRecheck = false
+	}
+	ts.logs.disk.Info("recheck done", "verified", len(verifiedPieces), "pieces", len(pieceIndices))
+	return nil
+}
@@ -0,0 +1,251 @@
+package ptorrent
+
+/** TOC
+- PENDING REQUESTS
+	- addPendingRequest, removePendingRequest, clearPendingRequests
+- DOWNLOAD
+	- fillRequestPipeline, refillAllRequestPipelines
+	- handlePieceMessage
+	- handleChokeMessage, handleUnchokeMessage
+- UPLOAD
+	- handleRequestMessage, handleCancelMessage
+	- serveUploadQueue
+*/
+
+const DefaultMaxPipelineDepth = 16
+
+/****************************** PENDING REQUESTS ******************************/
+
+func (pc *PeerConnection) addPendingRequest(request *BlockRequest) {
+	pc.requestsMutex.Lock()
+	defer pc.requestsMutex.Unlock()
+
+	pc.pendingRequests[blockKey(request.index, request.begin)] = request
+}
+
+// removePendingRequest returns false if the request was never sent or was already answered
+func (pc *PeerConnection) removePendingRequest(index uint32, begin uint32) bool {
+	pc.requestsMutex.Lock()
+	defer pc.requestsMutex.Unlock()
+
+	key := blockKey(index, begin)
+	if _, ok := pc.pendingRequests[key]; !ok {
+		return false
+	}
+	delete(pc.pendingRequests, key)
+	return true
+}
+
+func (pc *PeerConnection) clearPendingRequests() {
+	pc.requestsMutex.Lock()
+	defer pc.requestsMutex.Unlock()
+
+	clear(pc.pendingRequests)
+}
+
+func (pc *PeerConnection) numPendingRequests() int {
+	pc.requestsMutex.Lock()
+	defer pc.requestsMutex.Unlock()
+
+	return len(pc.pendingRequests)
+}
+
+/****************************** DOWNLOAD ******************************/
+
+// fillRequestPipeline requests blocks from the peer, until `maxPipelineDepth` requests are pending, or one if the
+// peer snubbed us.
+// While the peer chokes us, only pieces from its allowed fast set are requested.
+// Nothing is requested while the disk queue is full; the download rate is limited by reading the blocks off the wire.
+func (pc *PeerConnection) fillRequestPipeline(session *TorrentSession) {
+	if session.fileSystem == nil || session.diskIO == nil || session.diskIO.Saturated() {
+		return
+	}
+	if session.paused.Load() {
+		return
+	}
+
+	pc.stateMutex.RLock()
+	peerChoking := pc.peerChoking
+	pc.stateMutex.RUnlock()
+
+	var allowed func(pieceIndex uint32) bool
+	if peerChoking {
+		if !pc.hasAllowedFastOutgoing() {
+			return
+		}
+		allowed = pc.isAllowedFastOutgoing
+	}
+
+	slots := pc.pipelineDepth(session) - pc.numPendingRequests()
+	if slots <= 0 {
+		return
+	}
+
+	pc.piecesMutex.RLock()
+	peerBitfield := pc.piecesBitfield
+	pc.piecesMutex.RUnlock()
+
+	requests := session.piecePicker.PickBlocks(pc.peerIdStr, peerBitfield, pc.getSuggestedPieces(), allowed, slots)
+	for _, request := range requests {
+		pc.addPendingRequest(request)
+		// also called from the peer writer, which can not wait for room in its own channel
+		pc.queueWithoutBlocking(NewRequestMessage(request.index, request.begin, request.length))
+	}
+	if len(requests) > 0 {
+		pc.logs.picker.Debug("requested blocks", "blocks", len(requests))
+	}
+}
+
+// refillAllRequestPipelines e.g. once the disk queue drains; each peer writer refills its own pipeline, so that the
+// caller, e.g. a disk worker, never waits for a slow peer
+func (ts *TorrentSession) refillAllRequestPipelines() {
+	ts.connectedPeers.ReadOnlyIterate(func(peerIdStr string, connection *PeerConnection) bool {
+		connection.requestRefill()
+		return true
+	})
+}
+
+func (pc *PeerConnection) handlePieceMessage(piece *PieceResponse, session *TorrentSession) {
+	if !pc.removePendingRequest(piece.index, piece.begin) {
+		pc.logs.peer.Debug("unrequested block received, discarding", "block", piece)
+		return
+	}
+	pc.onBlockReceived()
+	if session.diskIO == nil {
+		return
+	}
+
+	if err := session.diskIO.BufferBlock(piece.index, piece.begin, piece.block); err != nil {
+		pc.logs.disk.Warn("error buffering block", "err", err)
+		session.piecePicker.ReleaseBlock(piece.index, piece.begin, pc.peerIdStr)
+		pc.fillRequestPipeline(session)
+		return
+	}
+	session.updateState(Downloaded, int64(len(piece.block)))
+
+	// the piece is hashed and written by a disk worker, which completes or resets it in the piece picker
+	if allBlocksReceived := session.piecePicker.MarkBlockReceived(piece.index, piece.begin, pc.peer.IP.String()); allBlocksReceived {
+		session.diskIO.SubmitPiece(piece.index)
+	}
+	pc.fillRequestPipeline(session)
+}
+
+func (pc *PeerConnection) handleChokeMessage(session *TorrentSession) {
+	pc.stateMutex.Lock()
+	pc.peerChoking = true
+	pc.stateMutex.Unlock()
+
+	// with the fast extension, pending requests are explicitly rejected by the peer instead
+	if !pc.supportsFastExtension {
+		pc.clearPendingRequests()
+		session.piecePicker.ReleasePeer(pc.peerIdStr)
+	}
+}
+
+func (pc *PeerConnection) handleUnchokeMessage(session *TorrentSession) {
+	pc.stateMutex.Lock()
+	pc.peerChoking = false
+	pc.stateMutex.Unlock()
+
+	pc.fillRequestPipeline(session)
+}
+
+func (ts *TorrentSession) onPieceComplete(pieceIndex uint32) {
+	ts.logs.disk.Debug("piece downloaded and verified", "piece", pieceIndex)
+	ts.completionMu.Lock()
+	err := ts.bitfield.SetBit(uint(pieceIndex))
+	completed := err == nil && ts.fileSystem.WantedComplete() && ts.hasWantedPieces()
+	ts.completionMu.Unlock()
+	if err != nil {
+		ts.logs.disk.Error("can not mark the piece as downloaded", "piece", pieceIndex, "error", err)
+		return
+	}
+	ts.piecePicker.CompletePiece(pieceIndex)
+	ts.BroadcastMessage(NewHaveMessage(pieceIndex))
+
+	ts.client.publish(Event{Type: EventPieceCompleted, InfoHash: ts.torrent.InfoHash, Name: ts.torrent.Info.Name, PieceIndex: pieceIndex})
+	if completed {
+		ts.logs.client.Info("every wanted piece is downloaded and verified", "torrent", ts.torrent.Info.Name)
+		ts.client.publish(Event{Type: EventTorrentCompleted, InfoHash: ts.torrent.InfoHash, Name: ts.torrent.Info.Name})
+	}
+}
+
+// hasWantedPieces the file system counts a piece once written, before its disk worker marks it downloaded: the
+// completion is only published by the worker marking the last wanted piece
+func (ts *TorrentSession) hasWantedPieces() bool {
+	for pieceIndex := int64(0); pieceIndex < ts.fileSystem.numPieces; pieceIndex++ {
+		if ts.fileSystem.IsPieceWanted(pieceIndex) && ts.bitfield.GetBit(uint(pieceIndex)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+/****************************** UPLOAD ******************************/
+
+// handleRequestMessage queues the request for upload, or rejects it if we are choking the peer and the
+// piece is not in its allowed fast set. Rejections are only sent to peers supporting the fast extension.
+func (pc *PeerConnection) handleRequestMessage(request *BlockRequest, session *TorrentSession) {
+	if session.fileSystem == nil || uint(request.index) >= session.bitfield.Size() || session.bitfield.GetBit(uint(request.index)) == 0 {
+		pc.rejectRequest(request, "piece not available")
+		return
+	}
+
+	pc.stateMutex.RLock()
+	amChoking := pc.amChoking
+	pc.stateMutex.RUnlock()
+
+	if amChoking && !pc.isAllowedFastIncoming(request.index) {
+		pc.rejectRequest(request, "peer is choked")
+		return
+	}
+
+	if !pc.uploadQueue.Push(request) {
+		pc.rejectRequest(request, "upload queue is full")
+	}
+}
+
+func (pc *PeerConnection) handleCancelMessage(cancel *CancelRequest) {
+	removed := pc.uploadQueue.Remove(cancel.index, cancel.begin, cancel.length)
+
+	// with the fast extension, every request is answered with either a 'piece' or a 'reject request'
+	if removed && pc.supportsFastExtension {
+		pc.queueMessage(NewRejectRequestMessage(cancel.index, cancel.begin, cancel.length))
+	}
+}
+
+func (pc *PeerConnection) rejectRequest(request *BlockRequest, reason string) {
+	if !pc.supportsFastExtension {
+		pc.logs.choker.Debug("dropping request", "reason", reason, "request", request)
+		return
+	}
+	pc.logs.choker.Debug("rejecting request", "reason", reason, "request", request)
+	pc.queueMessage(NewRejectRequestMessage(request.index, request.begin, request.length))
+}
+
+// serveUploadQueue Meant to be called from the peer writer goroutine
+func (pc *PeerConnection) serveUploadQueue(session *TorrentSession) {
+	for request := pc.uploadQueue.Pop(); request != nil; request = pc.uploadQueue.Pop() {
+		// queued protocol messages go first, then the block waits for the upload limiters to pay off their debt
+		if !pc.writeQueuedMessages(session) || !pc.waitForUploadBandwidth(session) {
+			return
+		}
+		_, block, err := session.fileSystem.ReadBlock(int64(request.index), int64(request.begin), int64(request.length))
+		if err != nil {
+			pc.logs.disk.Warn("error reading requested block", "err", err)
+			if pc.supportsFastExtension {
+				_, err = pc.SendRejectRequest(request.index, request.begin, request.length, session)
+				if pc.errorHandler(err, session, nil, Writing) {
+					return
+				}
+			}
+			continue
+		}
+
+		_, err = pc.SendPiece(request.index, request.begin, block, session)
+		if pc.errorHandler(err, session, nil, Writing) {
+			return
+		}
+		session.updateState(Uploaded, int64(len(block)))
+	}
+}
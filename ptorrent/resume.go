@@ -0,0 +1,510 @@
+package ptorrent
+
+import (
+	bencodingParser "bittorrent-client/bencoding-parser"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/** TOC
+- RESUME DATA
+	- struct definition
+	- serializer
+	- parser
+	- read/write resume file
+- TORRENT FILE SYSTEM
+	- snapshotPieces
+	- fileInfos
+	- restorePieces
+- SESSION
+	- BuildResumeData, SaveResumeData, LoadResumeData
+	- ResumeWriter (goroutine)
+*/
+
+const ResumeFileSuffix = ".resume"
+const resumeFileVersion = 1
+
+const (
+	resumeVersionKey    = "version"
+	resumeInfoHashKey   = "info-hash"
+	resumePiecesKey     = "pieces"
+	resumePartialKey    = "partial"
+	resumeIndexKey      = "index"
+	resumeBlocksKey     = "blocks"
+	resumeUploadedKey   = "uploaded"
+	resumeDownloadedKey = "downloaded"
+	resumePeersKey      = "peers"
+	resumeFilesKey      = "files"
+	resumeLengthKey     = "length"
+	resumeModTimeKey    = "mtime"
+	resumeBannedKey     = "banned"
+)
+
+/************************************** RESUME DATA **************************************/
+
+// ResumeFileInfo size and modification time of a file on disk, used to spot-check that it was not modified
+type ResumeFileInfo struct {
+	Length  int64
+	ModTime int64 // unix nanoseconds
+}
+
+type ResumeData struct {
+	InfoHash      [20]byte
+	Pieces        *Bitset            // verified pieces
+	PartialPieces map[uint32]*Bitset // piece index -> blocks written, for pieces not yet complete
+	Uploaded      int64
+	Downloaded    int64
+	Peers         []Peer // known peers, IPv4 only
+	Files         []ResumeFileInfo
+	Banned        []string // IP addresses of the peers banned for sending corrupt pieces
+}
+
+// resumeFilePath the resume file is stored next to the download directory
+func resumeFilePath(baseDir string) string {
+	return filepath.Clean(baseDir) + ResumeFileSuffix
+}
+
+func (rd *ResumeData) Serialize() ([]byte, error) {
+	partialList := bencodingParser.NewBencodeList()
+	for pieceIndex, blocks := range rd.PartialPieces {
+		partialList.Add(bencodingParser.NewSortedBencodeDict(map[string]*bencodingParser.Bencode{
+			resumeIndexKey:  bencodingParser.NewBencodeFromInt64(int64(pieceIndex)),
+			resumeBlocksKey: bencodingParser.NewBencodeFromString(string(blocks.Serialize())),
+		}))
+	}
+
+	// same format as the compact peer list of a tracker response
+	compactPeers := make([]byte, 0, 6*len(rd.Peers))
+	for _, peer := range rd.Peers {
+		if ipv4 := peer.IP.To4(); ipv4 != nil {
+			compactPeers = append(compactPeers, ipv4...)
+			compactPeers = binary.BigEndian.AppendUint16(compactPeers, peer.Port)
+		}
+	}
+
+	filesList := bencodingParser.NewBencodeList()
+	for _, file := range rd.Files {
+		filesList.Add(bencodingParser.NewSortedBencodeDict(map[string]*bencodingParser.Bencode{
+			resumeLengthKey:  bencodingParser.NewBencodeFromInt64(file.Length),
+			resumeModTimeKey: bencodingParser.NewBencodeFromInt64(file.ModTime),
+		}))
+	}
+
+	bannedList := bencodingParser.NewBencodeList()
+	for _, address := range rd.Banned {
+		bannedList.Add(bencodingParser.NewBencodeFromString(address))
+	}
+
+	resume := bencodingParser.NewSortedBencodeDict(map[string]*bencodingParser.Bencode{
+		resumeVersionKey:    bencodingParser.NewBencodeFromInt64(resumeFileVersion),
+		resumeInfoHashKey:   bencodingParser.NewBencodeFromString(string(rd.InfoHash[:])),
+		resumePiecesKey:     bencodingParser.NewBencodeFromString(string(rd.Pieces.Serialize())),
+		resumePartialKey:    bencodingParser.NewBencodeFromBList(partialList),
+		resumeUploadedKey:   bencodingParser.NewBencodeFromInt64(rd.Uploaded),
+		resumeDownloadedKey: bencodingParser.NewBencodeFromInt64(rd.Downloaded),
+		resumePeersKey:      bencodingParser.NewBencodeFromString(string(compactPeers)),
+		resumeFilesKey:      bencodingParser.NewBencodeFromBList(filesList),
+		resumeBannedKey:     bencodingParser.NewBencodeFromBList(bannedList),
+	})
+	return bencodingParser.SerializeBencode(resume)
+}
+
+func getResumeInt(resumeDict *bencodingParser.BencodeDict, key string) (int64, error) {
+	value, exists := resumeDict.Get(key)
+	if !exists || value.BInt == nil {
+		return 0, fmt.Errorf("resume file: expected integer key '%s' but not found", key)
+	}
+	return int64(*value.BInt), nil
+}
+
+func getResumeString(resumeDict *bencodingParser.BencodeDict, key string) ([]byte, error) {
+	value, exists := resumeDict.Get(key)
+	if !exists || value.BString == nil {
+		return nil, fmt.Errorf("resume file: expected string key '%s' but not found", key)
+	}
+	return []byte(*value.BString), nil
+}
+
+func getResumeList(resumeDict *bencodingParser.BencodeDict, key string) (bencodingParser.BencodeList, error) {
+	value, exists := resumeDict.Get(key)
+	if !exists || value.BList == nil {
+		return nil, fmt.Errorf("resume file: expected list key '%s' but not found", key)
+	}
+	return *value.BList, nil
+}
+
+// ParseResumeData parses and validates a resume file against the torrent
+func ParseResumeData(data []byte, torrent *Torrent) (*ResumeData, error) {
+	resumeBencode, err := bencodingParser.ParseBencodeFromByteSlice(data)
+	if err != nil || resumeBencode.BDict == nil {
+		return nil, fmt.Errorf("error parsing resume file: %v", err)
+	}
+	resumeDict := resumeBencode.BDict
+	rd := &ResumeData{PartialPieces: make(map[uint32]*Bitset)}
+
+	if version, err := getResumeInt(resumeDict, resumeVersionKey); err != nil || version != resumeFileVersion {
+		return nil, fmt.Errorf("unsupported resume file version")
+	}
+
+	infoHash, err := getResumeString(resumeDict, resumeInfoHashKey)
+	if err != nil {
+		return nil, err
+	}
+	copy(rd.InfoHash[:], infoHash)
+	if rd.InfoHash != torrent.InfoHash {
+		return nil, fmt.Errorf("resume file belongs to a different torrent: info-hash %x", rd.InfoHash)
+	}
+
+	pieces, err := getResumeString(resumeDict, resumePiecesKey)
+	if err != nil {
+		return nil, err
+	}
+	if rd.Pieces, err = ParseAndValidateBitset(pieces, torrent.Info.NumPieces); err != nil {
+		return nil, err
+	}
+
+	partialList, err := getResumeList(resumeDict, resumePartialKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, partialBencode := range partialList {
+		if partialBencode.BDict == nil {
+			return nil, fmt.Errorf("resume file: expected dictionary in '%s'", resumePartialKey)
+		}
+		pieceIndex, err := getResumeInt(partialBencode.BDict, resumeIndexKey)
+		if err != nil {
+			return nil, err
+		}
+		if pieceIndex < 0 || pieceIndex >= int64(torrent.Info.NumPieces) {
+			return nil, ErrOutOfRange("resume file: partial piece index")
+		}
+		blocks, err := getResumeString(partialBencode.BDict, resumeBlocksKey)
+		if err != nil {
+			return nil, err
+		}
+		numBlocks := ceilDiv(lengthOfPieceInTorrent(torrent, pieceIndex), BlockSize)
+		blocksBitset, err := ParseAndValidateBitset(blocks, uint(numBlocks))
+		if err != nil {
+			return nil, err
+		}
+		rd.PartialPieces[uint32(pieceIndex)] = blocksBitset
+	}
+
+	if rd.Uploaded, err = getResumeInt(resumeDict, resumeUploadedKey); err != nil {
+		return nil, err
+	}
+	if rd.Downloaded, err = getResumeInt(resumeDict, resumeDownloadedKey); err != nil {
+		return nil, err
+	}
+
+	peersBencode, exists := resumeDict.Get(resumePeersKey)
+	if exists && peersBencode.BString != nil {
+		if rd.Peers, err = getPeerListFromBencode(peersBencode); err != nil {
+			return nil, err
+		}
+	}
+
+	filesList, err := getResumeList(resumeDict, resumeFilesKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, fileBencode := range filesList {
+		if fileBencode.BDict == nil {
+			return nil, fmt.Errorf("resume file: expected dictionary in '%s'", resumeFilesKey)
+		}
+		length, err := getResumeInt(fileBencode.BDict, resumeLengthKey)
+		if err != nil {
+			return nil, err
+		}
+		modTime, err := getResumeInt(fileBencode.BDict, resumeModTimeKey)
+		if err != nil {
+			return nil, err
+		}
+		rd.Files = append(rd.Files, ResumeFileInfo{Length: length, ModTime: modTime})
+	}
+
+	// absent from resume files written before peers were banned
+	if bannedBencode, exists := resumeDict.Get(resumeBannedKey); exists && bannedBencode.BList != nil {
+		for _, addressBencode := range *bannedBencode.BList {
+			if addressBencode.BString == nil || net.ParseIP(string(*addressBencode.BString)) == nil {
+				return nil, fmt.Errorf("resume file: expected IP addresses in '%s'", resumeBannedKey)
+			}
+			rd.Banned = append(rd.Banned, string(*addressBencode.BString))
+		}
+	}
+	return rd, nil
+}
+
+func lengthOfPieceInTorrent(torrent *Torrent, pieceIndex int64) int64 {
+	if pieceIndex == int64(torrent.Info.NumPieces)-1 {
+		return torrent.Info.Length - torrent.Info.PieceLength*pieceIndex
+	}
+	return torrent.Info.PieceLength
+}
+
+// WriteResumeFile writes to a temporary file and renames it, so that a crash never leaves a truncated resume file
+func WriteResumeFile(path string, rd *ResumeData) error {
+	data, err := rd.Serialize()
+	if err != nil {
+		return err
+	}
+
+	temporaryPath := path + ".tmp"
+	if err = os.WriteFile(temporaryPath, data, 0644); err != nil {
+		return ErrWritingFile(temporaryPath, err)
+	}
+	if err = os.Rename(temporaryPath, path); err != nil {
+		return ErrWritingFile(path, err)
+	}
+	return nil
+}
+
+// ReadResumeFile returns an error wrapping fs.ErrNotExist if there is no resume file
+func ReadResumeFile(path string, torrent *Torrent) (*ResumeData, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseResumeData(data, torrent)
+}
+
+/************************************** TORRENT FILE SYSTEM **************************************/
+
+// snapshotPieces returns the verified pieces, and the written blocks of incomplete pieces
+func (tfs *TorrentFileSystem) snapshotPieces() (*Bitset, map[uint32]*Bitset, error) {
+	pieces := NewBitset(uint(tfs.numPieces))
+	partialPieces := make(map[uint32]*Bitset)
+
+	for pieceIndex := int64(0); pieceIndex < tfs.numPieces; pieceIndex++ {
+		tfs.pieceMutexes[pieceIndex].RLock()
+		piece := tfs.pieces[pieceIndex]
+		var err error
+		if piece.complete {
+			err = pieces.SetBit(uint(pieceIndex))
+		} else if piece.numBlocksCompleted > 0 {
+			blocks := NewBitset(uint(piece.numBlocksInPiece))
+			for blockIndex, hasBlock := range piece.hasBlock {
+				if hasBlock && err == nil {
+					err = blocks.SetBit(uint(blockIndex))
+				}
+			}
+			partialPieces[uint32(pieceIndex)] = blocks
+		}
+		tfs.pieceMutexes[pieceIndex].RUnlock()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return pieces, partialPieces, nil
+}
+
+// fileInfos stats every file of the torrent on disk
+func (tfs *TorrentFileSystem) fileInfos() ([]ResumeFileInfo, error) {
+	infos := make([]ResumeFileInfo, 0, len(tfs.files))
+	for _, file := range tfs.files {
+		// skipped files are not on disk; if one is wanted later, the mismatch discards its pieces
+		if file.priority == PrioritySkip {
+			infos = append(infos, ResumeFileInfo{})
+			continue
+		}
+		// the path changes once a complete file is moved out of staging
+		file.mu.RLock()
+		fileInfo, err := os.Stat(filepath.Join(file.path...))
+		file.mu.RUnlock()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, ResumeFileInfo{Length: fileInfo.Size(), ModTime: fileInfo.ModTime().UnixNano()})
+	}
+	return infos, nil
+}
+
+// discardModifiedFiles spot-checks the files on disk against the resume file, and forgets every piece that
+// overlaps a file whose size or modification time does not match
+func (tfs *TorrentFileSystem) discardModifiedFiles(rd *ResumeData) error {
+	currentInfos, err := tfs.fileInfos()
+	if err != nil {
+		return err
+	}
+	if len(currentInfos) != len(rd.Files) {
+		logs.disk.Warn("resume file lists a different number of files, discarding resume data", "resumeFiles", len(rd.Files), "torrentFiles", len(currentInfos))
+		rd.Pieces.Clear()
+		clear(rd.PartialPieces)
+		return nil
+	}
+
+	for fileIndex, file := range tfs.files {
+		if currentInfos[fileIndex] == rd.Files[fileIndex] {
+			// the resume file already tells which of its pieces are verified
+			file.needsRecheck = false
+			continue
+		}
+		logs.disk.Info("file was modified since the resume file was written, discarding its pieces", "file", filepath.Join(file.path...))
+		if file.length == 0 {
+			continue
+		}
+
+		firstPiece := file.startingOffset / tfs.pieceLength
+		lastPiece := (file.startingOffset + file.length - 1) / tfs.pieceLength
+		for pieceIndex := firstPiece; pieceIndex <= lastPiece; pieceIndex++ {
+			if err = rd.Pieces.ResetBit(uint(pieceIndex)); err != nil {
+				return err
+			}
+			delete(rd.PartialPieces, uint32(pieceIndex))
+		}
+	}
+	return nil
+}
+
+// restorePieces marks pieces and blocks as obtained without reading them back, returns the length of the verified wanted pieces
+func (tfs *TorrentFileSystem) restorePieces(pieces *Bitset, partialPieces map[uint32]*Bitset) int64 {
+	lengthObtained := int64(0)
+	for pieceIndex := int64(0); pieceIndex < tfs.numPieces; pieceIndex++ {
+		piece := tfs.pieces[pieceIndex]
+		tfs.pieceMutexes[pieceIndex].Lock()
+
+		if pieces.GetBit(uint(pieceIndex)) == 1 {
+			tfs.markPieceVerified(pieceIndex)
+			if tfs.IsPieceWanted(pieceIndex) {
+				lengthObtained += piece.length
+			}
+		} else if blocks, ok := partialPieces[uint32(pieceIndex)]; ok {
+			for blockIndex := range piece.hasBlock {
+				if blocks.GetBit(uint(blockIndex)) == 1 && !piece.hasBlock[blockIndex] {
+					piece.hasBlock[blockIndex] = true
+					piece.numBlocksCompleted++
+				}
+			}
+		}
+		tfs.pieceMutexes[pieceIndex].Unlock()
+	}
+	return lengthObtained
+}
+
+/************************************** SESSION **************************************/
+
+func (ts *TorrentSession) BuildResumeData() (*ResumeData, error) {
+	pieces, partialPieces, err := ts.fileSystem.snapshotPieces()
+	if err != nil {
+		return nil, err
+	}
+	files, err := ts.fileSystem.fileInfos()
+	if err != nil {
+		return nil, err
+	}
+
+	var peers []Peer
+	ts.connectedPeers.ReadOnlyIterate(func(peerIdStr string, connection *PeerConnection) bool {
+		if connection.isOutgoing {
+			peers = append(peers, connection.peer)
+		}
+		return true
+	})
+	if ts.trackerClient != nil {
+		if lastResponse := ts.trackerClient.LastResponse(); lastResponse != nil {
+			for _, peer := range lastResponse.Peers {
+				if !ts.client.isSelfAddress(peerAddress(peer)) {
+					peers = append(peers, peer)
+				}
+			}
+		}
+	}
+
+	_, downloaded, uploaded := ts.state.GetState()
+	return &ResumeData{
+		InfoHash:      ts.torrent.InfoHash,
+		Pieces:        pieces,
+		PartialPieces: partialPieces,
+		Uploaded:      uploaded,
+		Downloaded:    downloaded,
+		Peers:         peers,
+		Files:         files,
+		Banned:        ts.bans.Banned(),
+	}, nil
+}
+
+func (ts *TorrentSession) SaveResumeData() error {
+	if ts.fileSystem == nil || ts.state == nil {
+		return ErrNullObject("torrent file system or state not initialized, can not save resume data")
+	}
+	if ts.rechecking.Load() {
+		// the files would be vouched for while only some of their pieces are checked
+		ts.logs.disk.Debug("recheck in progress, not saving resume data")
+		return nil
+	}
+
+	rd, err := ts.BuildResumeData()
+	if err != nil {
+		return err
+	}
+	path := resumeFilePath(ts.fileSystem.baseDir)
+	if err = WriteResumeFile(path, rd); err != nil {
+		return err
+	}
+	ts.logs.disk.Debug("resume data saved", "path", path, "verified", rd.Pieces.CountSetBits(), "partial", len(rd.PartialPieces))
+	return nil
+}
+
+// LoadResumeData restores verified pieces, partial pieces, counters and banned peers from the resume file, if present.
+// Must be called after the torrent file system and state are created, and before connecting to any peer.
+// Returns the known peers from the resume file.
+func (ts *TorrentSession) LoadResumeData() ([]Peer, error) {
+	path := resumeFilePath(ts.fileSystem.baseDir)
+	rd, err := ReadResumeFile(path, ts.torrent)
+	if errors.Is(err, fs.ErrNotExist) {
+		ts.logs.disk.Info("no resume file found, starting from scratch", "path", path)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ts.fileSystem.discardModifiedFiles(rd); err != nil {
+		return nil, err
+	}
+
+	lengthObtained := ts.fileSystem.restorePieces(rd.Pieces, rd.PartialPieces)
+	for pieceIndex := uint(0); pieceIndex < rd.Pieces.Size(); pieceIndex++ {
+		if rd.Pieces.GetBit(pieceIndex) == 1 {
+			if err = ts.bitfield.SetBit(pieceIndex); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for pieceIndex, blocks := range rd.PartialPieces {
+		received := make([]bool, blocks.Size())
+		for blockIndex := range received {
+			received[blockIndex] = blocks.GetBit(uint(blockIndex)) == 1
+		}
+		ts.piecePicker.RestorePartialPiece(pieceIndex, received)
+	}
+	ts.state.RestoreState(ts.fileSystem.WantedLength()-lengthObtained, rd.Downloaded, rd.Uploaded)
+	ts.bans.restore(rd.Banned)
+
+	ts.logs.disk.Info("resumed", "path", path, "verified", rd.Pieces.CountSetBits(), "partial", len(rd.PartialPieces), "knownPeers", len(rd.Peers), "banned", len(rd.Banned))
+	return rd.Peers, nil
+}
+
+// ResumeWriter Meant to be run as a goroutine, saves the resume data periodically
+func (ts *TorrentSession) ResumeWriter(ctx context.Context) {
+	ticker := time.NewTicker(ts.configurable.resumeSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := ts.SaveResumeData(); err != nil {
+			ts.logs.disk.Error("error saving resume data", "err", err)
+		}
+	}
+}
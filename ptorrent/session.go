@@ -0,0 +1,566 @@
+package ptorrent
+
+import (
+	"bittorrent-client/structs"
+	"context"
+	"encoding/hex"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Configurable struct {
+	/* TCP-conn conf */
+	tcpDialTimeout time.Duration
+	listenerPort   uint16
+
+	/* Keep Alive conf*/
+	keepAliveInterval time.Duration
+
+	/* Peer reaper conf */
+	peerTimeout          time.Duration // nothing read from the peer
+	notInterestedTimeout time.Duration // neither side interested
+	snubTimeout          time.Duration // no block received from a peer unchoking us
+
+	/* Ban conf */
+	maxBadPieces int // corrupt pieces a peer is banned after
+
+	/* Request pipeline conf */
+	maxPipelineDepth int
+
+	/* Fast Extension conf */
+	allowedFastSetSize int
+
+	/* Resume conf */
+	resumeSaveInterval time.Duration
+
+	/* Recheck conf */
+	recheckWorkers int
+
+	/* File system conf */
+	maxOpenFiles int
+
+	/* Disk IO conf */
+	diskWorkers     int
+	diskQueueLength int
+
+	/* Shutdown conf */
+	stoppedAnnounceTimeout time.Duration
+}
+
+// TODO: Concurrency Control here??
+
+type TorrentSession struct {
+	torrent         *Torrent      // the parsed torrent
+	configurable    *Configurable // configurations
+	bitfield        *Bitset       // local bitfield
+	rateTracker     *RateTracker  // the rate tracker for torrent session
+	client          *Client       // the client owning the session, with the shared listener and global limits
+	localPeerId     [20]byte      // local peer id
+	trackerClient   *TrackerClient
+	bitfieldManager *BitfieldManager
+	piecePicker     *PiecePicker
+	peerManager     *PeerManager
+	bans            *BanList
+	fileSystem      *TorrentFileSystem
+	diskIO          *DiskIO
+	metrics         *sessionMetrics
+	logs            *subsystemLoggers // tagged with the info-hash
+	downloadLimiter *RateLimiter      // of this torrent only, the client limits every torrent
+	uploadLimiter   *RateLimiter
+
+	connectedPeers *structs.MutexMap[string, *PeerConnection] // dictionary of peer connections, look up using peer id
+	initializeMu   sync.Mutex                                 // a peer is checked for duplicates and added at once
+	unchokedPeers  *structs.MutexMap[string, *PeerConnection] // dictionary of peer connections, that we have unchoked curerently
+	//sentRequests
+
+	quitChannel chan *PeerConnection // A quitter to terminate peer connections
+
+	state *TorrentState
+
+	rechecking   atomic.Bool // resume data is not saved while existing data is being rechecked
+	completionMu sync.Mutex  // a piece is marked downloaded and the completion checked at once
+
+	ctx    context.Context // cancelled once the torrent is removed
+	cancel context.CancelFunc
+	wg     sync.WaitGroup // every goroutine of the session, waited for before the files are closed
+
+	activeMu     sync.Mutex
+	paused       atomic.Bool        // no peers are connected and the tracker is not polled while paused
+	cancelActive context.CancelFunc // stops the tracker polling and the dial queue on pause
+
+	addedAt time.Time
+}
+
+// newTorrentSession a session always belongs to a client, see Client.AddTorrent
+func newTorrentSession(client *Client, torrent *Torrent) (*TorrentSession, error) {
+	selfBitfield := NewBitset(torrent.Info.NumPieces)
+	bitfieldManager := NewBitfieldManager(selfBitfield)
+	piecePicker := NewPiecePicker(torrent, selfBitfield, bitfieldManager)
+
+	connectedPeers := structs.NewMutexMap[string, *PeerConnection]()
+	unchokedPeers := structs.NewMutexMap[string, *PeerConnection]()
+
+	configurable := &Configurable{
+		tcpDialTimeout:    time.Second * 5,
+		listenerPort:      8888,
+		keepAliveInterval: time.Second * 120,
+
+		peerTimeout:          DefaultPeerTimeout,
+		notInterestedTimeout: time.Minute * 5,
+		snubTimeout:          time.Second * 60,
+
+		maxBadPieces: DefaultMaxBadPieces,
+
+		maxPipelineDepth:   DefaultMaxPipelineDepth,
+		allowedFastSetSize: 10,
+
+		resumeSaveInterval: time.Second * 30,
+
+		recheckWorkers: runtime.NumCPU(),
+
+		maxOpenFiles: DefaultMaxOpenFiles,
+
+		diskWorkers:     DefaultDiskWorkers,
+		diskQueueLength: DefaultDiskQueueLength,
+
+		stoppedAnnounceTimeout: time.Second * 5,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	session := &TorrentSession{
+		client:          client,
+		torrent:         torrent,
+		configurable:    configurable,
+		localPeerId:     client.localPeerId,
+		bitfield:        selfBitfield,
+		bitfieldManager: bitfieldManager,
+		piecePicker:     piecePicker,
+		connectedPeers:  connectedPeers,
+		unchokedPeers:   unchokedPeers,
+		quitChannel:     make(chan *PeerConnection, 10),
+		metrics:         &sessionMetrics{},
+		logs:            logs.with("infohash", hex.EncodeToString(torrent.InfoHash[:])),
+		downloadLimiter: NewRateLimiter(0),
+		uploadLimiter:   NewRateLimiter(0),
+		ctx:             ctx,
+		cancel:          cancel,
+	}
+	session.peerManager = NewPeerManager(session, DefaultMaxPeersPerTorrent)
+	session.bans = NewBanList(configurable.maxBadPieces)
+	// sessions start paused, until added to a client and resumed
+	session.paused.Store(true)
+	return session, nil
+}
+
+// SetRateLimits changes the download and upload rates of this torrent, in bytes per second; 0 for unlimited.
+// The limits of the client apply as well.
+func (ts *TorrentSession) SetRateLimits(maxDownloadRate int64, maxUploadRate int64) {
+	ts.downloadLimiter.SetRate(maxDownloadRate)
+	ts.uploadLimiter.SetRate(maxUploadRate)
+}
+
+func (ts *TorrentSession) RateLimits() (maxDownloadRate int64, maxUploadRate int64) {
+	return ts.downloadLimiter.Rate(), ts.uploadLimiter.Rate()
+}
+
+// SetMaxPeers the maximum number of connected peers of this torrent, 0 for unlimited; the client limits the
+// connections across all torrents as well
+func (ts *TorrentSession) SetMaxPeers(maxPeers int) {
+	ts.peerManager.SetMaxPeers(maxPeers)
+}
+
+func (ts *TorrentSession) MaxPeers() int {
+	return ts.peerManager.MaxPeers()
+}
+
+// bandwidthLimiters the limiters of the session, then those of the client
+func (ts *TorrentSession) bandwidthLimiters() (download bandwidthLimiters, upload bandwidthLimiters) {
+	download = bandwidthLimiters{ts.downloadLimiter, ts.client.downloadLimiter}
+	upload = bandwidthLimiters{ts.uploadLimiter, ts.client.uploadLimiter}
+	return
+}
+
+// startGoroutine runs `f` as a goroutine, waited for by Stop
+func (ts *TorrentSession) startGoroutine(f func()) {
+	ts.wg.Add(1)
+	go func() {
+		defer ts.wg.Done()
+		f()
+	}()
+}
+
+// reportQuit hands the connection to the quitter, unless the session is stopped
+func (ts *TorrentSession) reportQuit(peerConnection *PeerConnection) {
+	select {
+	case ts.quitChannel <- peerConnection:
+	case <-ts.ctx.Done():
+	}
+}
+
+// updateState hands the update to the state handler; updates after the session is stopped are dropped
+func (ts *TorrentSession) updateState(requestType StateRequestType, length int64) {
+	select {
+	case ts.state.stateChannel <- MakePair(requestType, length):
+	case <-ts.ctx.Done():
+	}
+}
+
+/* HANDLE PEER CONNECTION */
+
+// InitializePeer returns ErrDuplicatePeer if the peer is connected already, and the tie-break keeps the other
+// connection; otherwise the other connection is closed. See peer-dedup.go
+func (ts *TorrentSession) InitializePeer(peerConnection *PeerConnection) error {
+	ts.initializeMu.Lock()
+	defer ts.initializeMu.Unlock()
+
+	if existing := ts.findDuplicate(peerConnection); existing != nil {
+		if !ts.keepsNewConnection(existing, peerConnection) {
+			return newHandshakeError(HandshakeFailureDuplicate, ErrDuplicatePeer)
+		}
+		existing.logs.peer.Debug("closing duplicate connection", "outgoing", existing.isOutgoing)
+		if ts.RemovePeer(existing) {
+			existing.CloseConnection()
+		}
+		if existing.isOutgoing {
+			ts.peerManager.backOff(existing.peer, duplicatePeerBackoff)
+		}
+	}
+
+	peerConnection.mutex.Lock()
+	defer peerConnection.mutex.Unlock()
+
+	ts.connectedPeers.Put(peerConnection.peerIdStr, peerConnection)
+	ts.bitfieldManager.AddPeerWithoutBitfield(peerConnection.peerIdStr)
+	peerConnection.isActive = true
+	ts.publishPeerEvent(EventPeerConnected, peerConnection)
+	return nil
+}
+
+// RemovePeer returns false if the peer was removed already
+func (ts *TorrentSession) RemovePeer(peerConnection *PeerConnection) bool {
+	peerConnection.mutex.Lock()
+	defer peerConnection.mutex.Unlock()
+
+	if !peerConnection.isActive {
+		return false
+	}
+	ts.connectedPeers.Delete(peerConnection.peerIdStr)
+	ts.bitfieldManager.RemovePeer(peerConnection.peerIdStr)
+	ts.piecePicker.ReleasePeer(peerConnection.peerIdStr)
+	ts.client.releaseConnectionSlot()
+	peerConnection.isActive = false
+	ts.peerManager.peerDisconnected(peerConnection)
+	ts.publishPeerEvent(EventPeerDisconnected, peerConnection)
+	return true
+}
+
+func (ts *TorrentSession) publishPeerEvent(eventType EventType, peerConnection *PeerConnection) {
+	if ts.client == nil {
+		return
+	}
+	ts.client.publish(Event{
+		Type:     eventType,
+		InfoHash: ts.torrent.InfoHash,
+		Name:     ts.torrent.Info.Name,
+		Peer:     peerConnection.tcpConn.RemoteAddr().String(),
+	})
+}
+
+// disconnectAllPeers e.g. on pause
+func (ts *TorrentSession) disconnectAllPeers() {
+	var connections []*PeerConnection
+	ts.connectedPeers.ReadOnlyIterate(func(peerIdStr string, connection *PeerConnection) bool {
+		connections = append(connections, connection)
+		return true
+	})
+	for _, connection := range connections {
+		if ts.RemovePeer(connection) {
+			connection.CloseConnection()
+		}
+	}
+}
+
+/* PAUSE AND RESUME */
+
+// Pause disconnects every peer and stops announcing to the tracker; incoming connections are refused
+func (ts *TorrentSession) Pause() {
+	ts.activeMu.Lock()
+	defer ts.activeMu.Unlock()
+
+	if ts.paused.Swap(true) {
+		return
+	}
+	if ts.cancelActive != nil {
+		ts.cancelActive()
+		ts.cancelActive = nil
+	}
+	ts.disconnectAllPeers()
+	if err := ts.SaveResumeData(); err != nil {
+		ts.logs.disk.Error("error saving resume data", "err", err)
+	}
+	ts.logs.client.Info("paused torrent", "name", ts.torrent.Info.Name)
+	ts.client.publish(Event{Type: EventTorrentPaused, InfoHash: ts.torrent.InfoHash, Name: ts.torrent.Info.Name})
+}
+
+// Resume announces to the tracker, and starts dialing the candidate peers of the peer manager
+func (ts *TorrentSession) Resume() {
+	ts.activeMu.Lock()
+	defer ts.activeMu.Unlock()
+
+	if !ts.paused.Load() {
+		return
+	}
+	ctx, cancel := context.WithCancel(ts.ctx)
+	ts.cancelActive = cancel
+	ts.paused.Store(false)
+
+	ts.startGoroutine(func() { ts.peerManager.run(ctx) })
+	ts.startGoroutine(func() { ts.StartPeerReaper(ctx) })
+	ts.startGoroutine(func() { ts.announce(ctx) })
+	ts.logs.client.Info("resumed torrent", "name", ts.torrent.Info.Name)
+	ts.client.publish(Event{Type: EventTorrentResumed, InfoHash: ts.torrent.InfoHash, Name: ts.torrent.Info.Name})
+}
+
+// announce polls the tracker until the session is paused or removed, then tells the tracker it stopped
+func (ts *TorrentSession) announce(ctx context.Context) {
+	trackerClient := ts.trackerClient
+	// e.g. a torrent added from a magnet link without trackers, its peers come from the link
+	if trackerClient.announce == "" {
+		ts.logs.tracker.Info("no tracker to announce to")
+		return
+	}
+	defer ts.announceStopped()
+
+	left, downloaded, uploaded := ts.state.GetState()
+	trackerResponse, err := trackerClient.GetTrackerResponse(ctx, TrackerEventStarted, uploaded, downloaded, left)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		ts.logs.tracker.Warn("error getting response from tracker", "err", err)
+		return
+	}
+	ts.logs.tracker.Info("announced", "peers", len(trackerResponse.Peers))
+
+	trackerClient.SetTrackerPolling()
+	trackerClient.HandleTrackerResponse(trackerResponse, ts)
+	trackerClient.TrackerPollHandler(ctx, ts)
+}
+
+// announceStopped sends the 'stopped' event, if the tracker was announced to; bounded by `stoppedAnnounceTimeout`
+func (ts *TorrentSession) announceStopped() {
+	if ts.trackerClient.LastResponse() == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ts.configurable.stoppedAnnounceTimeout)
+	defer cancel()
+
+	left, downloaded, uploaded := ts.state.GetState()
+	if err := ts.trackerClient.AnnounceStopped(ctx, uploaded, downloaded, left); err != nil {
+		ts.logs.tracker.Warn("error sending 'stopped' to the tracker", "err", err)
+		return
+	}
+	ts.logs.tracker.Info("sent 'stopped' to the tracker")
+}
+
+/* STATS */
+
+type TorrentStats struct {
+	InfoHash [20]byte
+	Name     string
+
+	TotalLength  int64
+	WantedLength int64 // total length of the pieces of files not skipped
+	Left         int64
+	Downloaded   int64
+	Uploaded     int64
+
+	NumPieces      int64
+	PiecesVerified int64
+	Progress       float64 // fraction of the wanted length downloaded and verified, 0 to 1
+
+	DownloadRate float64 // bytes per second
+	UploadRate   float64 // bytes per second
+	NumPeers     int
+
+	Paused   bool
+	Complete bool // every wanted piece is verified
+	AddedAt  time.Time
+}
+
+type PeerStats struct {
+	PeerId   string // hex
+	Address  string
+	Client   string // the client software, from the peer id; "" if unknown
+	Outgoing bool   // if we dialed the peer
+
+	Progress              float64 // fraction of the pieces the peer has, 0 to 1
+	SupportsFastExtension bool
+	Snubbed               bool // unchoked us, but sends no blocks
+
+	DownloadRate float64 // bytes per second
+	UploadRate   float64 // bytes per second
+
+	AmChoking      bool
+	AmInterested   bool
+	PeerChoking    bool
+	PeerInterested bool
+}
+
+func (ts *TorrentSession) Torrent() *Torrent {
+	return ts.torrent
+}
+
+func (ts *TorrentSession) Stats() TorrentStats {
+	stats := TorrentStats{
+		InfoHash:    ts.torrent.InfoHash,
+		Name:        ts.torrent.Info.Name,
+		TotalLength: ts.torrent.Info.Length,
+		NumPieces:   int64(ts.torrent.Info.NumPieces),
+		NumPeers:    ts.connectedPeers.Size(),
+		Paused:      ts.paused.Load(),
+		AddedAt:     ts.addedAt,
+	}
+	if ts.fileSystem != nil {
+		stats.WantedLength = ts.fileSystem.WantedLength()
+		stats.PiecesVerified = ts.fileSystem.NumPiecesObtained()
+		stats.Complete = ts.fileSystem.WantedComplete()
+	}
+	if ts.state != nil {
+		stats.Left, stats.Downloaded, stats.Uploaded = ts.state.GetState()
+	}
+	if stats.WantedLength > 0 {
+		stats.Progress = float64(stats.WantedLength-stats.Left) / float64(stats.WantedLength)
+	}
+	if ts.rateTracker != nil {
+		stats.DownloadRate = ts.rateTracker.GetTotalDownloadSpeed()
+		stats.UploadRate = ts.rateTracker.GetTotalUploadSpeed()
+	}
+	return stats
+}
+
+// Peers returns the stats of every connected peer
+func (ts *TorrentSession) Peers() []PeerStats {
+	var peers []PeerStats
+	ts.connectedPeers.ReadOnlyIterate(func(peerIdStr string, connection *PeerConnection) bool {
+		connection.stateMutex.RLock()
+		peer := PeerStats{
+			PeerId:                peerIdStr,
+			Address:               connection.tcpConn.RemoteAddr().String(),
+			Client:                PeerClientName(connection.peerId),
+			Outgoing:              connection.isOutgoing,
+			SupportsFastExtension: connection.supportsFastExtension,
+			Snubbed:               connection.snubbed.Load(),
+			AmChoking:             connection.amChoking,
+			AmInterested:          connection.amInterested,
+			PeerChoking:           connection.peerChoking,
+			PeerInterested:        connection.peerInterested,
+		}
+		connection.stateMutex.RUnlock()
+		connection.piecesMutex.RLock()
+		if connection.piecesBitfield != nil && connection.piecesBitfield.Size() > 0 {
+			peer.Progress = float64(connection.piecesBitfield.CountSetBits()) / float64(connection.piecesBitfield.Size())
+		}
+		connection.piecesMutex.RUnlock()
+		if ts.rateTracker != nil {
+			peer.DownloadRate = ts.rateTracker.GetDownloadSpeed(peerIdStr)
+			peer.UploadRate = ts.rateTracker.GetUploadSpeed(peerIdStr)
+		}
+		peers = append(peers, peer)
+		return true
+	})
+	return peers
+}
+
+type PieceState uint8
+
+const (
+	PieceMissing  PieceState = iota
+	PieceInFlight            // blocks are requested or received, the piece is not verified yet
+	PieceDone                // verified
+	PieceSkipped             // only overlaps skipped files
+)
+
+// PieceStates returns the state of every piece, by index
+func (ts *TorrentSession) PieceStates() []PieceState {
+	states := make([]PieceState, ts.torrent.Info.NumPieces)
+	for pieceIndex := range states {
+		if ts.bitfield.GetBit(uint(pieceIndex)) == 1 {
+			states[pieceIndex] = PieceDone
+		} else if ts.fileSystem != nil && !ts.fileSystem.IsPieceWanted(int64(pieceIndex)) {
+			states[pieceIndex] = PieceSkipped
+		}
+	}
+	for _, pieceIndex := range ts.piecePicker.InFlightPieces() {
+		if states[pieceIndex] == PieceMissing {
+			states[pieceIndex] = PieceInFlight
+		}
+	}
+	return states
+}
+
+// TrackerStatus the tracker is not queried while the torrent is paused
+func (ts *TorrentSession) TrackerStatus() TrackerStatus {
+	if ts.trackerClient == nil {
+		return TrackerStatus{Url: ts.torrent.Announce}
+	}
+	return ts.trackerClient.Status()
+}
+
+/* QUITTER GOROUTINE */
+
+// StartQuitter Meant to run as a goroutine
+func (ts *TorrentSession) StartQuitter(ctx context.Context) {
+	for {
+		var connection *PeerConnection
+		select {
+		case <-ctx.Done():
+			return
+		case connection = <-ts.quitChannel:
+		}
+		// a connection may be reported more than once, e.g. by its reader and its writer
+		if ts.RemovePeer(connection) {
+			connection.CloseConnection()
+		}
+	}
+}
+
+/* STOP AND CLEAN UP */
+
+// Stop disconnects every peer and waits for every goroutine of the session, then saves the resume data and closes
+// the files. Goroutines still running once the context is done are not waited for: the files are left open then,
+// as they may still read or write them, and the resume data is the one saved on pause.
+func (ts *TorrentSession) Stop(ctx context.Context) error {
+	ts.Pause()
+	ts.cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		ts.wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		ts.logs.disk.Warn("session not stopped in time, leaving its files open", "name", ts.torrent.Info.Name)
+		return ErrStopTimeout(ts.torrent.Info.Name, ctx.Err())
+	}
+	ts.CleanUp()
+	return nil
+}
+
+// CleanUp flushes buffered blocks, saves the resume data and closes the files, once the goroutines are stopped
+func (ts *TorrentSession) CleanUp() {
+	if ts.diskIO != nil {
+		ts.diskIO.FlushPartialPieces()
+	}
+	if ts.fileSystem != nil {
+		if err := ts.SaveResumeData(); err != nil {
+			ts.logs.disk.Error("error saving resume data", "err", err)
+		}
+		ts.fileSystem.CleanUp()
+	}
+}
@@ -0,0 +1,322 @@
+package ptorrent
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/** TOC
+- CLOCK
+	- Clock, SystemClock
+- RULES
+	- ParseSpeedSchedule, ParseScheduleRule
+	- ScheduleRule.Contains
+- SCHEDULER
+	- Client.StartSpeedSchedule, Client.SpeedScheduler
+	- run (goroutine), apply
+	- enterAlternate, leaveAlternate
+	- setNormalRateLimits, normalRateLimits
+*/
+
+/*
+- What is it supposed to do
+- - Switches the client between its normal and its alternate speed limits, following a weekly schedule: the
+    alternate limits apply while the local time is within any of the rules, e.g. `mon-fri 09:00-18:00`.
+- - The normal limits are those in effect when the alternate period starts; they are restored once it ends. Limits
+    changed during the period, e.g. through the http api, become the normal limits, applied once it ends.
+- - Optionally, every running torrent is paused during the alternate period, and resumed once it ends. Torrents paused
+    by hand are left paused, torrents resumed by hand during the period are left running.
+- - The schedule is checked at every minute; the clock is injectable, so that the schedule can be driven in tests.
+*/
+
+/************************************** CLOCK **************************************/
+
+// Clock the time source of the scheduler
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+var SystemClock Clock = systemClock{}
+
+/************************************** RULES **************************************/
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ScheduleRule a time range on some days of the week; a range ending before it starts runs past midnight, into the
+// next day
+type ScheduleRule struct {
+	Days  [7]bool       // indexed by time.Weekday, the days the range starts on
+	Start time.Duration // since midnight
+	End   time.Duration // since midnight, up to 24h
+}
+
+// ParseSpeedSchedule parses rules separated by `;`, e.g. `mon-fri 09:00-18:00; sat,sun 10:00-12:00`
+func ParseSpeedSchedule(value string) ([]ScheduleRule, error) {
+	var rules []ScheduleRule
+	for _, ruleValue := range strings.Split(value, ";") {
+		if strings.TrimSpace(ruleValue) == "" {
+			continue
+		}
+		rule, err := ParseScheduleRule(ruleValue)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("empty speed schedule")
+	}
+	return rules, nil
+}
+
+// ParseScheduleRule parses `<days> <start>-<end>`; days are `daily`, `weekdays`, `weekends`, or comma separated days
+// and day ranges like `mon-fri,sun`. Times are `HH:MM`, local time.
+func ParseScheduleRule(value string) (ScheduleRule, error) {
+	var rule ScheduleRule
+	fields := strings.Fields(strings.ToLower(value))
+	if len(fields) != 2 {
+		return rule, fmt.Errorf("invalid schedule rule %q: expected `<days> <start>-<end>`", value)
+	}
+
+	switch fields[0] {
+	case "daily":
+		fields[0] = "sun-sat"
+	case "weekdays":
+		fields[0] = "mon-fri"
+	case "weekends":
+		fields[0] = "sat,sun"
+	}
+	for _, days := range strings.Split(fields[0], ",") {
+		firstName, lastName, isRange := strings.Cut(days, "-")
+		if !isRange {
+			lastName = firstName
+		}
+		first, okFirst := weekdayNames[firstName]
+		last, okLast := weekdayNames[lastName]
+		if !okFirst || !okLast {
+			return rule, fmt.Errorf("invalid days %q in schedule rule %q", days, value)
+		}
+		// ranges wrap around the week, e.g. fri-mon
+		for day := first; ; day = (day + 1) % 7 {
+			rule.Days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+
+	startValue, endValue, found := strings.Cut(fields[1], "-")
+	if !found {
+		return rule, fmt.Errorf("invalid time range %q in schedule rule %q", fields[1], value)
+	}
+	var err error
+	if rule.Start, err = parseTimeOfDay(startValue); err != nil {
+		return rule, err
+	}
+	if rule.End, err = parseTimeOfDay(endValue); err != nil {
+		return rule, err
+	}
+	if rule.Start == rule.End {
+		return rule, fmt.Errorf("empty time range %q in schedule rule %q", fields[1], value)
+	}
+	return rule, nil
+}
+
+// parseTimeOfDay `HH:MM`, from 00:00 to 24:00
+func parseTimeOfDay(value string) (time.Duration, error) {
+	hoursValue, minutesValue, found := strings.Cut(value, ":")
+	hours, errHours := strconv.Atoi(hoursValue)
+	minutes, errMinutes := strconv.Atoi(minutesValue)
+	if !found || errHours != nil || errMinutes != nil || hours < 0 || minutes < 0 || minutes > 59 ||
+		hours*60+minutes > 24*60 {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+// Contains if the time, in its own location, is within the rule
+func (r ScheduleRule) Contains(t time.Time) bool {
+	// the wall clock, not the elapsed time, on days with a daylight saving change
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+
+	if r.Start < r.End {
+		return r.Days[today] && sinceMidnight >= r.Start && sinceMidnight < r.End
+	}
+	return (r.Days[today] && sinceMidnight >= r.Start) || (r.Days[yesterday] && sinceMidnight < r.End)
+}
+
+/************************************** SCHEDULER **************************************/
+
+// SpeedScheduleOptions the alternate limits, and when they apply
+type SpeedScheduleOptions struct {
+	Rules           []ScheduleRule
+	MaxDownloadRate int64 // bytes per second, across all torrents; 0 for unlimited
+	MaxUploadRate   int64 // bytes per second, across all torrents; 0 for unlimited
+	PauseTorrents   bool  // pauses every running torrent during the alternate period
+	Clock           Clock // SystemClock if nil
+}
+
+type SpeedScheduler struct {
+	client  *Client
+	options SpeedScheduleOptions
+
+	mu                 sync.Mutex
+	alternate          bool
+	normalDownloadRate int64             // restored once the alternate period ends
+	normalUploadRate   int64             // restored once the alternate period ends
+	pausedByScheduler  map[[20]byte]bool // resumed once the alternate period ends
+}
+
+// StartSpeedSchedule applies the schedule right away, then at every minute till the client is closed.
+// A client has at most one schedule.
+func (c *Client) StartSpeedSchedule(options SpeedScheduleOptions) (*SpeedScheduler, error) {
+	if len(options.Rules) == 0 {
+		return nil, fmt.Errorf("a speed schedule needs at least one rule")
+	}
+	if options.Clock == nil {
+		options.Clock = SystemClock
+	}
+	scheduler := &SpeedScheduler{
+		client:            c,
+		options:           options,
+		pausedByScheduler: make(map[[20]byte]bool),
+	}
+
+	c.mu.Lock()
+	if c.speedScheduler != nil {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("the client already has a speed schedule")
+	}
+	c.speedScheduler = scheduler
+	c.mu.Unlock()
+
+	scheduler.apply()
+	c.startGoroutine(func() { scheduler.run(c.ctx) })
+	return scheduler, nil
+}
+
+// SpeedScheduler nil if the client has no speed schedule
+func (c *Client) SpeedScheduler() *SpeedScheduler {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.speedScheduler
+}
+
+// Alternate if the alternate limits are in effect
+func (s *SpeedScheduler) Alternate() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.alternate
+}
+
+func (s *SpeedScheduler) Options() SpeedScheduleOptions {
+	return s.options
+}
+
+// run Meant to be run as a goroutine, applies the schedule at the start of every minute till the context is cancelled
+func (s *SpeedScheduler) run(ctx context.Context) {
+	for {
+		now := s.options.Clock.Now()
+		untilNextMinute := now.Truncate(time.Minute).Add(time.Minute).Sub(now)
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.options.Clock.After(untilNextMinute):
+		}
+		s.apply()
+	}
+}
+
+// apply switches the limits if the schedule says so
+func (s *SpeedScheduler) apply() {
+	now := s.options.Clock.Now()
+	alternate := false
+	for _, rule := range s.options.Rules {
+		if rule.Contains(now) {
+			alternate = true
+			break
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if alternate == s.alternate {
+		return
+	}
+	s.alternate = alternate
+	if alternate {
+		s.enterAlternate()
+	} else {
+		s.leaveAlternate()
+	}
+}
+
+// enterAlternate must be called with the mutex held
+func (s *SpeedScheduler) enterAlternate() {
+	s.normalDownloadRate, s.normalUploadRate = s.client.appliedRateLimits()
+	s.client.applyRateLimits(s.options.MaxDownloadRate, s.options.MaxUploadRate)
+	logs.client.Info("alternate speed limits on",
+		"maxDownloadRate", s.options.MaxDownloadRate, "maxUploadRate", s.options.MaxUploadRate)
+
+	if !s.options.PauseTorrents {
+		return
+	}
+	for _, session := range s.client.Sessions() {
+		if !session.paused.Load() {
+			session.Pause()
+			s.pausedByScheduler[session.torrent.InfoHash] = true
+		}
+	}
+}
+
+// leaveAlternate must be called with the mutex held
+func (s *SpeedScheduler) leaveAlternate() {
+	s.client.applyRateLimits(s.normalDownloadRate, s.normalUploadRate)
+	logs.client.Info("alternate speed limits off",
+		"maxDownloadRate", s.normalDownloadRate, "maxUploadRate", s.normalUploadRate)
+
+	for infoHash := range s.pausedByScheduler {
+		if session := s.client.Session(infoHash); session != nil {
+			session.Resume()
+		}
+	}
+	clear(s.pausedByScheduler)
+}
+
+// setNormalRateLimits applies the rates, or keeps them for the end of the alternate period
+func (s *SpeedScheduler) setNormalRateLimits(maxDownloadRate int64, maxUploadRate int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.alternate {
+		s.client.applyRateLimits(maxDownloadRate, maxUploadRate)
+		return
+	}
+	s.normalDownloadRate, s.normalUploadRate = maxDownloadRate, maxUploadRate
+	logs.client.Info("normal speed limits changed, applied once the alternate speed limits are off",
+		"maxDownloadRate", maxDownloadRate, "maxUploadRate", maxUploadRate)
+}
+
+func (s *SpeedScheduler) normalRateLimits() (maxDownloadRate int64, maxUploadRate int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.alternate {
+		return s.client.appliedRateLimits()
+	}
+	return s.normalDownloadRate, s.normalUploadRate
+}
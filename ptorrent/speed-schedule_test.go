@@ -0,0 +1,253 @@
+package ptorrent
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock a clock set by hand; its timers never fire, the tests apply the schedule themselves
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return make(chan time.Time)
+}
+
+func (c *fakeClock) set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// 2024-01-05 is a Friday
+func friday(hour int, minute int) time.Time {
+	return time.Date(2024, time.January, 5, hour, minute, 0, 0, time.UTC)
+}
+
+func TestParseScheduleRule(t *testing.T) {
+	days := func(weekdays ...time.Weekday) [7]bool {
+		var result [7]bool
+		for _, day := range weekdays {
+			result[day] = true
+		}
+		return result
+	}
+	tests := []struct {
+		value string
+		rule  ScheduleRule
+	}{
+		{"mon-fri 09:00-18:00", ScheduleRule{
+			Days:  days(time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday),
+			Start: 9 * time.Hour, End: 18 * time.Hour,
+		}},
+		{"fri-mon 22:30-02:00", ScheduleRule{
+			Days:  days(time.Friday, time.Saturday, time.Sunday, time.Monday),
+			Start: 22*time.Hour + 30*time.Minute, End: 2 * time.Hour,
+		}},
+		{"sat-sat 00:00-24:00", ScheduleRule{Days: days(time.Saturday), Start: 0, End: 24 * time.Hour}},
+		{"tue,thu,sun 10:00-11:15", ScheduleRule{
+			Days:  days(time.Tuesday, time.Thursday, time.Sunday),
+			Start: 10 * time.Hour, End: 11*time.Hour + 15*time.Minute,
+		}},
+		{"Daily 01:00-02:00", ScheduleRule{
+			Days:  days(time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday),
+			Start: time.Hour, End: 2 * time.Hour,
+		}},
+		{"weekdays 08:00-09:00", ScheduleRule{
+			Days:  days(time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday),
+			Start: 8 * time.Hour, End: 9 * time.Hour,
+		}},
+		{"weekends 08:00-09:00", ScheduleRule{
+			Days:  days(time.Saturday, time.Sunday),
+			Start: 8 * time.Hour, End: 9 * time.Hour,
+		}},
+	}
+	for _, test := range tests {
+		rule, err := ParseScheduleRule(test.value)
+		if err != nil {
+			t.Errorf("%q: %v", test.value, err)
+			continue
+		}
+		if rule != test.rule {
+			t.Errorf("%q: got %+v, expected %+v", test.value, rule, test.rule)
+		}
+	}
+
+	for _, value := range []string{
+		"", "mon", "mon-fri", "mon-fri 09:00", "mon-fri 09:00-18:00 extra", "monday 09:00-18:00", "mon-xyz 09:00-18:00",
+		"mon, 09:00-18:00", "mon 9-18", "mon 09:60-18:00", "mon 24:01-18:00", "mon -01:00-18:00", "mon 09:00-09:00",
+	} {
+		if _, err := ParseScheduleRule(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+
+	if _, err := ParseSpeedSchedule(" ; "); err == nil {
+		t.Error("empty schedule: expected an error")
+	}
+	rules, err := ParseSpeedSchedule("mon-fri 09:00-18:00; sat,sun 10:00-12:00;")
+	if err != nil || len(rules) != 2 {
+		t.Errorf("got %d rules, %v; expected 2 rules", len(rules), err)
+	}
+}
+
+func TestScheduleRuleContains(t *testing.T) {
+	daytime, err := ParseScheduleRule("fri 09:00-18:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	overnight, err := ParseScheduleRule("fri 22:00-02:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the week wraps from saturday to sunday
+	weekend, err := ParseScheduleRule("sat 23:00-01:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saturday := friday(0, 0).AddDate(0, 0, 1)
+	sunday := friday(0, 0).AddDate(0, 0, 2)
+
+	tests := []struct {
+		name     string
+		rule     ScheduleRule
+		time     time.Time
+		contains bool
+	}{
+		{"daytime, before", daytime, friday(8, 59), false},
+		{"daytime, at the start", daytime, friday(9, 0), true},
+		{"daytime, within", daytime, friday(12, 0), true},
+		{"daytime, at the end", daytime, friday(18, 0), false},
+		{"daytime, another day", daytime, saturday.Add(12 * time.Hour), false},
+		{"overnight, before", overnight, friday(21, 59), false},
+		{"overnight, before midnight", overnight, friday(23, 0), true},
+		{"overnight, after midnight", overnight, saturday.Add(1 * time.Hour), true},
+		{"overnight, at the end", overnight, saturday.Add(2 * time.Hour), false},
+		{"overnight, after", overnight, saturday.Add(3 * time.Hour), false},
+		{"overnight, the day before after midnight", overnight, friday(1, 0), false},
+		{"overnight, the next day before midnight", overnight, saturday.Add(23 * time.Hour), false},
+		{"week wrap, before midnight", weekend, saturday.Add(23*time.Hour + 30*time.Minute), true},
+		{"week wrap, after midnight", weekend, sunday.Add(30 * time.Minute), true},
+		{"week wrap, after", weekend, sunday.Add(time.Hour), false},
+	}
+	for _, test := range tests {
+		if contains := test.rule.Contains(test.time); contains != test.contains {
+			t.Errorf("%s: %v contains %v is %v, expected %v", test.name, test.rule, test.time, contains, test.contains)
+		}
+	}
+}
+
+func TestSpeedSchedulerApply(t *testing.T) {
+	dir := chdirTemp(t)
+
+	sourcePath := filepath.Join(dir, "scheduled.bin")
+	if err := os.WriteFile(sourcePath, make([]byte, BlockSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, torrent, err := CreateTorrent(CreateTorrentOptions{
+		Path:        sourcePath,
+		Announce:    "http://127.0.0.1:1/announce", // unreachable
+		PieceLength: BlockSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewClient(&ClientOptions{ListenerPort: freePort(t), MaxDownloadRate: 1000, MaxUploadRate: 2000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeClient(t, client)
+	session, err := client.AddTorrent(torrent, &AddTorrentOptions{StorageType: MemoryStorageType})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := &fakeClock{now: friday(8, 0)}
+	rules, err := ParseSpeedSchedule("fri 09:00-18:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler, err := client.StartSpeedSchedule(SpeedScheduleOptions{
+		Rules:           rules,
+		MaxDownloadRate: 10,
+		MaxUploadRate:   20,
+		PauseTorrents:   true,
+		Clock:           clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.StartSpeedSchedule(SpeedScheduleOptions{Rules: rules}); err == nil {
+		t.Error("expected an error starting a second schedule")
+	}
+
+	expectLimits := func(when string, alternate bool, applied [2]int64, normal [2]int64) {
+		t.Helper()
+		if scheduler.Alternate() != alternate {
+			t.Errorf("%s: alternate is %v, expected %v", when, scheduler.Alternate(), alternate)
+		}
+		if download, upload := client.appliedRateLimits(); [2]int64{download, upload} != applied {
+			t.Errorf("%s: applied limits %d/%d, expected %v", when, download, upload, applied)
+		}
+		if download, upload := client.RateLimits(); [2]int64{download, upload} != normal {
+			t.Errorf("%s: normal limits %d/%d, expected %v", when, download, upload, normal)
+		}
+	}
+
+	expectLimits("before the period", false, [2]int64{1000, 2000}, [2]int64{1000, 2000})
+	if session.Stats().Paused {
+		t.Error("torrent paused before the period")
+	}
+
+	clock.set(friday(9, 0))
+	scheduler.apply()
+	expectLimits("within the period", true, [2]int64{10, 20}, [2]int64{1000, 2000})
+	if !session.Stats().Paused {
+		t.Error("torrent running during the period")
+	}
+
+	// changed during the period, applied once it ends
+	client.SetRateLimits(3000, 4000)
+	expectLimits("changed within the period", true, [2]int64{10, 20}, [2]int64{3000, 4000})
+	if options := client.Options(); options.MaxDownloadRate != 3000 || options.MaxUploadRate != 4000 {
+		t.Errorf("options limits %d/%d, expected the normal limits", options.MaxDownloadRate, options.MaxUploadRate)
+	}
+
+	clock.set(friday(12, 0))
+	scheduler.apply()
+	expectLimits("still within the period", true, [2]int64{10, 20}, [2]int64{3000, 4000})
+
+	clock.set(friday(18, 0))
+	scheduler.apply()
+	expectLimits("after the period", false, [2]int64{3000, 4000}, [2]int64{3000, 4000})
+	if session.Stats().Paused {
+		t.Error("torrent still paused after the period")
+	}
+
+	client.SetRateLimits(5000, 0)
+	expectLimits("changed after the period", false, [2]int64{5000, 0}, [2]int64{5000, 0})
+
+	// a torrent paused by hand stays paused
+	session.Pause()
+	clock.set(friday(9, 30))
+	scheduler.apply()
+	expectLimits("within the period again", true, [2]int64{10, 20}, [2]int64{5000, 0})
+	clock.set(friday(18, 30))
+	scheduler.apply()
+	expectLimits("after the period again", false, [2]int64{5000, 0}, [2]int64{5000, 0})
+	if !session.Stats().Paused {
+		t.Error("torrent paused by hand resumed after the period")
+	}
+}
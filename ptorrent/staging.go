@@ -0,0 +1,213 @@
+package ptorrent
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+)
+
+/** TOC
+- STAGING
+	- applyStaging: chooses where every file is written while incomplete
+- PER-FILE COMPLETION
+	- filesOfPiece, onPieceVerified
+	- finalizeFile: moves a complete file to its final path
+- MOVE
+	- moveFile: rename, or copy and verify across file systems
+*/
+
+/*
+- What is it supposed to do
+- - While a file has missing pieces, it is written to a staging path: below the incomplete directory, and/or with a
+    `.part` suffix, so that half-written files are not opened by mistake.
+- - Every file tracks how many of its pieces are still unverified. Once none is left, the file is moved to its final
+    path: renamed if possible, copied and verified otherwise (e.g. when the incomplete directory is on another disk).
+- - A file already present at its final path is used in place.
+*/
+
+const PartSuffix = ".part"
+
+// fileRelocator is implemented by storage backends holding resources tied to the path of a file
+type fileRelocator interface {
+	releaseFile(file *TorrentFile) error
+	reacquireFile(file *TorrentFile) error
+}
+
+/************************************** STAGING **************************************/
+
+// applyStaging sets the staging path of every wanted file that is not at its final path yet.
+// Must be called after the file priorities are applied, and before the os file system is built.
+func (tfs *TorrentFileSystem) applyStaging(incompleteDir string, partSuffix bool) {
+	tfs.incompleteDir = incompleteDir
+	for _, file := range tfs.files {
+		file.finalPath = file.path
+		if file.priority == PrioritySkip || (incompleteDir == "" && !partSuffix) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(file.finalPath...)); err == nil {
+			logs.disk.Info("file exists at its final path, using it in place", "file", filepath.Join(file.finalPath...))
+			continue
+		}
+		// empty files are complete from the start
+		if file.length == 0 {
+			continue
+		}
+
+		stagingPath := slices.Clone(file.finalPath)
+		if incompleteDir != "" {
+			stagingPath = append([]string{incompleteDir}, stagingPath...)
+		}
+		if partSuffix {
+			stagingPath[len(stagingPath)-1] += PartSuffix
+		}
+		file.path = stagingPath
+	}
+}
+
+/************************************** PER-FILE COMPLETION **************************************/
+
+// filesOfPiece the indices of the files overlapping the piece
+func (tfs *TorrentFileSystem) filesOfPiece(pieceIndex int64) []int {
+	pieceStart := pieceIndex * tfs.pieceLength
+	pieceEnd := min(pieceStart+tfs.pieceLength, tfs.totalLength)
+
+	var fileIndices []int
+	for fileIndex := findNextOffsetIndex(tfs.fileOffset, pieceStart) - 1; fileIndex >= 0 && fileIndex < len(tfs.files); fileIndex++ {
+		if tfs.fileOffset[fileIndex] >= pieceEnd {
+			break
+		}
+		if tfs.files[fileIndex].length > 0 {
+			fileIndices = append(fileIndices, fileIndex)
+		}
+	}
+	return fileIndices
+}
+
+// onPieceVerified counts the piece towards the completion of its files, and moves the files that are now complete
+func (tfs *TorrentFileSystem) onPieceVerified(pieceIndex int64) {
+	for _, fileIndex := range tfs.filesOfPiece(pieceIndex) {
+		file := tfs.files[fileIndex]
+		if file.priority == PrioritySkip {
+			continue
+		}
+
+		tfs.mu.Lock()
+		file.piecesRemaining--
+		complete := file.piecesRemaining == 0
+		tfs.mu.Unlock()
+
+		if complete {
+			// the piece mutex is held by the caller, the move can take long
+			tfs.finalizing.Add(1)
+			go func() {
+				defer tfs.finalizing.Done()
+				if err := tfs.finalizeFile(file); err != nil {
+					logs.disk.Error("error moving complete file", "file", filepath.Join(file.finalPath...), "err", err)
+				}
+			}()
+		}
+	}
+}
+
+// finalizeFile moves a complete file from its staging path to its final path
+func (tfs *TorrentFileSystem) finalizeFile(file *TorrentFile) error {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+
+	if slices.Equal(file.path, file.finalPath) {
+		return nil
+	}
+
+	relocator, isRelocator := tfs.storage.(fileRelocator)
+	if isRelocator {
+		if err := relocator.releaseFile(file); err != nil {
+			return err
+		}
+	}
+
+	stagingPath := filepath.Join(file.path...)
+	finalPath := filepath.Join(file.finalPath...)
+	moveErr := moveFile(stagingPath, finalPath)
+	if moveErr == nil {
+		file.path = file.finalPath
+		logs.disk.Info("file complete, moved to its final path", "from", stagingPath, "to", finalPath)
+	}
+
+	if isRelocator {
+		if err := relocator.reacquireFile(file); err != nil {
+			return errors.Join(moveErr, err)
+		}
+	}
+	return moveErr
+}
+
+/************************************** MOVE **************************************/
+
+// moveFile renames the file, or copies it, verifies the copy and removes the source if it is on another file system
+func moveFile(sourcePath string, destinationPath string) error {
+	if err := os.MkdirAll(filepath.Dir(destinationPath), os.ModePerm); err != nil {
+		return ErrCreatingDirectory(filepath.Dir(destinationPath))
+	}
+
+	err := os.Rename(sourcePath, destinationPath)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	// the temporary file is on the destination file system, so that the final rename is atomic
+	temporaryPath := destinationPath + ".tmp"
+	if err = copyAndVerifyFile(sourcePath, temporaryPath); err != nil {
+		_ = os.Remove(temporaryPath)
+		return err
+	}
+	if err = os.Rename(temporaryPath, destinationPath); err != nil {
+		_ = os.Remove(temporaryPath)
+		return err
+	}
+	return os.Remove(sourcePath)
+}
+
+func copyAndVerifyFile(sourcePath string, destinationPath string) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return ErrOpeningFile(sourcePath)
+	}
+	defer CloseReadCloserWithLog(source)
+
+	destination, err := os.OpenFile(destinationPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return ErrCreatingFile(destinationPath)
+	}
+	defer CloseReadCloserWithLog(destination)
+
+	sourceHash := sha1.New()
+	if _, err = io.Copy(destination, io.TeeReader(source, sourceHash)); err != nil {
+		return ErrWritingFile(destinationPath, err)
+	}
+	if err = destination.Sync(); err != nil {
+		return ErrWritingFile(destinationPath, err)
+	}
+
+	// the copy is read back and hashed
+	if _, err = destination.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	destinationHash := sha1.New()
+	if _, err = io.Copy(destinationHash, destination); err != nil {
+		return ErrReadingFile(destinationPath, err)
+	}
+	if !slices.Equal(sourceHash.Sum(nil), destinationHash.Sum(nil)) {
+		return fmt.Errorf("copy of %s to %s does not match the source", sourcePath, destinationPath)
+	}
+
+	// keeps the modification time, which is checked against the resume file
+	if sourceInfo, err := source.Stat(); err == nil {
+		_ = os.Chtimes(destinationPath, sourceInfo.ModTime(), sourceInfo.ModTime())
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package ptorrent
+
+import (
+	"context"
+	"sync"
+)
+
+type StateRequestType int
+
+const (
+	Uploaded StateRequestType = iota
+	Downloaded
+	Left
+)
+
+type TorrentState struct {
+	mu         sync.RWMutex
+	left       int64
+	downloaded int64
+	uploaded   int64
+
+	stateChannel chan Pair[StateRequestType, int64]
+}
+
+func NewTorrentState(totalLength int64) *TorrentState {
+	return &TorrentState{
+		stateChannel: make(chan Pair[StateRequestType, int64], 10),
+		left:         totalLength,
+		downloaded:   0,
+		uploaded:     0,
+	}
+}
+
+// StateHandler Meant to be run as a goroutine, till the context is cancelled.
+// Updates buffered when the context is cancelled are still counted, so that they are saved in the resume file.
+func (st *TorrentState) StateHandler(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case pieceInfo := <-st.stateChannel:
+					st.update(pieceInfo)
+				default:
+					return
+				}
+			}
+		case pieceInfo := <-st.stateChannel:
+			st.update(pieceInfo)
+		}
+	}
+}
+
+func (st *TorrentState) update(pieceInfo Pair[StateRequestType, int64]) {
+	stateRequestType := pieceInfo.first
+	length := pieceInfo.second
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if stateRequestType == Uploaded {
+		st.uploaded += length
+	} else if stateRequestType == Downloaded {
+		st.downloaded += length
+	} else if stateRequestType == Left {
+		st.left -= length
+	}
+}
+
+func (st *TorrentState) GetState() (int64, int64, int64) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.left, st.downloaded, st.uploaded
+}
+
+// RestoreState sets the counters from a resume file, before the state handler starts
+func (st *TorrentState) RestoreState(left int64, downloaded int64, uploaded int64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.left = left
+	st.downloaded = downloaded
+	st.uploaded = uploaded
+}
@@ -0,0 +1,141 @@
+package ptorrent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// FileStorage stores the torrent in its files on disk, through the file handle cache
+type FileStorage struct {
+	layout      *fileLayout
+	handleCache *FileHandleCache // may be shared with other torrents
+}
+
+// FileStorageConstructor creates the files of the torrent, keeping existing data
+func FileStorageConstructor(handleCache *FileHandleCache, mode AllocationMode) StorageConstructor {
+	return func(tfs *TorrentFileSystem) (Storage, error) {
+		if err := tfs.BuildOsFileSystem(mode); err != nil {
+			return nil, err
+		}
+		return &FileStorage{layout: tfs.fileLayout(), handleCache: handleCache}, nil
+	}
+}
+
+func (fs *FileStorage) ReadAt(p []byte, absoluteOffset int64) (int, error) {
+	n := 0
+	err := fs.layout.forEachSegment(absoluteOffset, int64(len(p)), func(file *TorrentFile, offsetInFile int64, start int64, end int64) error {
+		read, err := file.readFileAtOffset(fs.handleCache, offsetInFile, p[start:end])
+		n += read
+		return err
+	})
+	return n, err
+}
+
+func (fs *FileStorage) WriteAt(p []byte, absoluteOffset int64) (int, error) {
+	n := 0
+	err := fs.layout.forEachSegment(absoluteOffset, int64(len(p)), func(file *TorrentFile, offsetInFile int64, start int64, end int64) error {
+		written, err := file.writeFileAtOffset(fs.handleCache, offsetInFile, p[start:end])
+		n += written
+		return err
+	})
+	return n, err
+}
+
+// Sync flushes the files that are open; closed files were flushed on close
+func (fs *FileStorage) Sync() error {
+	var errs []error
+	for _, file := range fs.allFiles() {
+		if err := fs.handleCache.Sync(file); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes the files of the torrent, leaving the handles of other torrents sharing the cache open
+func (fs *FileStorage) Close() error {
+	for _, file := range fs.allFiles() {
+		fs.handleCache.Evict(file)
+	}
+	return nil
+}
+
+// releaseFile the caller holds the file lock, so the handle is not in use
+func (fs *FileStorage) releaseFile(file *TorrentFile) error {
+	fs.handleCache.Evict(file)
+	return nil
+}
+
+// reacquireFile handles are opened lazily at the new path
+func (fs *FileStorage) reacquireFile(file *TorrentFile) error {
+	return nil
+}
+
+func (fs *FileStorage) allFiles() []*TorrentFile {
+	if fs.layout.partFile == nil {
+		return fs.layout.files
+	}
+	return append(fs.layout.files[:len(fs.layout.files):len(fs.layout.files)], fs.layout.partFile)
+}
+
+/* TORRENT FILE */
+
+func (tf *TorrentFile) readFileAtOffset(handleCache *FileHandleCache, offset int64, buffer []byte) (int, error) {
+	tf.mu.RLock()
+	defer tf.mu.RUnlock()
+
+	handle, err := handleCache.Acquire(tf, false)
+	if err != nil {
+		return 0, err
+	}
+	defer handleCache.Release(handle)
+
+	// the offset is relative to the file
+	n, err := handle.osFile.ReadAt(buffer, offset)
+	if err != nil {
+		return n, ErrReadingFile(handle.osFile.Name(), err)
+	}
+
+	// if short read
+	if n < len(buffer) {
+		return n, ErrShortRead(handle.osFile.Name(), err)
+	}
+	return n, nil
+}
+
+func (tf *TorrentFile) writeFileAtOffset(handleCache *FileHandleCache, offset int64, data []byte) (int, error) {
+	tf.mu.Lock()
+	defer tf.mu.Unlock()
+
+	handle, err := handleCache.Acquire(tf, true)
+	if err != nil {
+		return 0, err
+	}
+	defer handleCache.Release(handle)
+
+	n, err := handle.osFile.WriteAt(data, offset)
+	if err != nil {
+		return n, ErrWritingFile(handle.osFile.Name(), err)
+	}
+
+	if n < len(data) {
+		return n, ErrShortWrite(handle.osFile.Name(), err)
+	}
+	return n, nil
+}
+
+// createEmptyFile creates the file if it does not exist, keeping existing data
+func createEmptyFile(filePath string) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		return ErrCreatingDirectory(filepath.Dir(filePath))
+	}
+	osFile, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return ErrCreatingFile(filePath)
+	}
+	if err = osFile.Close(); err != nil {
+		return ErrClosingFile(filePath)
+	}
+	return nil
+}
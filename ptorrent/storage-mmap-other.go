@@ -0,0 +1,9 @@
+//go:build !(linux || darwin)
+
+package ptorrent
+
+func MmapStorageConstructor(mode AllocationMode) StorageConstructor {
+	return func(tfs *TorrentFileSystem) (Storage, error) {
+		return nil, ErrMmapNotSupported
+	}
+}
@@ -0,0 +1,168 @@
+//go:build linux || darwin
+
+package ptorrent
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// MmapStorage maps the files of the torrent into memory; reads and writes are copies, flushed by the kernel or on Sync
+type MmapStorage struct {
+	mu       sync.RWMutex // held for reading while copying, so that a file is not unmapped under a copy
+	layout   *fileLayout
+	mappings map[*TorrentFile][]byte
+}
+
+// MmapStorageConstructor creates the files of the torrent, keeping existing data, and maps them.
+// Files are always sized before they are mapped, AllocateNone behaves as AllocateSparse.
+// A mapping is at most math.MaxInt bytes long: a torrent with a larger file is rejected, before any file is created.
+func MmapStorageConstructor(mode AllocationMode) StorageConstructor {
+	return func(tfs *TorrentFileSystem) (Storage, error) {
+		if mode == AllocateNone {
+			mode = AllocateSparse
+		}
+		layout := tfs.fileLayout()
+		if file := layout.fileTooLargeToMap(); file != nil {
+			return nil, ErrFileTooLargeToMap(filepath.Join(file.path...), file.length)
+		}
+		if err := tfs.BuildOsFileSystem(mode); err != nil {
+			return nil, err
+		}
+
+		ms := &MmapStorage{layout: layout, mappings: make(map[*TorrentFile][]byte)}
+		for _, file := range ms.layout.files {
+			if file.priority == PrioritySkip || file.length == 0 {
+				continue
+			}
+			if err := ms.mapFile(file, mode); err != nil {
+				_ = ms.Close()
+				return nil, err
+			}
+		}
+		if ms.layout.partFile != nil {
+			if err := ms.mapFile(ms.layout.partFile, mode); err != nil {
+				_ = ms.Close()
+				return nil, err
+			}
+		}
+		return ms, nil
+	}
+}
+
+func (ms *MmapStorage) mapFile(file *TorrentFile, mode AllocationMode) error {
+	filePath := filepath.Join(file.path...)
+	if file.length > math.MaxInt {
+		return ErrFileTooLargeToMap(filePath, file.length)
+	}
+	osFile, err := os.OpenFile(filePath, os.O_RDWR, 0644)
+	if err != nil {
+		return ErrOpeningFile(filePath)
+	}
+	// the mapping stays valid once the file is closed
+	defer CloseReadCloserWithLog(osFile)
+
+	// the partfile grows as slots are filled, the whole of it is mapped
+	if err = resizeFile(osFile, file.length, mode); err != nil {
+		return ErrAllocatingBytes(filePath)
+	}
+	mapping, err := syscall.Mmap(int(osFile.Fd()), 0, int(file.length), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return errors.Join(ErrOpeningFile(filePath), err)
+	}
+	ms.mappings[file] = mapping
+	return nil
+}
+
+func (ms *MmapStorage) ReadAt(p []byte, absoluteOffset int64) (int, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	n := 0
+	err := ms.layout.forEachSegment(absoluteOffset, int64(len(p)), func(file *TorrentFile, offsetInFile int64, start int64, end int64) error {
+		mapping, ok := ms.mappings[file]
+		if !ok {
+			return ErrOpeningFile(filepath.Join(file.path...))
+		}
+		n += copy(p[start:end], mapping[offsetInFile:])
+		return nil
+	})
+	return n, err
+}
+
+func (ms *MmapStorage) WriteAt(p []byte, absoluteOffset int64) (int, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	n := 0
+	err := ms.layout.forEachSegment(absoluteOffset, int64(len(p)), func(file *TorrentFile, offsetInFile int64, start int64, end int64) error {
+		mapping, ok := ms.mappings[file]
+		if !ok {
+			return ErrOpeningFile(filepath.Join(file.path...))
+		}
+		n += copy(mapping[offsetInFile:], p[start:end])
+		return nil
+	})
+	return n, err
+}
+
+func (ms *MmapStorage) Sync() error {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var errs []error
+	for _, mapping := range ms.mappings {
+		if err := msync(mapping); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func msync(mapping []byte) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&mapping[0])), uintptr(len(mapping)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// releaseFile flushes and unmaps the file before it is moved
+func (ms *MmapStorage) releaseFile(file *TorrentFile) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	mapping, ok := ms.mappings[file]
+	if !ok {
+		return nil
+	}
+	delete(ms.mappings, file)
+	return errors.Join(msync(mapping), syscall.Munmap(mapping))
+}
+
+// reacquireFile maps the file again at its new path
+func (ms *MmapStorage) reacquireFile(file *TorrentFile) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.mapFile(file, AllocateSparse)
+}
+
+func (ms *MmapStorage) Close() error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var errs []error
+	for file, mapping := range ms.mappings {
+		if err := syscall.Munmap(mapping); err != nil {
+			errs = append(errs, err)
+		}
+		delete(ms.mappings, file)
+	}
+	return errors.Join(errs...)
+}
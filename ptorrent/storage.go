@@ -0,0 +1,210 @@
+package ptorrent
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sync"
+)
+
+/** TOC
+- STORAGE
+	- Storage interface, StorageConstructor
+	- mmapOrFileStorageConstructor: the file backend for files too large to be mapped
+- FILE LAYOUT
+	- fileLayout: maps piece space to files and partfile slots, shared by the file and mmap backends
+- MEMORY STORAGE
+*/
+
+/*
+- What is it supposed to do
+- - The torrent file system addresses data in piece space: absolute offsets from the start of the torrent,
+    i.e. pieceIndex * pieceLength + offset within the piece.
+- - A `Storage` backend persists that data: the file backend writes to the files of the torrent through the file
+    handle cache, the mmap backend maps them into memory, and the memory backend keeps everything in a slice,
+    so that the download engine can be tested without touching the disk.
+*/
+
+/************************************** STORAGE **************************************/
+
+type Storage interface {
+	// ReadAt reads len(p) bytes at the absolute offset in piece space
+	ReadAt(p []byte, absoluteOffset int64) (int, error)
+	// WriteAt writes len(p) bytes at the absolute offset in piece space
+	WriteAt(p []byte, absoluteOffset int64) (int, error)
+	// Sync flushes written data to the underlying medium
+	Sync() error
+	// Close releases the resources of the backend; it must not be used afterwards
+	Close() error
+}
+
+// StorageConstructor builds the storage of a torrent file system, once its files and priorities are known
+type StorageConstructor func(tfs *TorrentFileSystem) (Storage, error)
+
+type StorageType string
+
+const (
+	FileStorageType   StorageType = "file"
+	MmapStorageType   StorageType = "mmap"
+	MemoryStorageType StorageType = "memory"
+)
+
+// NewStorageConstructor `handleCache` is only used by the file backend, `mode` by the file and mmap backends
+func NewStorageConstructor(storageType StorageType, handleCache *FileHandleCache, mode AllocationMode) (StorageConstructor, error) {
+	switch storageType {
+	case FileStorageType:
+		return FileStorageConstructor(handleCache, mode), nil
+	case MmapStorageType:
+		return mmapOrFileStorageConstructor(handleCache, mode), nil
+	case MemoryStorageType:
+		return MemoryStorageConstructor(), nil
+	}
+	return nil, fmt.Errorf("unknown storage type %q, expected one of file, mmap, memory", storageType)
+}
+
+// mmapOrFileStorageConstructor falls back to the file backend for a torrent with a file that can not be mapped,
+// i.e. of 2 GiB or more on 32-bit platforms
+func mmapOrFileStorageConstructor(handleCache *FileHandleCache, mode AllocationMode) StorageConstructor {
+	return func(tfs *TorrentFileSystem) (Storage, error) {
+		if file := tfs.fileLayout().fileTooLargeToMap(); file != nil {
+			logs.disk.Warn("file too large to be memory-mapped, using the file storage instead",
+				"file", filepath.Join(file.path...), "length", file.length)
+			return FileStorageConstructor(handleCache, mode)(tfs)
+		}
+		return MmapStorageConstructor(mode)(tfs)
+	}
+}
+
+/************************************** FILE LAYOUT **************************************/
+
+type fileLayout struct {
+	files       []*TorrentFile
+	fileOffset  []int64 // starting offset of every file, and the end offset
+	totalLength int64
+	pieceLength int64
+	partSlots   map[int64]int64
+	partFile    *TorrentFile
+}
+
+func (tfs *TorrentFileSystem) fileLayout() *fileLayout {
+	return &fileLayout{
+		files:       tfs.files,
+		fileOffset:  tfs.fileOffset,
+		totalLength: tfs.totalLength,
+		pieceLength: tfs.pieceLength,
+		partSlots:   tfs.partSlots,
+		partFile:    tfs.partFile,
+	}
+}
+
+// fileTooLargeToMap returns the first file, skipped ones included, or the partfile whose length does not fit in an int;
+// nil if every file can be mapped
+func (fl *fileLayout) fileTooLargeToMap() *TorrentFile {
+	for _, file := range fl.files {
+		if file.length > math.MaxInt {
+			return file
+		}
+	}
+	if fl.partFile != nil && fl.partFile.length > math.MaxInt {
+		return fl.partFile
+	}
+	return nil
+}
+
+// segmentTarget returns the file, and the offset within it, where the bytes of the file at `fileIndex` starting at
+// `absoluteOffset` are stored, along with the absolute offset at which the segment ends.
+// Bytes of skipped files are stored in the partfile slot of the piece.
+func (fl *fileLayout) segmentTarget(fileIndex int, absoluteOffset int64) (*TorrentFile, int64, int64, error) {
+	file := fl.files[fileIndex]
+	fileEnd := fl.fileOffset[fileIndex+1]
+	if file.priority != PrioritySkip {
+		return file, absoluteOffset - file.startingOffset, fileEnd, nil
+	}
+
+	pieceIndex := absoluteOffset / fl.pieceLength
+	slot, ok := fl.partSlots[pieceIndex]
+	if !ok || fl.partFile == nil {
+		return nil, 0, 0, ErrPieceNotWanted(pieceIndex, filepath.Join(file.path...))
+	}
+	// slots are not contiguous in piece space
+	segmentEnd := min(fileEnd, (pieceIndex+1)*fl.pieceLength)
+	return fl.partFile, slot*fl.pieceLength + (absoluteOffset - pieceIndex*fl.pieceLength), segmentEnd, nil
+}
+
+// forEachSegment splits the range into segments that each lie within a single file (or partfile slot),
+// `start` and `end` are relative to the range
+func (fl *fileLayout) forEachSegment(absoluteOffset int64, length int64, f func(file *TorrentFile, offsetInFile int64, start int64, end int64) error) error {
+	if absoluteOffset < 0 || absoluteOffset+length > fl.totalLength {
+		return ErrOutOfRange("end offset")
+	}
+
+	processed := int64(0)
+	for processed < length {
+		currentAbsoluteOffset := absoluteOffset + processed
+		nextOffsetIndex := findNextOffsetIndex(fl.fileOffset, currentAbsoluteOffset)
+		if nextOffsetIndex == -1 {
+			return ErrFlawInLogic("next offset not found")
+		}
+
+		file, offsetInFile, segmentEnd, err := fl.segmentTarget(nextOffsetIndex-1, currentAbsoluteOffset)
+		if err != nil {
+			return err
+		}
+		segmentLength := min(segmentEnd, absoluteOffset+length) - currentAbsoluteOffset
+		if err = f(file, offsetInFile, processed, processed+segmentLength); err != nil {
+			return err
+		}
+		processed += segmentLength
+	}
+	return nil
+}
+
+/************************************** MEMORY STORAGE **************************************/
+
+type MemoryStorage struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+func NewMemoryStorage(totalLength int64) *MemoryStorage {
+	return &MemoryStorage{data: make([]byte, totalLength)}
+}
+
+func MemoryStorageConstructor() StorageConstructor {
+	return func(tfs *TorrentFileSystem) (Storage, error) {
+		return NewMemoryStorage(tfs.totalLength), nil
+	}
+}
+
+func (ms *MemoryStorage) ReadAt(p []byte, absoluteOffset int64) (int, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if absoluteOffset < 0 || absoluteOffset >= int64(len(ms.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, ms.data[absoluteOffset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (ms *MemoryStorage) WriteAt(p []byte, absoluteOffset int64) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if absoluteOffset < 0 || absoluteOffset+int64(len(p)) > int64(len(ms.data)) {
+		return 0, ErrOutOfRange("end offset")
+	}
+	return copy(ms.data[absoluteOffset:], p), nil
+}
+
+func (ms *MemoryStorage) Sync() error {
+	return nil
+}
+
+func (ms *MemoryStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,334 @@
+package ptorrent
+
+import (
+	bencodingParser "bittorrent-client/bencoding-parser"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+/** TOC
+- OPTIONS
+- SOURCE FILES
+	- collectSourceFiles
+- PIECES
+	- choosePieceLength
+	- hashPieces (parallel)
+- METAINFO
+	- buildMetainfo
+	- CreateTorrent
+	- WriteTorrentFile
+*/
+
+const minAutoPieceLength = 1 << 14 // 16KB, one block
+const maxAutoPieceLength = 1 << 24 // 16MB
+const targetNumPieces = 1500
+
+type CreateTorrentOptions struct {
+	Path         string     // file or directory to create the torrent from
+	Announce     string     // the 'announce' url of the tracker; mandatory
+	AnnounceList [][]string // tiers of alternate tracker urls
+	UrlList      []string   // web seeds (BEP 19)
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time // defaults to the present time
+	Private      bool      // BEP 27
+	PieceLength  int64     // a power of two, at least 16KB; chosen automatically if 0
+	Workers      int       // number of hashing goroutines; defaults to the number of CPUs
+}
+
+/************************************** SOURCE FILES **************************************/
+
+type sourceFile struct {
+	fullPath string
+	path     []string // path relative to the torrent root, as a list of segments
+	length   int64
+}
+
+// collectSourceFiles walks the file or directory in lexicographical order
+func collectSourceFiles(root string) ([]sourceFile, TorrentType, error) {
+	rootInfo, err := os.Stat(root)
+	if err != nil {
+		return nil, InvalidTorrentType, err
+	}
+
+	if rootInfo.Mode().IsRegular() {
+		file := sourceFile{fullPath: root, path: []string{rootInfo.Name()}, length: rootInfo.Size()}
+		return []sourceFile{file}, SingleFile, nil
+	}
+	if !rootInfo.IsDir() {
+		return nil, InvalidTorrentType, fmt.Errorf("%s is neither a regular file nor a directory", root)
+	}
+
+	var files []sourceFile
+	err = filepath.WalkDir(root, func(fullPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			if !entry.IsDir() {
+				logs.disk.Warn("skipping, not a regular file", "path", fullPath)
+			}
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(root, fullPath)
+		if err != nil {
+			return err
+		}
+		files = append(files, sourceFile{
+			fullPath: fullPath,
+			path:     strings.Split(filepath.ToSlash(relativePath), "/"),
+			length:   info.Size(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, InvalidTorrentType, err
+	}
+	if len(files) == 0 {
+		return nil, InvalidTorrentType, fmt.Errorf("no files found in directory %s", root)
+	}
+	return files, MultiFile, nil
+}
+
+/************************************** PIECES **************************************/
+
+// choosePieceLength picks the smallest power of two that keeps the number of pieces around `targetNumPieces`
+func choosePieceLength(totalLength int64) int64 {
+	pieceLength := int64(minAutoPieceLength)
+	for pieceLength < maxAutoPieceLength && totalLength/pieceLength > targetNumPieces {
+		pieceLength *= 2
+	}
+	return pieceLength
+}
+
+// hashPieces hashes the concatenation of all files, distributing pieces across `workers` goroutines
+func hashPieces(files []sourceFile, totalLength int64, pieceLength int64, workers int) ([]byte, error) {
+	fileOffset := make([]int64, 0, len(files)+1)
+	currentOffset := int64(0)
+	for _, file := range files {
+		fileOffset = append(fileOffset, currentOffset)
+		currentOffset += file.length
+	}
+	fileOffset = append(fileOffset, currentOffset) // has the end offset as well
+
+	numPieces := ceilDiv(totalLength, pieceLength)
+	pieces := make([]byte, numPieces*sha1.Size)
+
+	pieceIndexChannel := make(chan int64, workers)
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var hashErr error
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buffer := make([]byte, pieceLength)
+			for pieceIndex := range pieceIndexChannel {
+				absoluteOffset := pieceIndex * pieceLength
+				offsetToReadTill := min(absoluteOffset+pieceLength, totalLength)
+				piece := buffer[:offsetToReadTill-absoluteOffset]
+
+				if err := readSourceRange(files, fileOffset, piece, absoluteOffset); err != nil {
+					errOnce.Do(func() { hashErr = err })
+					continue
+				}
+				hash := sha1.Sum(piece)
+				copy(pieces[pieceIndex*sha1.Size:], hash[:])
+			}
+		}()
+	}
+
+	for pieceIndex := int64(0); pieceIndex < numPieces; pieceIndex++ {
+		pieceIndexChannel <- pieceIndex
+	}
+	close(pieceIndexChannel)
+	wg.Wait()
+
+	if hashErr != nil {
+		return nil, hashErr
+	}
+	return pieces, nil
+}
+
+// readSourceRange fills the buffer file by file, starting at an absolute offset
+func readSourceRange(files []sourceFile, fileOffset []int64, buffer []byte, absoluteOffset int64) error {
+	lengthRead := int64(0)
+	lengthToRead := int64(len(buffer))
+	for lengthRead < lengthToRead {
+		currentAbsoluteOffset := absoluteOffset + lengthRead
+		nextOffsetIndex := findNextOffsetIndex(fileOffset, currentAbsoluteOffset)
+		if nextOffsetIndex == -1 {
+			return ErrFlawInLogic("next offset not found while hashing")
+		}
+		currentFile := files[nextOffsetIndex-1]
+		lengthToReadInCurrentFile := min(fileOffset[nextOffsetIndex]-currentAbsoluteOffset, lengthToRead-lengthRead)
+
+		osFile, err := os.Open(currentFile.fullPath)
+		if err != nil {
+			return ErrOpeningFile(currentFile.fullPath)
+		}
+		n, err := osFile.ReadAt(buffer[lengthRead:lengthRead+lengthToReadInCurrentFile], currentAbsoluteOffset-fileOffset[nextOffsetIndex-1])
+		CloseReadCloserWithLog(osFile)
+		if int64(n) < lengthToReadInCurrentFile {
+			return ErrShortRead(currentFile.fullPath, err)
+		}
+		lengthRead += lengthToReadInCurrentFile
+	}
+	return nil
+}
+
+/************************************** METAINFO **************************************/
+
+func buildMetainfo(options *CreateTorrentOptions, name string, files []sourceFile, structureType TorrentType, pieceLength int64, pieces []byte) *bencodingParser.Bencode {
+	info := map[string]*bencodingParser.Bencode{
+		NameKey:        bencodingParser.NewBencodeFromString(name),
+		PieceLengthKey: bencodingParser.NewBencodeFromInt64(pieceLength),
+		PiecesKey:      bencodingParser.NewBencodeFromString(string(pieces)),
+	}
+	if options.Private {
+		info[PrivateKey] = bencodingParser.NewBencodeFromInt64(1)
+	}
+
+	if structureType == SingleFile {
+		info[LengthKey] = bencodingParser.NewBencodeFromInt64(files[0].length)
+	} else {
+		fileList := bencodingParser.NewBencodeList()
+		for _, file := range files {
+			path := bencodingParser.NewBencodeList()
+			for _, segment := range file.path {
+				path.Add(bencodingParser.NewBencodeFromString(segment))
+			}
+			fileList.Add(bencodingParser.NewSortedBencodeDict(map[string]*bencodingParser.Bencode{
+				LengthKey: bencodingParser.NewBencodeFromInt64(file.length),
+				PathKey:   bencodingParser.NewBencodeFromBList(path),
+			}))
+		}
+		info[FilesKey] = bencodingParser.NewBencodeFromBList(fileList)
+	}
+
+	metainfo := map[string]*bencodingParser.Bencode{
+		AnnounceKey:     bencodingParser.NewBencodeFromString(options.Announce),
+		InfoKey:         bencodingParser.NewSortedBencodeDict(info),
+		CreationDateKey: bencodingParser.NewBencodeFromInt64(options.CreationDate.Unix()),
+	}
+	if len(options.AnnounceList) > 0 {
+		tiers := bencodingParser.NewBencodeList()
+		for _, tier := range options.AnnounceList {
+			trackerUrls := bencodingParser.NewBencodeList()
+			for _, trackerUrl := range tier {
+				trackerUrls.Add(bencodingParser.NewBencodeFromString(trackerUrl))
+			}
+			tiers.Add(bencodingParser.NewBencodeFromBList(trackerUrls))
+		}
+		metainfo[AnnounceListKey] = bencodingParser.NewBencodeFromBList(tiers)
+	}
+	if len(options.UrlList) > 0 {
+		urlList := bencodingParser.NewBencodeList()
+		for _, webSeed := range options.UrlList {
+			urlList.Add(bencodingParser.NewBencodeFromString(webSeed))
+		}
+		metainfo[UrlListKey] = bencodingParser.NewBencodeFromBList(urlList)
+	}
+	if options.Comment != "" {
+		metainfo[CommentKey] = bencodingParser.NewBencodeFromString(options.Comment)
+	}
+	if options.CreatedBy != "" {
+		metainfo[CreatedByKey] = bencodingParser.NewBencodeFromString(options.CreatedBy)
+	}
+	return bencodingParser.NewSortedBencodeDict(metainfo)
+}
+
+// CreateTorrent builds the metainfo for a file or directory and returns it serialized, along with the loaded torrent.
+// The serialized metainfo is loaded back with LoadTorrent, to make sure that it round-trips to the same info-hash.
+func CreateTorrent(options CreateTorrentOptions) ([]byte, *Torrent, error) {
+	if options.Announce == "" && len(options.AnnounceList) > 0 && len(options.AnnounceList[0]) > 0 {
+		options.Announce = options.AnnounceList[0][0]
+	}
+	if options.Announce == "" {
+		return nil, nil, fmt.Errorf("an announce url is required to create a torrent")
+	}
+	if options.PieceLength != 0 && (options.PieceLength < BlockSize || options.PieceLength&(options.PieceLength-1) != 0) {
+		return nil, nil, fmt.Errorf("piece length %d is not a power of two of at least %d bytes", options.PieceLength, BlockSize)
+	}
+	if options.Workers <= 0 {
+		options.Workers = runtime.NumCPU()
+	}
+	if options.CreationDate.IsZero() {
+		options.CreationDate = time.Now()
+	}
+
+	rootPath, err := filepath.Abs(options.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, structureType, err := collectSourceFiles(rootPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error collecting files from %s: %v", rootPath, err)
+	}
+
+	totalLength := int64(0)
+	for _, file := range files {
+		totalLength += file.length
+	}
+	if totalLength == 0 {
+		return nil, nil, fmt.Errorf("can not create a torrent with no data")
+	}
+
+	pieceLength := options.PieceLength
+	if pieceLength == 0 {
+		pieceLength = choosePieceLength(totalLength)
+	}
+	logs.disk.Info("creating torrent", "files", len(files), "length", totalLength, "pieceLength", pieceLength)
+
+	pieces, err := hashPieces(files, totalLength, pieceLength, options.Workers)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error hashing pieces: %v", err)
+	}
+
+	metainfo := buildMetainfo(&options, filepath.Base(rootPath), files, structureType, pieceLength, pieces)
+	serializedMetainfo, err := bencodingParser.SerializeBencode(metainfo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	infoBencode, _ := metainfo.BDict.Get(InfoKey)
+	serializedInfo, err := bencodingParser.SerializeBencode(infoBencode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	torrent, err := LoadTorrent(bytes.NewReader(serializedMetainfo))
+	if err != nil {
+		return nil, nil, fmt.Errorf("created torrent can not be loaded: %v", err)
+	}
+	if torrent.InfoHash != sha1.Sum(serializedInfo) {
+		return nil, nil, ErrFlawInLogic("info-hash of the created torrent does not round-trip")
+	}
+	return serializedMetainfo, torrent, nil
+}
+
+// WriteTorrentFile creates the torrent and writes it to `outputPath`
+func WriteTorrentFile(options CreateTorrentOptions, outputPath string) (*Torrent, error) {
+	serializedMetainfo, torrent, err := CreateTorrent(options)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(outputPath, serializedMetainfo, 0644); err != nil {
+		return nil, ErrWritingFile(outputPath, err)
+	}
+	return torrent, nil
+}
@@ -0,0 +1,168 @@
+package ptorrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const (
+	knownGoodTorrentPath = "../testdata/torrent-create/known-good.torrent"
+	knownGoodSourcePath  = "../testdata/torrent-create/source"
+	knownGoodInfoHash    = "153e23aa056a9f6f2c4d33a979915cbcdaed0a2e" // computed by make-fixture.py
+)
+
+func writeRandomFile(t *testing.T, path string, length int, seed int64) []byte {
+	t.Helper()
+	data := make([]byte, length)
+	rand.New(rand.NewSource(seed)).Read(data)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// expectPieceHashes checks the piece hashes of the torrent against the data, concatenated in file order
+func expectPieceHashes(t *testing.T, torrent *Torrent, data []byte) {
+	t.Helper()
+	numPieces := int(ceilDiv(int64(len(data)), torrent.Info.PieceLength))
+	if int(torrent.Info.NumPieces) != numPieces || len(torrent.Info.Pieces) != numPieces {
+		t.Fatalf("%d pieces, %d hashes, expected %d", torrent.Info.NumPieces, len(torrent.Info.Pieces), numPieces)
+	}
+	for pieceIndex := 0; pieceIndex < numPieces; pieceIndex++ {
+		begin := int64(pieceIndex) * torrent.Info.PieceLength
+		end := min(begin+torrent.Info.PieceLength, int64(len(data)))
+		if sha1.Sum(data[begin:end]) != torrent.Info.Pieces[pieceIndex] {
+			t.Errorf("hash of piece %d differs", pieceIndex)
+		}
+	}
+}
+
+// createAndLoad writes the torrent to a file and loads it back, checking that both are the same torrent
+func createAndLoad(t *testing.T, options CreateTorrentOptions) *Torrent {
+	t.Helper()
+	outputPath := filepath.Join(t.TempDir(), "created.torrent")
+	created, err := WriteTorrentFile(options, outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadTorrentFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.InfoHash != created.InfoHash {
+		t.Errorf("loaded info-hash %x, created %x", loaded.InfoHash, created.InfoHash)
+	}
+	return loaded
+}
+
+func TestCreateTorrentSingleFile(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "single.bin")
+	data := writeRandomFile(t, sourcePath, 5*BlockSize+777, 1)
+
+	torrent := createAndLoad(t, CreateTorrentOptions{
+		Path:        sourcePath,
+		Announce:    "http://tracker.example.com/announce",
+		PieceLength: 2 * BlockSize,
+	})
+	if torrent.StructureType != SingleFile {
+		t.Errorf("structure type %v, expected single file", torrent.StructureType)
+	}
+	if torrent.Info.Name != "single.bin" || torrent.Info.Length != int64(len(data)) || len(torrent.Info.Files) != 0 {
+		t.Errorf("name %q, length %d, %d files; expected single.bin, %d, no files",
+			torrent.Info.Name, torrent.Info.Length, len(torrent.Info.Files), len(data))
+	}
+	if torrent.Info.NumPieces != 3 {
+		t.Errorf("%d pieces, expected 3", torrent.Info.NumPieces)
+	}
+	expectPieceHashes(t, torrent, data)
+}
+
+func TestCreateTorrentNestedDirectory(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "tree")
+	// created out of order, the files are listed in lexicographical order of their paths
+	zData := writeRandomFile(t, filepath.Join(root, "z.bin"), 1000, 1)
+	nestedData := writeRandomFile(t, filepath.Join(root, "a", "b", "nested.bin"), 3*BlockSize+5, 2)
+	topData := writeRandomFile(t, filepath.Join(root, "a", "top.bin"), BlockSize, 3)
+	data := append(append(append([]byte{}, nestedData...), topData...), zData...)
+
+	torrent := createAndLoad(t, CreateTorrentOptions{
+		Path:         root,
+		Announce:     "http://tracker.example.com/announce",
+		AnnounceList: [][]string{{"http://tracker.example.com/announce"}, {"udp://backup.example.com:6969"}},
+		Comment:      "nested",
+		PieceLength:  BlockSize,
+		Private:      true,
+		Workers:      3,
+	})
+	if torrent.StructureType != MultiFile {
+		t.Errorf("structure type %v, expected multi file", torrent.StructureType)
+	}
+	if torrent.Info.Name != "tree" || torrent.Info.Length != int64(len(data)) || !torrent.Info.Private {
+		t.Errorf("name %q, length %d, private %v; expected tree, %d, private",
+			torrent.Info.Name, torrent.Info.Length, torrent.Info.Private, len(data))
+	}
+	expectedFiles := []File{
+		{Length: int64(len(nestedData)), Path: []string{"a", "b", "nested.bin"}},
+		{Length: int64(len(topData)), Path: []string{"a", "top.bin"}},
+		{Length: int64(len(zData)), Path: []string{"z.bin"}},
+	}
+	if !reflect.DeepEqual(torrent.Info.Files, expectedFiles) {
+		t.Errorf("files %v, expected %v", torrent.Info.Files, expectedFiles)
+	}
+	if torrent.Info.NumPieces != 5 {
+		t.Errorf("%d pieces, expected 5", torrent.Info.NumPieces)
+	}
+	expectPieceHashes(t, torrent, data)
+	if len(torrent.AnnounceList) != 2 || torrent.Comment != "nested" {
+		t.Errorf("announce list %v, comment %q", torrent.AnnounceList, torrent.Comment)
+	}
+}
+
+func TestCreateTorrentKnownGood(t *testing.T) {
+	expected, err := os.ReadFile(knownGoodTorrentPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixture, err := LoadTorrentFile(knownGoodTorrentPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(fixture.InfoHash[:]) != knownGoodInfoHash {
+		t.Errorf("fixture info-hash %x, expected %s", fixture.InfoHash, knownGoodInfoHash)
+	}
+
+	serialized, torrent, err := CreateTorrent(CreateTorrentOptions{
+		Path:         knownGoodSourcePath,
+		Announce:     "http://tracker.example.com/announce",
+		Comment:      "known-good fixture",
+		CreatedBy:    "make-fixture.py",
+		CreationDate: time.Unix(1700000000, 0),
+		PieceLength:  BlockSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(torrent.InfoHash[:]) != knownGoodInfoHash {
+		t.Errorf("created info-hash %x, expected %s", torrent.InfoHash, knownGoodInfoHash)
+	}
+	if torrent.Info.NumPieces != 4 {
+		t.Errorf("%d pieces, expected 4", torrent.Info.NumPieces)
+	}
+	if !reflect.DeepEqual(torrent.Info.Files, fixture.Info.Files) {
+		t.Errorf("files %v, expected %v", torrent.Info.Files, fixture.Info.Files)
+	}
+	if !bytes.Equal(serialized, expected) {
+		t.Error("created metainfo differs from the fixture")
+	}
+}
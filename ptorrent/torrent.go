@@ -1,4 +1,4 @@
-package main
+package ptorrent
 
 import (
 	bencodingParser "bittorrent-client/bencoding-parser"
@@ -6,7 +6,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
-	"log"
+	"os"
 	"strings"
 	"time"
 )
@@ -35,6 +35,7 @@ const (
 	LengthKey       = "length"
 	PathKey         = "path"
 	FilesKey        = "files"
+	PrivateKey      = "private"
 )
 
 func getTorrentFileType(infoDictionary *bencodingParser.BencodeDict) TorrentType {
@@ -59,6 +60,8 @@ type Torrent struct {
 	StructureType TorrentType // for single or multi file types
 	Info          *InfoDict   // info dictionary
 	InfoHash      [20]byte    // SHA1 hash of the info dictionary
+
+	infoBytes []byte // the bencoded info dictionary, served to peers fetching the metadata (BEP 9); nil if not loaded
 }
 
 type InfoDict struct {
@@ -68,6 +71,7 @@ type InfoDict struct {
 	NumPieces   uint       // number of pieces in the torrent
 	Length      int64      // for single-file torrent; total size of file in bytes; for a multi-file torrent contains the total size of all files combined
 	Files       []File     // for multi-file torrent
+	Private     bool       // if set, peers must only be obtained from the trackers in the metainfo
 }
 
 type File struct {
@@ -109,11 +113,12 @@ func (info *InfoDict) String() string {
 	}
 
 	return fmt.Sprintf(
-		"InfoDict{\n\t\tName: %s,\n\t\tPieceLength: %d,\n\t\tNumPieces: %d,\n\t\tLength: %d,\n\t\tFiles: %v\n\t}",
+		"InfoDict{\n\t\tName: %s,\n\t\tPieceLength: %d,\n\t\tNumPieces: %d,\n\t\tLength: %d,\n\t\tPrivate: %t,\n\t\tFiles: %v\n\t}",
 		info.Name,
 		info.PieceLength,
 		info.NumPieces,
 		info.Length,
+		info.Private,
 		filesStr,
 	)
 }
@@ -127,27 +132,32 @@ func (f *File) String() string {
 }
 
 // parseAnnounceUrl Mandatory Field
-func parseAnnounceUrl(bencodeTorrentDict *bencodingParser.BencodeDict) string {
+func parseAnnounceUrl(bencodeTorrentDict *bencodingParser.BencodeDict) (string, error) {
 	announceBencode, exists := bencodeTorrentDict.Get(AnnounceKey)
 	if !exists || announceBencode.BString == nil {
-		log.Fatalf("no announce URL in torrent file")
+		return "", ErrMissingTorrentField(AnnounceKey)
 	}
 
-	return string(*announceBencode.BString)
+	return string(*announceBencode.BString), nil
 }
 
 func parseOptionalAnnounceList(bencodeTorrentDict *bencodingParser.BencodeDict) [][]string {
 	announceListBencode, exists := bencodeTorrentDict.Get(AnnounceListKey)
 	if !exists || announceListBencode.BList == nil {
-		log.Printf("no 'announce-list' in torrent file")
+		logs.client.Debug("no 'announce-list' in torrent file")
 		return nil
 	}
 
 	var announceList [][]string
 	for _, trackerUrlsGroupBencode := range *announceListBencode.BList {
+		if trackerUrlsGroupBencode.BList == nil {
+			continue
+		}
 		var trackerUrls []string
 		for _, trackerUrlBencode := range *trackerUrlsGroupBencode.BList {
-			trackerUrls = append(trackerUrls, string(*trackerUrlBencode.BString))
+			if trackerUrlBencode.BString != nil {
+				trackerUrls = append(trackerUrls, string(*trackerUrlBencode.BString))
+			}
 		}
 		announceList = append(announceList, trackerUrls)
 	}
@@ -158,7 +168,7 @@ func parseOptionalAnnounceList(bencodeTorrentDict *bencodingParser.BencodeDict)
 func parseOptionalComment(bencodeTorrentDict *bencodingParser.BencodeDict) string {
 	commentBencode, exists := bencodeTorrentDict.Get(CommentKey)
 	if !exists || commentBencode.BString == nil {
-		log.Print("no 'comment' found in the torrent file")
+		logs.client.Debug("no 'comment' found in the torrent file")
 		return ""
 	}
 
@@ -169,7 +179,7 @@ func parseOptionalComment(bencodeTorrentDict *bencodingParser.BencodeDict) strin
 func parseOptionalCreatedBy(bencodeTorrentDict *bencodingParser.BencodeDict) string {
 	createdByBencode, exists := bencodeTorrentDict.Get(CreatedByKey)
 	if !exists || createdByBencode.BString == nil {
-		log.Printf("no 'created by' found in the torrent file")
+		logs.client.Debug("no 'created by' found in the torrent file")
 		return ""
 	}
 
@@ -180,7 +190,7 @@ func parseOptionalCreatedBy(bencodeTorrentDict *bencodingParser.BencodeDict) str
 func parseOptionalCreationDate(bencodeTorrentDict *bencodingParser.BencodeDict) time.Time {
 	creationDateBencode, exists := bencodeTorrentDict.Get(CreationDateKey)
 	if !exists || creationDateBencode.BInt == nil {
-		log.Printf("no 'creation date' found in the torrent file")
+		logs.client.Debug("no 'creation date' found in the torrent file")
 		return time.Time{}
 	}
 
@@ -191,7 +201,7 @@ func parseOptionalCreationDate(bencodeTorrentDict *bencodingParser.BencodeDict)
 func parseOptionalEncoding(bencodeTorrentDict *bencodingParser.BencodeDict) string {
 	encodingBencode, exists := bencodeTorrentDict.Get(EncodingKey)
 	if !exists || encodingBencode.BString == nil {
-		log.Printf("no 'encoding' found in the torrent file")
+		logs.client.Debug("no 'encoding' found in the torrent file")
 		return ""
 	}
 	return string(*encodingBencode.BString)
@@ -202,70 +212,93 @@ func parseOptionalUrlList(bencodeTorrentDict *bencodingParser.BencodeDict) []str
 	urlListBencode, exists := bencodeTorrentDict.Get(UrlListKey)
 	var urlList []string
 	if !exists || urlListBencode.BList == nil {
-		log.Printf("no 'url-list' found in the torrent file")
+		logs.client.Debug("no 'url-list' found in the torrent file")
 		return nil
 	} else {
 		for _, bencodeVal := range *urlListBencode.BList {
-			urlList = append(urlList, string(*bencodeVal.BString))
+			if bencodeVal.BString != nil {
+				urlList = append(urlList, string(*bencodeVal.BString))
+			}
 		}
 	}
 	return urlList
 }
 
 // parseInfoDictionary Mandatory Field
-func parseInfoDictionary(bencodeTorrentDict *bencodingParser.BencodeDict) *InfoDict {
+func parseInfoDictionary(bencodeTorrentDict *bencodingParser.BencodeDict) (*InfoDict, error) {
 	infoDictionaryBencode, exists := bencodeTorrentDict.Get(InfoKey)
-	if !exists {
-		log.Fatalf("no 'info' dictionary found in torrent file")
+	if !exists || infoDictionaryBencode.BDict == nil {
+		return nil, ErrMissingTorrentField(InfoKey)
 	}
 	infoDictionary := infoDictionaryBencode.BDict
 
+	var err error
 	infoDict := &InfoDict{}
-	infoDict.Name = parseNameInInfoDictionary(infoDictionary)
-	infoDict.PieceLength = parsePieceLengthInInfoDictionary(infoDictionary)
-	infoDict.Pieces, infoDict.NumPieces = parsePiecesInInfoDictionary(infoDictionary)
+	if infoDict.Name, err = parseNameInInfoDictionary(infoDictionary); err != nil {
+		return nil, err
+	}
+	if infoDict.PieceLength, err = parsePieceLengthInInfoDictionary(infoDictionary); err != nil {
+		return nil, err
+	}
+	if infoDict.Pieces, infoDict.NumPieces, err = parsePiecesInInfoDictionary(infoDictionary); err != nil {
+		return nil, err
+	}
+	infoDict.Private = parseOptionalPrivateInInfoDictionary(infoDictionary)
 
 	fileStructureType := getTorrentFileType(infoDictionary)
 	if fileStructureType == SingleFile {
-		infoDict.Length = parseLengthInInfoDictionary(infoDictionary)
+		if infoDict.Length, err = parseLengthInInfoDictionary(infoDictionary); err != nil {
+			return nil, err
+		}
 	} else if fileStructureType == MultiFile {
-		infoDict.Length = parseLengthInInfoDictionaryForMultiFileTorrent(infoDictionary)
-		infoDict.Files = parseFilesInInfoDictionary(infoDictionary)
+		if infoDict.Files, err = parseFilesInInfoDictionary(infoDictionary); err != nil {
+			return nil, err
+		}
+		for _, file := range infoDict.Files {
+			infoDict.Length += file.Length
+		}
 	} else {
-		log.Fatalf("unhandled torrent file type: neither single-file nor multi-file torrent")
+		return nil, fmt.Errorf("unhandled torrent file type: neither single-file nor multi-file torrent")
 	}
-	return infoDict
+
+	if numPiecesExpected := ceilDiv(infoDict.Length, infoDict.PieceLength); numPiecesExpected != int64(infoDict.NumPieces) {
+		return nil, fmt.Errorf("corrupt torrent file: %d pieces for a length of %d bytes, expected %d", infoDict.NumPieces, infoDict.Length, numPiecesExpected)
+	}
+	return infoDict, nil
 }
 
 // parseNameInInfoDictionary Mandatory field in the info dictionary
-func parseNameInInfoDictionary(infoDictionary *bencodingParser.BencodeDict) string {
+func parseNameInInfoDictionary(infoDictionary *bencodingParser.BencodeDict) (string, error) {
 	name, exists := infoDictionary.Get(NameKey)
 	if !exists || name.BString == nil {
-		log.Fatalf("no 'name' field found in info dictionary of the torrent file")
+		return "", ErrMissingTorrentField(NameKey)
 	}
 
-	return string(*name.BString)
+	return string(*name.BString), nil
 }
 
 // parsePieceLengthInInfoDictionary Mandatory field in the info dictionary
-func parsePieceLengthInInfoDictionary(infoDictionary *bencodingParser.BencodeDict) int64 {
+func parsePieceLengthInInfoDictionary(infoDictionary *bencodingParser.BencodeDict) (int64, error) {
 	pieceLength, exists := infoDictionary.Get(PieceLengthKey)
-	if !exists || pieceLength == nil {
-		log.Fatalf("no 'piece length' field found in info dictionary of the torrent file")
+	if !exists || pieceLength.BInt == nil {
+		return 0, ErrMissingTorrentField(PieceLengthKey)
+	}
+	if *pieceLength.BInt <= 0 {
+		return 0, fmt.Errorf("corrupt torrent file: invalid 'piece length' %d", *pieceLength.BInt)
 	}
 
-	return int64(*pieceLength.BInt)
+	return int64(*pieceLength.BInt), nil
 }
 
 // parsePiecesInInfoDictionary Mandatory field in the info dictionary
-func parsePiecesInInfoDictionary(infoDictionary *bencodingParser.BencodeDict) ([][20]byte, uint) {
+func parsePiecesInInfoDictionary(infoDictionary *bencodingParser.BencodeDict) ([][20]byte, uint, error) {
 	pieces, exists := infoDictionary.Get(PiecesKey)
 	if !exists || pieces.BString == nil {
-		log.Fatalf("no 'pieces' field found in info dictionary of the torrent file")
+		return nil, 0, ErrMissingTorrentField(PiecesKey)
 	}
 	piecesData := []byte(*pieces.BString)
 	if len(piecesData)%20 != 0 {
-		log.Fatalf("corrupt torrent file: 'pieces' field length is not a multiple of 20")
+		return nil, 0, fmt.Errorf("corrupt torrent file: 'pieces' field length is not a multiple of 20")
 	}
 	numPieces := len(piecesData) / 20
 	parsedPieces := make([][20]byte, numPieces)
@@ -273,92 +306,89 @@ func parsePiecesInInfoDictionary(infoDictionary *bencodingParser.BencodeDict) ([
 		copy(parsedPieces[i][:], piecesData[i*20:(i+1)*20])
 	}
 
-	return parsedPieces, uint(numPieces)
+	return parsedPieces, uint(numPieces), nil
+}
+
+// parseOptionalPrivateInInfoDictionary Optional field in the info dictionary (BEP 27)
+func parseOptionalPrivateInInfoDictionary(infoDictionary *bencodingParser.BencodeDict) bool {
+	private, exists := infoDictionary.Get(PrivateKey)
+	if !exists || private.BInt == nil {
+		return false
+	}
+	return *private.BInt == 1
 }
 
 // parseLengthInInfoDictionary Mandatory field for a single file torrent
-func parseLengthInInfoDictionary(infoDictionary *bencodingParser.BencodeDict) int64 {
+func parseLengthInInfoDictionary(infoDictionary *bencodingParser.BencodeDict) (int64, error) {
 	length, exists := infoDictionary.Get(LengthKey)
 	if !exists || length.BInt == nil {
-		log.Fatalf("no 'length' field found in info dictionary of the torrent file")
+		return 0, ErrMissingTorrentField(LengthKey)
 	}
-
-	return int64(*length.BInt)
-}
-
-// parseLengthInInfoDictionaryForMultiFileTorrent total length of all files combined
-func parseLengthInInfoDictionaryForMultiFileTorrent(infoDictionary *bencodingParser.BencodeDict) int64 {
-	files, exists := infoDictionary.Get(FilesKey)
-	if !exists || files.BList == nil {
-		log.Fatalf("no 'files' field found in info dictionary of the torrent file")
+	if *length.BInt < 0 {
+		return 0, fmt.Errorf("corrupt torrent file: negative 'length'")
 	}
 
-	totalLength := int64(0)
-	for _, bencodedFile := range *files.BList {
-		fileLengthBencode, exists := (*bencodedFile.BDict).Get(LengthKey)
-		if !exists || fileLengthBencode.BInt == nil {
-			log.Fatalf("no 'length' field found for a file")
-		}
-		fileLength := int64(*fileLengthBencode.BInt)
-
-		totalLength += fileLength
-	}
-	return totalLength
+	return int64(*length.BInt), nil
 }
 
 // parseFilesInInfoDictionary Mandatory field for a multi file torrent
-func parseFilesInInfoDictionary(infoDictionary *bencodingParser.BencodeDict) []File {
+func parseFilesInInfoDictionary(infoDictionary *bencodingParser.BencodeDict) ([]File, error) {
 	files, exists := infoDictionary.Get(FilesKey)
 	if !exists || files.BList == nil {
-		log.Fatalf("no 'files' field found in info dictionary of the torrent file")
+		return nil, ErrMissingTorrentField(FilesKey)
 	}
 
 	var filesList []File
-	for _, bencodedFile := range *files.BList {
+	for fileIndex, bencodedFile := range *files.BList {
+		if bencodedFile.BDict == nil {
+			return nil, fmt.Errorf("corrupt torrent file: file %d is not a dictionary", fileIndex)
+		}
 		fileLengthBencode, exists := (*bencodedFile.BDict).Get(LengthKey)
 		if !exists || fileLengthBencode.BInt == nil {
-			log.Fatalf("no 'length' field found for a file")
+			return nil, ErrMissingTorrentField(fmt.Sprintf("%s of file %d", LengthKey, fileIndex))
 		}
 		fileLength := int64(*fileLengthBencode.BInt)
+		if fileLength < 0 {
+			return nil, fmt.Errorf("corrupt torrent file: negative 'length' of file %d", fileIndex)
+		}
 
 		pathBencode, exists := (*bencodedFile.BDict).Get(PathKey)
 		if !exists || pathBencode.BList == nil {
-			log.Fatalf("no 'path' field found for a file")
+			return nil, ErrMissingTorrentField(fmt.Sprintf("%s of file %d", PathKey, fileIndex))
 		}
 		var path []string
 		for _, pathSegment := range *pathBencode.BList {
+			if pathSegment.BString == nil {
+				return nil, fmt.Errorf("corrupt torrent file: path segment of file %d is not a string", fileIndex)
+			}
 			path = append(path, string(*pathSegment.BString))
 		}
 
 		filesList = append(filesList, File{Length: fileLength, Path: path})
 	}
 
-	return filesList
+	return filesList, nil
 }
 
-func ComputeInfoHash(bencodeTorrentDict *bencodingParser.BencodeDict) [20]byte {
+func ComputeInfoHash(bencodeTorrentDict *bencodingParser.BencodeDict) ([20]byte, error) {
+	serializedInfo, err := serializeInfoDictionary(bencodeTorrentDict)
+	if err != nil {
+		return [20]byte{}, err
+	}
+	return sha1.Sum(serializedInfo), nil
+}
+
+func serializeInfoDictionary(bencodeTorrentDict *bencodingParser.BencodeDict) ([]byte, error) {
 	infoDictionaryBencode, exists := bencodeTorrentDict.Get(InfoKey)
 	if !exists {
-		log.Fatalf("no 'info' dictionary found in torrent file")
+		return nil, ErrMissingTorrentField(InfoKey)
 	}
 
 	serializedInfo, err := bencodingParser.SerializeBencode(infoDictionaryBencode)
 	if err != nil {
-		log.Fatalf("error encoding the info dictionary")
-	}
-
-	hasher := sha1.New()
-	hasher.Write(serializedInfo)
-
-	sum := hasher.Sum(nil)
-	if len(sum) != 20 {
-		log.Fatalf("SHA-1 hash length mismatch, expected 20 bytes, obtained %d bytes", len(sum))
+		return nil, fmt.Errorf("error encoding the info dictionary: %v", err)
 	}
-
-	var infoHash [20]byte
-	copy(infoHash[:], sum)
-
-	return infoHash
+	return serializedInfo, nil
 }
 
 func LoadTorrent(reader io.Reader) (*Torrent, error) {
@@ -370,21 +400,46 @@ func LoadTorrent(reader io.Reader) (*Torrent, error) {
 
 	torrent := NewTorrent()
 
-	bencodeInfoDictionary, _ := bencodeTorrentDict.Get(InfoKey)
+	bencodeInfoDictionary, exists := bencodeTorrentDict.Get(InfoKey)
+	if !exists || bencodeInfoDictionary.BDict == nil {
+		return nil, ErrMissingTorrentField(InfoKey)
+	}
 	torrent.StructureType = getTorrentFileType(bencodeInfoDictionary.BDict)
 	if torrent.StructureType == InvalidTorrentType {
 		return nil, fmt.Errorf("unhandled torrent file type: neither single-file nor multi-file torrent")
 	}
 
-	torrent.Announce = parseAnnounceUrl(bencodeTorrentDict)
+	if torrent.Announce, err = parseAnnounceUrl(bencodeTorrentDict); err != nil {
+		return nil, err
+	}
 	torrent.Comment = parseOptionalComment(bencodeTorrentDict)
 	torrent.CreatedBy = parseOptionalCreatedBy(bencodeTorrentDict)
 	torrent.CreationDate = parseOptionalCreationDate(bencodeTorrentDict)
 	torrent.Encoding = parseOptionalEncoding(bencodeTorrentDict)
 	torrent.UrlList = parseOptionalUrlList(bencodeTorrentDict)
 	torrent.AnnounceList = parseOptionalAnnounceList(bencodeTorrentDict)
-	torrent.Info = parseInfoDictionary(bencodeTorrentDict)
+	if torrent.Info, err = parseInfoDictionary(bencodeTorrentDict); err != nil {
+		return nil, err
+	}
+
+	if torrent.infoBytes, err = serializeInfoDictionary(bencodeTorrentDict); err != nil {
+		return nil, err
+	}
+	torrent.InfoHash = sha1.Sum(torrent.infoBytes)
+	return torrent, nil
+}
+
+// LoadTorrentFile opens and parses a .torrent file
+func LoadTorrentFile(path string) (*Torrent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, ErrOpeningFile(path)
+	}
+	defer CloseReadCloserWithLog(file)
 
-	torrent.InfoHash = ComputeInfoHash(bencodeTorrentDict)
+	torrent, err := LoadTorrent(file)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing torrent %s: %v", path, err)
+	}
 	return torrent, nil
 }
@@ -1,11 +1,10 @@
-package main
+package ptorrent
 
 import (
 	bencodingParser "bittorrent-client/bencoding-parser"
 	"crypto/sha1"
 	"encoding/binary"
 	"fmt"
-	"log"
 	"net"
 )
 
@@ -36,27 +35,23 @@ func parseTrackerResponse(respBody []byte) (*TrackerResponse, error) {
 
 	// optional fields
 	if trackerId, hasTrackerId := getTrackerId(responseBencode); hasTrackerId {
-		log.Printf("tracker has the key `tracker id`")
 		trackerResponse.TrackerId = trackerId
 	}
 
 	if warningMessage, hasWarning := checkWarning(responseBencode); hasWarning {
-		log.Printf("[warning] tracker has a warning messsage: %s", warningMessage)
+		logs.tracker.Warn("tracker has a warning message", "message", warningMessage)
 		trackerResponse.WarningMessage = warningMessage
 	}
 
 	if minInterval, hasMinInterval := getMinInterval(responseBencode); hasMinInterval {
-		log.Printf("tracker has a minimum interval: %d seconds", minInterval)
 		trackerResponse.MinInterval = minInterval
 	}
 
 	if complete, hasComplete := getComplete(responseBencode); hasComplete {
-		log.Printf("tracker has the key `complete`")
 		trackerResponse.Complete = complete
 	}
 
 	if incomplete, hasIncomplete := getIncomplete(responseBencode); hasIncomplete {
-		log.Printf("tracker has the key `incomplete`")
 		trackerResponse.Incomplete = incomplete
 	}
 
@@ -0,0 +1,306 @@
+package ptorrent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type TrackerClientConfigurable struct {
+	responseTimeout    time.Duration
+	maxBackoffDuration time.Duration // the timeout till when tracker request is fulfilled, taking in account the exponential backoff
+	pollInterval       time.Duration // would get from the response of the tracker
+	responseMinPeers   int           // min peers that the tracker should send
+}
+
+type TrackerClient struct {
+	conf *TrackerClientConfigurable
+
+	announce   string
+	httpClient *http.Client
+
+	infoHash          string
+	localPeerId       string
+	localListenerPort uint16
+
+	mu               sync.RWMutex // guards the last response, read by the resume writer
+	lastResponseTime time.Time
+	lastResponse     *TrackerResponse
+	lastRequestTime  time.Time
+	lastRequestError error // of the last request, nil if it succeeded
+
+	trackerPollTicker *time.Ticker
+
+	metrics *sessionMetrics
+	logs    *subsystemLoggers
+}
+
+func NewTrackerClient(torrent *Torrent, session *TorrentSession) *TrackerClient {
+	return newTrackerClient(torrent.Announce, torrent.InfoHash, session.localPeerId, session.configurable.listenerPort, session.metrics, session.logs)
+}
+
+// newTrackerClient also announces torrents without a session, e.g. to find the peers of a magnet link
+func newTrackerClient(announce string, infoHash [20]byte, localPeerId [20]byte, localListenerPort uint16, metrics *sessionMetrics, logs *subsystemLoggers) *TrackerClient {
+	responseTimeout := 10 * time.Second
+	return &TrackerClient{
+		conf: &TrackerClientConfigurable{
+			responseTimeout:    responseTimeout,
+			maxBackoffDuration: time.Second * 36,
+			responseMinPeers:   4,
+		},
+
+		announce: announce,
+		httpClient: &http.Client{
+			Timeout: responseTimeout,
+		},
+
+		infoHash:          string(infoHash[:]),
+		localPeerId:       string(localPeerId[:]),
+		localListenerPort: localListenerPort,
+
+		metrics: metrics,
+		logs:    logs,
+	}
+}
+
+// announce events; regular announces have none
+const (
+	TrackerEventStarted = "started"
+	TrackerEventStopped = "stopped"
+)
+
+type TrackerResponse struct {
+	Peers          []Peer
+	Interval       uint32
+	TrackerId      string
+	MinInterval    uint32
+	Incomplete     int
+	Complete       int
+	WarningMessage string
+}
+
+func NewEmptyTrackerResponse() *TrackerResponse {
+	return &TrackerResponse{}
+}
+
+func (tr *TrackerResponse) String() string {
+	peerListStr := ""
+	for _, peer := range tr.Peers {
+		peerListStr += fmt.Sprintln(peer)
+	}
+	return fmt.Sprintf(
+		"TrackerResponse:\n"+
+			"- Interval: %d seconds\n"+
+			"- Incomplete: %d\n"+
+			"- Complete: %d\n"+
+			"- Peers Count: %d\n"+
+			"- Peers: \n%s",
+		tr.Interval, tr.Incomplete, tr.Complete, len(tr.Peers), peerListStr,
+	)
+}
+
+func (tc *TrackerClient) buildTrackerRequestUrl(event string, uploaded int64, downloaded int64, left int64) (string, error) {
+	baseUrl, err := url.Parse(tc.announce)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse tracker URL: %w", err)
+	}
+	params := url.Values{
+		"info_hash":  []string{tc.infoHash},
+		"peer_id":    []string{tc.localPeerId},
+		"port":       []string{strconv.Itoa(int(tc.localListenerPort))},
+		"uploaded":   []string{strconv.FormatInt(uploaded, 10)},
+		"downloaded": []string{strconv.FormatInt(downloaded, 10)},
+		"left":       []string{strconv.FormatInt(left, 10)},
+		"compact":    []string{"1"},
+	}
+	if event != "" {
+		params.Set("event", event)
+	}
+	baseUrl.RawQuery = params.Encode()
+	tc.logs.tracker.Debug("querying tracker", "url", baseUrl.String())
+	return baseUrl.String(), nil
+}
+
+func (tc *TrackerClient) getTrackerResponse(ctx context.Context, event string, uploaded int64, downloaded int64, left int64) (*TrackerResponse, error) {
+	trackerRequestUrl, err := tc.buildTrackerRequestUrl(event, uploaded, downloaded, left)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build tracker request URL: %w", err)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, trackerRequestUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build tracker request: %w", err)
+	}
+	start := time.Now()
+	trackerResponse, err := tc.doTrackerRequest(request)
+	if ctx.Err() == nil {
+		tc.metrics.recordAnnounce(time.Since(start), err)
+		tc.mu.Lock()
+		tc.lastRequestTime = time.Now()
+		tc.lastRequestError = err
+		tc.mu.Unlock()
+	}
+	return trackerResponse, err
+}
+
+func (tc *TrackerClient) doTrackerRequest(request *http.Request) (*TrackerResponse, error) {
+	resp, err := tc.httpClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failure while sending request to tracker URL: %w", err)
+	}
+
+	defer CloseReadCloserWithLog(resp.Body)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+	return parseTrackerResponse(body)
+}
+
+// GetTrackerResponse queries tracker with exponential backoff, till the context is cancelled
+func (tc *TrackerClient) GetTrackerResponse(ctx context.Context, event string, upload int64, download int64, left int64) (*TrackerResponse, error) {
+	backoff := time.Second
+	var trackerResponse *TrackerResponse
+	var err error
+	for {
+		trackerResponse, err = tc.getTrackerResponse(ctx, event, upload, download, left)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			tc.logs.tracker.Warn("tracker returned error, retrying", "err", err, "backoff", backoff)
+
+			if err = sleepWithContext(ctx, backoff); err != nil {
+				return nil, err
+			}
+			backoff *= 2
+
+			if backoff >= tc.conf.maxBackoffDuration {
+				return nil, fmt.Errorf("tracker query timeout")
+			}
+			continue
+		}
+		if len(trackerResponse.Peers) >= tc.conf.responseMinPeers {
+			break
+		}
+		if backoff >= tc.conf.maxBackoffDuration {
+			return nil, fmt.Errorf("tracker query timeout")
+		}
+		tc.logs.tracker.Info("too few peers returned, retrying", "peers", len(trackerResponse.Peers), "backoff", backoff)
+		if err = sleepWithContext(ctx, backoff); err != nil {
+			return nil, err
+		}
+		backoff *= 2
+	}
+	tc.mu.Lock()
+	tc.lastResponse = trackerResponse
+	tc.lastResponseTime = time.Now()
+	tc.mu.Unlock()
+	return trackerResponse, nil
+}
+
+// AnnounceStopped tells the tracker the torrent is stopped; sent once, without retries
+func (tc *TrackerClient) AnnounceStopped(ctx context.Context, upload int64, download int64, left int64) error {
+	_, err := tc.getTrackerResponse(ctx, TrackerEventStopped, upload, download, left)
+	return err
+}
+
+// LastResponse returns nil if the tracker never responded
+func (tc *TrackerClient) LastResponse() *TrackerResponse {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	return tc.lastResponse
+}
+
+type TrackerStatus struct {
+	Url string
+
+	LastRequest time.Time // zero if the tracker was never queried
+	LastError   string    // of the last request, "" if it succeeded
+
+	LastResponse time.Time // zero if the tracker never responded with enough peers
+	NextAnnounce time.Time // zero if the tracker never responded
+	Peers        int       // in the last response
+	Seeders      int
+	Leechers     int
+}
+
+func (tc *TrackerClient) Status() TrackerStatus {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+
+	status := TrackerStatus{
+		Url:          tc.announce,
+		LastRequest:  tc.lastRequestTime,
+		LastResponse: tc.lastResponseTime,
+	}
+	if tc.lastRequestError != nil {
+		status.LastError = tc.lastRequestError.Error()
+	}
+	if tc.lastResponse != nil {
+		status.NextAnnounce = tc.lastResponseTime.Add(time.Second * time.Duration(tc.lastResponse.Interval))
+		status.Peers = len(tc.lastResponse.Peers)
+		status.Seeders = tc.lastResponse.Complete
+		status.Leechers = tc.lastResponse.Incomplete
+	}
+	return status
+}
+
+// TrackerPollHandler Meant to be run as a goroutine, polls till the context is cancelled
+func (tc *TrackerClient) TrackerPollHandler(ctx context.Context, session *TorrentSession) {
+	defer tc.StopTrackerPolling()
+	for {
+		if tc.trackerPollTicker == nil {
+			tc.logs.tracker.Error("tracker poll ticker is nil")
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-tc.trackerPollTicker.C:
+		}
+		left, downloaded, uploaded := session.state.GetState()
+		trackerResponse, err := tc.GetTrackerResponse(ctx, "", uploaded, downloaded, left)
+		if err != nil {
+			if ctx.Err() == nil {
+				tc.logs.tracker.Warn("error polling the tracker", "err", err)
+			}
+			return
+		}
+		tc.logs.tracker.Info("announced", "peers", len(trackerResponse.Peers))
+
+		tc.SetTrackerPolling()
+		tc.HandleTrackerResponse(trackerResponse, session)
+	}
+}
+
+// HandleTrackerResponse hands the peers to the peer manager, which dials them once slots are free
+func (tc *TrackerClient) HandleTrackerResponse(trackerResponse *TrackerResponse, torrentSession *TorrentSession) {
+	torrentSession.peerManager.AddPeers(trackerResponse.Peers, PeerSourceTracker)
+}
+
+/* TRACKER POLLING TICKER */
+
+func (tc *TrackerClient) SetTrackerPolling() {
+	if tc.trackerPollTicker != nil {
+		tc.trackerPollTicker.Stop()
+	}
+
+	tc.conf.pollInterval = time.Second * time.Duration(tc.LastResponse().Interval)
+	tc.trackerPollTicker = time.NewTicker(tc.conf.pollInterval)
+}
+
+func (tc *TrackerClient) StopTrackerPolling() {
+	if tc.trackerPollTicker == nil {
+		tc.logs.tracker.Debug("tracker polling is already stopped")
+		return
+	}
+	tc.trackerPollTicker.Stop()
+}
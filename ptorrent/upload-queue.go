@@ -0,0 +1,81 @@
+package ptorrent
+
+import (
+	"sync"
+)
+
+const DefaultUploadQueueLength = 64
+
+// UploadQueue holds the block requests received from a peer, in the order they were received.
+// The peer writer goroutine is notified whenever a new request is pushed.
+type UploadQueue struct {
+	mu        sync.Mutex
+	requests  []*BlockRequest
+	maxLength int
+
+	notify chan struct{}
+}
+
+func NewUploadQueue(maxLength int) *UploadQueue {
+	return &UploadQueue{
+		requests:  make([]*BlockRequest, 0, maxLength),
+		maxLength: maxLength,
+		notify:    make(chan struct{}, 1),
+	}
+}
+
+// Push returns false if the queue is full or the request is a duplicate
+func (uq *UploadQueue) Push(request *BlockRequest) bool {
+	uq.mu.Lock()
+	defer uq.mu.Unlock()
+
+	if len(uq.requests) >= uq.maxLength {
+		return false
+	}
+	for _, queued := range uq.requests {
+		if *queued == *request {
+			return false
+		}
+	}
+	uq.requests = append(uq.requests, request)
+
+	select {
+	case uq.notify <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Pop returns nil if the queue is empty
+func (uq *UploadQueue) Pop() *BlockRequest {
+	uq.mu.Lock()
+	defer uq.mu.Unlock()
+
+	if len(uq.requests) == 0 {
+		return nil
+	}
+	request := uq.requests[0]
+	uq.requests = uq.requests[1:]
+	return request
+}
+
+// Remove removes a queued request, in response to a 'cancel' from the peer
+func (uq *UploadQueue) Remove(index uint32, begin uint32, length uint32) bool {
+	uq.mu.Lock()
+	defer uq.mu.Unlock()
+
+	for i, queued := range uq.requests {
+		if queued.index == index && queued.begin == begin && queued.length == length {
+			uq.requests = append(uq.requests[:i], uq.requests[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (uq *UploadQueue) Len() int {
+	uq.mu.Lock()
+	defer uq.mu.Unlock()
+
+	return len(uq.requests)
+}
@@ -1,10 +1,11 @@
-package main
+package ptorrent
 
 import (
+	"context"
 	"crypto/rand"
 	"fmt"
 	"io"
-	"log"
+	"time"
 )
 
 type Pair[T, U any] struct {
@@ -18,7 +19,19 @@ func MakePair[T, U any](first T, second U) Pair[T, U] {
 
 func CloseReadCloserWithLog(c io.ReadCloser) {
 	if err := c.Close(); err != nil {
-		log.Printf("failed to close resource: %v", err)
+		logs.client.Warn("failed to close resource", "err", err)
+	}
+}
+
+// sleepWithContext returns the context error if it is cancelled before `d` elapses
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
 	}
 }
 
@@ -36,18 +49,18 @@ func ceilDiv[T Number](a, b T) T {
 	return (a / b) + 1
 }
 
-func assertAndReturnPerfectDivision[T Number](a, b T) T {
+func assertAndReturnPerfectDivision[T Number](a, b T) (T, error) {
 	if b == 0 {
-		log.Fatalln("division by zero")
+		return 0, ErrDivisionByZero
 	}
 	if a%b != 0 {
-		log.Fatalln("the division is not perfect and leaves a remainder")
+		return 0, ErrDivisionNotPerfect(int64(a), int64(b))
 	}
-	return a / b
+	return a / b, nil
 }
 
-// generateLocalPeerId generates a Peer ID for the client.
-func generateLocalPeerId() ([20]byte, error) {
+// GenerateLocalPeerId generates a random Peer ID for the client
+func GenerateLocalPeerId() ([20]byte, error) {
 	var localPeerId [20]byte
 
 	prefix := "-PTC001-"
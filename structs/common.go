@@ -1,6 +1,9 @@
 package structs
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
 
 type number interface {
 	int | int8 | int16 | int32 | int64 | uint | uint8 | uint16 | uint32 | uint64
@@ -15,9 +18,9 @@ type hashSet[K key] struct {
 }
 
 func (h *hashSet[K]) String() string {
-	res := make([]string, len(h.set))
+	res := make([]string, 0, len(h.set))
 	for k := range h.set {
-		res = append(res, string(k))
+		res = append(res, fmt.Sprint(k))
 	}
 	return strings.Join(res, ",")
 }
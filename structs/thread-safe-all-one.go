@@ -210,3 +210,14 @@ func (a *MutexAllForOne[K]) GetMostRareKey() K {
 
 	return a.linkedList.tail.prev.keys.getAny()
 }
+
+// GetCount returns 0 if the key is not present
+func (a *MutexAllForOne[K]) GetCount(key K) int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if nodeForKey, ok := a.lookup[key]; ok {
+		return nodeForKey.count
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+#!/usr/bin/env python3
+"""Writes known-good.torrent from source/, independently of the Go bencoder, and prints its info-hash."""
+
+import hashlib
+import os
+
+PIECE_LENGTH = 16384
+ANNOUNCE = "http://tracker.example.com/announce"
+CREATION_DATE = 1700000000
+COMMENT = "known-good fixture"
+CREATED_BY = "make-fixture.py"
+
+
+def bencode(value):
+    if isinstance(value, int):
+        return b"i%de" % value
+    if isinstance(value, str):
+        value = value.encode()
+    if isinstance(value, bytes):
+        return b"%d:%s" % (len(value), value)
+    if isinstance(value, list):
+        return b"l" + b"".join(bencode(item) for item in value) + b"e"
+    if isinstance(value, dict):
+        items = sorted((key.encode(), item) for key, item in value.items())
+        return b"d" + b"".join(bencode(key) + bencode(item) for key, item in items) + b"e"
+    raise TypeError(value)
+
+
+def main():
+    here = os.path.dirname(os.path.abspath(__file__))
+    root = os.path.join(here, "source")
+    # files in lexicographical order of their path segments
+    paths = []
+    for directory, _, names in os.walk(root):
+        for name in names:
+            paths.append(os.path.relpath(os.path.join(directory, name), root).split(os.sep))
+    paths.sort()
+
+    data = b""
+    files = []
+    for path in paths:
+        with open(os.path.join(root, *path), "rb") as f:
+            content = f.read()
+        data += content
+        files.append({"length": len(content), "path": path})
+    pieces = b"".join(hashlib.sha1(data[i:i + PIECE_LENGTH]).digest() for i in range(0, len(data), PIECE_LENGTH))
+
+    info = {"name": "source", "piece length": PIECE_LENGTH, "pieces": pieces, "files": files}
+    metainfo = {
+        "announce": ANNOUNCE,
+        "creation date": CREATION_DATE,
+        "comment": COMMENT,
+        "created by": CREATED_BY,
+        "info": info,
+    }
+    with open(os.path.join(here, "known-good.torrent"), "wb") as f:
+        f.write(bencode(metainfo))
+    print(hashlib.sha1(bencode(info)).hexdigest(), len(data), len(pieces) // 20)
+
+
+if __name__ == "__main__":
+    main()
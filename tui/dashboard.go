@@ -0,0 +1,245 @@
+package tui
+
+import (
+	"bittorrent-client/ptorrent"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+/** TOC
+- DASHBOARD
+	- NewDashboard, Run
+	- readKeys (goroutine), handleKey
+- HISTORY
+	- sample
+*/
+
+/*
+- What is it supposed to do
+- - Draws a full screen dashboard of the client on the terminal, refreshed every second:
+- - - overall progress, ETA, download and upload rates with sparklines of the last minutes
+- - - the tracker status
+- - - a piece map: pieces done, in flight, missing or skipped
+- - - a table of the connected peers: address, client, flags, rates and their progress
+- - One torrent is shown at a time; `n` (or Tab) selects the next one, `p` pauses or resumes it, `q` quits.
+- - The terminal is restored once the dashboard stops. Logging is meant to be redirected to a file while it runs,
+    log lines would be drawn over the dashboard.
+*/
+
+const (
+	DefaultRefreshInterval = time.Second
+
+	historyLength = 300 // samples kept for the sparklines
+	etaWindow     = 10  // samples averaged for the ETA
+)
+
+type Options struct {
+	RefreshInterval time.Duration // zero falls back to DefaultRefreshInterval
+}
+
+type rateHistory struct {
+	download []float64
+	upload   []float64
+}
+
+type Dashboard struct {
+	client          *ptorrent.Client
+	input           *os.File
+	output          *os.File
+	refreshInterval time.Duration
+
+	selected     [20]byte // info-hash of the torrent shown
+	history      map[[20]byte]*rateHistory
+	totalHistory rateHistory
+}
+
+/************************************** DASHBOARD **************************************/
+
+// NewDashboard keys are read from `input`, if it is a terminal
+func NewDashboard(client *ptorrent.Client, input *os.File, output *os.File, options Options) *Dashboard {
+	refreshInterval := options.RefreshInterval
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultRefreshInterval
+	}
+	return &Dashboard{
+		client:          client,
+		input:           input,
+		output:          output,
+		refreshInterval: refreshInterval,
+		history:         make(map[[20]byte]*rateHistory),
+	}
+}
+
+// Run draws the dashboard till the context is cancelled or `q` is pressed, then restores the terminal
+func (d *Dashboard) Run(ctx context.Context) {
+	keys := make(chan byte)
+	if restore, err := enterCbreakMode(d.input); err != nil {
+		log.Printf("dashboard keys disabled, the terminal can not be switched to cbreak mode: %v", err)
+	} else {
+		defer restore()
+		keysCtx, stopKeys := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.readKeys(keysCtx, keys)
+		}()
+		// the key reader stops within its read timeout, before the terminal mode is restored
+		defer wg.Wait()
+		defer stopKeys()
+	}
+
+	d.writeToTerminal([]byte(enterAlternateScreen))
+	defer d.writeToTerminal([]byte(leaveAlternateScreen))
+
+	ticker := time.NewTicker(d.refreshInterval)
+	defer ticker.Stop()
+
+	d.sample()
+	d.draw()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case key := <-keys:
+			if !d.handleKey(key) {
+				return
+			}
+		case <-ticker.C:
+			d.sample()
+		}
+		d.draw()
+	}
+}
+
+// readKeys Meant to be run as a goroutine, sends every key pressed till the context is cancelled
+func (d *Dashboard) readKeys(ctx context.Context, keys chan<- byte) {
+	buffer := make([]byte, 16)
+	for ctx.Err() == nil {
+		// in cbreak mode, a read with no key pressed returns nothing once the read timeout expires
+		n, err := d.input.Read(buffer)
+		if err != nil && !errors.Is(err, io.EOF) {
+			log.Printf("dashboard stopped reading keys: %v", err)
+			return
+		}
+		for _, key := range buffer[:n] {
+			select {
+			case keys <- key:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// handleKey returns false to quit
+func (d *Dashboard) handleKey(key byte) bool {
+	switch key {
+	case 'q', 'Q':
+		return false
+	case 'n', '\t':
+		sessions := d.sessions()
+		for i, session := range sessions {
+			if session.Torrent().InfoHash == d.selected {
+				d.selected = sessions[(i+1)%len(sessions)].Torrent().InfoHash
+				break
+			}
+		}
+	case 'p':
+		if session := d.client.Session(d.selected); session != nil {
+			if session.Stats().Paused {
+				session.Resume()
+			} else {
+				session.Pause()
+			}
+		}
+	}
+	return true
+}
+
+// sessions in the order they were added
+func (d *Dashboard) sessions() []*ptorrent.TorrentSession {
+	sessions := d.client.Sessions()
+	sort.Slice(sessions, func(i, j int) bool {
+		addedI, addedJ := sessions[i].Stats().AddedAt, sessions[j].Stats().AddedAt
+		if !addedI.Equal(addedJ) {
+			return addedI.Before(addedJ)
+		}
+		return bytes.Compare(sessions[i].Torrent().InfoHash[:], sessions[j].Torrent().InfoHash[:]) < 0
+	})
+	return sessions
+}
+
+func (d *Dashboard) draw() {
+	width, height, err := terminalSize(d.output)
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	s := newScreen(width, height)
+	d.render(s, time.Now())
+	d.writeToTerminal(s.frame())
+}
+
+func (d *Dashboard) writeToTerminal(data []byte) {
+	if _, err := d.output.Write(data); err != nil {
+		log.Printf("error drawing the dashboard: %v", err)
+	}
+}
+
+/************************************** HISTORY **************************************/
+
+// sample records the rates of every torrent, and forgets removed torrents
+func (d *Dashboard) sample() {
+	var totalDownload, totalUpload float64
+	seen := make(map[[20]byte]bool)
+	for _, session := range d.client.Sessions() {
+		stats := session.Stats()
+		seen[stats.InfoHash] = true
+		history, ok := d.history[stats.InfoHash]
+		if !ok {
+			history = &rateHistory{}
+			d.history[stats.InfoHash] = history
+		}
+		history.download = appendSample(history.download, stats.DownloadRate)
+		history.upload = appendSample(history.upload, stats.UploadRate)
+		totalDownload += stats.DownloadRate
+		totalUpload += stats.UploadRate
+	}
+	for infoHash := range d.history {
+		if !seen[infoHash] {
+			delete(d.history, infoHash)
+		}
+	}
+	d.totalHistory.download = appendSample(d.totalHistory.download, totalDownload)
+	d.totalHistory.upload = appendSample(d.totalHistory.upload, totalUpload)
+}
+
+func appendSample(samples []float64, sample float64) []float64 {
+	samples = append(samples, sample)
+	if len(samples) > historyLength {
+		samples = samples[len(samples)-historyLength:]
+	}
+	return samples
+}
+
+// averageRate of the last `etaWindow` samples
+func averageRate(samples []float64) float64 {
+	if len(samples) > etaWindow {
+		samples = samples[len(samples)-etaWindow:]
+	}
+	if len(samples) == 0 {
+		return 0
+	}
+	total := 0.0
+	for _, sample := range samples {
+		total += sample
+	}
+	return total / float64(len(samples))
+}
@@ -0,0 +1,115 @@
+package tui
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+var sparkRunes = []rune("▁▂▃▄▅▆▇█")
+
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value := float64(bytes)
+	prefixes := []string{"KiB", "MiB", "GiB", "TiB"}
+	var prefix string
+	for _, prefix = range prefixes {
+		value /= unit
+		if value < unit {
+			break
+		}
+	}
+	return fmt.Sprintf("%.1f %s", value, prefix)
+}
+
+func formatRate(bytesPerSecond float64) string {
+	return formatBytes(int64(bytesPerSecond)) + "/s"
+}
+
+// formatEta e.g. 1h02m, 3m12s, 45s
+func formatEta(eta time.Duration) string {
+	eta = eta.Round(time.Second)
+	switch {
+	case eta >= 100*time.Hour:
+		return fmt.Sprintf("%dd", int(eta.Hours()/24))
+	case eta >= time.Hour:
+		return fmt.Sprintf("%dh%02dm", int(eta.Hours()), int(eta.Minutes())%60)
+	case eta >= time.Minute:
+		return fmt.Sprintf("%dm%02ds", int(eta.Minutes()), int(eta.Seconds())%60)
+	default:
+		return fmt.Sprintf("%ds", int(eta.Seconds()))
+	}
+}
+
+// formatAgo e.g. "12s ago", or "never" for the zero time
+func formatAgo(t time.Time, now time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return formatEta(now.Sub(t)) + " ago"
+}
+
+// sparkline of the last `width` samples, scaled to the highest of them
+func sparkline(samples []float64, width int) string {
+	if len(samples) > width {
+		samples = samples[len(samples)-width:]
+	}
+	highest := 0.0
+	for _, sample := range samples {
+		highest = math.Max(highest, sample)
+	}
+	var sb strings.Builder
+	sb.WriteString(strings.Repeat(" ", width-len(samples)))
+	for _, sample := range samples {
+		level := 0
+		if highest > 0 {
+			level = int(sample / highest * float64(len(sparkRunes)-1))
+		}
+		sb.WriteRune(sparkRunes[level])
+	}
+	return sb.String()
+}
+
+// progressBar of `width` cells, e.g. [██████░░░░]
+func progressBar(fraction float64, width int) string {
+	filled := int(math.Round(math.Max(0, math.Min(1, fraction)) * float64(width)))
+	return "[" + strings.Repeat("█", filled) + strings.Repeat("░", width-filled) + "]"
+}
+
+// peerFlags as shown by most clients:
+// D downloading, d interested but choked, U uploading, u peer interested but choked,
+// K peer unchoked us but we are not interested, ? we unchoked the peer but it is not interested,
+// I incoming connection, F supports the fast extension, S snubbed
+func peerFlags(amChoking, amInterested, peerChoking, peerInterested, outgoing, fastExtension, snubbed bool) string {
+	var flags strings.Builder
+	switch {
+	case amInterested && !peerChoking:
+		flags.WriteByte('D')
+	case amInterested:
+		flags.WriteByte('d')
+	case !peerChoking:
+		flags.WriteByte('K')
+	}
+	switch {
+	case peerInterested && !amChoking:
+		flags.WriteByte('U')
+	case peerInterested:
+		flags.WriteByte('u')
+	case !amChoking:
+		flags.WriteByte('?')
+	}
+	if !outgoing {
+		flags.WriteByte('I')
+	}
+	if fastExtension {
+		flags.WriteByte('F')
+	}
+	if snubbed {
+		flags.WriteByte('S')
+	}
+	return flags.String()
+}
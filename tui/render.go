@@ -0,0 +1,206 @@
+package tui
+
+import (
+	"bittorrent-client/ptorrent"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	sparklineWidth   = 40
+	progressBarWidth = 30
+	maxPieceMapRows  = 6
+)
+
+// render lays out, top to bottom: the client totals, the selected torrent, its tracker, its piece map and its peers
+func (d *Dashboard) render(s *screen, now time.Time) {
+	sessions := d.sessions()
+	stats := d.client.Stats()
+
+	s.write(styleBold, " pTorrent ")
+	s.write("", fmt.Sprintf(" %d torrents  %d peers  ", stats.NumTorrents, stats.NumConnections))
+	s.write(styleGreen, "↓ "+formatRate(stats.DownloadRate))
+	s.write("", "  ")
+	s.write(styleCyan, "↑ "+formatRate(stats.UploadRate))
+	s.endLine()
+	s.line(styleDim, " n/Tab next torrent   p pause/resume   q quit")
+	s.endLine()
+
+	if len(sessions) == 0 {
+		s.line("", " no torrents; add one through the http api")
+		return
+	}
+	selectedIndex := 0
+	for i, session := range sessions {
+		if session.Torrent().InfoHash == d.selected {
+			selectedIndex = i
+		}
+	}
+	session := sessions[selectedIndex]
+	d.selected = session.Torrent().InfoHash
+
+	torrentStats := session.Stats()
+	history := d.history[torrentStats.InfoHash]
+	if history == nil {
+		history = &rateHistory{}
+	}
+	d.renderTorrent(s, selectedIndex, len(sessions), torrentStats, history)
+	renderTracker(s, session.TrackerStatus(), torrentStats.Paused, now)
+	s.endLine()
+	renderPieceMap(s, session.PieceStates())
+	s.endLine()
+	renderPeers(s, session.Peers())
+}
+
+func (d *Dashboard) renderTorrent(s *screen, index int, count int, stats ptorrent.TorrentStats, history *rateHistory) {
+	status, statusStyle := "downloading", styleGreen
+	switch {
+	case stats.Paused:
+		status, statusStyle = "paused", styleYellow
+	case stats.Complete:
+		status, statusStyle = "seeding", styleCyan
+	}
+	s.write("", fmt.Sprintf(" [%d/%d] ", index+1, count))
+	s.write(styleBold, stats.Name)
+	s.write("", "  ")
+	s.write(statusStyle, status)
+	s.endLine()
+
+	eta := "-"
+	if averageDownloadRate := averageRate(history.download); stats.Complete {
+		eta = "done"
+	} else if averageDownloadRate > 0 && !stats.Paused {
+		eta = formatEta(time.Duration(float64(stats.Left) / averageDownloadRate * float64(time.Second)))
+	}
+	s.write("", " ")
+	s.write(styleGreen, progressBar(stats.Progress, progressBarWidth))
+	s.write("", fmt.Sprintf(" %5.1f%%  %s of %s  ETA %s",
+		stats.Progress*100, formatBytes(stats.WantedLength-stats.Left), formatBytes(stats.WantedLength), eta))
+	s.endLine()
+
+	s.write(styleGreen, fmt.Sprintf(" ↓ %-12s ", formatRate(stats.DownloadRate)))
+	s.write(styleGreen, sparkline(history.download, sparklineWidth))
+	s.write("", fmt.Sprintf("  %s downloaded", formatBytes(stats.Downloaded)))
+	s.endLine()
+	s.write(styleCyan, fmt.Sprintf(" ↑ %-12s ", formatRate(stats.UploadRate)))
+	s.write(styleCyan, sparkline(history.upload, sparklineWidth))
+	s.write("", fmt.Sprintf("  %s uploaded", formatBytes(stats.Uploaded)))
+	s.endLine()
+}
+
+func renderTracker(s *screen, status ptorrent.TrackerStatus, paused bool, now time.Time) {
+	s.write("", " tracker ")
+	s.write(styleDim, status.Url)
+	s.write("", "  ")
+	switch {
+	case status.LastError != "":
+		s.write(styleRed, fmt.Sprintf("error %s: %s", formatAgo(status.LastRequest, now), status.LastError))
+	case status.LastResponse.IsZero():
+		s.write(styleYellow, "no response yet")
+	default:
+		s.write("", fmt.Sprintf("%d peers (%d seeders, %d leechers), announced %s",
+			status.Peers, status.Seeders, status.Leechers, formatAgo(status.LastResponse, now)))
+		if !paused && status.NextAnnounce.After(now) {
+			s.write("", ", next in "+formatEta(status.NextAnnounce.Sub(now)))
+		}
+	}
+	s.endLine()
+}
+
+// renderPieceMap one cell per piece if they fit in `maxPieceMapRows`, otherwise each cell covers a range of pieces:
+// done if every wanted piece of the range is done, in flight if any of them is
+func renderPieceMap(s *screen, states []ptorrent.PieceState) {
+	counts := make(map[ptorrent.PieceState]int)
+	for _, state := range states {
+		counts[state]++
+	}
+	s.write("", fmt.Sprintf(" pieces %d/%d done, %d in flight", counts[ptorrent.PieceDone], len(states), counts[ptorrent.PieceInFlight]))
+	if counts[ptorrent.PieceSkipped] > 0 {
+		s.write("", fmt.Sprintf(", %d skipped", counts[ptorrent.PieceSkipped]))
+	}
+	width := s.width - 2
+	rows := min(maxPieceMapRows, s.remainingLines()-4) // room for the peer table header
+	if width <= 0 || rows <= 0 || len(states) == 0 {
+		s.endLine()
+		return
+	}
+	piecesPerCell := (len(states) + width*rows - 1) / (width * rows)
+	if piecesPerCell > 1 {
+		s.write(styleDim, fmt.Sprintf("   %d pieces per cell: █ done  ▓ partly done  ▒ in flight  · missing", piecesPerCell))
+	} else {
+		s.write(styleDim, "   █ done  ▒ in flight  · missing")
+	}
+	s.endLine()
+
+	numCells := (len(states) + piecesPerCell - 1) / piecesPerCell
+	for row := 0; row*width < numCells; row++ {
+		s.write("", " ")
+		for cell := row * width; cell < min(numCells, (row+1)*width); cell++ {
+			start := cell * piecesPerCell
+			end := min(len(states), start+piecesPerCell)
+			cellRune, style := pieceCell(states[start:end])
+			s.write(style, cellRune)
+		}
+		s.endLine()
+	}
+}
+
+func pieceCell(states []ptorrent.PieceState) (string, string) {
+	var done, inFlight, missing int
+	for _, state := range states {
+		switch state {
+		case ptorrent.PieceDone:
+			done++
+		case ptorrent.PieceInFlight:
+			inFlight++
+		case ptorrent.PieceMissing:
+			missing++
+		}
+	}
+	switch {
+	case inFlight > 0:
+		return "▒", styleYellow
+	case missing > 0 && done > 0:
+		return "▓", styleGreen
+	case missing > 0:
+		return "·", styleDim
+	case done > 0:
+		return "█", styleGreen
+	default:
+		return " ", "" // skipped
+	}
+}
+
+// renderPeers the fastest peers first, as many as fit
+func renderPeers(s *screen, peers []ptorrent.PeerStats) {
+	sort.Slice(peers, func(i, j int) bool {
+		if peers[i].DownloadRate != peers[j].DownloadRate {
+			return peers[i].DownloadRate > peers[j].DownloadRate
+		}
+		if peers[i].UploadRate != peers[j].UploadRate {
+			return peers[i].UploadRate > peers[j].UploadRate
+		}
+		return peers[i].Address < peers[j].Address
+	})
+
+	s.line(styleBold, fmt.Sprintf(" %s %s %s %12s %12s %8s",
+		fit(fmt.Sprintf("peers (%d)", len(peers)), 24), fit("client", 20), fit("flags", 6), "down", "up", "progress"))
+	for i, peer := range peers {
+		if s.remainingLines() == 1 && i < len(peers)-1 {
+			s.line(styleDim, fmt.Sprintf(" ... and %d more", len(peers)-i))
+			return
+		}
+		client := peer.Client
+		if client == "" {
+			client = "?"
+		}
+		flags := peerFlags(peer.AmChoking, peer.AmInterested, peer.PeerChoking, peer.PeerInterested, peer.Outgoing, peer.SupportsFastExtension, peer.Snubbed)
+		s.line("", fmt.Sprintf(" %s %s %s %12s %12s %7.1f%%",
+			fit(peer.Address, 24), fit(client, 20), fit(flags, 6),
+			formatRate(peer.DownloadRate), formatRate(peer.UploadRate), peer.Progress*100))
+	}
+	if len(peers) == 0 {
+		s.line(styleDim, " no peers connected")
+	}
+}
@@ -0,0 +1,99 @@
+package tui
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	styleBold   = "\x1b[1m"
+	styleDim    = "\x1b[2m"
+	styleRed    = "\x1b[31m"
+	styleGreen  = "\x1b[32m"
+	styleYellow = "\x1b[33m"
+	styleCyan   = "\x1b[36m"
+	styleReset  = "\x1b[0m"
+
+	enterAlternateScreen = "\x1b[?1049h\x1b[?25l" // and hide the cursor
+	leaveAlternateScreen = "\x1b[?25h\x1b[?1049l"
+	cursorHome           = "\x1b[H"
+	clearToLineEnd       = "\x1b[K"
+	clearToScreenEnd     = "\x1b[J"
+)
+
+// screen builds a frame line by line; text past the width or the height of the terminal is dropped
+type screen struct {
+	buffer bytes.Buffer
+	width  int
+	height int
+	row    int
+	column int
+}
+
+func newScreen(width int, height int) *screen {
+	s := &screen{width: width, height: height}
+	s.buffer.WriteString(cursorHome)
+	return s
+}
+
+// full if no more lines fit
+func (s *screen) full() bool {
+	return s.row >= s.height
+}
+
+func (s *screen) remainingLines() int {
+	return s.height - s.row
+}
+
+func (s *screen) write(style string, text string) {
+	if s.full() || s.column >= s.width {
+		return
+	}
+	if length := utf8.RuneCountInString(text); s.column+length > s.width {
+		text = string([]rune(text)[:s.width-s.column])
+	}
+	s.column += utf8.RuneCountInString(text)
+	if style == "" {
+		s.buffer.WriteString(text)
+		return
+	}
+	s.buffer.WriteString(style)
+	s.buffer.WriteString(text)
+	s.buffer.WriteString(styleReset)
+}
+
+// endLine the last line of the terminal is not followed by a newline, so that the screen does not scroll
+func (s *screen) endLine() {
+	if s.full() {
+		return
+	}
+	s.buffer.WriteString(clearToLineEnd)
+	s.row++
+	s.column = 0
+	if !s.full() {
+		s.buffer.WriteString("\r\n")
+	}
+}
+
+func (s *screen) line(style string, text string) {
+	s.write(style, text)
+	s.endLine()
+}
+
+func (s *screen) frame() []byte {
+	s.buffer.WriteString(clearToScreenEnd)
+	return s.buffer.Bytes()
+}
+
+// fit pads or cuts the text to exactly `width` runes
+func fit(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) > width {
+		if width <= 1 {
+			return string(runes[:width])
+		}
+		return string(runes[:width-1]) + "…"
+	}
+	return text + strings.Repeat(" ", width-len(runes))
+}
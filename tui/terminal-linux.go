@@ -0,0 +1,46 @@
+//go:build linux
+
+package tui
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// keyReadTimeout in tenths of a second: a read with no key pressed returns empty, so that the key reader can stop
+const keyReadTimeout = 2
+
+// enterCbreakMode disables line buffering and echo, so that keys are read as they are pressed. Ctrl+C still raises
+// SIGINT. The returned function restores the previous mode.
+func enterCbreakMode(terminal *os.File) (func(), error) {
+	var original syscall.Termios
+	if err := ioctl(terminal.Fd(), syscall.TCGETS, unsafe.Pointer(&original)); err != nil {
+		return nil, err
+	}
+	cbreak := original
+	cbreak.Lflag &^= syscall.ICANON | syscall.ECHO
+	cbreak.Cc[syscall.VMIN] = 0
+	cbreak.Cc[syscall.VTIME] = keyReadTimeout
+	if err := ioctl(terminal.Fd(), syscall.TCSETS, unsafe.Pointer(&cbreak)); err != nil {
+		return nil, err
+	}
+	return func() { _ = ioctl(terminal.Fd(), syscall.TCSETS, unsafe.Pointer(&original)) }, nil
+}
+
+func terminalSize(terminal *os.File) (width int, height int, err error) {
+	var size struct {
+		rows, columns, xPixels, yPixels uint16
+	}
+	if err = ioctl(terminal.Fd(), syscall.TIOCGWINSZ, unsafe.Pointer(&size)); err != nil {
+		return 0, 0, err
+	}
+	return int(size.columns), int(size.rows), nil
+}
+
+func ioctl(fd uintptr, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
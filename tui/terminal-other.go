@@ -0,0 +1,17 @@
+//go:build !linux
+
+package tui
+
+import (
+	"errors"
+	"os"
+)
+
+// enterCbreakMode keys are not read on this platform; the dashboard is left with Ctrl+C
+func enterCbreakMode(terminal *os.File) (func(), error) {
+	return nil, errors.ErrUnsupported
+}
+
+func terminalSize(terminal *os.File) (width int, height int, err error) {
+	return 0, 0, errors.ErrUnsupported
+}